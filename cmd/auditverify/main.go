@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ry461ch/metric-collector/internal/audit"
+)
+
+func main() {
+	path := flag.String("file", "", "audit log file, rotated files path.1, path.2, ... are checked too")
+	flag.Parse()
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	count, err := audit.Verify(*path)
+	if err != nil {
+		fmt.Printf("Audit log is corrupted after %d valid records: %s\n", count, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Audit log is valid: %d records\n", count)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ry461ch/metric-collector/pkg/rsa"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory for generated keys")
+	keyID := flag.String("id", time.Now().UTC().Format("20060102-150405"), "key id, used as file name")
+	bits := flag.Int("bits", 4096, "key size in bits")
+	flag.Parse()
+
+	privatePath, publicPath, err := rsa.GenerateKeyPair(*dir, *keyID, *bits)
+	if err != nil {
+		log.Fatalf("Can't generate key pair: %s", err)
+	}
+	fmt.Printf("Private key: %s\n", privatePath)
+	fmt.Printf("Public key: %s\n", publicPath)
+}
@@ -7,9 +7,11 @@ import (
 	"net"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/ry461ch/metric-collector/internal/app/agent/collector"
 	"github.com/ry461ch/metric-collector/internal/app/agent/sender"
+	"github.com/ry461ch/metric-collector/internal/app/agent/status"
 	config "github.com/ry461ch/metric-collector/internal/config/agent"
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
 	"github.com/ry461ch/metric-collector/pkg/rsa"
@@ -20,6 +22,9 @@ type Agent struct {
 	metricSender    *sender.Sender
 	metricCollector *collector.Collector
 	rsaEncypter     *rsa.RsaEncrypter
+	cryptoReload    int64
+	stats           *status.Stats
+	statusAddr      string
 }
 
 // Init Agent instance
@@ -36,10 +41,19 @@ func New(cfg *config.Config) *Agent {
 	localIP := GetLocalIP()
 	log.Printf("local IP: %s", localIP)
 
+	stats := status.New()
+	metricSender := sender.New(encrypter, rsaEncrypter, cfg, localIP)
+	metricSender.SetStats(stats)
+	metricCollector := collector.New(cfg.PollIntervalSec)
+	metricCollector.SetStats(stats)
+
 	return &Agent{
-		metricSender:    sender.New(encrypter, rsaEncrypter, cfg, localIP),
-		metricCollector: collector.New(cfg.PollIntervalSec),
+		metricSender:    metricSender,
+		metricCollector: metricCollector,
 		rsaEncypter:     rsaEncrypter,
+		cryptoReload:    cfg.CryptoReload,
+		stats:           stats,
+		statusAddr:      cfg.StatusAddr,
 	}
 }
 
@@ -49,15 +63,20 @@ func GetLocalIP() string {
 	if err != nil {
 		return ""
 	}
+	ipv6 := ""
 	for _, address := range addrs {
 		// check the address type and if it is not a loopback the display it
 		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil {
 				return ipnet.IP.To4().String()
 			}
+			// IPv6 используется, только если на хосте нет IPv4 адреса
+			if ipv6 == "" && ipnet.IP.IsGlobalUnicast() {
+				ipv6 = ipnet.IP.String()
+			}
 		}
 	}
-	return ""
+	return ipv6
 }
 
 // Run agent work
@@ -78,6 +97,20 @@ func (a *Agent) Run(ctx context.Context) {
 	senderCtx, senderCtxCancel := context.WithCancel(stopCtx)
 	defer senderCtxCancel()
 
+	if a.rsaEncypter != nil {
+		go a.rsaEncypter.Watch(stopCtx, time.Duration(a.cryptoReload)*time.Second, func(err error) {
+			if err != nil {
+				log.Printf("Can't reload public key: %s", err)
+				return
+			}
+			log.Printf("Reloaded public key %s", a.rsaEncypter.KeyID())
+		})
+	}
+
+	if a.statusAddr != "" {
+		go a.stats.Serve(stopCtx, a.statusAddr)
+	}
+
 	metricChannel := a.metricCollector.CollectMetricsGenerator(collectorCtx)
 
 	go func() {
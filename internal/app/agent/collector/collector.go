@@ -12,12 +12,14 @@ import (
 	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
 
+	"github.com/ry461ch/metric-collector/internal/app/agent/status"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 )
 
 // Collector для сбора метрик
 type Collector struct {
 	pollIntervalSec int64
+	stats           *status.Stats
 }
 
 // Init Metric Collector
@@ -27,7 +29,28 @@ func New(pollIntervalSec int64) *Collector {
 	}
 }
 
-func collectRuntimeMetrics(ctx context.Context, metricChannel chan<- metrics.Metric) {
+// Учет длительности сбора и потерянных метрик в статистике агента
+func (c *Collector) SetStats(stats *status.Stats) {
+	c.stats = stats
+}
+
+// Запись метрик в канал. Метрики, которые не попали в канал до отмены
+// контекста, учитываются как потерянные, а приращения counter'ов агента из них
+// отправляются в следующий раз
+func pushMetrics(ctx context.Context, metricChannel chan<- metrics.Metric, metricList []metrics.Metric, stats *status.Stats) bool {
+	for idx, metric := range metricList {
+		select {
+		case <-ctx.Done():
+			stats.Dropped(int64(len(metricList) - idx))
+			stats.Undelivered(metricList[idx:])
+			return false
+		case metricChannel <- metric:
+		}
+	}
+	return true
+}
+
+func collectRuntimeMetrics(ctx context.Context, metricChannel chan<- metrics.Metric, stats *status.Stats) {
 	log.Println("Trying to collect runtime metrics")
 	var rtm runtime.MemStats
 	runtime.ReadMemStats(&rtm)
@@ -65,37 +88,29 @@ func collectRuntimeMetrics(ctx context.Context, metricChannel chan<- metrics.Met
 	metricCounterMap := map[string]int64{}
 	metricCounterMap["PollCount"] = 1
 
+	metricList := make([]metrics.Metric, 0, len(metricGaugeMap)+len(metricCounterMap))
 	for key, val := range metricGaugeMap {
-		metric := metrics.Metric{
+		metricList = append(metricList, metrics.Metric{
 			ID:    key,
 			MType: "gauge",
 			Value: &val,
-		}
-		select {
-		case <-ctx.Done():
-			return
-		case metricChannel <- metric:
-			continue
-		}
+		})
 	}
 	for key, val := range metricCounterMap {
-		metric := metrics.Metric{
+		metricList = append(metricList, metrics.Metric{
 			ID:    key,
 			MType: "counter",
 			Delta: &val,
-		}
-		select {
-		case <-ctx.Done():
-			return
-		case metricChannel <- metric:
-			continue
-		}
+		})
+	}
+	if !pushMetrics(ctx, metricChannel, metricList, stats) {
+		return
 	}
 
 	log.Println("Successfully got all runtime metrics")
 }
 
-func collectGopsutilMetrics(ctx context.Context, metricChannel chan<- metrics.Metric) {
+func collectGopsutilMetrics(ctx context.Context, metricChannel chan<- metrics.Metric, stats *status.Stats) {
 	log.Println("Trying to collect gopsutil metrics")
 	virtualMemory, _ := mem.VirtualMemory()
 
@@ -109,39 +124,44 @@ func collectGopsutilMetrics(ctx context.Context, metricChannel chan<- metrics.Me
 		metricGaugeMap[fmt.Sprintf("CPUutilization%d", idx+1)] = val
 	}
 
+	metricList := make([]metrics.Metric, 0, len(metricGaugeMap))
 	for key, val := range metricGaugeMap {
-		metric := metrics.Metric{
+		metricList = append(metricList, metrics.Metric{
 			ID:    key,
 			MType: "gauge",
 			Value: &val,
-		}
-		select {
-		case <-ctx.Done():
-			return
-		case metricChannel <- metric:
-			continue
-		}
+		})
+	}
+	if !pushMetrics(ctx, metricChannel, metricList, stats) {
+		return
 	}
 
 	log.Println("Successfully got all gopsutil metrics")
 }
 
-func collectMetrics(ctx context.Context, metricChannel chan<- metrics.Metric) {
+// Сбор метрик из всех источников. Если задана статистика агента, после сбора
+// в канал попадают и метрики самого агента
+func collectMetrics(ctx context.Context, metricChannel chan<- metrics.Metric, stats *status.Stats) {
 	log.Println("Trying to collect metrics")
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
-		collectRuntimeMetrics(ctx, metricChannel)
+		start := time.Now()
+		collectRuntimeMetrics(ctx, metricChannel, stats)
+		stats.Collected("runtime", time.Since(start))
 		wg.Done()
 	}()
 	go func() {
-		collectGopsutilMetrics(ctx, metricChannel)
+		start := time.Now()
+		collectGopsutilMetrics(ctx, metricChannel, stats)
+		stats.Collected("gopsutil", time.Since(start))
 		wg.Done()
 	}()
 
 	wg.Wait()
+	pushMetrics(ctx, metricChannel, stats.Metrics(), stats)
 	log.Println("Successfully collect all metrics")
 }
 
@@ -154,7 +174,7 @@ func (c *Collector) run(ctx context.Context, metricChannel chan<- metrics.Metric
 		default:
 		}
 		collectCtx, collectCtxCancel := context.WithTimeout(ctx, 3*time.Second)
-		collectMetrics(collectCtx, metricChannel)
+		collectMetrics(collectCtx, metricChannel, c.stats)
 		collectCtxCancel()
 		time.Sleep(time.Duration(c.pollIntervalSec) * time.Second)
 	}
@@ -163,6 +183,7 @@ func (c *Collector) run(ctx context.Context, metricChannel chan<- metrics.Metric
 // Creates channel and run goroutine for collecting metrics
 func (c *Collector) CollectMetricsGenerator(ctx context.Context) chan metrics.Metric {
 	metricChannel := make(chan metrics.Metric, 10000)
+	c.stats.SetQueue(metricChannel)
 
 	go func() {
 		defer close(metricChannel)
@@ -2,11 +2,13 @@ package collector
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/ry461ch/metric-collector/internal/app/agent/status"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 )
 
@@ -30,12 +32,39 @@ func TestCollectMetric(t *testing.T) {
 	}
 }
 
+func TestCollectStats(t *testing.T) {
+	stats := status.New()
+	metricChannel := make(chan metrics.Metric, 100)
+	collectMetrics(context.TODO(), metricChannel, stats)
+	close(metricChannel)
+
+	agentMetrics := 0
+	for metric := range metricChannel {
+		if strings.HasPrefix(metric.ID, status.Prefix) {
+			agentMetrics++
+		}
+	}
+	assert.Less(t, 0, agentMetrics, "Метрики агента должны отправляться вместе с остальными")
+	snapshot := stats.Snapshot()
+	assert.Contains(t, snapshot.CollectDurations, "runtime")
+	assert.Contains(t, snapshot.CollectDurations, "gopsutil")
+}
+
+func TestDroppedMetrics(t *testing.T) {
+	stats := status.New()
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	metricChannel := make(chan metrics.Metric)
+	pushMetrics(ctx, metricChannel, make([]metrics.Metric, 3), stats)
+	assert.Equal(t, int64(3), stats.Snapshot().Dropped)
+}
+
 func BenchmarkCollectMetric(b *testing.B) {
 	metricChannel := make(chan metrics.Metric, 100)
 	defer close(metricChannel)
 
 	for i := 0; i < b.N; i++ {
-		collectMetrics(context.TODO(), metricChannel)
+		collectMetrics(context.TODO(), metricChannel, nil)
 
 		b.StopTimer()
 		collectedMetrics := 0
@@ -3,24 +3,35 @@ package sender
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
 	"gopkg.in/resty.v1"
 
+	"github.com/ry461ch/metric-collector/internal/app/agent/status"
 	config "github.com/ry461ch/metric-collector/internal/config/agent"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 	pb "github.com/ry461ch/metric-collector/internal/proto"
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	encryptmiddleware "github.com/ry461ch/metric-collector/pkg/encrypt/middleware"
 	ipcheckermiddleware "github.com/ry461ch/metric-collector/pkg/ipchecker/middleware"
 	"github.com/ry461ch/metric-collector/pkg/rsa"
 	rsamiddleware "github.com/ry461ch/metric-collector/pkg/rsa/middleware"
+	tokensmiddleware "github.com/ry461ch/metric-collector/pkg/tokens/middleware"
 )
 
 // Sender для отправки метрик на сервер
@@ -29,6 +40,26 @@ type Sender struct {
 	encrypter    *encrypt.Encrypter
 	rsaEncrypter *rsa.RsaEncrypter
 	ip           string
+	stats        *status.Stats
+}
+
+// Задержки между повторами отправки пачки по grpc
+var grpcRetryDelays = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+// Коды ответа grpc, при которых пачку можно отправить повторно
+var retryableCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted}
+
+// Случайный идентификатор пачки
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Контекст стрима с идентификатором пачки. Сервер не применяет повторно
+// пачку с тем же идентификатором
+func (s *Sender) batchContext(ctx context.Context, batchID string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-batch-id", batchID)
 }
 
 func (s *Sender) convert(m *metrics.Metric) *pb.Metric {
@@ -69,66 +100,210 @@ func New(encrypter *encrypt.Encrypter, rsaEncrypter *rsa.RsaEncrypter, cfg *conf
 	}
 }
 
-func (s *Sender) sendGRPCMetricsWorker(ctx context.Context, metricChannel <-chan metrics.Metric) func() error {
-	return func() error {
-		var interceptors []grpc.StreamClientInterceptor
-		if s.ip != "" {
-			interceptors = append(interceptors, ipcheckermiddleware.SetIPGRPCClientStreamInterceptor(s.ip))
+// Учет отправок по воркерам и потерянных метрик в статистике агента
+func (s *Sender) SetStats(stats *status.Stats) {
+	s.stats = stats
+}
+
+// Подключение к grpc серверу
+func (s *Sender) dialGRPC() (*grpc.ClientConn, error) {
+	var interceptors []grpc.StreamClientInterceptor
+	if s.ip != "" {
+		interceptors = append(interceptors, ipcheckermiddleware.SetIPGRPCClientStreamInterceptor(s.ip))
+	}
+	if s.cfg.Token != "" {
+		interceptors = append(interceptors, tokensmiddleware.SetTokenGRPCClientStreamInterceptor(s.cfg.Token))
+	}
+	if s.encrypter != nil && !s.cfg.LegacySignature {
+		interceptors = append(interceptors, encryptmiddleware.SignStreamClientInterceptor(s.encrypter))
+	}
+	if s.rsaEncrypter != nil {
+		interceptors = append(interceptors, rsamiddleware.EncryptStreamClientInterceptor(s.rsaEncrypter))
+	}
+	return grpc.NewClient(s.cfg.GRPCAddr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithChainStreamInterceptor(interceptors...))
+}
+
+// Подпись запроса по методу, пути с query, времени и nonce. Каждая попытка отправки
+// подписывается заново, чтобы сервер не отклонил повтор как перехваченный запрос
+func (s *Sender) signRequest(restyRequest *resty.Request, method string, requestURI string, body []byte) {
+	if s.encrypter == nil || s.cfg.LegacySignature {
+		return
+	}
+	timestamp := time.Now().Unix()
+	nonce := newID()
+	restyRequest.SetHeader("X-Timestamp", strconv.FormatInt(timestamp, 10)).SetHeader("X-Nonce", nonce)
+	restyRequest.SetHeader("HashSHA256", fmt.Sprintf("%x", s.encrypter.SignRequest(method, requestURI, timestamp, nonce, body)))
+}
+
+// Отправка пачки метрик по http с повторами. Повторы идут с тем же
+// идентификатором пачки, поэтому сервер применяет пачку один раз
+func (s *Sender) postHTTP(ctx context.Context, client *resty.Client, batchID string, metricList []metrics.Metric) error {
+	requestURL, err := url.Parse("http://" + s.cfg.Addr.Host + ":" + strconv.FormatInt(s.cfg.Addr.Port, 10) + "/updates/")
+	if err != nil {
+		return fmt.Errorf("invalid server address")
+	}
+
+	reqBody, err := json.Marshal(metricList)
+	if err != nil {
+		return fmt.Errorf("can't convert model Metric to json")
+	}
+
+	restyRequest := client.R().SetContext(ctx).SetHeader("Content-Type", "application/json")
+	restyRequest.SetHeader("X-Batch-ID", batchID)
+	if s.ip != "" {
+		restyRequest.SetHeader("X-Real-IP", s.ip)
+	}
+	if s.cfg.Token != "" {
+		restyRequest.SetHeader("Authorization", "Bearer "+s.cfg.Token)
+	}
+	if s.encrypter != nil && s.cfg.LegacySignature {
+		reqBodyHash := s.encrypter.EncryptMessage(reqBody)
+		restyRequest.SetHeader("HashSHA256", fmt.Sprintf("%x", reqBodyHash))
+	}
+	// подпись по времени и nonce покрывает тело в том виде, в котором его
+	// получит сервер
+	sentBody := reqBody
+	if s.rsaEncrypter != nil {
+		rsaBody, encryptErr := s.rsaEncrypter.Encrypt(reqBody)
+		if encryptErr != nil {
+			return fmt.Errorf("can't encrypt body")
 		}
-		if s.rsaEncrypter != nil {
-			interceptors = append(interceptors, rsamiddleware.EncryptStreamClientInterceptor(s.rsaEncrypter))
+		sentBody = rsaBody
+		restyRequest.SetHeader(rsamiddleware.KeyIDHeader, s.rsaEncrypter.KeyID())
+	}
+	restyRequest.SetBody(sentBody)
+
+	var resp *resty.Response
+	err = resty.Backoff(func() (*resty.Response, error) {
+		s.signRequest(restyRequest, http.MethodPost, requestURL.RequestURI(), sentBody)
+		resp, err = restyRequest.Post(requestURL.String())
+		return resp, err
+	}, resty.Retries(4), resty.WaitTime(1), resty.MaxWaitTime(5))
+	if err != nil {
+		log.Println("Server is not available")
+		return fmt.Errorf("server is not available")
+	}
+	if resp.IsError() {
+		return fmt.Errorf("server responded with status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// Перевод пачки в сообщения grpc. Метрики, которые не удалось перевести,
+// пропускаются
+func (s *Sender) convertBatch(metricList []metrics.Metric) []*pb.Metric {
+	converted := make([]*pb.Metric, 0, len(metricList))
+	for _, metric := range metricList {
+		metricForSend := s.convert(&metric)
+		if metricForSend == nil {
+			log.Println("Can't convert metric")
+			s.stats.Dropped(1)
+			continue
 		}
-		conn, err := grpc.NewClient(":3200", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithChainStreamInterceptor(interceptors...))
-		if err != nil {
-			return fmt.Errorf("server is not available")
+		converted = append(converted, metricForSend)
+	}
+	return converted
+}
+
+// Отправка пачки одним стримом. Пачка принята, только если сервер ответил
+// без ошибки на CloseAndRecv
+func (s *Sender) postGRPCStream(ctx context.Context, client pb.MetricsClient, batchID string, metricList []*pb.Metric) error {
+	stream, err := client.PostMetrics(s.batchContext(ctx, batchID))
+	if err != nil {
+		return err
+	}
+	for _, metric := range metricList {
+		if err := stream.Send(metric); err != nil {
+			// причину, по которой сервер закрыл стрим, возвращает CloseAndRecv
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// Отправка пачки по grpc с повторами. Повторы идут с тем же идентификатором
+// пачки, поэтому сервер применяет пачку один раз
+func (s *Sender) sendGRPCBatch(ctx context.Context, client pb.MetricsClient, batchID string, metricList []*pb.Metric) error {
+	for attempt := 0; ; attempt++ {
+		err := s.postGRPCStream(ctx, client, batchID, metricList)
+		if err == nil || attempt == len(grpcRetryDelays) || !slices.Contains(retryableCodes, grpcstatus.Code(err)) {
+			return err
+		}
+		log.Printf("Error while sending batch %s, retrying: %s", batchID, err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(grpcRetryDelays[attempt]):
 		}
+	}
+}
+
+// Отправка пачки метрик по grpc одним стримом с ожиданием подтверждения сервера
+func (s *Sender) postGRPC(ctx context.Context, batchID string, metricList []metrics.Metric) error {
+	conn, err := s.dialGRPC()
+	if err != nil {
+		return fmt.Errorf("server is not available")
+	}
+	defer conn.Close()
 
-		client := pb.NewMetricsClient(conn)
+	return s.sendGRPCBatch(ctx, pb.NewMetricsClient(conn), batchID, s.convertBatch(metricList))
+}
 
-		var stream grpc.ClientStreamingClient[pb.Metric, pb.EmptyObject]
-		isStreamOpened := false
+// Отправка пачки метрик на сервер. В отличие от Run возвращает ошибку,
+// если сервер не подтвердил прием пачки. Пачка, повторно отправленная с тем же
+// batchID, применяется сервером один раз
+func (s *Sender) SendBatch(ctx context.Context, batchID string, metricList []metrics.Metric) error {
+	if s.cfg.UseGRPC {
+		return s.postGRPC(ctx, batchID, metricList)
+	}
+	return s.postHTTP(ctx, resty.New(), batchID, metricList)
+}
 
+// Воркер отправки по grpc: забирает накопленные в канале метрики и отправляет
+// их одной пачкой. Метрики учитываются как отправленные только после
+// подтверждения сервера
+func (s *Sender) sendGRPCMetricsWorker(ctx context.Context, worker int, metricChannel <-chan metrics.Metric) func() error {
+	return func() error {
+		var metricList []metrics.Metric
+	collect:
 		for {
 			select {
 			case <-ctx.Done():
-				err = stream.CloseSend()
-				return err
+				break collect
 			case metric := <-metricChannel:
-				if !isStreamOpened {
-					stream, err = client.PostMetrics(ctx)
-					if err != nil {
-						return fmt.Errorf("can't open stream for posting metrics")
-					}
-					isStreamOpened = true
-				}
-				metricForSend := s.convert(&metric)
-				if metricForSend == nil {
-					log.Println("Can't convert metric")
-					break
-				}
-				err = stream.Send(metricForSend)
-				if err != nil {
-					log.Printf("Error while sending metric: %s", err.Error())
-				}
+				metricList = append(metricList, metric)
 			default:
-				if !isStreamOpened {
-					break
-				}
-				isStreamOpened = false
-				err = stream.CloseSend()
-				if err != nil {
-					log.Println("Error while closing stream")
-					return err
-				}
+				break collect
 			}
 		}
+		batch := s.convertBatch(metricList)
+		if len(batch) == 0 {
+			return nil
+		}
+
+		conn, err := s.dialGRPC()
+		if err != nil {
+			s.stats.Failed(worker, int64(len(batch)))
+			s.stats.Undelivered(metricList)
+			return fmt.Errorf("server is not available")
+		}
+		defer conn.Close()
+
+		if err := s.sendGRPCBatch(ctx, pb.NewMetricsClient(conn), newID(), batch); err != nil {
+			log.Printf("Error while sending metrics: %s", err.Error())
+			s.stats.Failed(worker, int64(len(batch)))
+			s.stats.Undelivered(metricList)
+			return err
+		}
+		s.stats.Sent(worker, int64(len(batch)))
+		s.stats.Delivered(metricList)
+		return nil
 	}
 }
 
-func (s *Sender) sendHTTPMetricsWorker(ctx context.Context, metricChannel <-chan metrics.Metric) func() error {
+func (s *Sender) sendHTTPMetricsWorker(ctx context.Context, worker int, metricChannel <-chan metrics.Metric) func() error {
 	return func() error {
-		serverURL := "http://" + s.cfg.Addr.Host + ":" + strconv.FormatInt(s.cfg.Addr.Port, 10)
-
 		client := resty.New()
 		for {
 			select {
@@ -136,38 +311,13 @@ func (s *Sender) sendHTTPMetricsWorker(ctx context.Context, metricChannel <-chan
 				return nil
 			case metric := <-metricChannel:
 				metricList := []metrics.Metric{metric}
-
-				reqBody, err := json.Marshal(metricList)
-				if err != nil {
-					return fmt.Errorf("can't convert model Metric to json")
-				}
-
-				restyRequest := client.R().SetHeader("Content-Type", "application/json")
-				if s.ip != "" {
-					restyRequest.SetHeader("X-Real-IP", s.ip)
-				}
-				if s.encrypter != nil {
-					reqBodyHash := s.encrypter.EncryptMessage(reqBody)
-					restyRequest.SetHeader("HashSHA256", fmt.Sprintf("%x", reqBodyHash))
-				}
-				if s.rsaEncrypter != nil {
-					rsaBody, encryptErr := s.rsaEncrypter.Encrypt(reqBody)
-					if encryptErr != nil {
-						return fmt.Errorf("can't encrypt body")
-					}
-					restyRequest.SetBody(rsaBody)
-				} else {
-					restyRequest.SetBody(reqBody)
-				}
-
-				err = resty.Backoff(func() (*resty.Response, error) {
-					return restyRequest.Post(serverURL + "/updates/")
-				}, resty.Retries(4), resty.WaitTime(1), resty.MaxWaitTime(5))
-
-				if err != nil {
-					log.Println("Server is not available")
-					return fmt.Errorf("server is not available")
+				if err := s.postHTTP(ctx, client, newID(), metricList); err != nil {
+					s.stats.Failed(worker, 1)
+					s.stats.Undelivered(metricList)
+					return err
 				}
+				s.stats.Sent(worker, 1)
+				s.stats.Delivered(metricList)
 			default:
 				return nil
 			}
@@ -182,9 +332,9 @@ func (s *Sender) sendMetrics(ctx context.Context, metricChannel <-chan metrics.M
 
 	for w := 0; w < int(s.cfg.RateLimit); w++ {
 		if s.cfg.UseGRPC {
-			wg.Go(s.sendGRPCMetricsWorker(ctx, metricChannel))
+			wg.Go(s.sendGRPCMetricsWorker(ctx, w, metricChannel))
 		} else {
-			wg.Go(s.sendHTTPMetricsWorker(ctx, metricChannel))
+			wg.Go(s.sendHTTPMetricsWorker(ctx, w, metricChannel))
 		}
 	}
 
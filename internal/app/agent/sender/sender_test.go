@@ -8,6 +8,8 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -19,11 +21,19 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
 
+	"github.com/ry461ch/metric-collector/internal/app/agent/status"
 	config "github.com/ry461ch/metric-collector/internal/config/agent"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 	"github.com/ry461ch/metric-collector/internal/models/netaddr"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	encryptmiddleware "github.com/ry461ch/metric-collector/pkg/encrypt/middleware"
 	rsacomponent "github.com/ry461ch/metric-collector/pkg/rsa"
 	"github.com/ry461ch/metric-collector/pkg/rsa/middleware"
 )
@@ -175,6 +185,8 @@ func TestSendMetric(t *testing.T) {
 		&config.Config{Addr: *splitURL(srv.URL), RateLimit: 2, ReportIntervalSec: 1},
 		"127.0.0.1",
 	)
+	stats := status.New()
+	sender.SetStats(stats)
 
 	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*1)
 	defer cancel()
@@ -182,10 +194,84 @@ func TestSendMetric(t *testing.T) {
 	time.Sleep(time.Second)
 
 	assert.Equal(t, int64(5), serverStorage.timesCalled, "Не прошел запрос на сервер")
+	sent := int64(0)
+	for _, worker := range stats.Snapshot().Workers {
+		sent += worker.Sent
+	}
+	assert.Equal(t, int64(5), sent, "Отправки должны учитываться по воркерам")
 	assert.Equal(t, float64(10.0), serverStorage.metricsGauge["test_3"], "Неправильно записалась метрика в хранилище")
 	assert.Equal(t, int64(10), serverStorage.metricsCounter["test_1"], "Неправильно записалась метрика в хранилище")
 }
 
+func TestSendBatch(t *testing.T) {
+	serverStorage := MockServerStorage{}
+	srv := httptest.NewServer(serverStorage.mockRouter(nil))
+	defer srv.Close()
+
+	delta := int64(12)
+	value := 0.5
+	metricList := []metrics.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "HeapInuse", MType: "gauge", Value: &value},
+	}
+
+	sender := New(encrypt.New("test"), nil, &config.Config{Addr: *splitURL(srv.URL)}, "")
+	assert.NoError(t, sender.SendBatch(context.TODO(), "1", metricList))
+	assert.Equal(t, int64(1), serverStorage.timesCalled, "Пачка должна уйти одним запросом")
+	assert.Equal(t, int64(12), serverStorage.metricsCounter["PollCount"])
+	assert.Equal(t, 0.5, serverStorage.metricsGauge["HeapInuse"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	sender = New(nil, nil, &config.Config{Addr: *splitURL(failing.URL)}, "")
+	assert.Error(t, sender.SendBatch(context.TODO(), "1", metricList), "Ошибка сервера должна вернуться вызывающему")
+}
+
+func TestBatchHeaders(t *testing.T) {
+	var batchIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		batchIDs = append(batchIDs, req.Header.Get("X-Batch-ID"))
+		if len(batchIDs) == 1 {
+			// обрыв соединения, запрос будет повторен
+			conn, _, _ := res.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	delta := int64(1)
+	metricList := []metrics.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}}
+	sender := New(nil, nil, &config.Config{Addr: *splitURL(srv.URL)}, "")
+	assert.NoError(t, sender.SendBatch(context.TODO(), "1", metricList))
+	assert.NoError(t, sender.SendBatch(context.TODO(), "2", metricList))
+
+	assert.Equal(t, []string{"1", "1", "2"}, batchIDs, "Повтор запроса должен идти с тем же идентификатором пачки")
+}
+
+func TestSignedBatch(t *testing.T) {
+	serverStorage := MockServerStorage{}
+	router := chi.NewRouter()
+	router.Use(encryptmiddleware.CheckRequestAndEncryptResponse(encrypt.New("test"), encrypt.NewReplayGuard(time.Minute, false)))
+	router.Post("/*", serverStorage.handler)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	delta := int64(3)
+	metricList := []metrics.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}}
+
+	sender := New(encrypt.New("test"), nil, &config.Config{Addr: *splitURL(srv.URL)}, "")
+	assert.NoError(t, sender.SendBatch(context.TODO(), "1", metricList))
+	assert.NoError(t, sender.SendBatch(context.TODO(), "2", metricList), "Каждая пачка должна подписываться новым nonce")
+	assert.Equal(t, int64(2), serverStorage.timesCalled)
+
+	legacySender := New(encrypt.New("test"), nil, &config.Config{Addr: *splitURL(srv.URL), LegacySignature: true}, "")
+	assert.Error(t, legacySender.SendBatch(context.TODO(), "1", metricList), "Подпись только по телу должна отклоняться")
+}
+
 func BenchmarkSendMetric(b *testing.B) {
 	testCounterValue := int64(10)
 	testGaugeValue := float64(10.0)
@@ -219,3 +305,60 @@ func BenchmarkSendMetric(b *testing.B) {
 		sender.sendMetrics(context.TODO(), metricChannel)
 	}
 }
+
+// Grpc сервер, который отклоняет первую попытку отправки пачки как недоступный
+type flakyMetricsServer struct {
+	pb.UnimplementedMetricsServer
+	mu       sync.Mutex
+	batchIDs []string
+	received int
+}
+
+func (fms *flakyMetricsServer) PostMetrics(stream grpc.ClientStreamingServer[pb.Metric, pb.EmptyObject]) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	fms.mu.Lock()
+	fms.batchIDs = append(fms.batchIDs, md.Get("x-batch-id")[0])
+	attempt := len(fms.batchIDs)
+	fms.mu.Unlock()
+	if attempt == 1 {
+		return grpcstatus.Error(codes.Unavailable, "storage unavailable")
+	}
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.EmptyObject{})
+		}
+		if err != nil {
+			return err
+		}
+		fms.mu.Lock()
+		fms.received++
+		fms.mu.Unlock()
+	}
+}
+
+func TestGRPCWorkerRetry(t *testing.T) {
+	grpcRetryDelays = []time.Duration{time.Millisecond}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	metricsServer := &flakyMetricsServer{}
+	server := grpc.NewServer()
+	pb.RegisterMetricsServer(server, metricsServer)
+	go server.Serve(listen)
+	defer server.Stop()
+
+	stats := status.New()
+	sender := New(nil, nil, &config.Config{GRPCAddr: listen.Addr().String(), UseGRPC: true, RateLimit: 1}, "")
+	sender.SetStats(stats)
+
+	delta := int64(1)
+	metricChannel := make(chan metrics.Metric, 2)
+	metricChannel <- metrics.Metric{ID: "PollCount", MType: "counter", Delta: &delta}
+	metricChannel <- metrics.Metric{ID: "PollCount", MType: "counter", Delta: &delta}
+	assert.NoError(t, sender.sendGRPCMetricsWorker(context.Background(), 0, metricChannel)())
+
+	require.Len(t, metricsServer.batchIDs, 2)
+	assert.Equal(t, metricsServer.batchIDs[0], metricsServer.batchIDs[1], "Повтор должен идти с тем же идентификатором пачки")
+	assert.Equal(t, 2, metricsServer.received)
+	assert.Equal(t, int64(2), stats.Snapshot().Workers[0].Sent, "Метрики учитываются после подтверждения сервера")
+}
@@ -0,0 +1,264 @@
+// Module for agent self-instrumentation and status endpoint
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+)
+
+// Префикс имен метрик агента, которые отправляются на сервер
+const Prefix = "agent_"
+
+// Статистика отправки одного воркера
+type WorkerStats struct {
+	Worker int   `json:"worker"`
+	Sent   int64 `json:"sent"`
+	Failed int64 `json:"failed"`
+}
+
+// Состояние агента для статусной ручки
+type Snapshot struct {
+	QueueDepth       int                `json:"queue_depth"`
+	QueueCapacity    int                `json:"queue_capacity"`
+	Workers          []WorkerStats      `json:"workers"`
+	LastSend         *time.Time         `json:"last_send,omitempty"`
+	Dropped          int64              `json:"dropped"`
+	CollectDurations map[string]float64 `json:"collect_duration_ms"`
+}
+
+// Статистика работы агента: очередь метрик, отправки по воркерам, потерянные
+// метрики и длительность сбора по источникам. Методы можно вызывать у nil
+type Stats struct {
+	mu        sync.Mutex
+	queue     func() (int, int)
+	workers   map[int]*WorkerStats
+	lastSend  time.Time
+	dropped   int64
+	durations map[string]time.Duration
+	reported  map[string]int64 // приращения counter'ов, доставленные на сервер
+	pending   map[string]int64 // приращения counter'ов, которые еще отправляются
+}
+
+// Init stats
+func New() *Stats {
+	return &Stats{
+		queue: func() (int, int) {
+			return 0, 0
+		},
+		workers:   map[int]*WorkerStats{},
+		durations: map[string]time.Duration{},
+		reported:  map[string]int64{},
+		pending:   map[string]int64{},
+	}
+}
+
+// Канал метрик, глубина которого показывается как очередь
+func (s *Stats) SetQueue(queue <-chan metrics.Metric) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = func() (int, int) {
+		return len(queue), cap(queue)
+	}
+}
+
+func (s *Stats) worker(worker int) *WorkerStats {
+	stats, ok := s.workers[worker]
+	if !ok {
+		stats = &WorkerStats{Worker: worker}
+		s.workers[worker] = stats
+	}
+	return stats
+}
+
+// Учет успешно отправленных воркером метрик
+func (s *Stats) Sent(worker int, count int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.worker(worker).Sent += count
+	s.lastSend = time.Now()
+}
+
+// Учет метрик, которые воркер не смог отправить. Такие метрики потеряны
+func (s *Stats) Failed(worker int, count int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.worker(worker).Failed += count
+	s.dropped += count
+}
+
+// Учет метрик, которые не попали в очередь или не были отправлены
+func (s *Stats) Dropped(count int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped += count
+}
+
+// Длительность последнего сбора метрик из источника
+func (s *Stats) Collected(source string, duration time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.durations[source] = duration
+}
+
+// Текущее состояние агента
+func (s *Stats) Snapshot() Snapshot {
+	if s == nil {
+		return Snapshot{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := Snapshot{
+		Workers:          make([]WorkerStats, 0, len(s.workers)),
+		Dropped:          s.dropped,
+		CollectDurations: make(map[string]float64, len(s.durations)),
+	}
+	snapshot.QueueDepth, snapshot.QueueCapacity = s.queue()
+	for _, stats := range s.workers {
+		snapshot.Workers = append(snapshot.Workers, *stats)
+	}
+	sort.Slice(snapshot.Workers, func(i, j int) bool {
+		return snapshot.Workers[i].Worker < snapshot.Workers[j].Worker
+	})
+	if !s.lastSend.IsZero() {
+		lastSend := s.lastSend
+		snapshot.LastSend = &lastSend
+	}
+	for source, duration := range s.durations {
+		snapshot.CollectDurations[source] = float64(duration.Microseconds()) / 1000
+	}
+	return snapshot
+}
+
+// Метрики агента для отправки на сервер. Counter'ы отправляются приращениями,
+// которые еще не доставлены и не отправляются, counter'ы без приращения пропускаются.
+// Отправитель подтверждает доставку через Delivered или возвращает приращения через Undelivered
+func (s *Stats) Metrics() []metrics.Metric {
+	if s == nil {
+		return nil
+	}
+	snapshot := s.Snapshot()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []metrics.Metric
+	gauge := func(name string, value float64) {
+		res = append(res, metrics.Metric{ID: Prefix + name, MType: "gauge", Value: &value})
+	}
+	counter := func(name string, total int64) {
+		delta := total - s.reported[name] - s.pending[name]
+		if delta == 0 {
+			return
+		}
+		s.pending[name] += delta
+		res = append(res, metrics.Metric{ID: Prefix + name, MType: "counter", Delta: &delta})
+	}
+
+	gauge("queue_depth", float64(snapshot.QueueDepth))
+	if snapshot.LastSend != nil {
+		gauge("last_send_timestamp", float64(snapshot.LastSend.Unix()))
+	}
+	for source, duration := range snapshot.CollectDurations {
+		gauge("collect_duration_ms_"+source, duration)
+	}
+	counter("dropped", snapshot.Dropped)
+	for _, worker := range snapshot.Workers {
+		counter("sent_worker_"+strconv.Itoa(worker.Worker), worker.Sent)
+		counter("failed_worker_"+strconv.Itoa(worker.Worker), worker.Failed)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Приращения counter'ов агента из отправленных метрик. Остальные метрики пропускаются
+func (s *Stats) counterDeltas(metricList []metrics.Metric, apply func(name string, delta int64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, metric := range metricList {
+		if metric.MType != "counter" || metric.Delta == nil || !strings.HasPrefix(metric.ID, Prefix) {
+			continue
+		}
+		apply(strings.TrimPrefix(metric.ID, Prefix), *metric.Delta)
+	}
+}
+
+// Подтверждение доставки метрик на сервер: приращения counter'ов агента
+// больше не отправляются
+func (s *Stats) Delivered(metricList []metrics.Metric) {
+	if s == nil {
+		return
+	}
+	s.counterDeltas(metricList, func(name string, delta int64) {
+		s.pending[name] -= delta
+		s.reported[name] += delta
+	})
+}
+
+// Метрики не доставлены на сервер: приращения counter'ов агента будут
+// отправлены снова при следующем вызове Metrics
+func (s *Stats) Undelivered(metricList []metrics.Metric) {
+	if s == nil {
+		return
+	}
+	s.counterDeltas(metricList, func(name string, delta int64) {
+		s.pending[name] -= delta
+	})
+}
+
+// Статусная ручка агента: GET /status отдает состояние агента в json
+func (s *Stats) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		resp, err := json.Marshal(s.Snapshot())
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write(resp)
+	})
+	return mux
+}
+
+// Запуск статусного сервера до отмены контекста
+func (s *Stats) Serve(ctx context.Context, addr string) {
+	server := &http.Server{Addr: addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Printf("Status endpoint is running: %s", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Status endpoint failed: %s", err)
+	}
+}
@@ -0,0 +1,100 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+)
+
+func metricsByID(metricList []metrics.Metric) map[string]metrics.Metric {
+	res := map[string]metrics.Metric{}
+	for _, metric := range metricList {
+		res[metric.ID] = metric
+	}
+	return res
+}
+
+func TestStats(t *testing.T) {
+	stats := New()
+	queue := make(chan metrics.Metric, 10)
+	queue <- metrics.Metric{}
+	stats.SetQueue(queue)
+	stats.Sent(1, 3)
+	stats.Sent(0, 2)
+	stats.Failed(1, 1)
+	stats.Dropped(2)
+	stats.Collected("runtime", 1500*time.Microsecond)
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, 1, snapshot.QueueDepth)
+	assert.Equal(t, 10, snapshot.QueueCapacity)
+	assert.Equal(t, []WorkerStats{{Worker: 0, Sent: 2}, {Worker: 1, Sent: 3, Failed: 1}}, snapshot.Workers)
+	assert.Equal(t, int64(3), snapshot.Dropped, "неотправленные метрики тоже потеряны")
+	assert.Equal(t, 1.5, snapshot.CollectDurations["runtime"])
+	require.NotNil(t, snapshot.LastSend)
+
+	reported := metricsByID(stats.Metrics())
+	assert.Equal(t, 1.0, *reported["agent_queue_depth"].Value)
+	assert.Equal(t, 1.5, *reported["agent_collect_duration_ms_runtime"].Value)
+	assert.Equal(t, int64(3), *reported["agent_sent_worker_1"].Delta)
+	assert.Equal(t, int64(3), *reported["agent_dropped"].Delta)
+
+	stats.Sent(1, 1)
+	reported = metricsByID(stats.Metrics())
+	assert.Equal(t, int64(1), *reported["agent_sent_worker_1"].Delta, "counter'ы отправляются приращениями")
+	assert.NotContains(t, reported, "agent_dropped")
+}
+
+func TestDelivery(t *testing.T) {
+	stats := New()
+	stats.Dropped(2)
+
+	first := stats.Metrics()
+	assert.Equal(t, int64(2), *metricsByID(first)["agent_dropped"].Delta)
+	assert.NotContains(t, metricsByID(stats.Metrics()), "agent_dropped", "отправляемое приращение не повторяется")
+
+	stats.Undelivered(first)
+	stats.Dropped(1)
+	second := stats.Metrics()
+	assert.Equal(t, int64(3), *metricsByID(second)["agent_dropped"].Delta, "недоставленное приращение отправляется снова")
+
+	stats.Delivered(second)
+	assert.NotContains(t, metricsByID(stats.Metrics()), "agent_dropped", "доставленное приращение не отправляется снова")
+}
+
+func TestNilStats(t *testing.T) {
+	var stats *Stats
+	stats.Sent(0, 1)
+	stats.Failed(0, 1)
+	stats.Dropped(1)
+	stats.Collected("runtime", time.Second)
+	stats.Delivered(nil)
+	stats.Undelivered(nil)
+	assert.Nil(t, stats.Metrics())
+	assert.Equal(t, Snapshot{}, stats.Snapshot())
+}
+
+func TestHandler(t *testing.T) {
+	stats := New()
+	stats.Sent(0, 5)
+	handler := stats.Handler()
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	var snapshot Snapshot
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &snapshot))
+	assert.Equal(t, []WorkerStats{{Worker: 0, Sent: 5}}, snapshot.Workers)
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, res.Code)
+}
@@ -0,0 +1,353 @@
+// Module for partitioning metrics between servers of a cluster
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+// Dialer - подключение к узлу кластера по его адресу
+type Dialer func(addr string) (*grpc.ClientConn, error)
+
+// Узел кластера. Метрики распределяются между узлами по имени через кольцо
+// консистентного хеширования: запись и чтение одной метрики уходят узлу-владельцу,
+// выборки по всем метрикам рассылаются всем узлам и объединяются
+type Cluster struct {
+	Storage
+	self  string
+	ring  *Ring
+	peers map[string]pb.ClusterClient
+	conns []*grpc.ClientConn
+}
+
+// Init cluster node. self - адрес текущего узла из списка nodes
+func New(self string, nodes []string, storage Storage, dial Dialer) (*Cluster, error) {
+	if !slices.Contains(nodes, self) {
+		return nil, fmt.Errorf("node %q is not listed in cluster nodes", self)
+	}
+	c := &Cluster{
+		Storage: storage,
+		self:    self,
+		ring:    NewRing(nodes, DefaultVirtualNodes),
+		peers:   map[string]pb.ClusterClient{},
+	}
+	for _, node := range nodes {
+		if _, ok := c.peers[node]; ok || node == self {
+			continue
+		}
+		conn, err := dial(node)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("can't connect to cluster node %s: %w", node, err)
+		}
+		c.conns = append(c.conns, conn)
+		c.peers[node] = pb.NewClusterClient(conn)
+	}
+	return c, nil
+}
+
+// Закрытие подключений к остальным узлам
+func (c *Cluster) Close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// Проверка доступности локального хранилища
+func (c *Cluster) Ping(ctx context.Context) bool {
+	if storage, ok := c.Storage.(externalStorage); ok {
+		return storage.Ping(ctx)
+	}
+	return true
+}
+
+// Узел-владелец метрики
+func (c *Cluster) Owner(id string) string {
+	return c.ring.Owner(id)
+}
+
+// Принадлежит ли метрика или производное значение с именем id текущему узлу.
+// По владельцу выбирается единственный узел, который вычисляет правило
+func (c *Cluster) Owns(id string) bool {
+	return c.ring.Owner(id) == c.self
+}
+
+// Клиент узла-владельца метрики, nil если метрика принадлежит текущему узлу
+func (c *Cluster) peerFor(id string) pb.ClusterClient {
+	owner := c.ring.Owner(id)
+	if owner == c.self {
+		return nil
+	}
+	return c.peers[owner]
+}
+
+// Клиент узла-владельца метрики. Метрики неизвестного типа обрабатываются
+// локально, чтобы хранилище вернуло обычную ошибку валидации
+func (c *Cluster) peerForMetric(metric *metrics.Metric) pb.ClusterClient {
+	if metric.MType != "gauge" && metric.MType != "counter" {
+		return nil
+	}
+	return c.peerFor(metric.ID)
+}
+
+func validateMetric(metric metrics.Metric) error {
+	if metric.ID == "" {
+		return storageerrors.NewInvalidMetricError(metric.ID, "id is required")
+	}
+	switch {
+	case metric.MType == "gauge" && metric.Value == nil:
+		return storageerrors.NewInvalidMetricError(metric.ID, "value is required for gauge")
+	case metric.MType == "counter" && metric.Delta == nil:
+		return storageerrors.NewInvalidMetricError(metric.ID, "delta is required for counter")
+	case metric.MType != "gauge" && metric.MType != "counter":
+		return storageerrors.NewInvalidMetricError(metric.ID, "unknown type "+metric.MType)
+	}
+	return nil
+}
+
+// Сохранение пачки: пачка делится по владельцам, части пересылаются параллельно.
+// Пачка проверяется целиком до отправки, чтобы невалидная метрика не приводила к
+// частичной записи
+func (c *Cluster) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	_, err := c.save(ctx, nil, metricList)
+	return err
+}
+
+// Однократное сохранение пачки. Каждый владелец отсекает повторы своей части
+// пачки по тому же ключу; пачка считается примененной, если применена хоть одна часть
+func (c *Cluster) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	return c.save(ctx, &batch, metricList)
+}
+
+func (c *Cluster) save(ctx context.Context, batch *metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	parts := map[string][]metrics.Metric{}
+	for _, metric := range metricList {
+		if err := validateMetric(metric); err != nil {
+			return false, err
+		}
+		owner := c.ring.Owner(metric.ID)
+		parts[owner] = append(parts[owner], metric)
+	}
+
+	var applied atomic.Bool
+	eg, egCtx := errgroup.WithContext(ctx)
+	for owner, part := range parts {
+		owner, part := owner, part
+		eg.Go(func() error {
+			if owner == c.self {
+				if batch == nil {
+					applied.Store(true)
+					return c.Storage.SaveMetrics(egCtx, part)
+				}
+				partApplied, err := c.Storage.SaveBatch(egCtx, *batch, part)
+				if partApplied {
+					applied.Store(true)
+				}
+				return err
+			}
+			list := listToProto(part)
+			if batch != nil {
+				list.AgentId = batch.AgentID
+				list.BatchId = batch.BatchID
+			}
+			resp, err := c.peers[owner].SaveLocal(egCtx, list)
+			if err != nil {
+				return fromStatus(owner, "", err)
+			}
+			if resp.GetApplied() {
+				applied.Store(true)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return false, err
+	}
+	return applied.Load() || len(parts) == 0, nil
+}
+
+// Получение метрики с узла-владельца
+func (c *Cluster) GetMetric(ctx context.Context, metric *metrics.Metric) error {
+	peer := c.peerForMetric(metric)
+	if peer == nil {
+		return c.Storage.GetMetric(ctx, metric)
+	}
+	resp, err := peer.GetLocal(ctx, keyToProto(metric))
+	if err != nil {
+		return fromStatus(c.ring.Owner(metric.ID), metric.ID, err)
+	}
+	*metric = metricFromProto(resp)
+	return nil
+}
+
+// Удаление метрики на узле-владельце
+func (c *Cluster) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	peer := c.peerForMetric(metric)
+	if peer == nil {
+		return c.Storage.DeleteMetric(ctx, metric)
+	}
+	resp, err := peer.DeleteLocal(ctx, keyToProto(metric))
+	if err != nil {
+		return fromStatus(c.ring.Owner(metric.ID), metric.ID, err)
+	}
+	*metric = metricFromProto(resp)
+	return nil
+}
+
+// Сброс счетчика на узле-владельце
+func (c *Cluster) ResetCounter(ctx context.Context, id string) error {
+	peer := c.peerFor(id)
+	if peer == nil {
+		return c.Storage.ResetCounter(ctx, id)
+	}
+	_, err := peer.ResetLocal(ctx, &pb.ResetCounterRequest{Id: id})
+	return fromStatus(c.ring.Owner(id), id, err)
+}
+
+// Скорость роста счетчика с узла-владельца
+func (c *Cluster) CounterRate(ctx context.Context, id string) (float64, error) {
+	peer := c.peerFor(id)
+	if peer == nil {
+		return c.Storage.CounterRate(ctx, id)
+	}
+	resp, err := peer.RateLocal(ctx, &pb.MetricKey{Id: id, Type: pb.Metric_counter})
+	if err != nil {
+		return 0, fromStatus(c.ring.Owner(id), id, err)
+	}
+	return resp.GetRate(), nil
+}
+
+// Рассылка запроса всем узлам кластера, включая текущий
+func (c *Cluster) fanOut(ctx context.Context, local func(ctx context.Context) error, remote func(ctx context.Context, node string, peer pb.ClusterClient) error) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return local(egCtx)
+	})
+	for node, peer := range c.peers {
+		node, peer := node, peer
+		eg.Go(func() error {
+			return remote(egCtx, node, peer)
+		})
+	}
+	return eg.Wait()
+}
+
+// Все метрики кластера
+func (c *Cluster) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
+	var mu sync.Mutex
+	var res []metrics.Metric
+	collect := func(metricList []metrics.Metric) {
+		mu.Lock()
+		res = append(res, metricList...)
+		mu.Unlock()
+	}
+	err := c.fanOut(ctx, func(ctx context.Context) error {
+		metricList, err := c.Storage.ExtractMetrics(ctx)
+		collect(metricList)
+		return err
+	}, func(ctx context.Context, node string, peer pb.ClusterClient) error {
+		resp, err := peer.ExtractLocal(ctx, &pb.EmptyObject{})
+		if err != nil {
+			return fromStatus(node, "", err)
+		}
+		collect(listFromProto(resp))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Выборка метрик со всех узлов. Каждый узел возвращает отсортированную страницу
+// по тому же запросу, страницы сливаются и обрезаются до лимита
+func (c *Cluster) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	var mu sync.Mutex
+	var res []metrics.Metric
+	collect := func(metricList []metrics.Metric) {
+		mu.Lock()
+		res = append(res, metricList...)
+		mu.Unlock()
+	}
+	req := listQueryToProto(query)
+	err := c.fanOut(ctx, func(ctx context.Context) error {
+		metricList, err := c.Storage.ListMetrics(ctx, query)
+		collect(metricList)
+		return err
+	}, func(ctx context.Context, node string, peer pb.ClusterClient) error {
+		resp, err := peer.ListLocal(ctx, req)
+		if err != nil {
+			return fromStatus(node, "", err)
+		}
+		collect(listFromProto(resp))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return metrics.CompareKeys(res[i].ID, res[i].MType, res[j].ID, res[j].MType) < 0
+	})
+	if query.Limit > 0 && len(res) > query.Limit {
+		res = res[:query.Limit]
+	}
+	return res, nil
+}
+
+// Удаление метрик по шаблону на всех узлах
+func (c *Cluster) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	var mu sync.Mutex
+	total := 0
+	add := func(deleted int) {
+		mu.Lock()
+		total += deleted
+		mu.Unlock()
+	}
+	err := c.fanOut(ctx, func(ctx context.Context) error {
+		deleted, err := c.Storage.DeleteMetrics(ctx, mType, pattern)
+		add(deleted)
+		return err
+	}, func(ctx context.Context, node string, peer pb.ClusterClient) error {
+		resp, err := peer.DeleteMatchingLocal(ctx, &pb.DeleteMetricsRequest{Pattern: pattern, Type: mType})
+		if err != nil {
+			return fromStatus(node, pattern, err)
+		}
+		add(int(resp.GetDeleted()))
+		return nil
+	})
+	return total, err
+}
+
+// Восстановление ошибки хранилища из ответа другого узла
+func fromStatus(node string, id string, err error) error {
+	if err == nil {
+		return nil
+	}
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.NotFound:
+		return storageerrors.ErrNotFound
+	case codes.InvalidArgument:
+		if strings.HasPrefix(st.Message(), storageerrors.ErrTypeMismatch.Error()) {
+			return fmt.Errorf("%w: %s", storageerrors.ErrTypeMismatch, st.Message())
+		}
+		return storageerrors.NewInvalidMetricError(id, st.Message())
+	default:
+		return storageerrors.Unavailable(fmt.Errorf("cluster node %s: %w", node, err))
+	}
+}
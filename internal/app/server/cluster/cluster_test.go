@@ -0,0 +1,163 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+// Кластер из нескольких серверов в одном процессе, связанных через bufconn
+func newTestCluster(t *testing.T, nodes []string) []*Cluster {
+	listeners := map[string]*bufconn.Listener{}
+	for _, node := range nodes {
+		listeners[node] = bufconn.Listen(1024 * 1024)
+	}
+	dial := func(addr string) (*grpc.ClientConn, error) {
+		listener := listeners[addr]
+		return grpc.NewClient(
+			"passthrough:///"+addr,
+			grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+	}
+
+	var res []*Cluster
+	for _, node := range nodes {
+		storage := memstorage.New()
+		require.NoError(t, storage.Initialize(context.Background()))
+		c, err := New(node, nodes, storage, dial)
+		require.NoError(t, err)
+		t.Cleanup(c.Close)
+
+		server := grpc.NewServer()
+		pb.RegisterClusterServer(server, NewGRPCServer(c))
+		go server.Serve(listeners[node])
+		t.Cleanup(server.Stop)
+		res = append(res, c)
+	}
+	return res
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("node-4", []string{"node-1", "node-2"}, memstorage.New(), nil)
+	assert.Error(t, err, "узел должен входить в состав кластера")
+}
+
+func TestWritesRoutedToOwner(t *testing.T) {
+	ctx := context.Background()
+	nodes := []string{"node-1", "node-2", "node-3"}
+	cluster := newTestCluster(t, nodes)
+
+	var batch []metrics.Metric
+	for i := 0; i < 30; i++ {
+		delta := int64(i)
+		batch = append(batch, metrics.Metric{ID: "counter_" + strconv.Itoa(i), MType: "counter", Delta: &delta})
+	}
+	require.NoError(t, cluster[0].SaveMetrics(ctx, batch))
+	require.NoError(t, cluster[1].SaveMetrics(ctx, batch))
+
+	for i, node := range nodes {
+		local, err := cluster[i].Storage.ExtractMetrics(ctx)
+		require.NoError(t, err)
+		assert.NotEmpty(t, local)
+		for _, metric := range local {
+			assert.Equal(t, node, cluster[i].Owner(metric.ID), "метрика должна лежать только у владельца")
+			assert.True(t, cluster[i].Owns(metric.ID))
+			assert.False(t, cluster[(i+1)%len(nodes)].Owns(metric.ID), "у метрики один владелец")
+		}
+	}
+
+	for _, c := range cluster {
+		metric := metrics.Metric{ID: "counter_7", MType: "counter"}
+		require.NoError(t, c.GetMetric(ctx, &metric))
+		assert.Equal(t, int64(14), *metric.Delta)
+
+		rate, err := c.CounterRate(ctx, "counter_7")
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, rate, 0.0)
+	}
+
+	err := cluster[2].GetMetric(ctx, &metrics.Metric{ID: "unknown", MType: "counter"})
+	assert.ErrorIs(t, err, storageerrors.ErrNotFound)
+
+	err = cluster[0].SaveMetrics(ctx, []metrics.Metric{batch[0], {ID: "broken", MType: "gauge"}})
+	assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+	metric := metrics.Metric{ID: "counter_0", MType: "counter"}
+	require.NoError(t, cluster[0].GetMetric(ctx, &metric))
+	assert.Equal(t, int64(0), *metric.Delta)
+
+	for _, id := range []string{"counter_3", "counter_4", "counter_5"} {
+		require.NoError(t, cluster[0].ResetCounter(ctx, id))
+		metric := metrics.Metric{ID: id, MType: "counter"}
+		require.NoError(t, cluster[1].GetMetric(ctx, &metric))
+		assert.Equal(t, int64(0), *metric.Delta)
+	}
+
+	deleted := metrics.Metric{ID: "counter_9", MType: "counter"}
+	require.NoError(t, cluster[2].DeleteMetric(ctx, &deleted))
+	assert.Equal(t, int64(18), *deleted.Delta, "возвращается последнее значение удаленной метрики")
+	assert.ErrorIs(t, cluster[0].GetMetric(ctx, &metrics.Metric{ID: "counter_9", MType: "counter"}), storageerrors.ErrNotFound)
+}
+
+func TestReadsFannedOut(t *testing.T) {
+	ctx := context.Background()
+	cluster := newTestCluster(t, []string{"node-1", "node-2", "node-3"})
+
+	var batch []metrics.Metric
+	var ids []string
+	for i := 0; i < 20; i++ {
+		value := float64(i)
+		id := "gauge_" + strconv.Itoa(i)
+		ids = append(ids, id)
+		batch = append(batch, metrics.Metric{ID: id, MType: "gauge", Value: &value})
+	}
+	sort.Strings(ids)
+	require.NoError(t, cluster[0].SaveMetrics(ctx, batch))
+
+	all, err := cluster[1].ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 20)
+
+	// постраничный обход через разные узлы дает все метрики по порядку
+	var listed []string
+	query := metrics.ListQuery{Limit: 7}
+	for page := 0; ; page++ {
+		metricList, err := cluster[page%3].ListMetrics(ctx, query)
+		require.NoError(t, err)
+		if len(metricList) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(metricList), 7)
+		for _, metric := range metricList {
+			listed = append(listed, metric.ID)
+		}
+		cursor := metrics.CursorOf(metricList[len(metricList)-1])
+		query.After = &cursor
+	}
+	assert.Equal(t, ids, listed)
+
+	deleted, err := cluster[2].DeleteMetrics(ctx, "gauge", "gauge_1*")
+	require.NoError(t, err)
+	assert.Equal(t, 11, deleted)
+	all, err = cluster[0].ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 9)
+
+	_, err = cluster[0].ListMetrics(ctx, metrics.ListQuery{Regex: "("})
+	assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+}
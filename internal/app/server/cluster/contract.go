@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+)
+
+// Storage - локальное хранилище узла, в котором лежат принадлежащие ему метрики
+type Storage interface {
+	Initialize(ctx context.Context) error
+	ExtractMetrics(ctx context.Context) ([]metrics.Metric, error)
+	SaveMetrics(ctx context.Context, metricList []metrics.Metric) error
+	SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error)
+	GetMetric(ctx context.Context, metric *metrics.Metric) error
+	DeleteMetric(ctx context.Context, metric *metrics.Metric) error
+	DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error)
+	ResetCounter(ctx context.Context, id string) error
+	CounterRate(ctx context.Context, id string) (float64, error)
+	ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error)
+	EvictMetrics(ctx context.Context, policy retention.Policy, now time.Time) (int, error)
+}
+
+type externalStorage interface {
+	Ping(ctx context.Context) bool
+	Close()
+}
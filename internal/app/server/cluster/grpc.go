@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+// Grpc сервер для запросов других узлов кластера. Работает только с локальным
+// хранилищем и ничего не пересылает дальше
+type ClusterGRPCServer struct {
+	pb.UnimplementedClusterServer
+	storage Storage
+}
+
+// Init cluster grpc server
+func NewGRPCServer(c *Cluster) *ClusterGRPCServer {
+	return &ClusterGRPCServer{storage: c.Storage}
+}
+
+// Ошибка хранилища в виде grpc статуса. Для невалидной метрики передается
+// только причина, чтобы узел-отправитель восстановил исходную ошибку
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	var invalidErr *storageerrors.InvalidMetricError
+	switch {
+	case errors.As(err, &invalidErr):
+		return status.Error(codes.InvalidArgument, invalidErr.Reason)
+	case errors.Is(err, storageerrors.ErrTypeMismatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storageerrors.ErrInvalidMetric):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storageerrors.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storageerrors.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// Сохранение части пачки, принадлежащей узлу. Если передан ключ пачки,
+// повтор уже примененной части не сохраняется
+func (s *ClusterGRPCServer) SaveLocal(ctx context.Context, in *pb.MetricList) (*pb.SaveLocalResponse, error) {
+	metricList := listFromProto(in)
+	if in.GetBatchId() == "" {
+		if err := s.storage.SaveMetrics(ctx, metricList); err != nil {
+			return nil, toStatus(err)
+		}
+		return &pb.SaveLocalResponse{Applied: true}, nil
+	}
+	applied, err := s.storage.SaveBatch(ctx, metrics.BatchKey{AgentID: in.GetAgentId(), BatchID: in.GetBatchId()}, metricList)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.SaveLocalResponse{Applied: applied}, nil
+}
+
+// Получение локальной метрики
+func (s *ClusterGRPCServer) GetLocal(ctx context.Context, in *pb.MetricKey) (*pb.Metric, error) {
+	metric := keyFromProto(in)
+	if err := s.storage.GetMetric(ctx, &metric); err != nil {
+		return nil, toStatus(err)
+	}
+	return metricToProto(metric), nil
+}
+
+// Удаление локальной метрики
+func (s *ClusterGRPCServer) DeleteLocal(ctx context.Context, in *pb.MetricKey) (*pb.Metric, error) {
+	metric := keyFromProto(in)
+	if err := s.storage.DeleteMetric(ctx, &metric); err != nil {
+		return nil, toStatus(err)
+	}
+	return metricToProto(metric), nil
+}
+
+// Удаление локальных метрик по шаблону
+func (s *ClusterGRPCServer) DeleteMatchingLocal(ctx context.Context, in *pb.DeleteMetricsRequest) (*pb.DeleteMetricsResponse, error) {
+	deleted, err := s.storage.DeleteMetrics(ctx, in.GetType(), in.GetPattern())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteMetricsResponse{Deleted: int64(deleted)}, nil
+}
+
+// Сброс локального счетчика
+func (s *ClusterGRPCServer) ResetLocal(ctx context.Context, in *pb.ResetCounterRequest) (*pb.EmptyObject, error) {
+	return &pb.EmptyObject{}, toStatus(s.storage.ResetCounter(ctx, in.GetId()))
+}
+
+// Скорость роста локального счетчика
+func (s *ClusterGRPCServer) RateLocal(ctx context.Context, in *pb.MetricKey) (*pb.Metric, error) {
+	rate, err := s.storage.CounterRate(ctx, in.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.Metric{Id: in.GetId(), Type: pb.Metric_counter, Rate: rate}, nil
+}
+
+// Все локальные метрики
+func (s *ClusterGRPCServer) ExtractLocal(ctx context.Context, in *pb.EmptyObject) (*pb.MetricList, error) {
+	metricList, err := s.storage.ExtractMetrics(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return listToProto(metricList), nil
+}
+
+// Выборка локальных метрик
+func (s *ClusterGRPCServer) ListLocal(ctx context.Context, in *pb.ListMetricsRequest) (*pb.MetricList, error) {
+	metricList, err := s.storage.ListMetrics(ctx, listQueryFromProto(in))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return listToProto(metricList), nil
+}
+
+func typeToProto(mType string) pb.Metric_Type {
+	if mType == "counter" {
+		return pb.Metric_counter
+	}
+	return pb.Metric_gauge
+}
+
+func typeFromProto(mType pb.Metric_Type) string {
+	if mType == pb.Metric_counter {
+		return "counter"
+	}
+	return "gauge"
+}
+
+func keyToProto(metric *metrics.Metric) *pb.MetricKey {
+	return &pb.MetricKey{Id: metric.ID, Type: typeToProto(metric.MType)}
+}
+
+func keyFromProto(key *pb.MetricKey) metrics.Metric {
+	return metrics.Metric{ID: key.GetId(), MType: typeFromProto(key.GetType())}
+}
+
+func metricToProto(m metrics.Metric) *pb.Metric {
+	res := &pb.Metric{Id: m.ID, Type: typeToProto(m.MType)}
+	if m.Delta != nil {
+		res.Delta = *m.Delta
+	}
+	if m.Value != nil {
+		res.Value = *m.Value
+	}
+	return res
+}
+
+func metricFromProto(m *pb.Metric) metrics.Metric {
+	res := metrics.Metric{ID: m.GetId(), MType: typeFromProto(m.GetType())}
+	if m.GetType() == pb.Metric_counter {
+		delta := m.GetDelta()
+		res.Delta = &delta
+	} else {
+		value := m.GetValue()
+		res.Value = &value
+	}
+	return res
+}
+
+func listToProto(metricList []metrics.Metric) *pb.MetricList {
+	res := &pb.MetricList{Metrics: make([]*pb.Metric, 0, len(metricList))}
+	for _, metric := range metricList {
+		res.Metrics = append(res.Metrics, metricToProto(metric))
+	}
+	return res
+}
+
+func listFromProto(list *pb.MetricList) []metrics.Metric {
+	res := make([]metrics.Metric, 0, len(list.GetMetrics()))
+	for _, metric := range list.GetMetrics() {
+		res = append(res, metricFromProto(metric))
+	}
+	return res
+}
+
+func listQueryToProto(query metrics.ListQuery) *pb.ListMetricsRequest {
+	req := &pb.ListMetricsRequest{Type: query.MType, Prefix: query.Prefix, Regex: query.Regex, Limit: int64(query.Limit)}
+	if query.After != nil {
+		req.AfterId = query.After.ID
+		req.AfterType = query.After.MType
+	}
+	return req
+}
+
+func listQueryFromProto(req *pb.ListMetricsRequest) metrics.ListQuery {
+	query := metrics.ListQuery{MType: req.GetType(), Prefix: req.GetPrefix(), Regex: req.GetRegex(), Limit: int(req.GetLimit())}
+	if req.GetAfterId() != "" {
+		query.After = &metrics.Cursor{ID: req.GetAfterId(), MType: req.GetAfterType()}
+	}
+	return query
+}
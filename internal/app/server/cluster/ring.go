@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Кол-во виртуальных узлов на один сервер кластера по умолчанию
+const DefaultVirtualNodes = 128
+
+// Кольцо консистентного хеширования. Каждый узел кластера занимает на кольце
+// несколько виртуальных точек, метрика принадлежит первому узлу по часовой стрелке
+// от хеша ее имени. При изменении состава кластера переезжает только часть метрик
+type Ring struct {
+	points []uint64
+	owners map[uint64]string
+}
+
+// Хеш FNV-1a с перемешиванием из murmur3: у чистого FNV похожие имена
+// (metric_1, metric_2) ложатся на кольце слишком близко друг к другу
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Построение кольца по списку узлов. Порядок узлов в конфиге не важен
+func NewRing(nodes []string, virtualNodes int) *Ring {
+	sorted := append([]string(nil), nodes...)
+	sort.Strings(sorted)
+	r := &Ring{owners: map[uint64]string{}}
+	for _, node := range sorted {
+		for i := 0; i < virtualNodes; i++ {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[point]; ok {
+				continue
+			}
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Узел, которому принадлежит метрика
+func (r *Ring) Owner(id string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(id)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingDistribution(t *testing.T) {
+	nodes := []string{"node-1:3200", "node-2:3200", "node-3:3200"}
+	ring := NewRing(nodes, DefaultVirtualNodes)
+
+	owned := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		owned[ring.Owner("metric_"+strconv.Itoa(i))]++
+	}
+	for _, node := range nodes {
+		assert.Greater(t, owned[node], 600, "метрики должны распределяться между всеми узлами")
+	}
+
+	reordered := NewRing([]string{"node-3:3200", "node-1:3200", "node-2:3200"}, DefaultVirtualNodes)
+	assert.Equal(t, ring.Owner("PollCount"), reordered.Owner("PollCount"), "порядок узлов в конфиге не влияет на владельца")
+	assert.Equal(t, "", NewRing(nil, DefaultVirtualNodes).Owner("PollCount"))
+}
+
+func TestRingStability(t *testing.T) {
+	ring := NewRing([]string{"node-1", "node-2", "node-3"}, DefaultVirtualNodes)
+	grown := NewRing([]string{"node-1", "node-2", "node-3", "node-4"}, DefaultVirtualNodes)
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		id := "metric_" + strconv.Itoa(i)
+		before, after := ring.Owner(id), grown.Owner(id)
+		if before != after {
+			moved++
+			assert.Equal(t, "node-4", after, "метрики переезжают только на новый узел")
+		}
+	}
+	assert.Less(t, moved, 1200, "переезжает примерно четверть метрик")
+}
@@ -0,0 +1,215 @@
+// Module for periodic evaluation of alert rules
+package alerting
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/rules"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+// Сколько хранятся алерты в состоянии resolved
+const resolvedRetention = 15 * time.Minute
+
+// Воркер, который периодически вычисляет правила алертов по метрикам из хранилища
+type Engine struct {
+	intervalSec int64
+	rules       []rules.AlertRule
+	storage     Storage
+	notifier    Notifier
+	owns        func(alert string) bool
+
+	mutex  sync.RWMutex
+	states map[string]*alerts.Alert
+}
+
+// Init alerting engine
+func New(intervalSec int64, alertRules []rules.AlertRule, storage Storage, notifier Notifier) *Engine {
+	return &Engine{
+		intervalSec: intervalSec,
+		rules:       alertRules,
+		storage:     storage,
+		notifier:    notifier,
+		states:      map[string]*alerts.Alert{},
+	}
+}
+
+// Отбор алертов, уведомления по которым отправляет текущий узел кластера.
+// Правила вычисляются на всех узлах, а уведомление по алерту отправляет один
+// узел. По умолчанию уведомления отправляются по всем алертам
+func (e *Engine) SetOwner(owns func(alert string) bool) {
+	e.owns = owns
+}
+
+// Получение значения метрики, которое используется в условии
+func (e *Engine) metricValue(ctx context.Context, cond rules.Condition) (float64, bool, error) {
+	mTypes := []string{cond.MType}
+	if cond.MType == "" {
+		mTypes = []string{"gauge", "counter"}
+	}
+
+	for _, mType := range mTypes {
+		metric := metrics.Metric{ID: cond.Metric, MType: mType}
+		err := e.storage.GetMetric(ctx, &metric)
+		if errors.Is(err, storageerrors.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if metric.Value != nil {
+			return *metric.Value, true, nil
+		}
+		return float64(*metric.Delta), true, nil
+	}
+	return 0, false, nil
+}
+
+// Получение rate counter метрик, используемых в правилах. Rate берется из
+// хранилища, поэтому совпадает с тем, что отдают /rate/ и /value/
+func (e *Engine) collectRates(ctx context.Context) (map[string]float64, error) {
+	rates := map[string]float64{}
+	seen := map[string]bool{}
+	for _, rule := range e.rules {
+		cond := rule.Condition
+		if cond.Func != rules.FuncRate || seen[cond.Metric] {
+			continue
+		}
+		seen[cond.Metric] = true
+
+		rate, err := e.storage.CounterRate(ctx, cond.Metric)
+		if errors.Is(err, storageerrors.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rates[cond.Metric] = rate
+	}
+	return rates, nil
+}
+
+// Вычисление всех правил на момент now. Если задан notifier, ему передаются
+// алерты текущего узла после вычисления
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) error {
+	err := e.evaluate(ctx, now)
+	if e.notifier != nil {
+		alertList := e.Alerts(true)
+		if e.owns != nil {
+			alertList = slices.DeleteFunc(alertList, func(alert alerts.Alert) bool {
+				return !e.owns(alert.Name)
+			})
+		}
+		e.notifier.Notify(alertList, now)
+	}
+	return err
+}
+
+func (e *Engine) evaluate(ctx context.Context, now time.Time) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	rates, err := e.collectRates(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range e.rules {
+		var value float64
+		var ok bool
+		if rule.Condition.Func == rules.FuncRate {
+			value, ok = rates[rule.Condition.Metric]
+		} else {
+			value, ok, err = e.metricValue(ctx, rule.Condition)
+			if err != nil {
+				return err
+			}
+		}
+		e.transition(rule, ok && rule.Condition.Holds(value), value, now)
+	}
+	return nil
+}
+
+// Переход алерта между состояниями
+func (e *Engine) transition(rule rules.AlertRule, holds bool, value float64, now time.Time) {
+	alert, exists := e.states[rule.Name]
+
+	if !holds {
+		if !exists {
+			return
+		}
+		switch alert.State {
+		case alerts.StatePending:
+			delete(e.states, rule.Name)
+		case alerts.StateFiring:
+			alert.State = alerts.StateResolved
+			resolvedAt := now
+			alert.ResolvedAt = &resolvedAt
+		case alerts.StateResolved:
+			if now.Sub(*alert.ResolvedAt) > resolvedRetention {
+				delete(e.states, rule.Name)
+			}
+		}
+		return
+	}
+
+	if !exists || alert.State == alerts.StateResolved {
+		alert = &alerts.Alert{
+			Name:        rule.Name,
+			Expr:        rule.Expr,
+			State:       alerts.StatePending,
+			ActiveAt:    now,
+			Labels:      rule.Labels,
+			Annotations: rule.Annotations,
+		}
+		e.states[rule.Name] = alert
+	}
+	alert.Value = value
+	if alert.State == alerts.StatePending && now.Sub(alert.ActiveAt) >= time.Duration(rule.For) {
+		alert.State = alerts.StateFiring
+		firedAt := now
+		alert.FiredAt = &firedAt
+	}
+}
+
+// Получение алертов, отсортированных по имени. Алерты в состоянии resolved
+// возвращаются, только если includeResolved
+func (e *Engine) Alerts(includeResolved bool) []alerts.Alert {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	alertList := []alerts.Alert{}
+	for _, alert := range e.states {
+		if includeResolved || alert.IsActive() {
+			alertList = append(alertList, *alert)
+		}
+	}
+	slices.SortFunc(alertList, func(a, b alerts.Alert) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return alertList
+}
+
+// Run alerting engine
+func (e *Engine) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Logger.Info("Alerting engine shutdown")
+			return
+		default:
+		}
+		if err := e.Evaluate(ctx, time.Now()); err != nil {
+			logging.Logger.Warnf("Can't evaluate alert rules: %s", err)
+		}
+		time.Sleep(time.Duration(e.intervalSec) * time.Second)
+	}
+}
@@ -0,0 +1,178 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/rules"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+func newRules(t *testing.T, alertRules ...rules.AlertRule) []rules.AlertRule {
+	file := &rules.File{Alerts: alertRules}
+	require.NoError(t, file.Validate())
+	return file.Alerts
+}
+
+func saveGauge(t *testing.T, storage *memstorage.MemStorage, id string, value float64) {
+	require.NoError(t, storage.SaveMetrics(context.Background(), []metrics.Metric{{ID: id, MType: "gauge", Value: &value}}))
+}
+
+func TestThresholdLifecycle(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+	engine := New(1, newRules(t, rules.AlertRule{
+		Name:   "HighHeap",
+		Expr:   "HeapInuse > 100",
+		For:    rules.Duration(5 * time.Minute),
+		Labels: map[string]string{"severity": "warning"},
+	}), storage, nil)
+	start := time.Now()
+
+	// метрики нет - алерта нет
+	require.NoError(t, engine.Evaluate(ctx, start))
+	assert.Empty(t, engine.Alerts(true))
+
+	saveGauge(t, storage, "HeapInuse", 200)
+	require.NoError(t, engine.Evaluate(ctx, start))
+	alertList := engine.Alerts(false)
+	require.Len(t, alertList, 1)
+	assert.Equal(t, alerts.StatePending, alertList[0].State)
+	assert.Equal(t, 200.0, alertList[0].Value)
+	assert.Equal(t, "warning", alertList[0].Labels["severity"])
+
+	require.NoError(t, engine.Evaluate(ctx, start.Add(4*time.Minute)))
+	assert.Equal(t, alerts.StatePending, engine.Alerts(false)[0].State)
+
+	require.NoError(t, engine.Evaluate(ctx, start.Add(5*time.Minute)))
+	alertList = engine.Alerts(false)
+	assert.Equal(t, alerts.StateFiring, alertList[0].State)
+	require.NotNil(t, alertList[0].FiredAt)
+
+	saveGauge(t, storage, "HeapInuse", 50)
+	require.NoError(t, engine.Evaluate(ctx, start.Add(6*time.Minute)))
+	assert.Empty(t, engine.Alerts(false), "resolved алерт не активен")
+	alertList = engine.Alerts(true)
+	require.Len(t, alertList, 1)
+	assert.Equal(t, alerts.StateResolved, alertList[0].State)
+	require.NotNil(t, alertList[0].ResolvedAt)
+
+	require.NoError(t, engine.Evaluate(ctx, start.Add(6*time.Minute+resolvedRetention+time.Second)))
+	assert.Empty(t, engine.Alerts(true), "resolved алерт должен удаляться через некоторое время")
+}
+
+func TestPendingAlertDroppedWhenConditionStops(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+	engine := New(1, newRules(t, rules.AlertRule{Name: "HighHeap", Expr: "HeapInuse > 100", For: rules.Duration(time.Minute)}), storage, nil)
+	start := time.Now()
+
+	saveGauge(t, storage, "HeapInuse", 200)
+	require.NoError(t, engine.Evaluate(ctx, start))
+	assert.Len(t, engine.Alerts(false), 1)
+
+	saveGauge(t, storage, "HeapInuse", 10)
+	require.NoError(t, engine.Evaluate(ctx, start.Add(30*time.Second)))
+	assert.Empty(t, engine.Alerts(true), "pending алерт не должен переходить в resolved")
+}
+
+// Хранилище с заданным rate counter метрик
+type rateStorage struct {
+	*memstorage.MemStorage
+	rates map[string]float64
+}
+
+func (rs *rateStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	rate, ok := rs.rates[id]
+	if !ok {
+		return 0, storageerrors.ErrNotFound
+	}
+	return rate, nil
+}
+
+func TestRate(t *testing.T) {
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	storage := &rateStorage{MemStorage: memStorage, rates: map[string]float64{}}
+	engine := New(1, newRules(t,
+		rules.AlertRule{Name: "AgentStalled", Expr: "PollCount rate == 0"},
+		rules.AlertRule{Name: "FastPolling", Expr: "PollCount rate > 1"},
+	), storage, nil)
+	start := time.Now()
+
+	require.NoError(t, engine.Evaluate(ctx, start))
+	assert.Empty(t, engine.Alerts(true), "без counter в хранилище rate нет")
+
+	storage.rates["PollCount"] = 2
+	require.NoError(t, engine.Evaluate(ctx, start.Add(10*time.Second)))
+	alertList := engine.Alerts(false)
+	require.Len(t, alertList, 1)
+	assert.Equal(t, "FastPolling", alertList[0].Name)
+	assert.Equal(t, alerts.StateFiring, alertList[0].State, "правило без for срабатывает сразу")
+	assert.Equal(t, 2.0, alertList[0].Value, "rate counter берется из хранилища")
+
+	storage.rates["PollCount"] = 0
+	require.NoError(t, engine.Evaluate(ctx, start.Add(20*time.Second)))
+	alertList = engine.Alerts(false)
+	require.Len(t, alertList, 1)
+	assert.Equal(t, "AgentStalled", alertList[0].Name)
+}
+
+type MockNotifier struct {
+	calls [][]alerts.Alert
+}
+
+func (m *MockNotifier) Notify(alertList []alerts.Alert, now time.Time) {
+	m.calls = append(m.calls, alertList)
+}
+
+func TestNotify(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+	notifier := &MockNotifier{}
+	engine := New(1, newRules(t, rules.AlertRule{Name: "HighHeap", Expr: "HeapInuse > 100"}), storage, notifier)
+
+	saveGauge(t, storage, "HeapInuse", 200)
+	require.NoError(t, engine.Evaluate(ctx, time.Now()))
+	saveGauge(t, storage, "HeapInuse", 10)
+	require.NoError(t, engine.Evaluate(ctx, time.Now()))
+
+	require.Len(t, notifier.calls, 2)
+	require.Len(t, notifier.calls[0], 1)
+	assert.Equal(t, alerts.StateFiring, notifier.calls[0][0].State)
+	require.Len(t, notifier.calls[1], 1)
+	assert.Equal(t, alerts.StateResolved, notifier.calls[1][0].State, "resolved алерты тоже передаются в notifier")
+}
+
+func TestNotifyOwnedAlerts(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+	notifier := &MockNotifier{}
+	engine := New(1, newRules(t,
+		rules.AlertRule{Name: "HighHeap", Expr: "HeapInuse > 100"},
+		rules.AlertRule{Name: "VeryHighHeap", Expr: "HeapInuse > 150"},
+	), storage, notifier)
+	engine.SetOwner(func(alert string) bool {
+		return alert == "HighHeap"
+	})
+
+	saveGauge(t, storage, "HeapInuse", 200)
+	require.NoError(t, engine.Evaluate(ctx, time.Now()))
+
+	assert.Len(t, engine.Alerts(false), 2, "правила вычисляются на всех узлах")
+	require.Len(t, notifier.calls, 1)
+	require.Len(t, notifier.calls[0], 1, "уведомление отправляет только узел-владелец алерта")
+	assert.Equal(t, "HighHeap", notifier.calls[0][0].Name)
+}
@@ -0,0 +1,20 @@
+package alerting
+
+import (
+	"context"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+)
+
+// Storage - интерфейс хранилища, по метрикам которого вычисляются правила
+type Storage interface {
+	GetMetric(ctx context.Context, metric *metrics.Metric) error
+	CounterRate(ctx context.Context, id string) (float64, error)
+}
+
+// Notifier - интерфейс для отправки уведомлений об алертах
+type Notifier interface {
+	Notify(alertList []alerts.Alert, now time.Time)
+}
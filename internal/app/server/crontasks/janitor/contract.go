@@ -0,0 +1,15 @@
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+)
+
+// Storage - интерфейс хранилища, из которого удаляются устаревшие метрики
+type Storage interface {
+	EvictMetrics(ctx context.Context, policy retention.Policy, now time.Time) (int, error)
+	ExtractMetrics(ctx context.Context) ([]metrics.Metric, error)
+}
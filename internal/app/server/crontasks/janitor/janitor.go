@@ -0,0 +1,78 @@
+// Module for evicting stale metrics from storage
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+)
+
+// Воркер, который периодически удаляет метрики, не обновлявшиеся дольше TTL
+type Janitor struct {
+	intervalSec int64
+	policy      retention.Policy
+	storage     Storage
+	limiter     *ratelimit.Limiter
+}
+
+// Init janitor
+func New(intervalSec int64, policy retention.Policy, storage Storage) *Janitor {
+	return &Janitor{
+		intervalSec: intervalSec,
+		policy:      policy,
+		storage:     storage,
+	}
+}
+
+// Ограничитель агентов, в котором освобождается квота удаленных метрик
+func (j *Janitor) SetLimiter(limiter *ratelimit.Limiter) {
+	j.limiter = limiter
+}
+
+// Удаление устаревших на момент now метрик
+func (j *Janitor) Clean(ctx context.Context, now time.Time) (int, error) {
+	evicted, err := j.storage.EvictMetrics(ctx, j.policy, now)
+	if err != nil || evicted == 0 || j.limiter == nil {
+		return evicted, err
+	}
+	return evicted, j.releaseEvicted(ctx)
+}
+
+// Освобождение квоты метрик, которых не осталось в хранилище
+func (j *Janitor) releaseEvicted(ctx context.Context) error {
+	metricList, err := j.storage.ExtractMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]struct{}, len(metricList))
+	for _, metric := range metricList {
+		stored[ratelimit.MetricKey(metric.MType, metric.ID)] = struct{}{}
+	}
+	j.limiter.ReleaseMatching(func(key string) bool {
+		_, ok := stored[key]
+		return !ok
+	})
+	return nil
+}
+
+// Run janitor
+func (j *Janitor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Logger.Info("Janitor shutdown")
+			return
+		default:
+		}
+		evicted, err := j.Clean(ctx, time.Now())
+		if err != nil {
+			logging.Logger.Warnf("Can't evict stale metrics: %s", err)
+		} else if evicted > 0 {
+			logging.Logger.Infof("Evicted %d stale metrics", evicted)
+		}
+		time.Sleep(time.Duration(j.intervalSec) * time.Second)
+	}
+}
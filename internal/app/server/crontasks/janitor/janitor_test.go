@@ -0,0 +1,74 @@
+package janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+)
+
+func TestClean(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+
+	value := 1.0
+	delta := int64(1)
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		{ID: "HostCPU", MType: "gauge", Value: &value},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}))
+
+	policy := retention.Policy{}
+	require.NoError(t, policy.Set("gauge:Host*=1m,counter:*=1h"))
+	janitor := New(1, policy, storage)
+
+	evicted, err := janitor.Clean(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, evicted, "свежие метрики не должны удаляться")
+
+	evicted, err = janitor.Clean(ctx, time.Now().Add(10*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	searchMetric := metrics.Metric{ID: "HostCPU", MType: "gauge"}
+	assert.Error(t, storage.GetMetric(ctx, &searchMetric))
+
+	evicted, err = janitor.Clean(ctx, time.Now().Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	searchMetric = metrics.Metric{ID: "Alloc", MType: "gauge"}
+	assert.NoError(t, storage.GetMetric(ctx, &searchMetric), "метрика без правила хранится бессрочно")
+}
+
+func TestCleanReleasesQuota(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+
+	limiter := ratelimit.New(ratelimit.Limits{MaxMetricIDs: 2}, nil)
+	value := 1.0
+	require.NoError(t, limiter.AllowMetrics("agent", []string{ratelimit.MetricKey("gauge", "HostCPU"), ratelimit.MetricKey("gauge", "Alloc")}, time.Now()))
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		{ID: "HostCPU", MType: "gauge", Value: &value},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	}))
+
+	policy := retention.Policy{}
+	require.NoError(t, policy.Set("gauge:Host*=1m"))
+	janitor := New(1, policy, storage)
+	janitor.SetLimiter(limiter)
+
+	evicted, err := janitor.Clean(ctx, time.Now().Add(10*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{ratelimit.MetricKey("gauge", "HostMem")}, time.Now()), "удаленная метрика не занимает квоту")
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{ratelimit.MetricKey("gauge", "HostDisk")}, time.Now()), ratelimit.ErrQuotaExceeded)
+}
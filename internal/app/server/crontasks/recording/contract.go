@@ -0,0 +1,14 @@
+package recording
+
+import (
+	"context"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+)
+
+// Storage - интерфейс хранилища, по метрикам которого вычисляются правила записи
+type Storage interface {
+	ExtractMetrics(ctx context.Context) ([]metrics.Metric, error)
+	SaveMetrics(ctx context.Context, metricList []metrics.Metric) error
+	CounterRate(ctx context.Context, id string) (float64, error)
+}
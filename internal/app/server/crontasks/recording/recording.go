@@ -0,0 +1,176 @@
+// Module for periodic evaluation of recording rules
+package recording
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/rules"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+// Воркер, который периодически вычисляет правила записи и сохраняет результаты как gauge метрики
+type Recorder struct {
+	intervalSec int64
+	rules       []rules.RecordRule
+	storage     Storage
+	owns        func(record string) bool
+
+	mutex sync.Mutex
+}
+
+// Init recorder
+func New(intervalSec int64, recordRules []rules.RecordRule, storage Storage) *Recorder {
+	return &Recorder{
+		intervalSec: intervalSec,
+		rules:       recordRules,
+		storage:     storage,
+	}
+}
+
+// Отбор правил, которые вычисляет текущий узел кластера: результат каждого
+// правила записывает один узел. По умолчанию вычисляются все правила
+func (r *Recorder) SetOwner(owns func(record string) bool) {
+	r.owns = owns
+}
+
+// Значения метрик на момент вычисления правил. Rate counter метрик берется из хранилища
+type snapshot struct {
+	gauges      map[string]float64
+	counters    map[string]float64
+	rates       map[string]float64
+	counterRate func(id string) (float64, error)
+	err         error
+}
+
+func newSnapshot(metricList []metrics.Metric, counterRate func(id string) (float64, error)) *snapshot {
+	s := &snapshot{
+		gauges:      map[string]float64{},
+		counters:    map[string]float64{},
+		rates:       map[string]float64{},
+		counterRate: counterRate,
+	}
+	for _, metric := range metricList {
+		switch {
+		case metric.MType == "gauge" && metric.Value != nil:
+			s.gauges[metric.ID] = *metric.Value
+		case metric.MType == "counter" && metric.Delta != nil:
+			s.counters[metric.ID] = float64(*metric.Delta)
+		}
+	}
+	return s
+}
+
+func (s *snapshot) Value(mType string, name string) (float64, bool) {
+	if mType != "counter" {
+		if value, ok := s.gauges[name]; ok {
+			return value, true
+		}
+	}
+	if mType != "gauge" {
+		if value, ok := s.counters[name]; ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func (s *snapshot) Match(re *regexp.Regexp) []float64 {
+	values := []float64{}
+	for _, mValues := range []map[string]float64{s.gauges, s.counters} {
+		for name, value := range mValues {
+			if re.MatchString(name) {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Rate запрашивается у хранилища не больше одного раза за вычисление правил,
+// даже если counter используется в нескольких правилах. Первая ошибка хранилища
+// запоминается и возвращается из Evaluate
+func (s *snapshot) Rate(name string) (float64, bool) {
+	if rate, ok := s.rates[name]; ok {
+		return rate, true
+	}
+	if _, ok := s.counters[name]; !ok {
+		return 0, false
+	}
+	rate, err := s.counterRate(name)
+	if err != nil {
+		if !errors.Is(err, storageerrors.ErrNotFound) && s.err == nil {
+			s.err = err
+		}
+		return 0, false
+	}
+	s.rates[name] = rate
+	return rate, true
+}
+
+// Вычисление всех правил и сохранение результатов. Правила
+// вычисляются по порядку, поэтому правило может использовать результаты предыдущих.
+// Возвращает кол-во сохраненных метрик
+func (r *Recorder) Evaluate(ctx context.Context) (int, error) {
+	if len(r.rules) == 0 {
+		return 0, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	metricList, err := r.storage.ExtractMetrics(ctx)
+	if err != nil {
+		return 0, err
+	}
+	env := newSnapshot(metricList, func(id string) (float64, error) {
+		return r.storage.CounterRate(ctx, id)
+	})
+
+	recorded := []metrics.Metric{}
+	for _, rule := range r.rules {
+		if r.owns != nil && !r.owns(rule.Record) {
+			// правило вычисляет другой узел, его результат уже есть в хранилище
+			continue
+		}
+		value, ok := rule.Expression.Eval(env)
+		if !ok {
+			delete(env.gauges, rule.Record)
+			continue
+		}
+		env.gauges[rule.Record] = value
+		recorded = append(recorded, metrics.Metric{ID: rule.Record, MType: "gauge", Value: &value})
+	}
+
+	if env.err != nil {
+		return 0, env.err
+	}
+	if len(recorded) == 0 {
+		return 0, nil
+	}
+	if err := r.storage.SaveMetrics(ctx, recorded); err != nil {
+		return 0, err
+	}
+	return len(recorded), nil
+}
+
+// Run recorder
+func (r *Recorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Logger.Info("Recorder shutdown")
+			return
+		default:
+		}
+		if _, err := r.Evaluate(ctx); err != nil {
+			logging.Logger.Warnf("Can't evaluate recording rules: %s", err)
+		}
+		time.Sleep(time.Duration(r.intervalSec) * time.Second)
+	}
+}
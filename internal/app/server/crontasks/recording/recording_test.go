@@ -0,0 +1,110 @@
+package recording
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/rules"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+func getGauge(t *testing.T, storage *memstorage.MemStorage, id string) (float64, bool) {
+	metric := metrics.Metric{ID: id, MType: "gauge"}
+	if err := storage.GetMetric(context.Background(), &metric); err != nil {
+		return 0, false
+	}
+	return *metric.Value, true
+}
+
+// Хранилище с заданным rate counter метрик
+type rateStorage struct {
+	*memstorage.MemStorage
+	rates map[string]float64
+}
+
+func (rs *rateStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	rate, ok := rs.rates[id]
+	if !ok {
+		return 0, storageerrors.ErrNotFound
+	}
+	return rate, nil
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	storage := &rateStorage{MemStorage: memStorage, rates: map[string]float64{"PollCount": 2}}
+
+	total, free, cpu1, cpu2 := 1000.0, 250.0, 10.0, 30.0
+	pollCount := int64(10)
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		{ID: "TotalMemory", MType: "gauge", Value: &total},
+		{ID: "FreeMemory", MType: "gauge", Value: &free},
+		{ID: "CPUutilization1", MType: "gauge", Value: &cpu1},
+		{ID: "CPUutilization2", MType: "gauge", Value: &cpu2},
+		{ID: "PollCount", MType: "counter", Delta: &pollCount},
+	}))
+
+	file := &rules.File{Records: []rules.RecordRule{
+		{Record: "used_memory", Expr: "TotalMemory - FreeMemory"},
+		{Record: "used_memory_percent", Expr: "used_memory / TotalMemory * 100"},
+		{Record: "cpu_avg", Expr: `avg("CPUutilization[0-9]+")`},
+		{Record: "poll_rate", Expr: "rate(PollCount)"},
+		{Record: "unknown", Expr: "Unknown * 2"},
+	}}
+	require.NoError(t, file.Validate())
+	recorder := New(1, file.Records, storage)
+
+	saved, err := recorder.Evaluate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, saved, "у unknown нет значения")
+
+	value, ok := getGauge(t, memStorage, "used_memory")
+	assert.True(t, ok)
+	assert.Equal(t, 750.0, value)
+	value, _ = getGauge(t, memStorage, "used_memory_percent")
+	assert.Equal(t, 75.0, value, "правило может использовать результат предыдущего правила")
+	value, _ = getGauge(t, memStorage, "cpu_avg")
+	assert.Equal(t, 20.0, value)
+	_, ok = getGauge(t, memStorage, "unknown")
+	assert.False(t, ok)
+
+	value, _ = getGauge(t, memStorage, "poll_rate")
+	assert.Equal(t, 2.0, value, "rate counter берется из хранилища")
+}
+
+func TestEvaluateOwnedRules(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+
+	total, free := 1000.0, 250.0
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		{ID: "TotalMemory", MType: "gauge", Value: &total},
+		{ID: "FreeMemory", MType: "gauge", Value: &free},
+	}))
+
+	file := &rules.File{Records: []rules.RecordRule{
+		{Record: "used_memory", Expr: "TotalMemory - FreeMemory"},
+		{Record: "free_memory_percent", Expr: "FreeMemory / TotalMemory * 100"},
+	}}
+	require.NoError(t, file.Validate())
+	recorder := New(1, file.Records, storage)
+	recorder.SetOwner(func(record string) bool {
+		return record == "used_memory"
+	})
+
+	saved, err := recorder.Evaluate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, saved)
+	_, ok := getGauge(t, storage, "used_memory")
+	assert.True(t, ok)
+	_, ok = getGauge(t, storage, "free_memory_percent")
+	assert.False(t, ok, "правило другого узла не должно вычисляться")
+}
@@ -1,8 +1,19 @@
 package snapshotmaker
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // FileWorker - интерфейс для сохраненя метрик в файл
 type FileWorker interface {
 	ImportToFile(ctx context.Context) error
+	FileSize() (int64, error)
+}
+
+// Registry - метрики сервера, в которых учитываются снимки
+type Registry interface {
+	Add(name string, delta int64)
+	Set(name string, value float64)
+	Observe(name string, duration time.Duration)
 }
@@ -12,13 +12,33 @@ import (
 type SnapshotMaker struct {
 	storeIntervalSec int64
 	fileWorker       FileWorker
+	registry         Registry
 }
 
-// Init snapshotMaker
-func New(storeIntervalSec int64, fileWorker FileWorker) *SnapshotMaker {
+// Init snapshotMaker. Длительность, размер и ошибки снимков учитываются в
+// registry, если он задан
+func New(storeIntervalSec int64, fileWorker FileWorker, registry Registry) *SnapshotMaker {
 	return &SnapshotMaker{
 		storeIntervalSec: storeIntervalSec,
 		fileWorker:       fileWorker,
+		registry:         registry,
+	}
+}
+
+// Сохранение снимка с учетом в метриках сервера
+func (sm *SnapshotMaker) snapshot(ctx context.Context) {
+	start := time.Now()
+	err := sm.fileWorker.ImportToFile(ctx)
+	if sm.registry == nil {
+		return
+	}
+	sm.registry.Observe("snapshot_duration", time.Since(start))
+	if err != nil {
+		sm.registry.Add("snapshot_errors", 1)
+		return
+	}
+	if size, err := sm.fileWorker.FileSize(); err == nil {
+		sm.registry.Set("snapshot_size_bytes", float64(size))
 	}
 }
 
@@ -31,7 +51,7 @@ func (sm *SnapshotMaker) Run(ctx context.Context) {
 			return
 		default:
 		}
-		sm.fileWorker.ImportToFile(ctx)
+		sm.snapshot(ctx)
 		time.Sleep(time.Duration(sm.storeIntervalSec) * time.Second)
 	}
 }
@@ -0,0 +1,20 @@
+// Module with embedded web dashboard for browsing metrics
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// Обработчик, раздающий статику дашборда. prefix - путь, по которому смонтирован дашборд
+func New(prefix string) http.Handler {
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(prefix, http.FileServer(http.FS(static)))
+}
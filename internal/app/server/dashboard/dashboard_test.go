@@ -0,0 +1,59 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	handler := New("/dashboard/")
+
+	testCases := []struct {
+		testName            string
+		path                string
+		expectedCode        int
+		expectedContentType string
+	}{
+		{testName: "index", path: "/dashboard/", expectedCode: http.StatusOK, expectedContentType: "text/html"},
+		{testName: "script", path: "/dashboard/app.js", expectedCode: http.StatusOK, expectedContentType: "text/javascript"},
+		{testName: "styles", path: "/dashboard/style.css", expectedCode: http.StatusOK, expectedContentType: "text/css"},
+		{testName: "unknown file", path: "/dashboard/unknown.js", expectedCode: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.expectedCode, res.Code)
+			if tc.expectedContentType != "" {
+				assert.True(t, strings.HasPrefix(res.Header().Get("Content-Type"), tc.expectedContentType),
+					"неверный content-type %s", res.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestNoExternalResources(t *testing.T) {
+	// ссылки на внешние скрипты, стили, шрифты и запросы к внешним адресам
+	externalRef := regexp.MustCompile(`(src|href)\s*=\s*["']?(https?:)?//|url\(\s*["']?(https?:)?//|@import|fetch\(\s*["'](https?:)?//`)
+	for _, name := range []string{"static/index.html", "static/app.js", "static/style.css"} {
+		data, err := staticFiles.ReadFile(name)
+		assert.NoError(t, err)
+		assert.False(t, externalRef.Match(data), "дашборд не должен зависеть от внешних ресурсов: %s", name)
+	}
+}
+
+func TestTokenHeader(t *testing.T) {
+	index, err := staticFiles.ReadFile("static/index.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(index), `id="token"`, "на странице должно быть поле для токена")
+
+	script, err := staticFiles.ReadFile("static/app.js")
+	assert.NoError(t, err)
+	assert.Contains(t, string(script), `"Bearer " + token`, "токен должен передаваться в заголовке Authorization")
+}
@@ -0,0 +1,316 @@
+"use strict";
+
+(function () {
+  var API_PAGE_SIZE = 1000;
+  var HISTORY_SIZE = 120;
+  var HISTORY_KEY = "metric-history";
+  var TOKEN_KEY = "metric-token";
+  var SVG_NS = "http://www.w3.org/2000/svg";
+
+  var state = {
+    metrics: [],
+    sortKey: "id",
+    sortDir: 1,
+    timer: null,
+    history: loadHistory(),
+  };
+
+  var el = {
+    listView: document.getElementById("list-view"),
+    detailView: document.getElementById("detail-view"),
+    table: document.getElementById("metrics"),
+    search: document.getElementById("search"),
+    typeFilter: document.getElementById("type-filter"),
+    count: document.getElementById("count"),
+    refresh: document.getElementById("refresh"),
+    token: document.getElementById("token"),
+    status: document.getElementById("status"),
+  };
+
+  function metricKey(metric) {
+    return metric.type + "/" + metric.id;
+  }
+
+  function metricValue(metric) {
+    return metric.type === "counter" ? metric.delta : metric.value;
+  }
+
+  function formatValue(value) {
+    if (value === undefined || value === null) {
+      return "";
+    }
+    return Number.isInteger(value) ? String(value) : value.toPrecision(6).replace(/\.?0+$/, "");
+  }
+
+  // history is kept per browser tab, server does not store it
+  function loadHistory() {
+    try {
+      return JSON.parse(sessionStorage.getItem(HISTORY_KEY)) || {};
+    } catch (e) {
+      return {};
+    }
+  }
+
+  function saveHistory() {
+    try {
+      sessionStorage.setItem(HISTORY_KEY, JSON.stringify(state.history));
+    } catch (e) {
+      // storage is full or disabled, history stays in memory only
+    }
+  }
+
+  function recordHistory(metrics) {
+    var now = Date.now();
+    var seen = {};
+    metrics.forEach(function (metric) {
+      var key = metricKey(metric);
+      seen[key] = true;
+      var points = state.history[key] || [];
+      points.push([now, metricValue(metric)]);
+      if (points.length > HISTORY_SIZE) {
+        points.splice(0, points.length - HISTORY_SIZE);
+      }
+      state.history[key] = points;
+    });
+    Object.keys(state.history).forEach(function (key) {
+      if (!seen[key]) {
+        delete state.history[key];
+      }
+    });
+    saveHistory();
+  }
+
+  // token is kept per browser tab like history, so it is not left on shared machines
+  function loadToken() {
+    try {
+      return sessionStorage.getItem(TOKEN_KEY) || "";
+    } catch (e) {
+      return "";
+    }
+  }
+
+  function saveToken() {
+    try {
+      sessionStorage.setItem(TOKEN_KEY, el.token.value.trim());
+    } catch (e) {
+      // storage is disabled, token stays in the input only
+    }
+  }
+
+  function requestHeaders() {
+    var headers = { Accept: "application/json" };
+    var token = el.token.value.trim();
+    if (token) {
+      headers.Authorization = "Bearer " + token;
+    }
+    return headers;
+  }
+
+  function setStatus(text, isError) {
+    el.status.textContent = text;
+    el.status.className = isError ? "status error" : "status";
+  }
+
+  // load all pages of the listing API
+  function fetchAll(cursor, acc) {
+    var url = "../api/metrics?limit=" + API_PAGE_SIZE;
+    if (cursor) {
+      url += "&cursor=" + encodeURIComponent(cursor);
+    }
+    return fetch(url, { headers: requestHeaders() })
+      .then(function (res) {
+        if (res.status === 401 || res.status === 403) {
+          throw new Error("HTTP " + res.status + ", check the token");
+        }
+        if (!res.ok) {
+          throw new Error("HTTP " + res.status);
+        }
+        return res.json();
+      })
+      .then(function (page) {
+        acc = acc.concat(page.metrics || []);
+        return page.next_cursor ? fetchAll(page.next_cursor, acc) : acc;
+      });
+  }
+
+  function refresh() {
+    return fetchAll("", [])
+      .then(function (metrics) {
+        state.metrics = metrics;
+        recordHistory(metrics);
+        setStatus("updated " + new Date().toLocaleTimeString(), false);
+        render();
+      })
+      .catch(function (err) {
+        setStatus("refresh failed: " + err.message, true);
+      });
+  }
+
+  function schedule() {
+    if (state.timer) {
+      clearInterval(state.timer);
+      state.timer = null;
+    }
+    var interval = Number(el.refresh.value);
+    if (interval > 0) {
+      state.timer = setInterval(refresh, interval);
+    }
+  }
+
+  function sparkline(points, width, height, className) {
+    var svg = document.createElementNS(SVG_NS, "svg");
+    svg.setAttribute("viewBox", "0 0 " + width + " " + height);
+    svg.setAttribute("preserveAspectRatio", "none");
+    if (className) {
+      svg.setAttribute("class", className);
+    }
+    if (!points || points.length < 2) {
+      return svg;
+    }
+
+    var values = points.map(function (p) { return p[1]; });
+    var min = Math.min.apply(null, values);
+    var max = Math.max.apply(null, values);
+    var span = max - min || 1;
+    var step = width / (points.length - 1);
+    var pad = 2;
+
+    var coords = values.map(function (v, i) {
+      var x = i * step;
+      var y = height - pad - ((v - min) / span) * (height - 2 * pad);
+      return x.toFixed(1) + "," + y.toFixed(1);
+    });
+    var line = document.createElementNS(SVG_NS, "polyline");
+    line.setAttribute("points", coords.join(" "));
+    line.setAttribute("vector-effect", "non-scaling-stroke");
+    svg.appendChild(line);
+    return svg;
+  }
+
+  function visibleMetrics() {
+    var query = el.search.value.trim().toLowerCase();
+    var type = el.typeFilter.value;
+    var list = state.metrics.filter(function (metric) {
+      return (!type || metric.type === type) && (!query || metric.id.toLowerCase().indexOf(query) !== -1);
+    });
+
+    var key = state.sortKey;
+    var dir = state.sortDir;
+    list.sort(function (a, b) {
+      var av = key === "value" ? metricValue(a) : a[key];
+      var bv = key === "value" ? metricValue(b) : b[key];
+      if (av < bv) {
+        return -dir;
+      }
+      if (av > bv) {
+        return dir;
+      }
+      return a.id < b.id ? -1 : a.id > b.id ? 1 : 0;
+    });
+    return list;
+  }
+
+  function renderList() {
+    var list = visibleMetrics();
+    var rows = document.createDocumentFragment();
+
+    list.forEach(function (metric) {
+      var row = document.createElement("tr");
+
+      var nameCell = document.createElement("td");
+      var link = document.createElement("a");
+      link.href = "#/metric/" + encodeURIComponent(metric.type) + "/" + encodeURIComponent(metric.id);
+      link.textContent = metric.id;
+      nameCell.appendChild(link);
+
+      var typeCell = document.createElement("td");
+      var badge = document.createElement("span");
+      badge.className = "type " + metric.type;
+      badge.textContent = metric.type;
+      typeCell.appendChild(badge);
+
+      var valueCell = document.createElement("td");
+      valueCell.className = "num";
+      valueCell.textContent = formatValue(metricValue(metric));
+
+      var historyCell = document.createElement("td");
+      historyCell.appendChild(sparkline(state.history[metricKey(metric)], 120, 24, "sparkline"));
+
+      row.appendChild(nameCell);
+      row.appendChild(typeCell);
+      row.appendChild(valueCell);
+      row.appendChild(historyCell);
+      rows.appendChild(row);
+    });
+
+    el.table.replaceChildren(rows);
+    el.count.textContent = list.length + " of " + state.metrics.length;
+
+    document.querySelectorAll("th[data-sort]").forEach(function (th) {
+      th.classList.remove("sorted-asc", "sorted-desc");
+      if (th.dataset.sort === state.sortKey) {
+        th.classList.add(state.sortDir > 0 ? "sorted-asc" : "sorted-desc");
+      }
+    });
+  }
+
+  function renderDetail(type, id) {
+    var metric = state.metrics.find(function (m) { return m.type === type && m.id === id; });
+    var points = state.history[type + "/" + id] || [];
+    var values = points.map(function (p) { return p[1]; });
+
+    document.getElementById("detail-name").textContent = id;
+    document.getElementById("detail-type").textContent = type;
+    document.getElementById("detail-value").textContent = metric ? formatValue(metricValue(metric)) : "not found";
+    document.getElementById("detail-range").textContent = values.length
+      ? formatValue(Math.min.apply(null, values)) + " / " + formatValue(Math.max.apply(null, values))
+      : "";
+    document.getElementById("detail-samples").textContent = String(points.length);
+
+    var chart = document.getElementById("detail-chart");
+    chart.replaceChildren(sparkline(points, 1000, 240, ""));
+  }
+
+  function currentRoute() {
+    var match = /^#\/metric\/([^/]+)\/(.+)$/.exec(location.hash);
+    if (!match) {
+      return null;
+    }
+    return { type: decodeURIComponent(match[1]), id: decodeURIComponent(match[2]) };
+  }
+
+  function render() {
+    var route = currentRoute();
+    el.listView.hidden = route !== null;
+    el.detailView.hidden = route === null;
+    if (route) {
+      renderDetail(route.type, route.id);
+    } else {
+      renderList();
+    }
+  }
+
+  document.querySelectorAll("th[data-sort]").forEach(function (th) {
+    th.addEventListener("click", function () {
+      if (state.sortKey === th.dataset.sort) {
+        state.sortDir = -state.sortDir;
+      } else {
+        state.sortKey = th.dataset.sort;
+        state.sortDir = 1;
+      }
+      renderList();
+    });
+  });
+  el.search.addEventListener("input", renderList);
+  el.typeFilter.addEventListener("change", renderList);
+  el.refresh.addEventListener("change", schedule);
+  el.token.addEventListener("change", function () {
+    saveToken();
+    refresh();
+  });
+  window.addEventListener("hashchange", render);
+
+  el.token.value = loadToken();
+  refresh();
+  schedule();
+})();
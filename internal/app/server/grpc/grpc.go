@@ -1,23 +1,53 @@
 package metricsgrpc
 
 import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"io"
 
+	"github.com/ry461ch/metric-collector/internal/app/server/selfmetrics"
+	"github.com/ry461ch/metric-collector/internal/audit"
 	config "github.com/ry461ch/metric-collector/internal/config/server"
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 	pb "github.com/ry461ch/metric-collector/internal/proto"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/ipchecker"
+	ipcheckermiddleware "github.com/ry461ch/metric-collector/pkg/ipchecker/middleware"
 	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
 )
 
+// Права токена, необходимые для вызова методов сервиса метрик. Проверка
+// готовности grpc.health.v1 доступна без токена
+var MethodScopes = map[string]string{
+	pb.Metrics_PostMetrics_FullMethodName:   tokens.ScopeWrite,
+	pb.Metrics_GetMetric_FullMethodName:     tokens.ScopeRead,
+	pb.Metrics_GetAlerts_FullMethodName:     tokens.ScopeRead,
+	pb.Metrics_DeleteMetric_FullMethodName:  tokens.ScopeAdmin,
+	pb.Metrics_DeleteMetrics_FullMethodName: tokens.ScopeAdmin,
+	pb.Metrics_ResetCounter_FullMethodName:  tokens.ScopeAdmin,
+	healthpb.Health_Check_FullMethodName:    tokens.ScopePublic,
+	healthpb.Health_Watch_FullMethodName:    tokens.ScopePublic,
+}
+
 // Создание инстанса grpc-сервера
-func New(config *config.Config, metricStorage Storage, fileWorker FileWorker) *MetricsGRPCServer {
+func New(config *config.Config, metricStorage Storage, fileWorker FileWorker, alertSource AlertSource) *MetricsGRPCServer {
 	return &MetricsGRPCServer{
 		metricStorage: metricStorage,
 		config:        config,
 		fileWorker:    fileWorker,
+		alertSource:   alertSource,
 	}
 }
 
@@ -28,6 +58,33 @@ type MetricsGRPCServer struct {
 	config        *config.Config
 	metricStorage Storage
 	fileWorker    FileWorker
+	alertSource   AlertSource
+	auditor       Auditor
+	ipChecker     *ipchecker.IPChecker
+	limiter       *ratelimit.Limiter
+}
+
+// Включение журнала аудита операций записи и администрирования
+func (mgs *MetricsGRPCServer) SetAuditor(auditor Auditor) {
+	mgs.auditor = auditor
+}
+
+// Проверка адреса клиента с учетом доверенных прокси. Адрес определяет агента
+// пачки, если стрим пришел без токена
+func (mgs *MetricsGRPCServer) SetIPChecker(ipChecker *ipchecker.IPChecker) {
+	mgs.ipChecker = ipChecker
+}
+
+// Ограничитель агентов, в котором освобождается квота удаленных и сброшенных метрик
+func (mgs *MetricsGRPCServer) SetLimiter(limiter *ratelimit.Limiter) {
+	mgs.limiter = limiter
+}
+
+// Запись операции в журнал аудита, если он включен
+func (mgs *MetricsGRPCServer) audit(ctx context.Context, entry audit.Entry) {
+	if mgs.auditor != nil {
+		mgs.auditor.RecordGRPC(ctx, entry)
+	}
 }
 
 func (mgs *MetricsGRPCServer) convert(m *pb.Metric) *metrics.Metric {
@@ -52,7 +109,21 @@ func (mgs *MetricsGRPCServer) convert(m *pb.Metric) *metrics.Metric {
 	return &res
 }
 
-// Единственная ручка обработки запросов у grpc-сервера
+// Сопоставление ошибки хранилища с кодом grpc
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, storageerrors.ErrInvalidMetric), errors.Is(err, storageerrors.ErrTypeMismatch):
+		return codes.InvalidArgument
+	case errors.Is(err, storageerrors.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, storageerrors.ErrUnavailable):
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// Ручка приема пачки метрик у grpc-сервера
 func (mgs *MetricsGRPCServer) PostMetrics(srv grpc.ClientStreamingServer[pb.Metric, pb.EmptyObject]) error {
 	ctx := srv.Context()
 	metricList := []metrics.Metric{}
@@ -62,8 +133,16 @@ func (mgs *MetricsGRPCServer) PostMetrics(srv grpc.ClientStreamingServer[pb.Metr
 			break
 		}
 
-		if err != nil || metric == nil {
+		if err != nil {
 			logging.Logger.Errorf("Failed while receiving metric %s", err.Error())
+			// ошибки interceptor'ов (подпись, повтор, расшифровка) возвращаются как есть,
+			// DataLoss - только если сообщение не удалось разобрать
+			if st, ok := status.FromError(err); ok && st.Code() != codes.Internal && st.Code() != codes.Unknown {
+				return err
+			}
+			return status.Error(codes.DataLoss, "Can't parse received metric")
+		}
+		if metric == nil {
 			return status.Error(codes.DataLoss, "Can't parse received metric")
 		}
 
@@ -75,13 +154,205 @@ func (mgs *MetricsGRPCServer) PostMetrics(srv grpc.ClientStreamingServer[pb.Metr
 		}
 	}
 
-	err := mgs.metricStorage.SaveMetrics(ctx, metricList)
+	ids := make([]string, 0, len(metricList))
+	keys := make([]string, 0, len(metricList))
+	for _, metric := range metricList {
+		if selfmetrics.Reserved(metric.ID) {
+			return status.Error(codes.PermissionDenied, "Metric name "+metric.ID+" is reserved for server metrics")
+		}
+		if !tokens.CanWriteAll(ctx, metric.ID) {
+			return status.Error(codes.PermissionDenied, "Metric name "+metric.ID+" is not allowed for token")
+		}
+		ids = append(ids, metric.ID)
+		keys = append(keys, ratelimit.MetricKey(metric.MType, metric.ID))
+	}
+	reservation, err := ratelimit.ReserveMetrics(ctx, keys...)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	applied := true
+	if batch, ok := mgs.batchKeyOf(ctx); ok {
+		applied, err = mgs.metricStorage.SaveBatch(ctx, batch, metricList)
+		if err == nil && !applied {
+			logging.Logger.Infof("Batch %s of agent %s was already applied", batch.BatchID, batch.AgentID)
+		}
+	} else {
+		err = mgs.metricStorage.SaveMetrics(ctx, metricList)
+	}
 	if err != nil {
+		reservation.Cancel()
 		logging.Logger.Errorf("%s", err.Error())
-		srv.SendAndClose(&pb.EmptyObject{})
-		return status.Error(codes.Internal, "Can't save metrics")
+		return errorStatus(err, "Can't save metrics")
+	}
+	if applied {
+		reservation.Commit()
+		mgs.audit(ctx, audit.NewEntry(audit.ActionWrite, ids...))
+	} else {
+		reservation.Duplicate()
 	}
 
 	srv.SendAndClose(&pb.EmptyObject{})
 	return nil
 }
+
+// Ключ пачки из метаданных x-batch-id. Агент определяется по имени токена, а
+// без токена - по адресу клиента, чтобы агент не мог выдать себя за другого
+func (mgs *MetricsGRPCServer) batchKeyOf(ctx context.Context) (metrics.BatchKey, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return metrics.BatchKey{}, false
+	}
+	values := md.Get("x-batch-id")
+	if len(values) == 0 || values[0] == "" {
+		return metrics.BatchKey{}, false
+	}
+	if identity, ok := tokens.FromContext(ctx); ok {
+		return metrics.BatchKey{AgentID: "token:" + identity.Name, BatchID: values[0]}, true
+	}
+	clientIP, _ := ipcheckermiddleware.GRPCClientIP(ctx, mgs.ipChecker)
+	return metrics.BatchKey{AgentID: "ip:" + clientIP.String(), BatchID: values[0]}, true
+}
+
+// Сопоставление ошибки хранилища со статусом grpc
+func errorStatus(err error, msg string) error {
+	code := errorCode(err)
+	if code == codes.Internal {
+		return status.Error(code, msg)
+	}
+	return status.Errorf(code, "%s: %s", msg, err.Error())
+}
+
+func (mgs *MetricsGRPCServer) metricType(t pb.Metric_Type) string {
+	switch t {
+	case pb.Metric_counter:
+		return "counter"
+	case pb.Metric_gauge:
+		return "gauge"
+	default:
+		return ""
+	}
+}
+
+// Получение одной метрики, для counter заполняется скорость роста в секунду
+func (mgs *MetricsGRPCServer) GetMetric(ctx context.Context, req *pb.MetricKey) (*pb.Metric, error) {
+	metric := metrics.Metric{ID: req.GetId(), MType: mgs.metricType(req.GetType())}
+	err := mgs.metricStorage.GetMetric(ctx, &metric)
+	if err != nil {
+		return nil, errorStatus(err, "Can't get metric")
+	}
+
+	res := &pb.Metric{Id: metric.ID, Type: req.GetType()}
+	if metric.Value != nil {
+		res.Value = *metric.Value
+	}
+	if metric.Delta != nil {
+		res.Delta = *metric.Delta
+		rate, err := mgs.metricStorage.CounterRate(ctx, metric.ID)
+		if err != nil {
+			return nil, errorStatus(err, "Can't get counter rate")
+		}
+		res.Rate = rate
+	}
+	return res, nil
+}
+
+// Удаление одной метрики, возвращает последнее значение удаленной метрики
+func (mgs *MetricsGRPCServer) DeleteMetric(ctx context.Context, req *pb.MetricKey) (*pb.Metric, error) {
+	metric := metrics.Metric{ID: req.GetId(), MType: mgs.metricType(req.GetType())}
+	err := mgs.metricStorage.DeleteMetric(ctx, &metric)
+	if err != nil {
+		logging.Logger.Errorf("%s", err.Error())
+		return nil, errorStatus(err, "Can't delete metric")
+	}
+	mgs.limiter.Release(ratelimit.MetricKey(metric.MType, metric.ID))
+	mgs.syncSnapshot(ctx)
+	mgs.audit(ctx, audit.NewEntry(audit.ActionDelete, metric.ID))
+
+	res := &pb.Metric{Id: metric.ID, Type: req.GetType()}
+	if metric.Delta != nil {
+		res.Delta = *metric.Delta
+	}
+	if metric.Value != nil {
+		res.Value = *metric.Value
+	}
+	return res, nil
+}
+
+// Удаление всех метрик, имена которых подходят под шаблон
+func (mgs *MetricsGRPCServer) DeleteMetrics(ctx context.Context, req *pb.DeleteMetricsRequest) (*pb.DeleteMetricsResponse, error) {
+	if req.GetPattern() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Pattern is required")
+	}
+	deleted, err := mgs.metricStorage.DeleteMetrics(ctx, req.GetType(), req.GetPattern())
+	if err != nil {
+		logging.Logger.Errorf("%s", err.Error())
+		return nil, errorStatus(err, "Can't delete metrics")
+	}
+	if deleted > 0 {
+		mgs.limiter.ReleasePattern(req.GetType(), req.GetPattern())
+	}
+	mgs.syncSnapshot(ctx)
+	mgs.audit(ctx, audit.Entry{Action: audit.ActionDelete, Count: deleted, Pattern: req.GetPattern()})
+	return &pb.DeleteMetricsResponse{Deleted: int64(deleted)}, nil
+}
+
+// Сброс counter метрики в ноль
+func (mgs *MetricsGRPCServer) ResetCounter(ctx context.Context, req *pb.ResetCounterRequest) (*pb.EmptyObject, error) {
+	err := mgs.metricStorage.ResetCounter(ctx, req.GetId())
+	if err != nil {
+		logging.Logger.Errorf("%s", err.Error())
+		return nil, errorStatus(err, "Can't reset counter")
+	}
+	mgs.syncSnapshot(ctx)
+	mgs.audit(ctx, audit.NewEntry(audit.ActionReset, req.GetId()))
+	return &pb.EmptyObject{}, nil
+}
+
+func (mgs *MetricsGRPCServer) convertLabels(labels map[string]string) []*pb.Label {
+	res := make([]*pb.Label, 0, len(labels))
+	for name, value := range labels {
+		res = append(res, &pb.Label{Name: name, Value: value})
+	}
+	slices.SortFunc(res, func(a, b *pb.Label) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res
+}
+
+func (mgs *MetricsGRPCServer) convertAlert(alert alerts.Alert) *pb.Alert {
+	res := &pb.Alert{
+		Name:        alert.Name,
+		Expr:        alert.Expr,
+		State:       alert.State,
+		Value:       alert.Value,
+		ActiveAt:    alert.ActiveAt.UnixMilli(),
+		Labels:      mgs.convertLabels(alert.Labels),
+		Annotations: mgs.convertLabels(alert.Annotations),
+	}
+	if alert.FiredAt != nil {
+		res.FiredAt = alert.FiredAt.UnixMilli()
+	}
+	if alert.ResolvedAt != nil {
+		res.ResolvedAt = alert.ResolvedAt.UnixMilli()
+	}
+	return res
+}
+
+// Получение алертов, время в ответе - unix время в миллисекундах
+func (mgs *MetricsGRPCServer) GetAlerts(ctx context.Context, req *pb.GetAlertsRequest) (*pb.AlertList, error) {
+	res := &pb.AlertList{}
+	for _, alert := range mgs.alertSource.Alerts(req.GetIncludeResolved()) {
+		res.Alerts = append(res.Alerts, mgs.convertAlert(alert))
+	}
+	return res, nil
+}
+
+// Синхронное сохранение слепка метрик в файл, если периодическое сохранение выключено
+func (mgs *MetricsGRPCServer) syncSnapshot(ctx context.Context) {
+	if mgs.config.StoreInterval == int64(0) {
+		fileCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		defer cancel()
+		mgs.fileWorker.ImportToFile(fileCtx)
+	}
+}
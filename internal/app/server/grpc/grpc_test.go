@@ -0,0 +1,245 @@
+package metricsgrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ry461ch/metric-collector/internal/app/server/crontasks/alerting"
+	config "github.com/ry461ch/metric-collector/internal/config/server"
+	"github.com/ry461ch/metric-collector/internal/fileworker"
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+	"github.com/ry461ch/metric-collector/internal/rules"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	ratelimitmiddleware "github.com/ry461ch/metric-collector/pkg/ratelimit/middleware"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, codes.InvalidArgument, errorCode(storageerrors.NewInvalidMetricError("test", "id is required")))
+	assert.Equal(t, codes.InvalidArgument, errorCode(storageerrors.NewTypeMismatchError("test", "invalid")))
+	assert.Equal(t, codes.NotFound, errorCode(storageerrors.ErrNotFound))
+	assert.Equal(t, codes.Unavailable, errorCode(storageerrors.Unavailable(errors.New("timeout"))))
+	assert.Equal(t, codes.Internal, errorCode(errors.New("unknown")))
+}
+
+func TestConvert(t *testing.T) {
+	server := &MetricsGRPCServer{}
+
+	metric := server.convert(&pb.Metric{Id: "test", Type: pb.Metric_counter, Delta: 5})
+	assert.Equal(t, "counter", metric.MType)
+	assert.Equal(t, int64(5), *metric.Delta)
+
+	metric = server.convert(&pb.Metric{Id: "test", Type: pb.Metric_gauge, Value: 1.5})
+	assert.Equal(t, "gauge", metric.MType)
+	assert.Equal(t, 1.5, *metric.Value)
+
+	assert.Nil(t, server.convert(nil))
+}
+
+func newTestClient(t *testing.T, metricStorage Storage, alertSource AlertSource, opts ...grpc.ServerOption) pb.MetricsClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(server, New(&config.Config{StoreInterval: 1}, metricStorage, fileworker.New("", metricStorage), alertSource))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func TestDeleteAndReset(t *testing.T) {
+	logging.Initialize("INFO")
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	value := 1.5
+	delta := int64(10)
+	memStorage.SaveMetrics(ctx, []metrics.Metric{
+		{ID: "HostCPU", MType: "gauge", Value: &value},
+		{ID: "HostMem", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	})
+	client := newTestClient(t, memStorage, alerting.New(1, nil, memStorage, nil))
+
+	deleted, err := client.DeleteMetric(ctx, &pb.MetricKey{Id: "HostCPU", Type: pb.Metric_gauge})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, deleted.GetValue(), "должно вернуться последнее значение удаленной метрики")
+
+	_, err = client.DeleteMetric(ctx, &pb.MetricKey{Id: "HostCPU", Type: pb.Metric_gauge})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	resp, err := client.DeleteMetrics(ctx, &pb.DeleteMetricsRequest{Pattern: "Host*"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetDeleted())
+
+	_, err = client.DeleteMetrics(ctx, &pb.DeleteMetricsRequest{Pattern: ""})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.DeleteMetrics(ctx, &pb.DeleteMetricsRequest{Pattern: "*", Type: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ResetCounter(ctx, &pb.ResetCounterRequest{Id: "PollCount"})
+	require.NoError(t, err)
+	searchMetric := metrics.Metric{ID: "PollCount", MType: "counter"}
+	require.NoError(t, memStorage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(0), *searchMetric.Delta)
+
+	_, err = client.ResetCounter(ctx, &pb.ResetCounterRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGetAlerts(t *testing.T) {
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	value := 200.0
+	memStorage.SaveMetrics(ctx, []metrics.Metric{{ID: "HeapInuse", MType: "gauge", Value: &value}})
+
+	ruleFile := &rules.File{Alerts: []rules.AlertRule{
+		{Name: "HighHeap", Expr: "HeapInuse > 100", Labels: map[string]string{"severity": "warning", "team": "core"}},
+		{Name: "LowHeap", Expr: "HeapInuse < 100"},
+	}}
+	require.NoError(t, ruleFile.Validate())
+	engine := alerting.New(1, ruleFile.Alerts, memStorage, nil)
+	now := time.Now()
+	require.NoError(t, engine.Evaluate(ctx, now))
+
+	client := newTestClient(t, memStorage, engine)
+
+	resp, err := client.GetAlerts(ctx, &pb.GetAlertsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetAlerts(), 1)
+	alert := resp.GetAlerts()[0]
+	assert.Equal(t, "HighHeap", alert.GetName())
+	assert.Equal(t, "firing", alert.GetState())
+	assert.Equal(t, 200.0, alert.GetValue())
+	assert.Equal(t, now.UnixMilli(), alert.GetFiredAt())
+	assert.Equal(t, int64(0), alert.GetResolvedAt())
+	require.Len(t, alert.GetLabels(), 2)
+	assert.Equal(t, "severity", alert.GetLabels()[0].GetName())
+	assert.Equal(t, "team", alert.GetLabels()[1].GetName())
+}
+
+func TestGetMetric(t *testing.T) {
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	value := 1.5
+	delta := int64(10)
+	memStorage.SaveMetrics(ctx, []metrics.Metric{
+		{ID: "HostCPU", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	})
+	time.Sleep(20 * time.Millisecond)
+	memStorage.SaveMetrics(ctx, []metrics.Metric{{ID: "PollCount", MType: "counter", Delta: &delta}})
+	client := newTestClient(t, memStorage, alerting.New(1, nil, memStorage, nil))
+
+	metric, err := client.GetMetric(ctx, &pb.MetricKey{Id: "HostCPU", Type: pb.Metric_gauge})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, metric.GetValue())
+	assert.Equal(t, 0.0, metric.GetRate())
+
+	metric, err = client.GetMetric(ctx, &pb.MetricKey{Id: "PollCount", Type: pb.Metric_counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), metric.GetDelta())
+	assert.Greater(t, metric.GetRate(), 0.0)
+
+	_, err = client.GetMetric(ctx, &pb.MetricKey{Id: "unknown", Type: pb.Metric_counter})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestPostMetricsBatchID(t *testing.T) {
+	logging.Initialize("INFO")
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	client := newTestClient(t, memStorage, alerting.New(1, nil, memStorage, nil))
+
+	post := func(agentID, batchID string) {
+		stream, err := client.PostMetrics(metadata.AppendToOutgoingContext(ctx, "x-agent-id", agentID, "x-batch-id", batchID))
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.Metric{Id: "PollCount", Type: pb.Metric_counter, Delta: 5}))
+		_, err = stream.CloseAndRecv()
+		require.NoError(t, err)
+	}
+	post("agent", "1")
+	post("agent", "1")
+	// агент определяется по токену или адресу, а не по x-agent-id
+	post("spoofed", "1")
+	post("agent", "2")
+
+	metric := metrics.Metric{ID: "PollCount", MType: "counter"}
+	require.NoError(t, memStorage.GetMetric(ctx, &metric))
+	assert.Equal(t, int64(10), *metric.Delta, "повтор пачки не должен учитываться повторно")
+}
+
+type rejectingServerStream struct {
+	grpc.ServerStream
+}
+
+func (rejectingServerStream) RecvMsg(m interface{}) error {
+	return status.Error(codes.Unauthenticated, "invalid message signature")
+}
+
+func TestPostMetricsInterceptorError(t *testing.T) {
+	logging.Initialize("INFO")
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	client := newTestClient(t, memStorage, alerting.New(1, nil, memStorage, nil),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, rejectingServerStream{ServerStream: ss})
+		}))
+
+	stream, err := client.PostMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.Metric{Id: "PollCount", Type: pb.Metric_counter, Delta: 1}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "ошибка подписи не должна превращаться в DataLoss")
+}
+
+func TestPostMetricsQuota(t *testing.T) {
+	logging.Initialize("INFO")
+	ctx := context.Background()
+	memStorage := memstorage.New()
+	memStorage.Initialize(ctx)
+	limiter := ratelimit.New(ratelimit.Limits{MaxMetricIDs: 1}, nil)
+	client := newTestClient(t, memStorage, alerting.New(1, nil, memStorage, nil),
+		grpc.StreamInterceptor(ratelimitmiddleware.LimitGRPCRequests(limiter, nil, []string{pb.Metrics_PostMetrics_FullMethodName})))
+
+	post := func(id string) error {
+		stream, err := client.PostMetrics(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.Metric{Id: id, Type: pb.Metric_counter, Delta: 1}))
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	assert.NoError(t, post("PollCount"))
+	assert.NoError(t, post("PollCount"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(post("Other")))
+
+	metric := metrics.Metric{ID: "Other", MType: "counter"}
+	assert.ErrorIs(t, memStorage.GetMetric(ctx, &metric), storageerrors.ErrNotFound)
+}
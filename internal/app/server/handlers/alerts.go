@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+)
+
+type (
+	// AlertHandlers - обработчики запросов на получение алертов
+	AlertHandlers struct {
+		alertSource AlertSource
+	}
+
+	// ResponseAlertListObject - список алертов
+	ResponseAlertListObject struct {
+		Alerts []alerts.Alert `json:"alerts"` // алерты, отсортированные по имени
+	}
+)
+
+// Init alert handlers
+func NewAlertHandlers(alertSource AlertSource) *AlertHandlers {
+	return &AlertHandlers{alertSource: alertSource}
+}
+
+// GetAlertsHandler godoc
+// @Summary Get alerts
+// @Description Get pending and firing alerts, resolved alerts are returned only if requested
+// @ID alertsGetAlerts
+// @Produce application/json
+// @Param resolved query bool false "Include resolved alerts" default(false)
+// @Success 200 {object} ResponseAlertListObject
+// @Failure 400 {object} ResponseErrorObject
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /alerts [get]
+func (ah *AlertHandlers) GetAlertsHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	includeResolved := false
+	if rawResolved := req.URL.Query().Get("resolved"); rawResolved != "" {
+		var err error
+		includeResolved, err = strconv.ParseBool(rawResolved)
+		if err != nil {
+			respErr, _ := json.Marshal(ResponseErrorObject{Detail: "Bad request format: resolved must be a boolean"})
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write(respErr)
+			return
+		}
+	}
+
+	resp, err := json.Marshal(ResponseAlertListObject{Alerts: ah.alertSource.Alerts(includeResolved)})
+	if err != nil {
+		respErr, _ := json.Marshal(ResponseErrorObject{Detail: "Internal Server Error"})
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write(respErr)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+)
+
+type MockAlertSource struct {
+	alertList []alerts.Alert
+}
+
+func (m *MockAlertSource) Alerts(includeResolved bool) []alerts.Alert {
+	res := []alerts.Alert{}
+	for _, alert := range m.alertList {
+		if includeResolved || alert.IsActive() {
+			res = append(res, alert)
+		}
+	}
+	return res
+}
+
+func TestGetAlertsHandler(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	source := &MockAlertSource{alertList: []alerts.Alert{
+		{Name: "HighHeap", Expr: "HeapInuse > 100", State: alerts.StateFiring, Value: 200, ActiveAt: now, FiredAt: &now},
+		{Name: "Stalled", Expr: "PollCount rate == 0", State: alerts.StateResolved, ActiveAt: now, ResolvedAt: &now},
+	}}
+	handlers := NewAlertHandlers(source)
+
+	testCases := []struct {
+		testName       string
+		requestPath    string
+		expectedCode   int
+		expectedAlerts []string
+	}{
+		{testName: "active only", requestPath: "/alerts", expectedCode: http.StatusOK, expectedAlerts: []string{"HighHeap"}},
+		{testName: "with resolved", requestPath: "/alerts?resolved=true", expectedCode: http.StatusOK, expectedAlerts: []string{"HighHeap", "Stalled"}},
+		{testName: "invalid resolved", requestPath: "/alerts?resolved=maybe", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.requestPath, nil)
+			res := httptest.NewRecorder()
+			handlers.GetAlertsHandler(res, req)
+
+			assert.Equal(t, tc.expectedCode, res.Code)
+			assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var body ResponseAlertListObject
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+			names := []string{}
+			for _, alert := range body.Alerts {
+				names = append(names, alert.Name)
+			}
+			assert.Equal(t, tc.expectedAlerts, names)
+		})
+	}
+}
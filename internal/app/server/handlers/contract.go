@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/ry461ch/metric-collector/internal/app/server/health"
+	"github.com/ry461ch/metric-collector/internal/audit"
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 )
 
@@ -10,7 +14,13 @@ import (
 type Storage interface {
 	ExtractMetrics(ctx context.Context) ([]metrics.Metric, error)
 	SaveMetrics(ctx context.Context, metricList []metrics.Metric) error
+	SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error)
 	GetMetric(ctx context.Context, metric *metrics.Metric) error
+	DeleteMetric(ctx context.Context, metric *metrics.Metric) error
+	DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error)
+	ResetCounter(ctx context.Context, id string) error
+	CounterRate(ctx context.Context, id string) (float64, error)
+	ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error)
 }
 
 // ExternalStorage для удаленного хранилища метрик + функцональность доступности хранлища
@@ -23,3 +33,18 @@ type ExternalStorage interface {
 type FileWorker interface {
 	ImportToFile(ctx context.Context) error
 }
+
+// AlertSource - интерфейс для получения вычисленных алертов
+type AlertSource interface {
+	Alerts(includeResolved bool) []alerts.Alert
+}
+
+// Auditor - интерфейс журнала аудита операций записи и администрирования
+type Auditor interface {
+	RecordHTTP(req *http.Request, entry audit.Entry)
+}
+
+// HealthChecker - интерфейс проверки готовности сервера по компонентам
+type HealthChecker interface {
+	Check(ctx context.Context) health.Response
+}
@@ -6,16 +6,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"html"
 	"io"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgerrcode"
 
+	"github.com/ry461ch/metric-collector/internal/app/server/selfmetrics"
+	"github.com/ry461ch/metric-collector/internal/audit"
 	config "github.com/ry461ch/metric-collector/internal/config/server"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/ipchecker"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
 )
 
 // @Title Metric API
@@ -26,6 +32,10 @@ import (
 // @In header
 // @Name HashSHA256
 
+// @SecurityDefinitions.apikey BearerAuth
+// @In header
+// @Name Authorization
+
 // Типы для работы хэндлеров сервера метрик
 type (
 	// Handlers - обработчики запросов на сохранение/получение метрик
@@ -33,17 +43,39 @@ type (
 		config        *config.Config
 		metricStorage Storage
 		fileWorker    FileWorker
+		auditor       Auditor
+		ipChecker     *ipchecker.IPChecker
+		limiter       *ratelimit.Limiter
 	}
 
 	// ResponseEmptyObject - пустой объект для возврата из функций с content-type=application/json
 	ResponseEmptyObject struct{}
 
+	// ResponseDeletedObject - результат массового удаления метрик
+	ResponseDeletedObject struct {
+		Deleted int `json:"deleted"` // кол-во удаленных метрик
+	}
+
+	// ResponseMetricListObject - страница списка метрик
+	ResponseMetricListObject struct {
+		Metrics    []metrics.Metric `json:"metrics"`               // метрики, отсортированные по имени и типу
+		NextCursor string           `json:"next_cursor,omitempty"` // курсор следующей страницы, пустой на последней странице
+	}
+
 	// ResponseErrorObject - объект-ошибка для возврата из функций с content-type=application/json
 	ResponseErrorObject struct {
+		Code   string `json:"code,omitempty"`
 		Detail string `json:"detail,omitempty"`
 	}
 )
 
+const (
+	// Размер страницы списка метрик по умолчанию
+	defaultListLimit = 100
+	// Максимальный размер страницы списка метрик
+	maxListLimit = 1000
+)
+
 // Init metric handlers
 func New(config *config.Config, metricStorage Storage, fileWorker FileWorker) *Handlers {
 	return &Handlers{
@@ -53,64 +85,223 @@ func New(config *config.Config, metricStorage Storage, fileWorker FileWorker) *H
 	}
 }
 
-func (h *Handlers) saveMetrics(ctx context.Context, metricList []metrics.Metric) error {
-	for i := 0; i <= 1; i += 1 {
-		DBCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-		defer cancel()
-		err := h.metricStorage.SaveMetrics(DBCtx, metricList)
-		if err == nil {
-			break
-		}
-		if pgerrcode.IsConnectionException(err.Error()) && i != 1 {
-			cancel()
-			time.Sleep(time.Second * time.Duration(1))
-			continue
-		}
-		if err.Error() == "INVALID_METRIC" {
-			return err
+// Включение журнала аудита операций записи и администрирования
+func (h *Handlers) SetAuditor(auditor Auditor) {
+	h.auditor = auditor
+}
+
+// Проверка адреса клиента с учетом доверенных прокси. Адрес определяет агента
+// пачки, если запрос пришел без токена
+func (h *Handlers) SetIPChecker(ipChecker *ipchecker.IPChecker) {
+	h.ipChecker = ipChecker
+}
+
+// Ограничитель агентов, в котором освобождается квота удаленных и сброшенных метрик
+func (h *Handlers) SetLimiter(limiter *ratelimit.Limiter) {
+	h.limiter = limiter
+}
+
+// Запись операции в журнал аудита, если он включен
+func (h *Handlers) audit(req *http.Request, entry audit.Entry) {
+	if h.auditor != nil {
+		h.auditor.RecordHTTP(req, entry)
+	}
+}
+
+// Сопоставление ошибки хранилища с http статусом и телом ответа
+func errorResponse(err error) (int, ResponseErrorObject) {
+	var invalidErr *storageerrors.InvalidMetricError
+	switch {
+	case errors.As(err, &invalidErr):
+		return http.StatusBadRequest, ResponseErrorObject{Code: storageerrors.ErrInvalidMetric.Error(), Detail: "Bad request format: " + invalidErr.Reason}
+	case errors.Is(err, storageerrors.ErrInvalidMetric):
+		return http.StatusBadRequest, ResponseErrorObject{Code: storageerrors.ErrInvalidMetric.Error(), Detail: "Bad request format"}
+	case errors.Is(err, storageerrors.ErrTypeMismatch):
+		return http.StatusBadRequest, ResponseErrorObject{Code: storageerrors.ErrTypeMismatch.Error(), Detail: "Bad metric type"}
+	case errors.Is(err, storageerrors.ErrNotFound):
+		return http.StatusNotFound, ResponseErrorObject{Code: storageerrors.ErrNotFound.Error(), Detail: "Metric not found"}
+	case errors.Is(err, storageerrors.ErrUnavailable):
+		return http.StatusServiceUnavailable, ResponseErrorObject{Code: storageerrors.ErrUnavailable.Error(), Detail: "Storage unavailable"}
+	default:
+		return http.StatusInternalServerError, ResponseErrorObject{Detail: "Internal Server Error"}
+	}
+}
+
+// Запись ошибки хранилища в ответ с content-type=application/json
+func writeJSONError(res http.ResponseWriter, err error) {
+	status, errObject := errorResponse(err)
+	resp, _ := json.Marshal(errObject)
+	res.WriteHeader(status)
+	res.Write(resp)
+}
+
+func metricIDs(metricList []metrics.Metric) []string {
+	ids := make([]string, 0, len(metricList))
+	for _, metric := range metricList {
+		ids = append(ids, metric.ID)
+	}
+	return ids
+}
+
+// Проверка, что токен запроса разрешает запись всех метрик. Метрики сервера
+// записывать нельзя
+func writeAllowed(req *http.Request, metricList []metrics.Metric) bool {
+	for _, metric := range metricList {
+		if selfmetrics.Reserved(metric.ID) {
+			return false
 		}
-		return errors.New("INTERNAL_SERVER_ERROR")
 	}
-	return nil
+	return tokens.CanWriteAll(req.Context(), metricIDs(metricList)...)
 }
 
-func (h *Handlers) getMetric(ctx context.Context, metric *metrics.Metric) error {
+// Резерв лимитов агента под метрики, ошибка - если лимит или квота превышены.
+// Резерв подтверждается после сохранения и отменяется, если пачка не применилась
+func reserveLimits(req *http.Request, metricList []metrics.Metric) (*ratelimit.Reservation, error) {
+	keys := make([]string, 0, len(metricList))
+	for _, metric := range metricList {
+		keys = append(keys, ratelimit.MetricKey(metric.MType, metric.ID))
+	}
+	return ratelimit.ReserveMetrics(req.Context(), keys...)
+}
+
+// Ответ на запись сверх лимита агента с content-type=application/json
+func writeJSONTooManyRequests(res http.ResponseWriter, err error) {
+	resp, _ := json.Marshal(ResponseErrorObject{Code: "too_many_requests", Detail: err.Error()})
+	res.Header().Set("Retry-After", "1")
+	res.WriteHeader(http.StatusTooManyRequests)
+	res.Write(resp)
+}
+
+// Ответ на запись метрики, запрещенной токеном, с content-type=application/json
+func writeJSONForbidden(res http.ResponseWriter) {
+	resp, _ := json.Marshal(ResponseErrorObject{Code: "forbidden", Detail: "Metric name is not allowed for token"})
+	res.WriteHeader(http.StatusForbidden)
+	res.Write(resp)
+}
+
+// Запись ошибки хранилища в ответ с content-type=text/plain
+func writePlainError(res http.ResponseWriter, err error) {
+	status, _ := errorResponse(err)
+	res.WriteHeader(status)
+}
+
+// Повтор операции с хранилищем, если хранилище было временно недоступно
+func retryUnavailable(ctx context.Context, timeout time.Duration, op func(ctx context.Context) error) error {
+	var err error
 	for i := 0; i <= 1; i += 1 {
-		DBCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-		defer cancel()
-		err := h.metricStorage.GetMetric(DBCtx, metric)
-		if err == nil {
+		DBCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = op(DBCtx)
+		cancel()
+		if err == nil || !errors.Is(err, storageerrors.ErrUnavailable) || i == 1 {
 			break
 		}
-		if pgerrcode.IsConnectionException(err.Error()) && i != 1 {
-			cancel()
-			time.Sleep(time.Second * time.Duration(1))
-			continue
-		}
-		if err.Error() == "NOT_FOUND" || err.Error() == "INVALID_METRIC_TYPE" {
-			return err
-		}
-		return errors.New("INTERNAL_SERVER_ERROR")
+		time.Sleep(time.Second * time.Duration(1))
 	}
-	return nil
+	return err
+}
+
+func (h *Handlers) saveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	return retryUnavailable(ctx, 1*time.Second, func(ctx context.Context) error {
+		return h.metricStorage.SaveMetrics(ctx, metricList)
+	})
+}
+
+func (h *Handlers) saveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	var applied bool
+	err := retryUnavailable(ctx, 1*time.Second, func(ctx context.Context) error {
+		var err error
+		applied, err = h.metricStorage.SaveBatch(ctx, batch, metricList)
+		return err
+	})
+	return applied, err
+}
+
+// Ключ пачки из заголовка X-Batch-ID. Агент определяется по имени токена, а
+// без токена - по адресу клиента, чтобы агент не мог выдать себя за другого
+func (h *Handlers) batchKeyOf(req *http.Request) (metrics.BatchKey, bool) {
+	batchID := req.Header.Get("X-Batch-ID")
+	if batchID == "" {
+		return metrics.BatchKey{}, false
+	}
+	if identity, ok := tokens.FromContext(req.Context()); ok {
+		return metrics.BatchKey{AgentID: "token:" + identity.Name, BatchID: batchID}, true
+	}
+	clientIP := h.ipChecker.ClientIP(req.RemoteAddr, req.Header.Get("X-Forwarded-For"), req.Header.Get("X-Real-IP"))
+	return metrics.BatchKey{AgentID: "ip:" + clientIP.String(), BatchID: batchID}, true
+}
+
+func (h *Handlers) getMetric(ctx context.Context, metric *metrics.Metric) error {
+	return retryUnavailable(ctx, 1*time.Second, func(ctx context.Context) error {
+		return h.metricStorage.GetMetric(ctx, metric)
+	})
 }
 
 func (h *Handlers) extractMetrics(ctx context.Context) ([]metrics.Metric, error) {
-	for i := 0; i <= 1; i += 1 {
-		DBCtx, cancel := context.WithTimeout(ctx, 4*time.Second)
+	var metricList []metrics.Metric
+	err := retryUnavailable(ctx, 4*time.Second, func(ctx context.Context) error {
+		var err error
+		metricList, err = h.metricStorage.ExtractMetrics(ctx)
+		return err
+	})
+	return metricList, err
+}
+
+func (h *Handlers) deleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	err := retryUnavailable(ctx, 1*time.Second, func(ctx context.Context) error {
+		return h.metricStorage.DeleteMetric(ctx, metric)
+	})
+	if err == nil {
+		h.limiter.Release(ratelimit.MetricKey(metric.MType, metric.ID))
+	}
+	return err
+}
+
+func (h *Handlers) deleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	var deleted int
+	err := retryUnavailable(ctx, 4*time.Second, func(ctx context.Context) error {
+		var err error
+		deleted, err = h.metricStorage.DeleteMetrics(ctx, mType, pattern)
+		return err
+	})
+	if err == nil && deleted > 0 {
+		h.limiter.ReleasePattern(mType, pattern)
+	}
+	return deleted, err
+}
+
+func (h *Handlers) resetCounter(ctx context.Context, id string) error {
+	return retryUnavailable(ctx, 1*time.Second, func(ctx context.Context) error {
+		return h.metricStorage.ResetCounter(ctx, id)
+	})
+}
+
+func (h *Handlers) counterRate(ctx context.Context, id string) (float64, error) {
+	var rate float64
+	err := retryUnavailable(ctx, 1*time.Second, func(ctx context.Context) error {
+		var err error
+		rate, err = h.metricStorage.CounterRate(ctx, id)
+		return err
+	})
+	return rate, err
+}
+
+func (h *Handlers) listMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	var metricList []metrics.Metric
+	err := retryUnavailable(ctx, 4*time.Second, func(ctx context.Context) error {
+		var err error
+		metricList, err = h.metricStorage.ListMetrics(ctx, query)
+		return err
+	})
+	return metricList, err
+}
+
+// Синхронное сохранение слепка метрик в файл, если периодическое сохранение выключено
+func (h *Handlers) syncSnapshot(ctx context.Context) {
+	if h.config.StoreInterval == int64(0) {
+		fileCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 		defer cancel()
-		metricList, err := h.metricStorage.ExtractMetrics(DBCtx)
-		if err == nil {
-			return metricList, nil
-		}
-		if pgerrcode.IsConnectionException(err.Error()) && i != 1 {
-			cancel()
-			time.Sleep(time.Second * time.Duration(1))
-			continue
-		}
-		return nil, errors.New("INTERNAL_SERVER_ERROR")
+		h.fileWorker.ImportToFile(fileCtx)
 	}
-	return []metrics.Metric{}, nil
 }
 
 // PostPlainGaugeHandler godoc
@@ -124,7 +315,12 @@ func (h *Handlers) extractMetrics(ctx context.Context) ([]metrics.Metric, error)
 // @Success 200 {string} string "OK"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 429 {string} string "Too Many Requests"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /update/gauge/{name}/{value} [post]
 func (h *Handlers) PostPlainGaugeHandler(res http.ResponseWriter, req *http.Request) {
 	metricName := chi.URLParam(req, "name")
@@ -141,17 +337,27 @@ func (h *Handlers) PostPlainGaugeHandler(res http.ResponseWriter, req *http.Requ
 		},
 	}
 
-	err = h.saveMetrics(req.Context(), metricList)
+	if !writeAllowed(req, metricList) {
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+	reservation, err := reserveLimits(req, metricList)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		res.Header().Set("Retry-After", "1")
+		res.WriteHeader(http.StatusTooManyRequests)
 		return
 	}
 
-	if h.config.StoreInterval == int64(0) {
-		fileCtx, cancel := context.WithTimeout(req.Context(), 1*time.Second)
-		defer cancel()
-		h.fileWorker.ImportToFile(fileCtx)
+	err = h.saveMetrics(req.Context(), metricList)
+	if err != nil {
+		reservation.Cancel()
+		writePlainError(res, err)
+		return
 	}
+	reservation.Commit()
+	h.audit(req, audit.NewEntry(audit.ActionWrite, metricName))
+
+	h.syncSnapshot(req.Context())
 	res.WriteHeader(http.StatusOK)
 }
 
@@ -166,7 +372,12 @@ func (h *Handlers) PostPlainGaugeHandler(res http.ResponseWriter, req *http.Requ
 // @Success 200 {string} string "OK"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 429 {string} string "Too Many Requests"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /update/counter/{name}/{value} [post]
 func (h *Handlers) PostPlainCounterHandler(res http.ResponseWriter, req *http.Request) {
 	metricName := chi.URLParam(req, "name")
@@ -183,17 +394,27 @@ func (h *Handlers) PostPlainCounterHandler(res http.ResponseWriter, req *http.Re
 		},
 	}
 
-	err = h.saveMetrics(req.Context(), metricList)
+	if !writeAllowed(req, metricList) {
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+	reservation, err := reserveLimits(req, metricList)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		res.Header().Set("Retry-After", "1")
+		res.WriteHeader(http.StatusTooManyRequests)
 		return
 	}
 
-	if h.config.StoreInterval == int64(0) {
-		fileCtx, cancel := context.WithTimeout(req.Context(), 1*time.Second)
-		defer cancel()
-		h.fileWorker.ImportToFile(fileCtx)
+	err = h.saveMetrics(req.Context(), metricList)
+	if err != nil {
+		reservation.Cancel()
+		writePlainError(res, err)
+		return
 	}
+	reservation.Commit()
+	h.audit(req, audit.NewEntry(audit.ActionWrite, metricName))
+
+	h.syncSnapshot(req.Context())
 	res.WriteHeader(http.StatusOK)
 }
 
@@ -208,7 +429,11 @@ func (h *Handlers) PostPlainCounterHandler(res http.ResponseWriter, req *http.Re
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /value/counter/{name} [get]
 func (h *Handlers) GetPlainCounterHandler(res http.ResponseWriter, req *http.Request) {
 	metricName := chi.URLParam(req, "name")
@@ -219,11 +444,7 @@ func (h *Handlers) GetPlainCounterHandler(res http.ResponseWriter, req *http.Req
 
 	err := h.getMetric(req.Context(), &metric)
 	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			res.WriteHeader(http.StatusNotFound)
-			return
-		}
-		res.WriteHeader(http.StatusInternalServerError)
+		writePlainError(res, err)
 		return
 	}
 
@@ -241,7 +462,11 @@ func (h *Handlers) GetPlainCounterHandler(res http.ResponseWriter, req *http.Req
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /value/gauge/{name} [get]
 func (h *Handlers) GetPlainGaugeHandler(res http.ResponseWriter, req *http.Request) {
 	metricName := chi.URLParam(req, "name")
@@ -252,11 +477,7 @@ func (h *Handlers) GetPlainGaugeHandler(res http.ResponseWriter, req *http.Reque
 
 	err := h.getMetric(req.Context(), &metric)
 	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			res.WriteHeader(http.StatusNotFound)
-			return
-		}
-		res.WriteHeader(http.StatusInternalServerError)
+		writePlainError(res, err)
 		return
 	}
 
@@ -271,23 +492,27 @@ func (h *Handlers) GetPlainGaugeHandler(res http.ResponseWriter, req *http.Reque
 // @Produce text/plain
 // @Success 200 {string} string "OK"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router / [get]
 func (h *Handlers) GetPlainAllMetricsHandler(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	metricList, err := h.extractMetrics(req.Context())
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+		writePlainError(res, err)
 		return
 	}
 
 	for _, metric := range metricList {
 		switch metric.MType {
 		case "counter":
-			io.WriteString(res, metric.ID+" : "+strconv.FormatInt(*metric.Delta, 10)+"\n")
+			io.WriteString(res, html.EscapeString(metric.ID)+" : "+strconv.FormatInt(*metric.Delta, 10)+"\n")
 		case "gauge":
-			io.WriteString(res, metric.ID+" : "+strconv.FormatFloat(*metric.Value, 'f', -1, 64)+"\n")
+			io.WriteString(res, html.EscapeString(metric.ID)+" : "+strconv.FormatFloat(*metric.Value, 'f', -1, 64)+"\n")
 		default:
 			res.WriteHeader(http.StatusInternalServerError)
 			return
@@ -305,7 +530,12 @@ func (h *Handlers) GetPlainAllMetricsHandler(res http.ResponseWriter, req *http.
 // @Success 200 {string} string "OK"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 429 {string} string "Too Many Requests"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /update [post]
 func (h *Handlers) PostJSONHandler(res http.ResponseWriter, req *http.Request) {
 	var buf bytes.Buffer
@@ -325,25 +555,26 @@ func (h *Handlers) PostJSONHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = h.saveMetrics(req.Context(), []metrics.Metric{metric})
+	if !writeAllowed(req, []metrics.Metric{metric}) {
+		writeJSONForbidden(res)
+		return
+	}
+	reservation, err := reserveLimits(req, []metrics.Metric{metric})
 	if err != nil {
-		if err.Error() == "INVALID_METRIC" {
-			resp, _ := json.Marshal(ResponseErrorObject{Detail: "Bad request format"})
-			res.WriteHeader(http.StatusBadRequest)
-			res.Write(resp)
-			return
-		}
-		resp, _ := json.Marshal(ResponseErrorObject{Detail: "Internal Server Error"})
-		res.WriteHeader(http.StatusInternalServerError)
-		res.Write(resp)
+		writeJSONTooManyRequests(res, err)
 		return
 	}
 
-	if h.config.StoreInterval == int64(0) {
-		fileCtx, cancel := context.WithTimeout(req.Context(), 1*time.Second)
-		defer cancel()
-		h.fileWorker.ImportToFile(fileCtx)
+	err = h.saveMetrics(req.Context(), []metrics.Metric{metric})
+	if err != nil {
+		reservation.Cancel()
+		writeJSONError(res, err)
+		return
 	}
+	reservation.Commit()
+	h.audit(req, audit.NewEntry(audit.ActionWrite, metric.ID))
+
+	h.syncSnapshot(req.Context())
 
 	resp, _ := json.Marshal(ResponseEmptyObject{})
 	res.WriteHeader(http.StatusOK)
@@ -361,7 +592,11 @@ func (h *Handlers) PostJSONHandler(res http.ResponseWriter, req *http.Request) {
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /value [get]
 func (h *Handlers) GetJSONHandler(res http.ResponseWriter, req *http.Request) {
 	var buf bytes.Buffer
@@ -381,23 +616,19 @@ func (h *Handlers) GetJSONHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	metric.Rate = nil
 	err = h.getMetric(req.Context(), &metric)
 	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			resp, _ := json.Marshal(ResponseErrorObject{Detail: "Metric not found"})
-			res.WriteHeader(http.StatusNotFound)
-			res.Write(resp)
+		writeJSONError(res, err)
+		return
+	}
+	if metric.MType == "counter" {
+		rate, err := h.counterRate(req.Context(), metric.ID)
+		if err != nil {
+			writeJSONError(res, err)
 			return
 		}
-		if err.Error() == "INVALID_METRIC_TYPE" {
-			resp, _ := json.Marshal(ResponseErrorObject{Detail: "Bad metric type"})
-			res.WriteHeader(http.StatusBadRequest)
-			res.Write(resp)
-		}
-		resp, _ := json.Marshal(ResponseErrorObject{Detail: "Internal Server Error"})
-		res.WriteHeader(http.StatusInternalServerError)
-		res.Write(resp)
-		return
+		metric.Rate = &rate
 	}
 
 	resp, err := json.Marshal(metric)
@@ -436,10 +667,17 @@ func (h *Handlers) Ping(res http.ResponseWriter, req *http.Request) {
 // @Accept  application/json
 // @Produce application/json
 // @Param metrics body []metrics.Metric true "Metric data"
+// @Param X-Batch-ID header string false "Batch id, retried batch with the same id from the same token or client address is not applied twice"
 // @Success 200 {string} string "OK"
+// @Header 200 {string} X-Batch-Duplicate "true if batch was already applied"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 429 {string} string "Too Many Requests"
 // @Security SecurityKeyAuth
+// @Security BearerAuth
 // @Router /updates [post]
 func (h *Handlers) PostMetricsHandler(res http.ResponseWriter, req *http.Request) {
 	var buf bytes.Buffer
@@ -468,21 +706,277 @@ func (h *Handlers) PostMetricsHandler(res http.ResponseWriter, req *http.Request
 		return
 	}
 
-	err = h.saveMetrics(req.Context(), metricList)
+	if !writeAllowed(req, metricList) {
+		writeJSONForbidden(res)
+		return
+	}
+	reservation, err := reserveLimits(req, metricList)
 	if err != nil {
-		if err.Error() == "INVALID_METRIC" {
-			resp, _ := json.Marshal(ResponseErrorObject{Detail: "Bad request format"})
-			res.WriteHeader(http.StatusBadRequest)
-			res.Write(resp)
+		writeJSONTooManyRequests(res, err)
+		return
+	}
+
+	if batch, ok := h.batchKeyOf(req); ok {
+		applied, err := h.saveBatch(req.Context(), batch, metricList)
+		if err != nil {
+			reservation.Cancel()
+			writeJSONError(res, err)
 			return
 		}
-		resp, _ := json.Marshal(ResponseErrorObject{Detail: "Internal Server Error"})
-		res.WriteHeader(http.StatusInternalServerError)
-		res.Write(resp)
+		if !applied {
+			reservation.Duplicate()
+			res.Header().Set("X-Batch-Duplicate", "true")
+		} else {
+			reservation.Commit()
+			h.audit(req, audit.NewEntry(audit.ActionWrite, metricIDs(metricList)...))
+		}
+	} else if err := h.saveMetrics(req.Context(), metricList); err != nil {
+		reservation.Cancel()
+		writeJSONError(res, err)
 		return
+	} else {
+		reservation.Commit()
+		h.audit(req, audit.NewEntry(audit.ActionWrite, metricIDs(metricList)...))
 	}
 
 	resp, _ := json.Marshal(ResponseEmptyObject{})
 	res.WriteHeader(http.StatusOK)
 	res.Write(resp)
 }
+
+func (h *Handlers) deletePlainMetric(res http.ResponseWriter, req *http.Request, mType string) {
+	metric := metrics.Metric{
+		ID:    chi.URLParam(req, "name"),
+		MType: mType,
+	}
+
+	err := h.deleteMetric(req.Context(), &metric)
+	if err != nil {
+		writePlainError(res, err)
+		return
+	}
+	h.syncSnapshot(req.Context())
+	h.audit(req, audit.NewEntry(audit.ActionDelete, metric.ID))
+
+	switch mType {
+	case "counter":
+		io.WriteString(res, strconv.FormatInt(*metric.Delta, 10))
+	case "gauge":
+		io.WriteString(res, strconv.FormatFloat(*metric.Value, 'f', -1, 64))
+	}
+}
+
+// DeletePlainCounterHandler godoc
+// @Summary Delete one metric with counter type
+// @Description Delete counter metric, returns its last value
+// @ID storageDeletePlainCounter
+// @Accept  text/plain
+// @Produce text/plain
+// @Param name path string true "Metric name"
+// @Success 200 {string} string "OK"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /value/counter/{name} [delete]
+func (h *Handlers) DeletePlainCounterHandler(res http.ResponseWriter, req *http.Request) {
+	h.deletePlainMetric(res, req, "counter")
+}
+
+// DeletePlainGaugeHandler godoc
+// @Summary Delete one metric with gauge type
+// @Description Delete gauge metric, returns its last value
+// @ID storageDeletePlainGauge
+// @Accept  text/plain
+// @Produce text/plain
+// @Param name path string true "Metric name"
+// @Success 200 {string} string "OK"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /value/gauge/{name} [delete]
+func (h *Handlers) DeletePlainGaugeHandler(res http.ResponseWriter, req *http.Request) {
+	h.deletePlainMetric(res, req, "gauge")
+}
+
+// DeleteMetricsHandler godoc
+// @Summary Delete metrics by name pattern
+// @Description Delete all metrics with names matching shell pattern (e.g. Host*), optionally only of one type
+// @ID storageDeleteByPattern
+// @Produce application/json
+// @Param pattern query string true "Metric name pattern"
+// @Param type query string false "Metric type" Enums(counter, gauge)
+// @Success 200 {object} ResponseDeletedObject
+// @Failure 400 {object} ResponseErrorObject
+// @Failure 500 {object} ResponseErrorObject
+// @Failure 503 {object} ResponseErrorObject
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /values [delete]
+func (h *Handlers) DeleteMetricsHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	pattern := req.URL.Query().Get("pattern")
+	if pattern == "" {
+		resp, _ := json.Marshal(ResponseErrorObject{Code: storageerrors.ErrInvalidMetric.Error(), Detail: "Pattern is required"})
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write(resp)
+		return
+	}
+
+	deleted, err := h.deleteMetrics(req.Context(), req.URL.Query().Get("type"), pattern)
+	if err != nil {
+		writeJSONError(res, err)
+		return
+	}
+	h.syncSnapshot(req.Context())
+	h.audit(req, audit.Entry{Action: audit.ActionDelete, Count: deleted, Pattern: pattern})
+
+	resp, _ := json.Marshal(ResponseDeletedObject{Deleted: deleted})
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// ResetPlainCounterHandler godoc
+// @Summary Reset counter metric
+// @Description Set counter metric value to zero
+// @ID storageResetPlainCounter
+// @Accept  text/plain
+// @Produce text/plain
+// @Param name path string true "Metric name"
+// @Success 200 {string} string "OK"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /reset/counter/{name} [post]
+func (h *Handlers) ResetPlainCounterHandler(res http.ResponseWriter, req *http.Request) {
+	err := h.resetCounter(req.Context(), chi.URLParam(req, "name"))
+	if err != nil {
+		writePlainError(res, err)
+		return
+	}
+	h.syncSnapshot(req.Context())
+	h.audit(req, audit.NewEntry(audit.ActionReset, chi.URLParam(req, "name")))
+	res.WriteHeader(http.StatusOK)
+}
+
+// GetPlainCounterRateHandler godoc
+// @Summary Get counter growth rate
+// @Description Get counter metric growth rate per second over the configured window
+// @ID storageGetPlainCounterRate
+// @Accept  text/plain
+// @Produce text/plain
+// @Param name path string true "Metric name"
+// @Success 200 {string} string "OK"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Error"
+// @Failure 503 {string} string "Storage Unavailable"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /rate/counter/{name} [get]
+func (h *Handlers) GetPlainCounterRateHandler(res http.ResponseWriter, req *http.Request) {
+	rate, err := h.counterRate(req.Context(), chi.URLParam(req, "name"))
+	if err != nil {
+		writePlainError(res, err)
+		return
+	}
+
+	io.WriteString(res, strconv.FormatFloat(rate, 'f', -1, 64))
+}
+
+// Разбор параметров запроса списка метрик
+func parseListQuery(req *http.Request) (metrics.ListQuery, error) {
+	params := req.URL.Query()
+	query := metrics.ListQuery{
+		MType:  params.Get("type"),
+		Prefix: params.Get("prefix"),
+		Regex:  params.Get("regex"),
+		Limit:  defaultListLimit,
+	}
+
+	if rawLimit := params.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return query, storageerrors.NewInvalidMetricError("", "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+		}
+		query.Limit = limit
+	}
+
+	if rawCursor := params.Get("cursor"); rawCursor != "" {
+		cursor, err := metrics.DecodeCursor(rawCursor)
+		if err != nil {
+			return query, storageerrors.NewInvalidMetricError("", err.Error())
+		}
+		query.After = &cursor
+	}
+	return query, nil
+}
+
+// ListMetricsHandler godoc
+// @Summary List metrics
+// @Description List metrics sorted by name and type with filtering and cursor pagination
+// @ID storageListMetrics
+// @Produce application/json
+// @Param type query string false "Metric type" Enums(counter, gauge)
+// @Param prefix query string false "Metric name prefix"
+// @Param regex query string false "Regular expression for metric name"
+// @Param limit query int false "Page size, from 1 to 1000" default(100)
+// @Param cursor query string false "Cursor from previous page"
+// @Success 200 {object} ResponseMetricListObject
+// @Failure 400 {object} ResponseErrorObject
+// @Failure 500 {object} ResponseErrorObject
+// @Failure 503 {object} ResponseErrorObject
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Security SecurityKeyAuth
+// @Security BearerAuth
+// @Router /api/metrics [get]
+func (h *Handlers) ListMetricsHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	query, err := parseListQuery(req)
+	if err != nil {
+		writeJSONError(res, err)
+		return
+	}
+
+	// запрашиваем на одну метрику больше, чтобы понять, есть ли следующая страница
+	pageSize := query.Limit
+	query.Limit++
+	metricList, err := h.listMetrics(req.Context(), query)
+	if err != nil {
+		writeJSONError(res, err)
+		return
+	}
+
+	page := ResponseMetricListObject{Metrics: metricList}
+	if len(metricList) > pageSize {
+		page.Metrics = metricList[:pageSize]
+		page.NextCursor = metrics.CursorOf(page.Metrics[pageSize-1]).Encode()
+	}
+
+	resp, err := json.Marshal(page)
+	if err != nil {
+		respErr, _ := json.Marshal(ResponseErrorObject{Detail: "Internal Server Error"})
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write(respErr)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
@@ -6,18 +6,27 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgerrcode"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/resty.v1"
 
+	"github.com/ry461ch/metric-collector/internal/audit"
 	config "github.com/ry461ch/metric-collector/internal/config/server"
 	"github.com/ry461ch/metric-collector/internal/fileworker"
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
 	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
 )
 
 func mockRouter(handlers *Handlers) chi.Router {
@@ -30,6 +39,12 @@ func mockRouter(handlers *Handlers) chi.Router {
 	router.Get("/value/gauge/{name}", handlers.GetPlainGaugeHandler)
 	router.Post("/value/", handlers.GetJSONHandler)
 	router.Get("/", handlers.GetPlainAllMetricsHandler)
+	router.Delete("/value/counter/{name}", handlers.DeletePlainCounterHandler)
+	router.Delete("/value/gauge/{name}", handlers.DeletePlainGaugeHandler)
+	router.Delete("/values/", handlers.DeleteMetricsHandler)
+	router.Post("/reset/counter/{name}", handlers.ResetPlainCounterHandler)
+	router.Get("/rate/counter/{name}", handlers.GetPlainCounterRateHandler)
+	router.Get("/api/metrics", handlers.ListMetricsHandler)
 	return router
 }
 
@@ -41,9 +56,57 @@ func (is *InvalidStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric,
 func (is *InvalidStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
 	return errors.New(pgerrcode.ConnectionException)
 }
+func (is *InvalidStorage) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	return false, errors.New(pgerrcode.ConnectionException)
+}
 func (is *InvalidStorage) GetMetric(ctx context.Context, metric *metrics.Metric) error {
 	return errors.New(pgerrcode.ConnectionException)
 }
+func (is *InvalidStorage) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	return errors.New(pgerrcode.ConnectionException)
+}
+func (is *InvalidStorage) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	return 0, errors.New(pgerrcode.ConnectionException)
+}
+func (is *InvalidStorage) ResetCounter(ctx context.Context, id string) error {
+	return errors.New(pgerrcode.ConnectionException)
+}
+func (is *InvalidStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	return 0, errors.New(pgerrcode.ConnectionException)
+}
+func (is *InvalidStorage) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	return nil, errors.New(pgerrcode.ConnectionException)
+}
+
+type UnavailableStorage struct{}
+
+func (us *UnavailableStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
+	return nil, storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	return storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	return false, storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) GetMetric(ctx context.Context, metric *metrics.Metric) error {
+	return storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	return storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	return 0, storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) ResetCounter(ctx context.Context, id string) error {
+	return storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	return 0, storageerrors.Unavailable(errors.New("connection refused"))
+}
+func (us *UnavailableStorage) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	return nil, storageerrors.Unavailable(errors.New("connection refused"))
+}
 
 func TestPostTextGaugeHandler(t *testing.T) {
 	memStorage := memstorage.New()
@@ -491,6 +554,173 @@ func TestPostSeveralHandler(t *testing.T) {
 	assert.Equal(t, float64(10.0), *searchGaugeMetric.Value, "Сохраненное значение метрики типа gauge не совпадает с ожидаемым")
 }
 
+func TestPostBatchIDHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+
+	fileWorker := fileworker.New("", memStorage)
+	handlers := New(&config.Config{StoreInterval: 0}, memStorage, fileWorker)
+
+	store, _ := tokens.New([]tokens.Token{
+		{Name: "agent", Token: "agent-token", Scopes: []string{tokens.ScopeWrite}},
+		{Name: "another", Token: "another-token", Scopes: []string{tokens.ScopeWrite}},
+	})
+
+	post := func(token, agentID, batchID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id": "test", "type": "counter", "delta": 10}]`))
+		req.Header.Set("X-Agent-ID", agentID)
+		req.Header.Set("X-Batch-ID", batchID)
+		identity, _ := store.Lookup(token)
+		req = req.WithContext(tokens.WithIdentity(req.Context(), identity))
+		res := httptest.NewRecorder()
+		handlers.PostMetricsHandler(res, req)
+		return res
+	}
+
+	res := post("agent-token", "agent", "1")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("X-Batch-Duplicate"))
+	res = post("agent-token", "agent", "1")
+	assert.Equal(t, http.StatusOK, res.Code, "повтор пачки подтверждается как успешный")
+	assert.Equal(t, "true", res.Header().Get("X-Batch-Duplicate"))
+	res = post("agent-token", "spoofed", "1")
+	assert.Equal(t, "true", res.Header().Get("X-Batch-Duplicate"), "агент определяется по токену, а не по X-Agent-ID")
+	post("another-token", "agent", "1")
+
+	searchCounterMetric := metrics.Metric{ID: "test", MType: "counter"}
+	memStorage.GetMetric(context.TODO(), &searchCounterMetric)
+	assert.Equal(t, int64(20), *searchCounterMetric.Delta, "Повтор пачки не должен учитываться повторно")
+}
+
+func TestPostForbiddenPrefixHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	handlers := New(&config.Config{StoreInterval: 1}, memStorage, fileworker.New("", memStorage))
+
+	store, _ := tokens.New([]tokens.Token{{Name: "agent", Token: "t", Scopes: []string{tokens.ScopeWrite}, Prefixes: []string{"host1."}}})
+	identity, _ := store.Lookup("t")
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req = req.WithContext(tokens.WithIdentity(req.Context(), identity))
+		res := httptest.NewRecorder()
+		handlers.PostMetricsHandler(res, req)
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(`[{"id": "host1.cpu", "type": "gauge", "value": 1}]`))
+	assert.Equal(t, http.StatusForbidden, post(`[{"id": "host1.cpu", "type": "gauge", "value": 1}, {"id": "host2.cpu", "type": "gauge", "value": 1}]`))
+
+	searchMetric := metrics.Metric{ID: "host2.cpu", MType: "gauge"}
+	assert.ErrorIs(t, memStorage.GetMetric(context.TODO(), &searchMetric), storageerrors.ErrNotFound, "Пачка с запрещенной метрикой не должна сохраняться")
+}
+
+func TestPostQuotaHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	handlers := New(&config.Config{StoreInterval: 1}, memStorage, fileworker.New("", memStorage))
+	limiter := ratelimit.New(ratelimit.Limits{MaxMetricIDs: 2}, nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req = req.WithContext(ratelimit.WithAgent(req.Context(), limiter, "agent"))
+		res := httptest.NewRecorder()
+		handlers.PostMetricsHandler(res, req)
+		return res
+	}
+
+	assert.Equal(t, http.StatusOK, post(`[{"id": "cpu", "type": "gauge", "value": 1}, {"id": "mem", "type": "gauge", "value": 1}]`).Code)
+	res := post(`[{"id": "cpu", "type": "gauge", "value": 2}, {"id": "disk", "type": "gauge", "value": 1}]`)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Contains(t, res.Body.String(), "too_many_requests")
+
+	searchMetric := metrics.Metric{ID: "cpu", MType: "gauge"}
+	assert.NoError(t, memStorage.GetMetric(context.TODO(), &searchMetric))
+	assert.Equal(t, 1.0, *searchMetric.Value, "Пачка сверх квоты не должна сохраняться")
+}
+
+func TestQuotaReleaseHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	handlers := New(&config.Config{StoreInterval: 1}, memStorage, fileworker.New("", memStorage))
+	limiter := ratelimit.New(ratelimit.Limits{MetricRate: 0.001, MetricBurst: 3, MaxMetricIDs: 2}, nil)
+	handlers.SetLimiter(limiter)
+
+	post := func(body, batchID string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req.Header.Set("X-Batch-ID", batchID)
+		req = req.WithContext(ratelimit.WithAgent(req.Context(), limiter, "agent"))
+		res := httptest.NewRecorder()
+		handlers.PostMetricsHandler(res, req)
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(`[{"id": "cpu", "type": "gauge", "value": 1}]`, "1"))
+	assert.Equal(t, http.StatusOK, post(`[{"id": "cpu", "type": "gauge", "value": 1}]`, "1"))
+	assert.Equal(t, http.StatusOK, post(`[{"id": "cpu", "type": "gauge", "value": 1}]`, "1"), "повтор пачки не расходует лимит метрик")
+	assert.Equal(t, http.StatusOK, post(`[{"id": "mem", "type": "gauge", "value": 1}]`, "2"))
+	assert.Equal(t, http.StatusTooManyRequests, post(`[{"id": "disk", "type": "gauge", "value": 1}]`, "3"))
+
+	req := httptest.NewRequest(http.MethodDelete, "/value/gauge/mem", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("name", "mem")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	res := httptest.NewRecorder()
+	handlers.DeletePlainGaugeHandler(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, http.StatusOK, post(`[{"id": "disk", "type": "gauge", "value": 1}]`, "3"), "удаленная метрика не занимает квоту")
+}
+
+type mockAuditor struct {
+	entries []audit.Entry
+}
+
+func (ma *mockAuditor) RecordHTTP(req *http.Request, entry audit.Entry) {
+	entry.Endpoint = req.Method + " " + req.URL.Path
+	ma.entries = append(ma.entries, entry)
+}
+
+func TestAuditHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	handlers := New(&config.Config{StoreInterval: 1}, memStorage, fileworker.New("", memStorage))
+	auditor := &mockAuditor{}
+	handlers.SetAuditor(auditor)
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id": "cpu", "type": "gauge", "value": 1}, {"id": "mem", "type": "gauge", "value": 1}]`))
+	res := httptest.NewRecorder()
+	handlers.PostMetricsHandler(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`invalid`))
+	res = httptest.NewRecorder()
+	handlers.PostMetricsHandler(res, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/values/?pattern=*", nil)
+	res = httptest.NewRecorder()
+	handlers.DeleteMetricsHandler(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	require.Len(t, auditor.entries, 2, "Неуспешные операции не попадают в журнал")
+	assert.Equal(t, audit.ActionWrite, auditor.entries[0].Action)
+	assert.Equal(t, []string{"cpu", "mem"}, auditor.entries[0].IDs)
+	assert.Equal(t, audit.ActionDelete, auditor.entries[1].Action)
+	assert.Equal(t, "*", auditor.entries[1].Pattern)
+	assert.Equal(t, 2, auditor.entries[1].Count)
+}
+
+func TestReservedMetricHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	handlers := New(&config.Config{StoreInterval: 1}, memStorage, fileworker.New("", memStorage))
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id": "server_cardinality", "type": "gauge", "value": 1}]`))
+	res := httptest.NewRecorder()
+	handlers.PostMetricsHandler(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code, "Метрики сервера нельзя записывать клиентам")
+}
+
 func TestPostSeveralBadRequestHandler(t *testing.T) {
 	memStorage := memstorage.New()
 	memStorage.Initialize(context.TODO())
@@ -677,3 +907,267 @@ func TestGetAllInvalidStorageHandler(t *testing.T) {
 	resp, _ := client.R().Get(srv.URL + "/")
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode(), "Статус не совпадает")
 }
+
+func TestUnavailableStorageHandlers(t *testing.T) {
+	fileWorker := fileworker.New("", &UnavailableStorage{})
+	handlers := New(&config.Config{StoreInterval: 1}, &UnavailableStorage{}, fileWorker)
+
+	router := mockRouter(handlers)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := resty.New()
+
+	value := float64(5.5)
+	req, _ := json.Marshal(metrics.Metric{ID: "test", MType: "gauge", Value: &value})
+	resp, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(req).
+		Post(srv.URL + "/update/")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+
+	errObject := ResponseErrorObject{}
+	json.Unmarshal(resp.Body(), &errObject)
+	assert.Equal(t, "STORAGE_UNAVAILABLE", errObject.Code, "Неверный код ошибки")
+
+	resp, _ = client.R().Get(srv.URL + "/value/gauge/test")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+}
+
+func TestErrorResponse(t *testing.T) {
+	testCases := []struct {
+		testName       string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			testName:       "invalid metric",
+			err:            storageerrors.NewInvalidMetricError("test", "value is required for gauge"),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "INVALID_METRIC",
+		},
+		{
+			testName:       "type mismatch",
+			err:            storageerrors.NewTypeMismatchError("test", "invalid"),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "INVALID_METRIC_TYPE",
+		},
+		{
+			testName:       "not found",
+			err:            storageerrors.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "NOT_FOUND",
+		},
+		{
+			testName:       "unavailable",
+			err:            storageerrors.Unavailable(errors.New("timeout")),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "STORAGE_UNAVAILABLE",
+		},
+		{
+			testName:       "unknown",
+			err:            errors.New("unknown"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			status, errObject := errorResponse(tc.err)
+			assert.Equal(t, tc.expectedStatus, status, "Неверный http статус")
+			assert.Equal(t, tc.expectedCode, errObject.Code, "Неверный код ошибки")
+		})
+	}
+}
+
+func TestDeleteHandlers(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	gaugeValue := 10.5
+	counterValue := int64(7)
+	memStorage.SaveMetrics(context.TODO(), []metrics.Metric{
+		{ID: "HostCPU", MType: "gauge", Value: &gaugeValue},
+		{ID: "HostMem", MType: "gauge", Value: &gaugeValue},
+		{ID: "Alloc", MType: "gauge", Value: &gaugeValue},
+		{ID: "PollCount", MType: "counter", Delta: &counterValue},
+	})
+
+	snapshotPath := filepath.Join(t.TempDir(), "metrics.json")
+	fileWorker := fileworker.New(snapshotPath, memStorage)
+	handlers := New(&config.Config{StoreInterval: 0}, memStorage, fileWorker)
+
+	router := mockRouter(handlers)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := resty.New()
+	resp, err := client.R().Delete(srv.URL + "/value/gauge/Alloc")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "10.5", string(resp.Body()), "Должно вернуться последнее значение удаленной метрики")
+
+	resp, err = client.R().Delete(srv.URL + "/value/gauge/Alloc")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode(), "Удалилась несуществующая метрика")
+
+	resp, err = client.R().Delete(srv.URL + "/values/?type=gauge&pattern=Host*")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	deletedObject := ResponseDeletedObject{}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &deletedObject))
+	assert.Equal(t, 2, deletedObject.Deleted)
+
+	resp, err = client.R().Delete(srv.URL + "/values/")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Пустой шаблон должен быть ошибкой")
+
+	resp, err = client.R().Delete(srv.URL + "/values/?pattern=[")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Невалидный шаблон должен быть ошибкой")
+
+	resp, err = client.R().Post(srv.URL + "/reset/counter/PollCount")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	searchMetric := metrics.Metric{ID: "PollCount", MType: "counter"}
+	memStorage.GetMetric(context.TODO(), &searchMetric)
+	assert.Equal(t, int64(0), *searchMetric.Delta, "Counter не сбросился")
+
+	resp, err = client.R().Post(srv.URL + "/reset/counter/undefined")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// изменения должны попасть в слепок
+	data, err := os.ReadFile(snapshotPath)
+	assert.NoError(t, err)
+	snapshotMetrics := []metrics.Metric{}
+	assert.NoError(t, json.Unmarshal(data, &snapshotMetrics))
+	assert.Len(t, snapshotMetrics, 1)
+	assert.Equal(t, "PollCount", snapshotMetrics[0].ID)
+	assert.Equal(t, int64(0), *snapshotMetrics[0].Delta)
+}
+
+func TestListMetricsHandler(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	metricList := []metrics.Metric{}
+	for i := 0; i < 5; i++ {
+		value := float64(i)
+		delta := int64(i)
+		metricList = append(metricList,
+			metrics.Metric{ID: "metric_" + strconv.Itoa(i), MType: "gauge", Value: &value},
+			metrics.Metric{ID: "metric_" + strconv.Itoa(i), MType: "counter", Delta: &delta},
+		)
+	}
+	memStorage.SaveMetrics(context.TODO(), metricList)
+
+	fileWorker := fileworker.New("", memStorage)
+	handlers := New(&config.Config{StoreInterval: 1}, memStorage, fileWorker)
+
+	router := mockRouter(handlers)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := resty.New()
+
+	// обходим все страницы по курсору
+	ids := []string{}
+	cursor := ""
+	pages := 0
+	for {
+		resp, err := client.R().
+			SetQueryParams(map[string]string{"type": "gauge", "limit": "2", "cursor": cursor}).
+			Get(srv.URL + "/api/metrics")
+		assert.Nil(t, err, "Сервер вернул 500")
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+
+		page := ResponseMetricListObject{}
+		assert.NoError(t, json.Unmarshal(resp.Body(), &page))
+		for _, metric := range page.Metrics {
+			assert.Equal(t, "gauge", metric.MType)
+			ids = append(ids, metric.ID)
+		}
+		pages++
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"metric_0", "metric_1", "metric_2", "metric_3", "metric_4"}, ids)
+	assert.Equal(t, 3, pages)
+
+	resp, err := client.R().Get(srv.URL + "/api/metrics?regex=_[34]$&prefix=metric")
+	assert.Nil(t, err, "Сервер вернул 500")
+	page := ResponseMetricListObject{}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &page))
+	assert.Len(t, page.Metrics, 4)
+	assert.Empty(t, page.NextCursor)
+
+	badRequests := []string{
+		"/api/metrics?limit=0",
+		"/api/metrics?limit=abc",
+		"/api/metrics?limit=100000",
+		"/api/metrics?cursor=invalid",
+		"/api/metrics?regex=(",
+		"/api/metrics?type=invalid",
+	}
+	for _, badRequest := range badRequests {
+		resp, err := client.R().Get(srv.URL + badRequest)
+		assert.Nil(t, err, "Сервер вернул 500")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Неверный код ответа для %s", badRequest)
+	}
+}
+
+func TestCounterRateHandlers(t *testing.T) {
+	memStorage := memstorage.New()
+	memStorage.Initialize(context.TODO())
+	delta := int64(10)
+	memStorage.SaveMetrics(context.TODO(), []metrics.Metric{{ID: "some_metric", MType: "counter", Delta: &delta}})
+	time.Sleep(20 * time.Millisecond)
+	memStorage.SaveMetrics(context.TODO(), []metrics.Metric{{ID: "some_metric", MType: "counter", Delta: &delta}})
+
+	fileWorker := fileworker.New("", memStorage)
+	handlers := New(&config.Config{StoreInterval: 0}, memStorage, fileWorker)
+	srv := httptest.NewServer(mockRouter(handlers))
+	defer srv.Close()
+	client := resty.New()
+
+	resp, err := client.R().Get(srv.URL + "/rate/counter/some_metric")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	rate, err := strconv.ParseFloat(string(resp.Body()), 64)
+	assert.NoError(t, err)
+	assert.Greater(t, rate, 0.0)
+
+	resp, err = client.R().Get(srv.URL + "/rate/counter/undefined")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// rate из запроса игнорируется и заполняется сервером
+	fakeRate := -1.0
+	req, _ := json.Marshal(metrics.Metric{ID: "some_metric", MType: "counter", Rate: &fakeRate})
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(req).
+		Post(srv.URL + "/value/")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var metric metrics.Metric
+	assert.NoError(t, json.Unmarshal(resp.Body(), &metric))
+	assert.Equal(t, int64(20), *metric.Delta)
+	if assert.NotNil(t, metric.Rate) {
+		assert.Greater(t, *metric.Rate, 0.0)
+	}
+
+	gaugeValue := 1.5
+	memStorage.SaveMetrics(context.TODO(), []metrics.Metric{{ID: "some_gauge", MType: "gauge", Value: &gaugeValue}})
+	req, _ = json.Marshal(metrics.Metric{ID: "some_gauge", MType: "gauge"})
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(req).
+		Post(srv.URL + "/value/")
+	assert.Nil(t, err, "Сервер вернул 500")
+	assert.NotContains(t, string(resp.Body()), "rate", "у gauge нет скорости роста")
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ry461ch/metric-collector/internal/app/server/health"
+)
+
+// HealthHandlers - обработчики проверок живости и готовности сервера
+type HealthHandlers struct {
+	checker HealthChecker
+}
+
+// Init health handlers
+func NewHealthHandlers(checker HealthChecker) *HealthHandlers {
+	return &HealthHandlers{checker: checker}
+}
+
+// Запись состояния сервера в ответ с content-type=application/json
+func writeHealth(res http.ResponseWriter, status int, resp health.Response) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		respErr, _ := json.Marshal(ResponseErrorObject{Detail: "Internal Server Error"})
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write(respErr)
+		return
+	}
+	res.WriteHeader(status)
+	res.Write(body)
+}
+
+// Healthz godoc
+// @Summary Liveness check
+// @Description Process is alive, storage and other components are not checked
+// @ID infoHealthz
+// @Produce application/json
+// @Success 200 {object} health.Response
+// @Router /healthz [get]
+func (hh *HealthHandlers) Healthz(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	writeHealth(res, http.StatusOK, health.Response{Status: health.StatusOK})
+}
+
+// Readyz godoc
+// @Summary Readiness check
+// @Description Storage is initialised, snapshot is restored and gRPC listener is up. Response contains status of every component
+// @ID infoReadyz
+// @Produce application/json
+// @Success 200 {object} health.Response
+// @Failure 503 {object} health.Response
+// @Router /readyz [get]
+func (hh *HealthHandlers) Readyz(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	resp := hh.checker.Check(req.Context())
+	if resp.Status != health.StatusOK {
+		writeHealth(res, http.StatusServiceUnavailable, resp)
+		return
+	}
+	writeHealth(res, http.StatusOK, resp)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/app/server/health"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	checker := health.New("storage", "grpc")
+	checker.Set("storage", nil)
+	handlers := NewHealthHandlers(checker)
+
+	res := httptest.NewRecorder()
+	handlers.Healthz(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, res.Code, "Живость не зависит от компонентов")
+
+	res = httptest.NewRecorder()
+	handlers.Readyz(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, res.Code)
+	var resp health.Response
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &resp))
+	assert.Equal(t, health.StatusFail, resp.Status)
+	assert.Equal(t, health.StatusOK, resp.Components["storage"].Status)
+	assert.Equal(t, health.StatusFail, resp.Components["grpc"].Status)
+
+	checker.Set("grpc", nil)
+	checker.AddCheck("storage", func(ctx context.Context) error {
+		return errors.New("storage is not available")
+	})
+	res = httptest.NewRecorder()
+	handlers.Readyz(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+
+	checker.AddCheck("storage", func(ctx context.Context) error {
+		return nil
+	})
+	res = httptest.NewRecorder()
+	handlers.Readyz(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+}
@@ -0,0 +1,172 @@
+// Module for server readiness checks
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Статусы сервера и компонентов
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Таймаут проверки одного компонента
+const checkTimeout = time.Second
+
+var errNotStarted = errors.New("not started")
+
+// Состояние компонента
+type ComponentStatus struct {
+	Status string `json:"status"`          // ok или fail
+	Error  string `json:"error,omitempty"` // причина, по которой компонент не готов
+}
+
+// Состояние сервера с разбивкой по компонентам
+type Response struct {
+	Status     string                     `json:"status"`               // ok, если готовы все компоненты
+	Components map[string]ComponentStatus `json:"components,omitempty"` // состояние по компонентам
+}
+
+// Проверка готовности сервера. Компонент готов, если при запуске он отметился
+// без ошибки и его проверка, если она задана, проходит. Состояние дублируется
+// в стандартный grpc.health.v1 сервис
+type Checker struct {
+	mu         sync.Mutex
+	components []string
+	errs       map[string]error
+	checks     map[string]func(ctx context.Context) error
+	grpcHealth *grpchealth.Server
+}
+
+// Init checker. Перечисленные компоненты не готовы, пока не будут отмечены через Set
+func New(components ...string) *Checker {
+	c := &Checker{
+		components: components,
+		errs:       make(map[string]error, len(components)),
+		checks:     map[string]func(ctx context.Context) error{},
+		grpcHealth: grpchealth.NewServer(),
+	}
+	for _, component := range components {
+		c.errs[component] = errNotStarted
+	}
+	c.grpcHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return c
+}
+
+// Результат запуска компонента, nil - компонент готов
+func (c *Checker) Set(component string, err error) {
+	c.mu.Lock()
+	if _, ok := c.errs[component]; !ok {
+		c.components = append(c.components, component)
+	}
+	c.errs[component] = err
+	c.mu.Unlock()
+	c.Check(context.Background())
+}
+
+// Проверка, которая выполняется при каждом запросе готовности компонента
+func (c *Checker) AddCheck(component string, check func(ctx context.Context) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.errs[component]; !ok {
+		c.components = append(c.components, component)
+		c.errs[component] = nil
+	}
+	c.checks[component] = check
+}
+
+// Состояние сервера по компонентам. Обновляет статус grpc.health.v1
+func (c *Checker) Check(ctx context.Context) Response {
+	c.mu.Lock()
+	components := make([]string, len(c.components))
+	copy(components, c.components)
+	errs := make(map[string]error, len(c.errs))
+	for component, err := range c.errs {
+		errs[component] = err
+	}
+	checks := make(map[string]func(ctx context.Context) error, len(c.checks))
+	for component, check := range c.checks {
+		checks[component] = check
+	}
+	c.mu.Unlock()
+
+	resp := Response{Status: StatusOK, Components: make(map[string]ComponentStatus, len(components))}
+	for _, component := range components {
+		err := errs[component]
+		if check, ok := checks[component]; ok && err == nil {
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			err = check(checkCtx)
+			cancel()
+		}
+		if err != nil {
+			resp.Status = StatusFail
+			resp.Components[component] = ComponentStatus{Status: StatusFail, Error: err.Error()}
+			continue
+		}
+		resp.Components[component] = ComponentStatus{Status: StatusOK}
+	}
+
+	servingStatus := healthpb.HealthCheckResponse_SERVING
+	if resp.Status != StatusOK {
+		servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	c.grpcHealth.SetServingStatus("", servingStatus)
+	return resp
+}
+
+// Сервис grpc.health.v1 для регистрации на grpc сервере
+func (c *Checker) GRPCServer() healthpb.HealthServer {
+	return c.grpcHealth
+}
+
+// Периодическое обновление статуса grpc.health.v1, чтобы он учитывал проверки компонентов
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check(ctx)
+		}
+	}
+}
+
+// Перевод grpc.health.v1 в NOT_SERVING перед остановкой сервера
+func (c *Checker) Shutdown() {
+	c.grpcHealth.Shutdown()
+}
+
+// Методы grpc.health.v1, доступные без подписи и шифрования
+func isHealthMethod(method string) bool {
+	return method == healthpb.Health_Check_FullMethodName || method == healthpb.Health_Watch_FullMethodName
+}
+
+// Interceptor, который пропускает методы grpc.health.v1 мимо interceptor
+func SkipStream(interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		return interceptor(srv, ss, info, handler)
+	}
+}
+
+// Unary interceptor, который пропускает методы grpc.health.v1 мимо interceptor
+func SkipUnary(interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func grpcStatus(t *testing.T, c *Checker) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := c.GRPCServer().Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	return resp.Status
+}
+
+func TestCheck(t *testing.T) {
+	c := New("storage", "grpc")
+	resp := c.Check(context.Background())
+	assert.Equal(t, StatusFail, resp.Status)
+	assert.Equal(t, ComponentStatus{Status: StatusFail, Error: "not started"}, resp.Components["grpc"])
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, grpcStatus(t, c))
+
+	c.Set("storage", nil)
+	c.Set("grpc", nil)
+	resp = c.Check(context.Background())
+	assert.Equal(t, StatusOK, resp.Status)
+	assert.Equal(t, ComponentStatus{Status: StatusOK}, resp.Components["storage"])
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, grpcStatus(t, c))
+
+	c.Set("grpc", errors.New("listen failed"))
+	assert.Equal(t, StatusFail, c.Check(context.Background()).Status)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, grpcStatus(t, c))
+}
+
+func TestAddCheck(t *testing.T) {
+	c := New("storage")
+	available := false
+	c.AddCheck("storage", func(ctx context.Context) error {
+		if !available {
+			return errors.New("storage is not available")
+		}
+		return nil
+	})
+
+	c.Set("storage", nil)
+	resp := c.Check(context.Background())
+	assert.Equal(t, StatusFail, resp.Status, "проверка выполняется после успешного запуска")
+	assert.Equal(t, "storage is not available", resp.Components["storage"].Error)
+
+	available = true
+	assert.Equal(t, StatusOK, c.Check(context.Background()).Status)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, grpcStatus(t, c))
+
+	c.Shutdown()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, grpcStatus(t, c))
+}
+
+func TestSkipUnary(t *testing.T) {
+	reject := SkipUnary(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return nil, status.Error(codes.Unauthenticated, "request is not signed")
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	resp, err := reject(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: healthpb.Health_Check_FullMethodName}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = reject(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/proto.Metrics/GetMetric"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,309 @@
+// Module for sending alert notifications to webhooks
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/ry461ch/metric-collector/internal/config/server"
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+const (
+	// Метка, по которой группируются алерты, если группировка не задана
+	labelAlertName = "alertname"
+	// Задержка перед первым повтором отправки, дальше удваивается
+	defaultRetryBackoff = time.Second
+	// Таймаут одного запроса к вебхуку
+	requestTimeout = 5 * time.Second
+)
+
+// Тело запроса к вебхуку
+type Payload struct {
+	GroupKey    string            `json:"group_key"`    // ключ группы, одинаковый для всех уведомлений группы
+	GroupLabels map[string]string `json:"group_labels"` // значения меток, по которым сгруппированы алерты
+	Status      string            `json:"status"`       // firing, если в группе есть firing алерты, иначе resolved
+	Alerts      []alerts.Alert    `json:"alerts"`
+}
+
+// Алерт в конкретном вебхуке. Состояние отправки хранится отдельно для
+// каждого вебхука, чтобы после ошибки уведомление повторялось только туда,
+// куда оно не дошло
+type sentKey struct {
+	url   string
+	alert string
+}
+
+// Последнее отправленное состояние алерта
+type sentState struct {
+	state    string
+	activeAt time.Time
+	at       time.Time
+}
+
+// Группа алертов в конкретном вебхуке
+type groupKey struct {
+	url string
+	key string
+}
+
+// Группа алертов, ожидающих отправки
+type group struct {
+	labels  map[string]string
+	alerts  map[string]alerts.Alert
+	firstAt time.Time
+}
+
+// Воркер, который группирует изменения состояний алертов и отправляет их в вебхуки
+type Notifier struct {
+	urls           []string
+	encrypter      *encrypt.Encrypter
+	groupBy        []string
+	groupWait      time.Duration
+	repeatInterval time.Duration
+	retries        int
+	retryBackoff   time.Duration
+	client         *http.Client
+
+	mutex  sync.Mutex
+	sent   map[sentKey]sentState
+	groups map[groupKey]*group
+}
+
+// Init notifier
+func New(cfg *config.Config) *Notifier {
+	var encrypter *encrypt.Encrypter
+	if cfg.WebhookKey != "" {
+		encrypter = encrypt.New(cfg.WebhookKey)
+	}
+	groupBy := cfg.NotifyGroupBy
+	if len(groupBy) == 0 {
+		groupBy = []string{labelAlertName}
+	}
+
+	return &Notifier{
+		urls:           cfg.Webhooks,
+		encrypter:      encrypter,
+		groupBy:        groupBy,
+		groupWait:      time.Duration(cfg.NotifyGroupWait) * time.Second,
+		repeatInterval: time.Duration(cfg.NotifyRepeat) * time.Second,
+		retries:        cfg.NotifyRetries,
+		retryBackoff:   defaultRetryBackoff,
+		client:         &http.Client{Timeout: requestTimeout},
+		sent:           map[sentKey]sentState{},
+		groups:         map[groupKey]*group{},
+	}
+}
+
+// Ключ и метки группы, в которую попадает алерт
+func (n *Notifier) groupOf(alert alerts.Alert) (string, map[string]string) {
+	labels := map[string]string{}
+	parts := make([]string, 0, len(n.groupBy))
+	for _, name := range n.groupBy {
+		value := alert.Labels[name]
+		if name == labelAlertName {
+			value = alert.Name
+		}
+		labels[name] = value
+		parts = append(parts, fmt.Sprintf("%s=%q", name, value))
+	}
+	return "{" + strings.Join(parts, ",") + "}", labels
+}
+
+// Нужно ли отправлять алерт в вебхук: уведомление отправляется при смене состояния
+// и повторяется для firing алертов раз в repeatInterval
+func (n *Notifier) isDuplicate(url string, alert alerts.Alert, now time.Time) bool {
+	prev, ok := n.sent[sentKey{url: url, alert: alert.Name}]
+	if !ok || prev.state != alert.State || !prev.activeAt.Equal(alert.ActiveAt) {
+		return false
+	}
+	if alert.State == alerts.StateFiring && n.repeatInterval > 0 {
+		return now.Sub(prev.at) < n.repeatInterval
+	}
+	return true
+}
+
+// Прием текущих алертов. Pending алерты не отправляются,
+// повторные уведомления о том же состоянии отбрасываются
+func (n *Notifier) Notify(alertList []alerts.Alert, now time.Time) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, alert := range alertList {
+		if alert.State == alerts.StatePending {
+			continue
+		}
+		key, labels := n.groupOf(alert)
+		for _, url := range n.urls {
+			if n.isDuplicate(url, alert, now) {
+				continue
+			}
+			n.sent[sentKey{url: url, alert: alert.Name}] = sentState{state: alert.State, activeAt: alert.ActiveAt, at: now}
+
+			gk := groupKey{url: url, key: key}
+			g, ok := n.groups[gk]
+			if !ok {
+				g = &group{labels: labels, alerts: map[string]alerts.Alert{}, firstAt: now}
+				n.groups[gk] = g
+			}
+			g.alerts[alert.Name] = alert
+		}
+	}
+}
+
+// Уведомление, которое ждет отправки в вебхук
+type delivery struct {
+	url     string
+	payload Payload
+}
+
+// Отправка групп, которые ждут дольше groupWait
+func (n *Notifier) Flush(ctx context.Context, now time.Time) {
+	n.mutex.Lock()
+	deliveries := []delivery{}
+	for gk, g := range n.groups {
+		if now.Sub(g.firstAt) < n.groupWait {
+			continue
+		}
+		delete(n.groups, gk)
+
+		payload := Payload{GroupKey: gk.key, GroupLabels: g.labels, Status: alerts.StateResolved}
+		for _, alert := range g.alerts {
+			if alert.State == alerts.StateFiring {
+				payload.Status = alerts.StateFiring
+			}
+			payload.Alerts = append(payload.Alerts, alert)
+		}
+		slices.SortFunc(payload.Alerts, func(a, b alerts.Alert) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		deliveries = append(deliveries, delivery{url: gk.url, payload: payload})
+	}
+	n.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.deliver(ctx, d.url, d.payload); err != nil {
+				logging.Logger.Errorf("Can't send notification for group %s to %s: %s", d.payload.GroupKey, d.url, err)
+				// отклоненное вебхуком уведомление не повторяется, иначе оно
+				// отправлялось бы на каждом вычислении правил
+				var permanentErr *permanentError
+				if !errors.As(err, &permanentErr) {
+					n.forget(d.url, d.payload.Alerts)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Сброс информации об отправке в вебхук, чтобы алерты были отправлены туда
+// повторно при следующем вычислении правил
+func (n *Notifier) forget(url string, alertList []alerts.Alert) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, alert := range alertList {
+		key := sentKey{url: url, alert: alert.Name}
+		if prev, ok := n.sent[key]; ok && prev.state == alert.State {
+			delete(n.sent, key)
+		}
+	}
+}
+
+// Отправка уведомления в вебхук
+func (n *Notifier) deliver(ctx context.Context, url string, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return n.send(ctx, url, body)
+}
+
+// Ошибка, после которой повтор запроса не поможет
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Отправка уведомления в вебхук с повторами и экспоненциальной задержкой
+func (n *Notifier) send(ctx context.Context, url string, body []byte) error {
+	backoff := n.retryBackoff
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		err = n.post(ctx, url, body)
+		var permanentErr *permanentError
+		if err == nil || errors.As(err, &permanentErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.encrypter != nil {
+		req.Header.Set("HashSHA256", fmt.Sprintf("%x", n.encrypter.EncryptMessage(body)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	default:
+		return &permanentError{err: fmt.Errorf("webhook responded with status %d", resp.StatusCode)}
+	}
+}
+
+// Run notifier
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Logger.Info("Notifier shutdown")
+			return
+		default:
+		}
+		n.Flush(ctx, time.Now())
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,225 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/ry461ch/metric-collector/internal/config/server"
+	"github.com/ry461ch/metric-collector/internal/models/alerts"
+	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+// Вебхук, который запоминает полученные уведомления и отвечает заданными статусами
+type webhook struct {
+	mutex    sync.Mutex
+	statuses []int
+	attempts int
+	payloads []Payload
+	hashes   []string
+	bodies   [][]byte
+}
+
+func (w *webhook) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	status := http.StatusOK
+	if w.attempts < len(w.statuses) {
+		status = w.statuses[w.attempts]
+	}
+	w.attempts++
+	if status != http.StatusOK {
+		res.WriteHeader(status)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	var payload Payload
+	json.Unmarshal(body, &payload)
+	w.payloads = append(w.payloads, payload)
+	w.hashes = append(w.hashes, req.Header.Get("HashSHA256"))
+	w.bodies = append(w.bodies, body)
+	res.WriteHeader(status)
+}
+
+func newTestNotifier(cfg *config.Config, urls ...string) *Notifier {
+	cfg.Webhooks = urls
+	n := New(cfg)
+	n.retryBackoff = time.Millisecond
+	return n
+}
+
+func firing(name string, labels map[string]string, activeAt time.Time) alerts.Alert {
+	return alerts.Alert{Name: name, State: alerts.StateFiring, ActiveAt: activeAt, FiredAt: &activeAt, Labels: labels}
+}
+
+func TestDeliveryAndSignature(t *testing.T) {
+	hook := &webhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	n := newTestNotifier(&config.Config{WebhookKey: "secret", NotifyRetries: 3}, srv.URL)
+	now := time.Now()
+	n.Notify([]alerts.Alert{
+		firing("HighHeap", nil, now),
+		{Name: "Stalled", State: alerts.StatePending, ActiveAt: now},
+	}, now)
+	n.Flush(context.Background(), now)
+
+	require.Len(t, hook.payloads, 1)
+	payload := hook.payloads[0]
+	assert.Equal(t, alerts.StateFiring, payload.Status)
+	assert.Equal(t, map[string]string{"alertname": "HighHeap"}, payload.GroupLabels)
+	require.Len(t, payload.Alerts, 1, "pending алерты не отправляются")
+	assert.Equal(t, "HighHeap", payload.Alerts[0].Name)
+	assert.Equal(t, fmt.Sprintf("%x", encrypt.New("secret").EncryptMessage(hook.bodies[0])), hook.hashes[0])
+}
+
+func TestGroupingAndDeduplication(t *testing.T) {
+	hook := &webhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	n := newTestNotifier(&config.Config{NotifyGroupBy: []string{"team"}, NotifyGroupWait: 10, NotifyRepeat: 3600}, srv.URL)
+	ctx := context.Background()
+	start := time.Now()
+	heap := firing("HighHeap", map[string]string{"team": "core"}, start)
+	gc := firing("FrequentGC", map[string]string{"team": "core"}, start)
+	disk := firing("LowDisk", map[string]string{"team": "infra"}, start)
+
+	n.Notify([]alerts.Alert{heap}, start)
+	n.Notify([]alerts.Alert{heap, gc, disk}, start.Add(5*time.Second))
+	n.Flush(ctx, start.Add(9*time.Second))
+	assert.Empty(t, hook.payloads, "группа ждет groupWait перед отправкой")
+
+	n.Flush(ctx, start.Add(10*time.Second))
+	require.Len(t, hook.payloads, 1)
+	assert.Equal(t, map[string]string{"team": "core"}, hook.payloads[0].GroupLabels)
+	require.Len(t, hook.payloads[0].Alerts, 2, "алерты одной группы отправляются одним уведомлением")
+	assert.Equal(t, "FrequentGC", hook.payloads[0].Alerts[0].Name)
+	assert.Equal(t, "HighHeap", hook.payloads[0].Alerts[1].Name)
+
+	n.Flush(ctx, start.Add(15*time.Second))
+	require.Len(t, hook.payloads, 2)
+	assert.Equal(t, map[string]string{"team": "infra"}, hook.payloads[1].GroupLabels)
+
+	// то же состояние не отправляется повторно
+	n.Notify([]alerts.Alert{heap, gc, disk}, start.Add(20*time.Second))
+	n.Flush(ctx, start.Add(time.Minute))
+	assert.Len(t, hook.payloads, 2)
+
+	// firing алерт напоминается раз в repeatInterval
+	n.Notify([]alerts.Alert{heap}, start.Add(time.Hour+time.Second))
+	n.Flush(ctx, start.Add(time.Hour+time.Minute))
+	require.Len(t, hook.payloads, 3)
+	assert.Equal(t, "HighHeap", hook.payloads[2].Alerts[0].Name)
+
+	// смена состояния отправляется, даже если repeatInterval не прошел
+	resolvedAt := start.Add(time.Hour + 2*time.Second)
+	heap.State = alerts.StateResolved
+	heap.ResolvedAt = &resolvedAt
+	n.Notify([]alerts.Alert{heap}, resolvedAt)
+	n.Flush(ctx, resolvedAt.Add(10*time.Second))
+	require.Len(t, hook.payloads, 4)
+	assert.Equal(t, alerts.StateResolved, hook.payloads[3].Status)
+	require.Len(t, hook.payloads[3].Alerts, 1)
+	assert.Equal(t, alerts.StateResolved, hook.payloads[3].Alerts[0].State)
+}
+
+func TestRetries(t *testing.T) {
+	logging.Initialize("INFO")
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("retry on server error", func(t *testing.T) {
+		hook := &webhook{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+		srv := httptest.NewServer(hook)
+		defer srv.Close()
+
+		n := newTestNotifier(&config.Config{NotifyRetries: 2}, srv.URL)
+		n.Notify([]alerts.Alert{firing("HighHeap", nil, now)}, now)
+		n.Flush(ctx, now)
+		assert.Equal(t, 3, hook.attempts)
+		assert.Len(t, hook.payloads, 1)
+	})
+
+	t.Run("no retry on client error", func(t *testing.T) {
+		hook := &webhook{statuses: []int{http.StatusBadRequest}}
+		srv := httptest.NewServer(hook)
+		defer srv.Close()
+
+		n := newTestNotifier(&config.Config{NotifyRetries: 2}, srv.URL)
+		alert := firing("HighHeap", nil, now)
+		n.Notify([]alerts.Alert{alert}, now)
+		n.Flush(ctx, now)
+		assert.Equal(t, 1, hook.attempts)
+		assert.Empty(t, hook.payloads)
+
+		n.Notify([]alerts.Alert{alert}, now.Add(time.Second))
+		n.Flush(ctx, now.Add(time.Second))
+		assert.Equal(t, 1, hook.attempts, "отклоненное уведомление не отправляется повторно")
+	})
+
+	t.Run("resend after failed delivery", func(t *testing.T) {
+		hook := &webhook{statuses: []int{http.StatusBadGateway, http.StatusBadGateway}}
+		srv := httptest.NewServer(hook)
+		defer srv.Close()
+
+		n := newTestNotifier(&config.Config{NotifyRetries: 1}, srv.URL)
+		alert := firing("HighHeap", nil, now)
+		n.Notify([]alerts.Alert{alert}, now)
+		n.Flush(ctx, now)
+		assert.Equal(t, 2, hook.attempts)
+		assert.Empty(t, hook.payloads)
+
+		n.Notify([]alerts.Alert{alert}, now.Add(time.Second))
+		n.Flush(ctx, now.Add(time.Second))
+		assert.Len(t, hook.payloads, 1, "неотправленный алерт не должен считаться дубликатом")
+	})
+
+	t.Run("all webhooks receive notification", func(t *testing.T) {
+		failing := &webhook{statuses: []int{http.StatusNotFound}}
+		failingSrv := httptest.NewServer(failing)
+		defer failingSrv.Close()
+		hook := &webhook{}
+		srv := httptest.NewServer(hook)
+		defer srv.Close()
+
+		n := newTestNotifier(&config.Config{}, failingSrv.URL, srv.URL)
+		n.Notify([]alerts.Alert{firing("HighHeap", nil, now)}, now)
+		n.Flush(ctx, now)
+		assert.Len(t, hook.payloads, 1)
+	})
+
+	t.Run("resend only to failed webhook", func(t *testing.T) {
+		failing := &webhook{statuses: []int{http.StatusBadGateway}}
+		failingSrv := httptest.NewServer(failing)
+		defer failingSrv.Close()
+		hook := &webhook{}
+		srv := httptest.NewServer(hook)
+		defer srv.Close()
+
+		n := newTestNotifier(&config.Config{}, failingSrv.URL, srv.URL)
+		alert := firing("HighHeap", nil, now)
+		n.Notify([]alerts.Alert{alert}, now)
+		n.Flush(ctx, now)
+		assert.Empty(t, failing.payloads)
+		assert.Len(t, hook.payloads, 1)
+
+		n.Notify([]alerts.Alert{alert}, now.Add(time.Second))
+		n.Flush(ctx, now.Add(time.Second))
+		assert.Len(t, failing.payloads, 1, "уведомление повторяется в вебхук, куда оно не дошло")
+		assert.Len(t, hook.payloads, 1, "вебхук, получивший уведомление, не получает его повторно")
+	})
+}
@@ -0,0 +1,398 @@
+// Module for aggregating metrics and forwarding them to upstream server
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+// Релей: сохраняет метрики в локальное хранилище и копит агрегаты для отправки наверх.
+// Счетчики суммируются, для gauge хранится последнее значение. Принятые метрики
+// дописываются в журнал spool файла до ответа агенту и удаляются из него только
+// после подтверждения вышестоящего сервера. Журнал уплотняется при каждой пересылке
+type Relay struct {
+	Storage
+	intervalSec int64
+	spoolPath   string
+	sender      Sender
+
+	// mu защищает очередь и запись spool файла
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	// пачки, сформированные пересылкой и еще не подтвержденные сервером
+	sealed []spooledBatch
+	// открытый на дозапись spool файл, номер последней принятой пачки
+	// и кол-во записей, дописанных после уплотнения
+	journal   *os.File
+	lastID    uint64
+	journaled int
+
+	// forwardMu не дает двум пересылкам одновременно отправлять сформированные пачки
+	forwardMu sync.Mutex
+}
+
+// Пачка, ожидающая подтверждения вышестоящего сервера. Повторная отправка идет
+// с тем же идентификатором, поэтому сервер применяет пачку один раз
+type spooledBatch struct {
+	BatchID string           `json:"batch_id"`
+	Metrics []metrics.Metric `json:"metrics"`
+}
+
+// Случайный идентификатор пачки
+func newBatchID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Запись журнала spool файла. Файл состоит из JSON записей, по одной на строку
+type spoolRecord struct {
+	Sealed *spooledBatch    `json:"sealed,omitempty"` // сформированная пачка, пачки идут в порядке отправки
+	Open   []metrics.Metric `json:"open,omitempty"`   // метрики, принятые после последней пересылки
+	ID     uint64           `json:"id,omitempty"`     // номер принятой пачки
+	Cancel uint64           `json:"cancel,omitempty"` // номер пачки, которую не удалось сохранить
+}
+
+// Init relay. Неотправленные метрики восстанавливаются из spool файла
+func New(intervalSec int64, spoolPath string, storage Storage, sender Sender) *Relay {
+	r := &Relay{
+		Storage:     storage,
+		intervalSec: intervalSec,
+		spoolPath:   spoolPath,
+		sender:      sender,
+		counters:    map[string]int64{},
+		gauges:      map[string]float64{},
+	}
+	if err := r.readSpool(); err != nil {
+		logging.Logger.Warnf("Can't read relay spool, dropping the rest of it: %s", err)
+		if err := r.writeSpool(); err != nil {
+			logging.Logger.Warnf("Can't rewrite relay spool: %s", err)
+		}
+	}
+	return r
+}
+
+// Добавление пачки в очередь на отправку. Вызывается под r.mu
+func (r *Relay) enqueue(metricList []metrics.Metric) {
+	for _, metric := range metricList {
+		switch {
+		case metric.MType == "counter" && metric.Delta != nil:
+			r.counters[metric.ID] += *metric.Delta
+		case metric.MType == "gauge" && metric.Value != nil:
+			r.gauges[metric.ID] = *metric.Value
+		}
+	}
+}
+
+// Прием пачки: пачка дописывается в spool до сохранения и попадает в очередь после
+// него, а если save вернул ошибку или пачка уже была применена, в spool дописывается
+// ее отмена
+func (r *Relay) accept(metricList []metrics.Metric, save func() (bool, error)) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	id := r.lastID
+	if err := r.appendSpool(spoolRecord{ID: id, Open: metricList}); err != nil {
+		return false, err
+	}
+	applied, err := save()
+	if err != nil || !applied {
+		if spoolErr := r.appendSpool(spoolRecord{Cancel: id}); spoolErr != nil {
+			logging.Logger.Warnf("Can't roll back relay spool: %s", spoolErr)
+		}
+		return applied, err
+	}
+	r.enqueue(metricList)
+	return true, nil
+}
+
+// Сохранение пачки метрик локально и добавление ее в очередь на отправку
+func (r *Relay) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	_, err := r.accept(metricList, func() (bool, error) {
+		return true, r.Storage.SaveMetrics(ctx, metricList)
+	})
+	return err
+}
+
+// Однократное сохранение пачки: повтор уже примененной пачки не попадает в очередь
+func (r *Relay) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	return r.accept(metricList, func() (bool, error) {
+		return r.Storage.SaveBatch(ctx, batch, metricList)
+	})
+}
+
+// Проверка доступности локального хранилища
+func (r *Relay) Ping(ctx context.Context) bool {
+	if storage, ok := r.Storage.(externalStorage); ok {
+		return storage.Ping(ctx)
+	}
+	return true
+}
+
+// Закрытие spool файла и локального хранилища
+func (r *Relay) Close() {
+	r.mu.Lock()
+	r.closeJournal()
+	r.mu.Unlock()
+	if storage, ok := r.Storage.(externalStorage); ok {
+		storage.Close()
+	}
+}
+
+// Агрегаты очереди в виде списка метрик, отсортированного по типу и имени
+func aggregate(counters map[string]int64, gauges map[string]float64) []metrics.Metric {
+	metricList := make([]metrics.Metric, 0, len(counters)+len(gauges))
+	for id, delta := range counters {
+		metricList = append(metricList, metrics.Metric{ID: id, MType: "counter", Delta: &delta})
+	}
+	for id, value := range gauges {
+		metricList = append(metricList, metrics.Metric{ID: id, MType: "gauge", Value: &value})
+	}
+	sort.Slice(metricList, func(i, j int) bool {
+		if metricList[i].MType != metricList[j].MType {
+			return metricList[i].MType < metricList[j].MType
+		}
+		return metricList[i].ID < metricList[j].ID
+	})
+	return metricList
+}
+
+// Восстановление очереди из spool файла. Отмененные пачки пропускаются. При ошибке
+// в очереди остаются записи до поврежденной, например оборванной при падении
+func (r *Relay) readSpool() error {
+	if r.spoolPath == "" {
+		return nil
+	}
+	file, err := os.Open(r.spoolPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var records []spoolRecord
+	cancelled := map[uint64]bool{}
+	decoder := json.NewDecoder(file)
+	for {
+		var record spoolRecord
+		err = decoder.Decode(&record)
+		if err != nil {
+			break
+		}
+		if record.Cancel != 0 {
+			cancelled[record.Cancel] = true
+			continue
+		}
+		// номера новых пачек продолжают номера журнала, чтобы отмена не задела старую
+		r.lastID = max(r.lastID, record.ID)
+		records = append(records, record)
+	}
+	for _, record := range records {
+		switch {
+		case record.Sealed != nil:
+			r.sealed = append(r.sealed, *record.Sealed)
+		case record.ID == 0 || !cancelled[record.ID]:
+			r.enqueue(record.Open)
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// Дозапись записи в журнал spool файла с fsync. Если дозапись не удалась, файл
+// переписывается из очереди, чтобы в нем не осталось оборванной записи.
+// Вызывается под r.mu
+func (r *Relay) appendSpool(record spoolRecord) error {
+	if r.spoolPath == "" {
+		return nil
+	}
+	err := r.writeRecord(record)
+	if err != nil {
+		if rewriteErr := r.writeSpool(); rewriteErr != nil {
+			logging.Logger.Warnf("Can't rewrite relay spool: %s", rewriteErr)
+		}
+		return err
+	}
+	r.journaled++
+	return nil
+}
+
+func (r *Relay) writeRecord(record spoolRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if r.journal == nil {
+		r.journal, err = os.OpenFile(r.spoolPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := r.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return r.journal.Sync()
+}
+
+// Вызывается под r.mu
+func (r *Relay) closeJournal() {
+	if r.journal != nil {
+		r.journal.Close()
+		r.journal = nil
+	}
+}
+
+// Уплотнение журнала: очередь атомарно записывается в spool файл во временный файл,
+// fsync и rename. Пустая очередь удаляет файл. Вызывается под r.mu
+func (r *Relay) writeSpool() error {
+	if r.spoolPath == "" {
+		return nil
+	}
+	r.closeJournal()
+	if len(r.sealed) == 0 && len(r.counters) == 0 && len(r.gauges) == 0 {
+		if err := os.Remove(r.spoolPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		r.journaled = 0
+		return nil
+	}
+	var data []byte
+	for i := range r.sealed {
+		record, err := json.Marshal(spoolRecord{Sealed: &r.sealed[i]})
+		if err != nil {
+			return err
+		}
+		data = append(append(data, record...), '\n')
+	}
+	if len(r.counters) > 0 || len(r.gauges) > 0 {
+		record, err := json.Marshal(spoolRecord{Open: aggregate(r.counters, r.gauges)})
+		if err != nil {
+			return err
+		}
+		data = append(append(data, record...), '\n')
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.spoolPath), filepath.Base(r.spoolPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.spoolPath); err != nil {
+		return err
+	}
+	r.journaled = 0
+	return nil
+}
+
+// Пачки для отправки. Новая пачка из накопленных агрегатов формируется, только
+// когда все прежние подтверждены, иначе агрегаты продолжают копиться, и spool не
+// растет во время недоступности сервера. Идентификатор пачки и сама пачка остаются
+// в spool файле, пока сервер ее не подтвердит. fresh - пачка сформирована сейчас
+func (r *Relay) seal() (batches []spooledBatch, fresh bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.sealed) == 0 && (len(r.counters) > 0 || len(r.gauges) > 0) {
+		r.sealed = append(r.sealed, spooledBatch{BatchID: newBatchID(), Metrics: aggregate(r.counters, r.gauges)})
+		r.counters = map[string]int64{}
+		r.gauges = map[string]float64{}
+		fresh = true
+	}
+	if fresh || r.journaled > 0 {
+		if err := r.writeSpool(); err != nil {
+			return nil, false, err
+		}
+	}
+	return slices.Clone(r.sealed), fresh, nil
+}
+
+// Удаление подтвержденной сервером пачки из очереди и spool файла
+func (r *Relay) ack() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sealed = r.sealed[1:]
+	return r.writeSpool()
+}
+
+// Отправка накопленных агрегатов на вышестоящий сервер. Пачки отправляются по
+// порядку, каждая удаляется из spool файла после подтверждения. После досылки
+// прежних пачек отправляются агрегаты, накопленные за время их ожидания.
+// Возвращает количество отправленных метрик
+func (r *Relay) Forward(ctx context.Context) (int, error) {
+	r.forwardMu.Lock()
+	defer r.forwardMu.Unlock()
+
+	forwarded := 0
+	for {
+		batches, fresh, err := r.seal()
+		if err != nil {
+			return forwarded, err
+		}
+		for _, batch := range batches {
+			if err := r.sender.SendBatch(ctx, batch.BatchID, batch.Metrics); err != nil {
+				return forwarded, err
+			}
+			forwarded += len(batch.Metrics)
+			if err := r.ack(); err != nil {
+				return forwarded, err
+			}
+		}
+		if fresh || len(batches) == 0 {
+			return forwarded, nil
+		}
+	}
+}
+
+// Run relay
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			// последняя пересылка перед остановкой, при ошибке метрики останутся в spool
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if _, err := r.Forward(flushCtx); err != nil {
+				logging.Logger.Warnf("Can't forward metrics upstream on shutdown: %s", err)
+			}
+			cancel()
+			logging.Logger.Info("Relay shutdown")
+			return
+		case <-time.After(time.Duration(r.intervalSec) * time.Second):
+		}
+		forwarded, err := r.Forward(ctx)
+		if err != nil {
+			logging.Logger.Warnf("Can't forward metrics upstream, kept in spool: %s", err)
+		} else if forwarded > 0 {
+			logging.Logger.Infof("Forwarded %d metrics upstream", forwarded)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+type MockSender struct {
+	fail     bool
+	attempts []string
+	batches  [][]metrics.Metric
+}
+
+func (m *MockSender) SendBatch(ctx context.Context, batchID string, metricList []metrics.Metric) error {
+	m.attempts = append(m.attempts, batchID)
+	if m.fail {
+		return errors.New("upstream is not available")
+	}
+	m.batches = append(m.batches, metricList)
+	return nil
+}
+
+func newTestRelay(t *testing.T, sender Sender) (*Relay, string) {
+	logging.Initialize("INFO")
+	storage := memstorage.New()
+	require.NoError(t, storage.Initialize(context.Background()))
+	spoolPath := filepath.Join(t.TempDir(), "spool.json")
+	return New(1, spoolPath, storage, sender), spoolPath
+}
+
+func counter(id string, delta int64) metrics.Metric {
+	return metrics.Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+func gauge(id string, value float64) metrics.Metric {
+	return metrics.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func TestAggregation(t *testing.T) {
+	ctx := context.Background()
+	sender := &MockSender{}
+	relay, _ := newTestRelay(t, sender)
+
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 2), gauge("HeapInuse", 1)}))
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 3), gauge("HeapInuse", 5)}))
+
+	localMetric := metrics.Metric{ID: "PollCount", MType: "counter"}
+	require.NoError(t, relay.GetMetric(ctx, &localMetric))
+	assert.Equal(t, int64(5), *localMetric.Delta, "метрики должны сохраняться локально")
+
+	forwarded, err := relay.Forward(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, forwarded)
+	require.Len(t, sender.batches, 1)
+	assert.Equal(t, []metrics.Metric{counter("PollCount", 5), gauge("HeapInuse", 5)}, sender.batches[0])
+
+	forwarded, err = relay.Forward(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, forwarded, "пустая очередь не отправляется")
+	assert.Len(t, sender.batches, 1)
+}
+
+func TestSpoolOnUpstreamOutage(t *testing.T) {
+	ctx := context.Background()
+	sender := &MockSender{fail: true}
+	relay, spoolPath := newTestRelay(t, sender)
+
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 2), gauge("HeapInuse", 1)}))
+	_, err := relay.Forward(ctx)
+	assert.Error(t, err)
+	assert.FileExists(t, spoolPath)
+
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 3), gauge("HeapInuse", 7)}))
+	_, err = relay.Forward(ctx)
+	assert.Error(t, err)
+
+	// spool переживает перезапуск релея
+	restarted := New(1, spoolPath, relay.Storage, sender)
+	require.NoError(t, restarted.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+
+	sender.fail = false
+	forwarded, err := restarted.Forward(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, forwarded)
+	assert.Equal(t, [][]metrics.Metric{
+		{counter("PollCount", 2), gauge("HeapInuse", 1)},
+		{counter("PollCount", 4), gauge("HeapInuse", 7)},
+	}, sender.batches, "метрики, принятые во время недоступности сервера, копятся в одной пачке")
+	require.Len(t, sender.attempts, 4)
+	assert.Equal(t, sender.attempts[0], sender.attempts[1], "повтор пачки должен идти с тем же идентификатором")
+	assert.Equal(t, sender.attempts[0], sender.attempts[2], "идентификатор пачки должен переживать перезапуск")
+	assert.NotEqual(t, sender.attempts[2], sender.attempts[3])
+	_, err = os.Stat(spoolPath)
+	assert.True(t, os.IsNotExist(err), "spool должен удаляться после успешной отправки")
+}
+
+func TestInvalidBatchNotQueued(t *testing.T) {
+	ctx := context.Background()
+	sender := &MockSender{}
+	relay, _ := newTestRelay(t, sender)
+
+	assert.Error(t, relay.SaveMetrics(ctx, []metrics.Metric{{ID: "PollCount", MType: "unknown"}}))
+	forwarded, err := relay.Forward(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, forwarded)
+}
+
+func TestSpoolOnAccept(t *testing.T) {
+	ctx := context.Background()
+	sender := &MockSender{}
+	relay, spoolPath := newTestRelay(t, sender)
+
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 2)}))
+	applied, err := relay.SaveBatch(ctx, metrics.BatchKey{AgentID: "agent", BatchID: "1"}, []metrics.Metric{counter("PollCount", 3)})
+	require.NoError(t, err)
+	assert.True(t, applied)
+	applied, err = relay.SaveBatch(ctx, metrics.BatchKey{AgentID: "agent", BatchID: "1"}, []metrics.Metric{counter("PollCount", 3)})
+	require.NoError(t, err)
+	assert.False(t, applied)
+	assert.FileExists(t, spoolPath, "принятые метрики должны попадать в spool до пересылки")
+
+	// релей упал до пересылки
+	restarted := New(1, spoolPath, relay.Storage, sender)
+	forwarded, err := restarted.Forward(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, forwarded)
+	assert.Equal(t, [][]metrics.Metric{{counter("PollCount", 5)}}, sender.batches, "повтор пачки не должен попадать в spool")
+	_, err = os.Stat(spoolPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSpoolJournal(t *testing.T) {
+	ctx := context.Background()
+	sender := &MockSender{fail: true}
+	relay, spoolPath := newTestRelay(t, sender)
+
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+	_, err := relay.Forward(ctx)
+	assert.Error(t, err)
+	compacted, err := os.ReadFile(spoolPath)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 2), gauge("HeapInuse", float64(i))}))
+	}
+	assert.Error(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 100), {ID: "HeapInuse", MType: "unknown"}}))
+	journal, err := os.ReadFile(spoolPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(compacted), string(journal[:len(compacted)]), "принятые пачки дописываются в конец spool файла")
+	assert.Equal(t, 5, bytes.Count(journal[len(compacted):], []byte("\n")), "отмена пачки тоже дописывается")
+
+	_, err = relay.Forward(ctx)
+	assert.Error(t, err)
+	journal, err = os.ReadFile(spoolPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(journal, []byte("\n")), "пересылка уплотняет журнал до одной пачки и агрегатов")
+
+	// падение во время дозаписи оставило оборванную запись
+	require.NoError(t, relay.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 3)}))
+	file, err := os.OpenFile(spoolPath, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"id":9,"open":[{"id":"PollCount","ty`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restarted := New(1, spoolPath, relay.Storage, sender)
+	require.NoError(t, restarted.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 4)}))
+	sender.fail = false
+	_, err = restarted.Forward(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, [][]metrics.Metric{
+		{counter("PollCount", 1)},
+		{counter("PollCount", 13), gauge("HeapInuse", 2)},
+	}, sender.batches, "отмененная и оборванная пачки не пересылаются")
+}
@@ -0,0 +1,148 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+)
+
+// Grpc сервер репликации: прием пачек от primary, статус и повышение узла
+type ReplicationGRPCServer struct {
+	pb.UnimplementedReplicationServer
+	node *Node
+}
+
+// Init replication grpc server
+func NewGRPCServer(node *Node) *ReplicationGRPCServer {
+	return &ReplicationGRPCServer{node: node}
+}
+
+func (n *Node) status() *pb.ReplicationStatus {
+	return &pb.ReplicationStatus{Role: n.Role(), AppliedSeq: n.AppliedSeq(), Epoch: n.Epoch()}
+}
+
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrPrimary), errors.Is(err, ErrGap), errors.Is(err, ErrIncompleteSnapshot):
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
+
+// Прием пачек от primary. Первым сообщением follower отправляет свой статус,
+// дальше подтверждает каждую примененную пачку. Части снимка копятся до последней
+// и применяются вместе, подтверждается только снимок целиком
+func (s *ReplicationGRPCServer) Replicate(stream pb.Replication_ReplicateServer) error {
+	if err := stream.Send(s.node.status()); err != nil {
+		return err
+	}
+	var snapshot *entry
+	for {
+		batch, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := fromProto(batch)
+		if snapshot != nil {
+			if e.op != pb.ReplicatedBatch_snapshot || e.epoch != snapshot.epoch || e.seq != snapshot.seq {
+				return status.Error(errorCode(ErrIncompleteSnapshot), ErrIncompleteSnapshot.Error())
+			}
+			e.metrics = append(snapshot.metrics, e.metrics...)
+			snapshot = nil
+		}
+		if batch.GetMore() {
+			if e.op != pb.ReplicatedBatch_snapshot {
+				return status.Error(errorCode(ErrIncompleteSnapshot), ErrIncompleteSnapshot.Error())
+			}
+			snapshot = &e
+			continue
+		}
+		if err := s.node.apply(stream.Context(), e); err != nil {
+			return status.Error(errorCode(err), err.Error())
+		}
+		if err := stream.Send(s.node.status()); err != nil {
+			return err
+		}
+	}
+}
+
+// Роль узла, эпоха и номер последней примененной пачки
+func (s *ReplicationGRPCServer) GetReplicationStatus(ctx context.Context, in *pb.EmptyObject) (*pb.ReplicationStatus, error) {
+	return s.node.status(), nil
+}
+
+// Повышение follower до primary
+func (s *ReplicationGRPCServer) Promote(ctx context.Context, in *pb.EmptyObject) (*pb.ReplicationStatus, error) {
+	s.node.Promote()
+	return s.node.status(), nil
+}
+
+func metricToProto(m metrics.Metric) *pb.Metric {
+	res := &pb.Metric{Id: m.ID}
+	if m.MType == "counter" {
+		res.Type = pb.Metric_counter
+	}
+	if m.Delta != nil {
+		res.Delta = *m.Delta
+	}
+	if m.Value != nil {
+		res.Value = *m.Value
+	}
+	return res
+}
+
+func metricFromProto(m *pb.Metric) metrics.Metric {
+	res := metrics.Metric{ID: m.GetId()}
+	switch m.GetType() {
+	case pb.Metric_counter:
+		delta := m.GetDelta()
+		res.MType = "counter"
+		res.Delta = &delta
+	case pb.Metric_gauge:
+		value := m.GetValue()
+		res.MType = "gauge"
+		res.Value = &value
+	}
+	return res
+}
+
+func toProto(e entry) *pb.ReplicatedBatch {
+	batch := &pb.ReplicatedBatch{
+		Epoch:   e.epoch,
+		Seq:     e.seq,
+		Op:      e.op,
+		Pattern: e.pattern,
+		Type:    e.mType,
+		AgentId: e.batch.AgentID,
+		BatchId: e.batch.BatchID,
+	}
+	for _, metric := range e.metrics {
+		batch.Metrics = append(batch.Metrics, metricToProto(metric))
+	}
+	return batch
+}
+
+func fromProto(batch *pb.ReplicatedBatch) entry {
+	e := entry{
+		epoch:   batch.GetEpoch(),
+		seq:     batch.GetSeq(),
+		op:      batch.GetOp(),
+		pattern: batch.GetPattern(),
+		mType:   batch.GetType(),
+		batch:   metrics.BatchKey{AgentID: batch.GetAgentId(), BatchID: batch.GetBatchId()},
+	}
+	for _, metric := range batch.GetMetrics() {
+		e.metrics = append(e.metrics, metricFromProto(metric))
+	}
+	return e
+}
@@ -0,0 +1,483 @@
+// Module for replicating accepted metric batches from primary to followers
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+// Роли узла репликации
+const (
+	RolePrimary  = "primary"
+	RoleFollower = "follower"
+)
+
+var (
+	// ErrFollower - follower не принимает запись, писать нужно в primary
+	ErrFollower = errors.New("node is a replication follower")
+	// ErrPrimary - primary не принимает репликацию от другого узла
+	ErrPrimary = errors.New("node is a replication primary")
+	// ErrGap - follower пропустил часть пачек и нуждается в повторной синхронизации
+	ErrGap = errors.New("replication sequence gap")
+	// ErrIncompleteSnapshot - части снимка пришли не подряд
+	ErrIncompleteSnapshot = errors.New("incomplete replication snapshot")
+
+	errSnapshotUnsupported = errors.New("storage can't replace its content with snapshot")
+)
+
+// Максимальное кол-во метрик в одной части снимка. Снимок делится на части,
+// чтобы сообщение не превышало лимит размера сообщения grpc
+var snapshotChunkSize = 4096
+
+// Dialer - подключение к follower по его адресу
+type Dialer func(addr string) (*grpc.ClientConn, error)
+
+// Пачка изменений журнала репликации с порядковым номером
+type entry struct {
+	epoch   uint64
+	seq     uint64
+	op      pb.ReplicatedBatch_Op
+	metrics []metrics.Metric
+	pattern string
+	mType   string
+	batch   metrics.BatchKey
+}
+
+// Узел репликации. На primary каждая принятая пачка применяется к локальному
+// хранилищу, получает порядковый номер и попадает в журнал, из которого
+// асинхронно рассылается follower'ам. Follower применяет пачки строго по порядку,
+// повторы пропускает, поэтому повторная отправка не удваивает счетчики.
+// Чтение обслуживается любым узлом, запись - только primary.
+// writeMu упорядочивает запись в хранилище с журналом, mu защищает только номер,
+// журнал и канал оповещения и не держится во время обращений к хранилищу.
+// Номера пачек имеют смысл только внутри эпохи primary: эпоха выбирается заново
+// при каждом запуске primary и при повышении follower, на follower это эпоха
+// primary, от которого получены данные
+type Node struct {
+	Storage
+	followers []string
+	logSize   int
+	dial      Dialer
+
+	primary atomic.Bool
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	epoch   uint64
+	seq     uint64
+	log     []entry
+	changed chan struct{}
+}
+
+// Init replication node
+func New(role string, followers []string, logSize int, storage Storage, dial Dialer) (*Node, error) {
+	if role != RolePrimary && role != RoleFollower {
+		return nil, fmt.Errorf("unknown replication role %q", role)
+	}
+	if logSize <= 0 {
+		return nil, fmt.Errorf("replication log size must be positive")
+	}
+	n := &Node{
+		Storage:   storage,
+		followers: followers,
+		logSize:   logSize,
+		dial:      dial,
+		changed:   make(chan struct{}),
+	}
+	n.primary.Store(role == RolePrimary)
+	if role == RolePrimary {
+		n.epoch = newEpoch()
+	}
+	return n, nil
+}
+
+// Новая эпоха primary. Время запуска отличает эпохи одного узла между перезапусками
+func newEpoch() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// Текущая роль узла
+func (n *Node) Role() string {
+	if n.primary.Load() {
+		return RolePrimary
+	}
+	return RoleFollower
+}
+
+// Номер последней примененной пачки
+func (n *Node) AppliedSeq() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.seq
+}
+
+// Эпоха primary, к которой относится номер последней примененной пачки
+func (n *Node) Epoch() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.epoch
+}
+
+// Повышение follower до primary после потери старого primary. Пачка, которая
+// применяется в этот момент, успевает примениться до повышения. Новый primary
+// начинает свою эпоху: другие follower'ы могли получить от старого primary пачки
+// с теми же номерами, поэтому они синхронизируются снимком
+func (n *Node) Promote() {
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.primary.Swap(true) {
+		return
+	}
+	n.epoch = newEpoch()
+	logging.Logger.Infof("Promoted to replication primary at seq %d", n.seq)
+	n.notify()
+}
+
+// Разбудить рассылку по follower'ам. Вызывается под n.mu
+func (n *Node) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// Добавление пачки в журнал. Вызывается под n.mu
+func (n *Node) append(e entry) {
+	n.log = append(n.log, e)
+	if len(n.log) > n.logSize {
+		n.log = n.log[len(n.log)-n.logSize:]
+	}
+	n.notify()
+}
+
+// Запись пачки на primary в журнал под очередным номером. Вызывается под n.writeMu
+func (n *Node) record(e entry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	e.epoch = n.epoch
+	e.seq = n.seq
+	n.append(e)
+}
+
+func (n *Node) checkWritable() error {
+	if !n.primary.Load() {
+		return storageerrors.Unavailable(ErrFollower)
+	}
+	return nil
+}
+
+// Копия пачки метрик, не зависящая от памяти вызывающего
+func cloneMetrics(metricList []metrics.Metric) []metrics.Metric {
+	res := make([]metrics.Metric, len(metricList))
+	for i, metric := range metricList {
+		res[i] = metrics.Metric{ID: metric.ID, MType: metric.MType}
+		if metric.Delta != nil {
+			delta := *metric.Delta
+			res[i].Delta = &delta
+		}
+		if metric.Value != nil {
+			value := *metric.Value
+			res[i].Value = &value
+		}
+	}
+	return res
+}
+
+// Сохранение пачки метрик на primary с записью в журнал репликации
+func (n *Node) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	if err := n.checkWritable(); err != nil {
+		return err
+	}
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	if err := n.Storage.SaveMetrics(ctx, metricList); err != nil {
+		return err
+	}
+	n.record(entry{op: pb.ReplicatedBatch_save, metrics: cloneMetrics(metricList)})
+	return nil
+}
+
+// Однократное сохранение пачки на primary. В журнал попадают только примененные
+// пачки вместе с ключом, чтобы после повышения follower тоже отсекал повторы агентов
+func (n *Node) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	if err := n.checkWritable(); err != nil {
+		return false, err
+	}
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	applied, err := n.Storage.SaveBatch(ctx, batch, metricList)
+	if err != nil || !applied {
+		return applied, err
+	}
+	n.record(entry{op: pb.ReplicatedBatch_save, metrics: cloneMetrics(metricList), batch: batch})
+	return true, nil
+}
+
+// Удаление метрики на primary с записью в журнал репликации
+func (n *Node) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	if err := n.checkWritable(); err != nil {
+		return err
+	}
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	key := metrics.Metric{ID: metric.ID, MType: metric.MType}
+	if err := n.Storage.DeleteMetric(ctx, metric); err != nil {
+		return err
+	}
+	n.record(entry{op: pb.ReplicatedBatch_delete_metric, metrics: []metrics.Metric{key}})
+	return nil
+}
+
+// Удаление метрик по шаблону на primary с записью в журнал репликации
+func (n *Node) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	if err := n.checkWritable(); err != nil {
+		return 0, err
+	}
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	deleted, err := n.Storage.DeleteMetrics(ctx, mType, pattern)
+	if err != nil {
+		return deleted, err
+	}
+	if deleted > 0 {
+		n.record(entry{op: pb.ReplicatedBatch_delete_metrics, pattern: pattern, mType: mType})
+	}
+	return deleted, nil
+}
+
+// Сброс счетчика на primary с записью в журнал репликации
+func (n *Node) ResetCounter(ctx context.Context, id string) error {
+	if err := n.checkWritable(); err != nil {
+		return err
+	}
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	if err := n.Storage.ResetCounter(ctx, id); err != nil {
+		return err
+	}
+	n.record(entry{op: pb.ReplicatedBatch_reset_counter, metrics: []metrics.Metric{{ID: id, MType: "counter"}}})
+	return nil
+}
+
+// Применение пачки, полученной от primary. Повторно присланные пачки пропускаются,
+// пачка с пропуском номера или из другой эпохи отклоняется, снимок заменяет
+// содержимое хранилища целиком одной операцией, поэтому чтение не застает
+// хранилище пустым
+func (n *Node) apply(ctx context.Context, e entry) error {
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+
+	if n.primary.Load() {
+		return ErrPrimary
+	}
+	if e.op != pb.ReplicatedBatch_snapshot {
+		epoch, applied := n.Epoch(), n.AppliedSeq()
+		if e.epoch != epoch {
+			return fmt.Errorf("%w: applied epoch %d, got %d", ErrGap, epoch, e.epoch)
+		}
+		if e.seq <= applied {
+			return nil
+		}
+		if e.seq != applied+1 {
+			return fmt.Errorf("%w: applied %d, got %d", ErrGap, applied, e.seq)
+		}
+	}
+
+	var err error
+	switch e.op {
+	case pb.ReplicatedBatch_save:
+		if e.batch.BatchID != "" {
+			_, err = n.Storage.SaveBatch(ctx, e.batch, e.metrics)
+		} else {
+			err = n.Storage.SaveMetrics(ctx, e.metrics)
+		}
+	case pb.ReplicatedBatch_delete_metric:
+		if len(e.metrics) == 1 {
+			err = n.Storage.DeleteMetric(ctx, &e.metrics[0])
+		}
+	case pb.ReplicatedBatch_delete_metrics:
+		_, err = n.Storage.DeleteMetrics(ctx, e.mType, e.pattern)
+	case pb.ReplicatedBatch_reset_counter:
+		if len(e.metrics) == 1 {
+			err = n.Storage.ResetCounter(ctx, e.metrics[0].ID)
+		}
+	case pb.ReplicatedBatch_snapshot:
+		storage, ok := n.Storage.(snapshotStorage)
+		if !ok {
+			return errSnapshotUnsupported
+		}
+		err = storage.ReplaceMetrics(ctx, e.metrics)
+	default:
+		err = fmt.Errorf("unknown replication op %d", e.op)
+	}
+	if err != nil && !errors.Is(err, storageerrors.ErrNotFound) {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq = e.seq
+	if e.op == pb.ReplicatedBatch_snapshot {
+		n.epoch = e.epoch
+		n.log = nil
+		n.notify()
+	} else {
+		n.append(e)
+	}
+	return nil
+}
+
+// Пачки после from для отправки follower'у и канал, который закроется при
+// появлении новых. Если нужных пачек в журнале уже нет или данные follower'а
+// относятся к другой эпохе (пустой follower, перезапуск или смена primary),
+// вместо журнала отправляется снимок хранилища
+func (n *Node) since(ctx context.Context, epoch uint64, from uint64) ([]entry, <-chan struct{}, error) {
+	n.mu.Lock()
+	changed := n.changed
+	covered := from <= n.seq && from+uint64(len(n.log)) >= n.seq
+	if epoch == n.epoch && covered {
+		entries := make([]entry, n.seq-from)
+		copy(entries, n.log[len(n.log)-len(entries):])
+		n.mu.Unlock()
+		return entries, changed, nil
+	}
+	n.mu.Unlock()
+
+	// снимок должен соответствовать номеру, поэтому запись ждет конца выгрузки
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	epoch, seq := n.Epoch(), n.AppliedSeq()
+	metricList, err := n.Storage.ExtractMetrics(ctx)
+	if err != nil {
+		return nil, changed, err
+	}
+	return []entry{{epoch: epoch, seq: seq, op: pb.ReplicatedBatch_snapshot, metrics: metricList}}, changed, nil
+}
+
+// Рассылка журнала одному follower'у, пока узел остается primary
+func (n *Node) replicateTo(ctx context.Context, addr string) {
+	conn, err := n.dial(addr)
+	if err != nil {
+		logging.Logger.Errorf("Can't connect to follower %s: %s", addr, err)
+		return
+	}
+	defer conn.Close()
+	client := pb.NewReplicationClient(conn)
+
+	for {
+		if n.primary.Load() {
+			if err := n.stream(ctx, client); err != nil && ctx.Err() == nil {
+				logging.Logger.Warnf("Replication to %s interrupted: %s", addr, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Один сеанс репликации: follower сообщает эпоху и номер последней примененной
+// пачки, primary досылает недостающие пачки и дальше отправляет новые по мере появления
+func (n *Node) stream(ctx context.Context, client pb.ReplicationClient) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.Replicate(streamCtx)
+	if err != nil {
+		return err
+	}
+	followerStatus, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if followerStatus.GetRole() == RolePrimary {
+		return ErrPrimary
+	}
+
+	acks := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := stream.Recv(); err != nil {
+				acks <- err
+				return
+			}
+		}
+	}()
+
+	epoch, from := followerStatus.GetEpoch(), followerStatus.GetAppliedSeq()
+	for n.primary.Load() {
+		entries, changed, err := n.since(ctx, epoch, from)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := sendEntry(stream, e); err != nil {
+				return fmt.Errorf("can't send batch %d: %w", e.seq, err)
+			}
+			epoch, from = e.epoch, e.seq
+		}
+
+		select {
+		case <-ctx.Done():
+			return stream.CloseSend()
+		case err := <-acks:
+			return err
+		case <-changed:
+		}
+	}
+	return stream.CloseSend()
+}
+
+// Отправка пачки follower'у. Снимок отправляется частями по snapshotChunkSize
+// метрик с одним номером, у всех частей кроме последней выставлен флаг more
+func sendEntry(stream pb.Replication_ReplicateClient, e entry) error {
+	if e.op != pb.ReplicatedBatch_snapshot {
+		return stream.Send(toProto(e))
+	}
+	metricList := e.metrics
+	for {
+		chunk := e
+		chunk.metrics = metricList
+		if len(metricList) > snapshotChunkSize {
+			chunk.metrics = metricList[:snapshotChunkSize]
+		}
+		metricList = metricList[len(chunk.metrics):]
+
+		batch := toProto(chunk)
+		batch.More = len(metricList) > 0
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+		if !batch.More {
+			return nil
+		}
+	}
+}
+
+// Run replication to followers
+func (n *Node) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, addr := range n.followers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			n.replicateTo(ctx, addr)
+		}(addr)
+	}
+	wg.Wait()
+	logging.Logger.Info("Replication shutdown")
+}
@@ -0,0 +1,283 @@
+package replication
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	pb "github.com/ry461ch/metric-collector/internal/proto"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+func newTestNode(t *testing.T, role string, followers []string, dial Dialer) *Node {
+	logging.Initialize("INFO")
+	storage := memstorage.New()
+	require.NoError(t, storage.Initialize(context.Background()))
+	node, err := New(role, followers, 16, storage, dial)
+	require.NoError(t, err)
+	return node
+}
+
+func counter(id string, delta int64) metrics.Metric {
+	return metrics.Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+func gauge(id string, value float64) metrics.Metric {
+	return metrics.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func getCounter(t *testing.T, storage Storage, id string) int64 {
+	metric := metrics.Metric{ID: id, MType: "counter"}
+	require.NoError(t, storage.GetMetric(context.Background(), &metric))
+	return *metric.Delta
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("leader", nil, 16, memstorage.New(), nil)
+	assert.Error(t, err)
+	_, err = New(RolePrimary, nil, 0, memstorage.New(), nil)
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	follower := newTestNode(t, RoleFollower, nil, nil)
+
+	batch := entry{seq: 1, op: pb.ReplicatedBatch_save, metrics: []metrics.Metric{counter("PollCount", 5)}}
+	require.NoError(t, follower.apply(ctx, batch))
+	require.NoError(t, follower.apply(ctx, batch), "повтор пачки должен пропускаться")
+	assert.Equal(t, int64(5), getCounter(t, follower, "PollCount"))
+	assert.Equal(t, uint64(1), follower.AppliedSeq())
+
+	err := follower.apply(ctx, entry{seq: 3, op: pb.ReplicatedBatch_save, metrics: []metrics.Metric{counter("PollCount", 1)}})
+	assert.ErrorIs(t, err, ErrGap)
+	assert.Equal(t, int64(5), getCounter(t, follower, "PollCount"))
+
+	require.NoError(t, follower.apply(ctx, entry{seq: 2, op: pb.ReplicatedBatch_reset_counter, metrics: []metrics.Metric{{ID: "unknown", MType: "counter"}}}))
+	assert.Equal(t, uint64(2), follower.AppliedSeq(), "сброс отсутствующего счетчика не ломает репликацию")
+
+	snapshot := entry{seq: 10, op: pb.ReplicatedBatch_snapshot, metrics: []metrics.Metric{counter("Requests", 7), gauge("HeapInuse", 1.5)}}
+	require.NoError(t, follower.apply(ctx, snapshot))
+	assert.Equal(t, uint64(10), follower.AppliedSeq())
+	assert.Equal(t, int64(7), getCounter(t, follower, "Requests"))
+	err = follower.GetMetric(ctx, &metrics.Metric{ID: "PollCount", MType: "counter"})
+	assert.ErrorIs(t, err, storageerrors.ErrNotFound, "снимок заменяет содержимое хранилища")
+
+	err = follower.apply(ctx, entry{epoch: 7, seq: 11, op: pb.ReplicatedBatch_save, metrics: []metrics.Metric{counter("Requests", 1)}})
+	assert.ErrorIs(t, err, ErrGap, "пачка из другой эпохи требует снимка")
+	require.NoError(t, follower.apply(ctx, entry{epoch: 7, seq: 2, op: pb.ReplicatedBatch_snapshot, metrics: []metrics.Metric{counter("Requests", 1)}}))
+	assert.Equal(t, uint64(7), follower.Epoch())
+	assert.Equal(t, uint64(2), follower.AppliedSeq(), "снимок новой эпохи может иметь меньший номер")
+	require.NoError(t, follower.apply(ctx, entry{epoch: 7, seq: 3, op: pb.ReplicatedBatch_save, metrics: []metrics.Metric{counter("Requests", 1)}}))
+	assert.Equal(t, int64(2), getCounter(t, follower, "Requests"))
+
+	follower.Promote()
+	assert.NotEqual(t, uint64(7), follower.Epoch(), "повышенный follower начинает свою эпоху")
+	assert.ErrorIs(t, follower.apply(ctx, entry{epoch: 7, seq: 4, op: pb.ReplicatedBatch_save}), ErrPrimary)
+}
+
+func TestWritesOnlyOnPrimary(t *testing.T) {
+	ctx := context.Background()
+	node := newTestNode(t, RoleFollower, nil, nil)
+
+	assert.ErrorIs(t, node.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}), storageerrors.ErrUnavailable)
+	assert.ErrorIs(t, node.ResetCounter(ctx, "PollCount"), storageerrors.ErrUnavailable)
+
+	node.Promote()
+	assert.Equal(t, RolePrimary, node.Role())
+	require.NoError(t, node.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+	require.NoError(t, node.ResetCounter(ctx, "PollCount"))
+	assert.Equal(t, uint64(2), node.AppliedSeq())
+
+	// неудачная запись не попадает в журнал
+	assert.Error(t, node.SaveMetrics(ctx, []metrics.Metric{{ID: "PollCount", MType: "unknown"}}))
+	assert.Equal(t, uint64(2), node.AppliedSeq())
+}
+
+func TestSince(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestNode(t, RolePrimary, nil, nil)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, primary.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+	}
+
+	epoch := primary.Epoch()
+	entries, _, err := primary.since(ctx, epoch, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 10)
+	assert.Equal(t, uint64(11), entries[0].seq)
+	assert.Equal(t, uint64(20), entries[9].seq)
+
+	entries, _, err = primary.since(ctx, epoch, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "пачек уже нет в журнале, нужен снимок")
+	assert.Equal(t, pb.ReplicatedBatch_snapshot, entries[0].op)
+	assert.Equal(t, uint64(20), entries[0].seq)
+
+	entries, _, err = primary.since(ctx, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, pb.ReplicatedBatch_snapshot, entries[0].op, "новый follower получает снимок")
+	assert.Equal(t, epoch, entries[0].epoch)
+
+	entries, _, err = primary.since(ctx, epoch+1, 15)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, pb.ReplicatedBatch_snapshot, entries[0].op, "номер из другой эпохи не сравнивается с журналом")
+}
+
+func serveFollower(t *testing.T, follower *Node) Dialer {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	pb.RegisterReplicationServer(server, NewGRPCServer(follower))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return func(addr string) (*grpc.ClientConn, error) {
+		return grpc.NewClient(
+			"passthrough:///"+addr,
+			grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+	}
+}
+
+func TestReplicationStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	follower := newTestNode(t, RoleFollower, nil, nil)
+	primary := newTestNode(t, RolePrimary, []string{"follower"}, serveFollower(t, follower))
+
+	require.NoError(t, primary.Storage.SaveMetrics(ctx, []metrics.Metric{gauge("Restored", 3)}))
+	require.NoError(t, primary.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 2), gauge("HeapInuse", 1)}))
+	done := make(chan struct{})
+	go func() {
+		primary.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	require.NoError(t, primary.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 3), gauge("HeapInuse", 5)}))
+	_, err := primary.DeleteMetrics(ctx, "gauge", "Restored")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return follower.AppliedSeq() == primary.AppliedSeq()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(5), getCounter(t, follower, "PollCount"))
+	heap := metrics.Metric{ID: "HeapInuse", MType: "gauge"}
+	require.NoError(t, follower.GetMetric(ctx, &heap))
+	assert.Equal(t, 5.0, *heap.Value)
+	assert.ErrorIs(t, follower.GetMetric(ctx, &metrics.Metric{ID: "Restored", MType: "gauge"}), storageerrors.ErrNotFound)
+
+	// после повышения follower перестает принимать пачки от старого primary
+	follower.Promote()
+	require.NoError(t, follower.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+	require.NoError(t, primary.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 100)}))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(6), getCounter(t, follower, "PollCount"))
+}
+
+func TestReplicationSnapshotInChunks(t *testing.T) {
+	chunkSize := snapshotChunkSize
+	snapshotChunkSize = 2
+	t.Cleanup(func() { snapshotChunkSize = chunkSize })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	follower := newTestNode(t, RoleFollower, nil, nil)
+	require.NoError(t, follower.Storage.SaveMetrics(ctx, []metrics.Metric{gauge("Stale", 1)}))
+	primary := newTestNode(t, RolePrimary, []string{"follower"}, serveFollower(t, follower))
+	require.NoError(t, primary.SaveMetrics(ctx, []metrics.Metric{
+		counter("PollCount", 2), gauge("HeapInuse", 1), gauge("HeapAlloc", 2), gauge("HeapSys", 3), counter("Requests", 4),
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		primary.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	require.Eventually(t, func() bool {
+		return follower.AppliedSeq() == primary.AppliedSeq()
+	}, 5*time.Second, 10*time.Millisecond)
+	metricList, err := follower.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metricList, 5, "снимок из нескольких частей применяется целиком")
+	assert.Equal(t, int64(4), getCounter(t, follower, "Requests"))
+	assert.ErrorIs(t, follower.GetMetric(ctx, &metrics.Metric{ID: "Stale", MType: "gauge"}), storageerrors.ErrNotFound)
+}
+
+// Запуск primary в фоне до конца теста или до вызова возвращаемой функции
+func runPrimary(t *testing.T, primary *Node) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		primary.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestReplicationAfterPrimaryRestart(t *testing.T) {
+	ctx := context.Background()
+	follower := newTestNode(t, RoleFollower, nil, nil)
+	dial := serveFollower(t, follower)
+
+	primary := newTestNode(t, RolePrimary, []string{"follower"}, dial)
+	stop := runPrimary(t, primary)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, primary.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+	}
+	require.Eventually(t, func() bool {
+		return follower.AppliedSeq() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	stop()
+
+	// перезапущенный primary начинает нумерацию заново с восстановленными данными
+	restarted := newTestNode(t, RolePrimary, []string{"follower"}, dial)
+	require.NotEqual(t, primary.Epoch(), restarted.Epoch())
+	require.NoError(t, restarted.Storage.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 10)}))
+	require.NoError(t, restarted.SaveMetrics(ctx, []metrics.Metric{gauge("HeapInuse", 2)}))
+	runPrimary(t, restarted)
+
+	require.Eventually(t, func() bool {
+		return follower.Epoch() == restarted.Epoch()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), follower.AppliedSeq())
+	assert.Equal(t, int64(10), getCounter(t, follower, "PollCount"), "follower получает снимок нового primary")
+	heap := metrics.Metric{ID: "HeapInuse", MType: "gauge"}
+	require.NoError(t, follower.GetMetric(ctx, &heap))
+	assert.Equal(t, 2.0, *heap.Value)
+
+	require.NoError(t, restarted.SaveMetrics(ctx, []metrics.Metric{counter("PollCount", 1)}))
+	require.Eventually(t, func() bool {
+		return follower.AppliedSeq() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(11), getCounter(t, follower, "PollCount"))
+}
@@ -13,5 +13,20 @@ type metricHandlers interface {
 	PostJSONHandler(res http.ResponseWriter, req *http.Request)
 	GetJSONHandler(res http.ResponseWriter, req *http.Request)
 	PostMetricsHandler(res http.ResponseWriter, req *http.Request)
+	DeletePlainCounterHandler(res http.ResponseWriter, req *http.Request)
+	DeletePlainGaugeHandler(res http.ResponseWriter, req *http.Request)
+	DeleteMetricsHandler(res http.ResponseWriter, req *http.Request)
+	ResetPlainCounterHandler(res http.ResponseWriter, req *http.Request)
+	GetPlainCounterRateHandler(res http.ResponseWriter, req *http.Request)
+	ListMetricsHandler(res http.ResponseWriter, req *http.Request)
 	Ping(res http.ResponseWriter, req *http.Request)
 }
+
+type alertHandlers interface {
+	GetAlertsHandler(res http.ResponseWriter, req *http.Request)
+}
+
+type healthHandlers interface {
+	Healthz(res http.ResponseWriter, req *http.Request)
+	Readyz(res http.ResponseWriter, req *http.Request)
+}
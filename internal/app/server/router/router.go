@@ -7,6 +7,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/ry461ch/metric-collector/internal/app/server/dashboard"
+	"github.com/ry461ch/metric-collector/internal/app/server/selfmetrics"
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
 	encryptmiddleware "github.com/ry461ch/metric-collector/pkg/encrypt/middleware"
 	"github.com/ry461ch/metric-collector/pkg/ipchecker"
@@ -14,104 +16,169 @@ import (
 	requestlogger "github.com/ry461ch/metric-collector/pkg/logging/middleware"
 	"github.com/ry461ch/metric-collector/pkg/middlewares/compressor"
 	"github.com/ry461ch/metric-collector/pkg/middlewares/contenttypes"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	ratelimitmiddleware "github.com/ry461ch/metric-collector/pkg/ratelimit/middleware"
 	"github.com/ry461ch/metric-collector/pkg/rsa"
 	rsamiddleware "github.com/ry461ch/metric-collector/pkg/rsa/middleware"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+	tokensmiddleware "github.com/ry461ch/metric-collector/pkg/tokens/middleware"
 )
 
-// Router initialization
-func New(mHandlers metricHandlers, encrypter *encrypt.Encrypter, rsaDecrypter *rsa.RsaDecrypter, ipChecker *ipchecker.IPChecker) chi.Router {
-	r := chi.NewRouter()
-	r.Use(requestlogger.WithLogging)
-	if ipChecker != nil {
-		r.Use(ipcheckermiddleware.CheckRequesterIP(ipChecker))
+// Router initialization. Если tokenStore задан, запросы требуют bearer токен
+// с правом read, write или admin в зависимости от ручки. Если задан limiter,
+// запись метрик ограничивается по агентам. Если задан registry, запросы
+// учитываются в метриках сервера
+func New(mHandlers metricHandlers, aHandlers alertHandlers, hHandlers healthHandlers, encrypter *encrypt.Encrypter, replayGuard *encrypt.ReplayGuard, rsaDecrypter *rsa.RsaDecrypter, ipChecker *ipchecker.IPChecker, tokenStore *tokens.Store, limiter *ratelimit.Limiter, registry *selfmetrics.Registry) chi.Router {
+	noop := func(next http.Handler) http.Handler {
+		return next
+	}
+	scope := func(scope string) func(http.Handler) http.Handler {
+		if tokenStore == nil {
+			return noop
+		}
+		return tokensmiddleware.RequireScope(tokenStore, scope)
 	}
-	r.Use(
-		compressor.GzipHandle,
-		encryptmiddleware.CheckRequestAndEncryptResponse(encrypter),
-	)
-	if rsaDecrypter != nil {
-		r.Use(rsamiddleware.DecryptRequest(rsaDecrypter))
+	limit := noop
+	if limiter != nil {
+		limit = ratelimitmiddleware.LimitRequests(limiter, ipChecker)
 	}
 
-	r.Route("/updates/", func(r chi.Router) {
-		r.Use(contenttypes.ValidateJSONContentType)
-		r.Post("/", mHandlers.PostMetricsHandler)
-	})
-	r.Route("/update/", func(r chi.Router) {
-		r.Route("/counter/", func(r chi.Router) {
-			r.Use(contenttypes.ValidatePlainContentType)
-			r.Route("/{name:[a-zA-Z0-9-_]+}", func(r chi.Router) {
-				r.Post("/{value:[0-9]+}", mHandlers.PostPlainCounterHandler)
+	r := chi.NewRouter()
+	if registry != nil {
+		r.Use(selfmetrics.InstrumentHTTP(registry))
+	}
+	r.Use(requestlogger.WithLogging)
+	// проверки состояния доступны без проверки IP, подписи и шифрования, чтобы
+	// оркестратор мог опрашивать сервер без ключей
+	r.Get("/healthz", hHandlers.Healthz)
+	r.Get("/readyz", hHandlers.Readyz)
+
+	r.Group(func(r chi.Router) {
+		if ipChecker != nil {
+			r.Use(ipcheckermiddleware.CheckRequesterIP(ipChecker))
+		}
+		r.Use(
+			compressor.GzipHandle,
+			encryptmiddleware.CheckRequestAndEncryptResponse(encrypter, replayGuard),
+		)
+		if rsaDecrypter != nil {
+			r.Use(rsamiddleware.DecryptRequest(rsaDecrypter))
+		}
+
+		r.Route("/updates/", func(r chi.Router) {
+			r.Use(scope(tokens.ScopeWrite), limit, contenttypes.ValidateJSONContentType)
+			r.Post("/", mHandlers.PostMetricsHandler)
+		})
+		r.Route("/update/", func(r chi.Router) {
+			r.Use(scope(tokens.ScopeWrite), limit)
+			r.Route("/counter/", func(r chi.Router) {
+				r.Use(contenttypes.ValidatePlainContentType)
+				r.Route("/{name:[a-zA-Z0-9-_]+}", func(r chi.Router) {
+					r.Post("/{value:[0-9]+}", mHandlers.PostPlainCounterHandler)
+					r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
+						res.WriteHeader(http.StatusBadRequest)
+					})
+				})
 				r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
-					res.WriteHeader(http.StatusBadRequest)
+					res.WriteHeader(http.StatusNotFound)
 				})
 			})
-			r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
-				res.WriteHeader(http.StatusNotFound)
-			})
-		})
-		r.Route("/gauge/", func(r chi.Router) {
-			r.Use(contenttypes.ValidatePlainContentType)
-			r.Route("/{name:[a-zA-Z0-9-_]+}", func(r chi.Router) {
-				r.Post("/{value:[0-9]+\\.?[0-9]*}", mHandlers.PostPlainGaugeHandler)
+			r.Route("/gauge/", func(r chi.Router) {
+				r.Use(contenttypes.ValidatePlainContentType)
+				r.Route("/{name:[a-zA-Z0-9-_]+}", func(r chi.Router) {
+					r.Post("/{value:[0-9]+\\.?[0-9]*}", mHandlers.PostPlainGaugeHandler)
+					r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
+						res.WriteHeader(http.StatusBadRequest)
+					})
+				})
 				r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
-					res.WriteHeader(http.StatusBadRequest)
+					res.WriteHeader(http.StatusNotFound)
 				})
 			})
-			r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
-				res.WriteHeader(http.StatusNotFound)
+			r.Route("/", func(r chi.Router) {
+				r.Use(contenttypes.ValidateJSONContentType)
+				r.Post("/", mHandlers.PostJSONHandler)
+			})
+			r.Post("/+", func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(http.StatusBadRequest)
 			})
 		})
-		r.Route("/", func(r chi.Router) {
-			r.Use(contenttypes.ValidateJSONContentType)
-			r.Post("/", mHandlers.PostJSONHandler)
-		})
-		r.Post("/+", func(res http.ResponseWriter, req *http.Request) {
-			res.WriteHeader(http.StatusBadRequest)
-		})
-	})
-	r.Route("/value/", func(r chi.Router) {
-		r.Route("/counter/", func(r chi.Router) {
-			r.Use(contenttypes.ValidatePlainContentType)
+		r.Route("/value/", func(r chi.Router) {
+			r.Route("/counter/", func(r chi.Router) {
+				r.Use(contenttypes.ValidatePlainContentType)
 
-			r.Get("/{name:[a-zA-Z0-9-_]+}", mHandlers.GetPlainCounterHandler)
-			r.Get("/*", func(res http.ResponseWriter, req *http.Request) {
-				res.WriteHeader(http.StatusNotFound)
+				r.With(scope(tokens.ScopeRead)).Get("/{name:[a-zA-Z0-9-_]+}", mHandlers.GetPlainCounterHandler)
+				r.With(scope(tokens.ScopeAdmin)).Delete("/{name:[a-zA-Z0-9-_]+}", mHandlers.DeletePlainCounterHandler)
+				r.Get("/*", func(res http.ResponseWriter, req *http.Request) {
+					res.WriteHeader(http.StatusNotFound)
+				})
 			})
-		})
-		r.Route("/gauge/", func(r chi.Router) {
-			r.Use(contenttypes.ValidatePlainContentType)
+			r.Route("/gauge/", func(r chi.Router) {
+				r.Use(contenttypes.ValidatePlainContentType)
 
-			r.Get("/{name:[a-zA-Z0-9-_]+}", mHandlers.GetPlainGaugeHandler)
-			r.Get("/*", func(res http.ResponseWriter, req *http.Request) {
+				r.With(scope(tokens.ScopeRead)).Get("/{name:[a-zA-Z0-9-_]+}", mHandlers.GetPlainGaugeHandler)
+				r.With(scope(tokens.ScopeAdmin)).Delete("/{name:[a-zA-Z0-9-_]+}", mHandlers.DeletePlainGaugeHandler)
+				r.Get("/*", func(res http.ResponseWriter, req *http.Request) {
+					res.WriteHeader(http.StatusNotFound)
+				})
+			})
+			r.Route("/", func(r chi.Router) {
+				r.Use(scope(tokens.ScopeRead), contenttypes.ValidateJSONContentType)
+				r.Post("/", mHandlers.GetJSONHandler)
+			})
+			r.Get("/+", func(res http.ResponseWriter, req *http.Request) {
 				res.WriteHeader(http.StatusNotFound)
 			})
 		})
+		r.With(scope(tokens.ScopeAdmin)).Delete("/values/", mHandlers.DeleteMetricsHandler)
+		r.Route("/reset/", func(r chi.Router) {
+			r.Use(scope(tokens.ScopeAdmin))
+			r.Route("/counter/", func(r chi.Router) {
+				r.Use(contenttypes.ValidatePlainContentType)
+				r.Post("/{name:[a-zA-Z0-9-_]+}", mHandlers.ResetPlainCounterHandler)
+				r.Post("/*", func(res http.ResponseWriter, req *http.Request) {
+					res.WriteHeader(http.StatusNotFound)
+				})
+			})
+		})
+		r.Route("/rate/", func(r chi.Router) {
+			r.Use(scope(tokens.ScopeRead))
+			r.Route("/counter/", func(r chi.Router) {
+				r.Use(contenttypes.ValidatePlainContentType)
+				r.Get("/{name:[a-zA-Z0-9-_]+}", mHandlers.GetPlainCounterRateHandler)
+				r.Get("/*", func(res http.ResponseWriter, req *http.Request) {
+					res.WriteHeader(http.StatusNotFound)
+				})
+			})
+		})
+		r.Route("/api/", func(r chi.Router) {
+			r.Use(scope(tokens.ScopeRead))
+			r.Get("/metrics", mHandlers.ListMetricsHandler)
+		})
+		r.With(scope(tokens.ScopeRead)).Get("/alerts", aHandlers.GetAlertsHandler)
+		r.Get("/dashboard", func(res http.ResponseWriter, req *http.Request) {
+			http.Redirect(res, req, "/dashboard/", http.StatusMovedPermanently)
+		})
+		r.Handle("/dashboard/*", dashboard.New("/dashboard/"))
+		r.Get("/ping", mHandlers.Ping)
 		r.Route("/", func(r chi.Router) {
-			r.Use(contenttypes.ValidateJSONContentType)
-			r.Post("/", mHandlers.GetJSONHandler)
+			r.Use(scope(tokens.ScopeRead), contenttypes.ValidatePlainContentType)
+			r.Get("/", mHandlers.GetPlainAllMetricsHandler)
 		})
-		r.Get("/+", func(res http.ResponseWriter, req *http.Request) {
-			res.WriteHeader(http.StatusNotFound)
+		r.Route("/debug/pprof/", func(r chi.Router) {
+			r.Use(scope(tokens.ScopeAdmin))
+			r.Get("/", pprof.Index)
+			r.Get("/cmdline", pprof.Cmdline)
+			r.Get("/profile", pprof.Profile)
+			r.Get("/symbol", pprof.Symbol)
+			r.Get("/trace", pprof.Trace)
+			r.Handle("/allocs", pprof.Handler("allocs"))
+			r.Handle("/goroutine", pprof.Handler("goroutine"))
+			r.Handle("/threadcreate", pprof.Handler("threadcreate"))
+			r.Handle("/mutex", pprof.Handler("mutex"))
+			r.Handle("/heap", pprof.Handler("heap"))
+			r.Handle("/block", pprof.Handler("block"))
 		})
 	})
-	r.Get("/ping", mHandlers.Ping)
-	r.Route("/", func(r chi.Router) {
-		r.Use(contenttypes.ValidatePlainContentType)
-		r.Get("/", mHandlers.GetPlainAllMetricsHandler)
-	})
-	r.Route("/debug/pprof/", func(r chi.Router) {
-		r.Get("/", pprof.Index)
-		r.Get("/cmdline", pprof.Cmdline)
-		r.Get("/profile", pprof.Profile)
-		r.Get("/symbol", pprof.Symbol)
-		r.Get("/trace", pprof.Trace)
-		r.Handle("/allocs", pprof.Handler("allocs"))
-		r.Handle("/goroutine", pprof.Handler("goroutine"))
-		r.Handle("/threadcreate", pprof.Handler("threadcreate"))
-		r.Handle("/mutex", pprof.Handler("mutex"))
-		r.Handle("/heap", pprof.Handler("heap"))
-		r.Handle("/block", pprof.Handler("block"))
-	})
 	return r
 }
@@ -1,16 +1,24 @@
 package router
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/resty.v1"
 
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	"github.com/ry461ch/metric-collector/pkg/ipchecker"
 	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
 )
 
 type MockHandlers struct {
@@ -61,11 +69,56 @@ func (m *MockHandlers) PostMetricsHandler(res http.ResponseWriter, req *http.Req
 	res.WriteHeader(http.StatusOK)
 }
 
+func (m *MockHandlers) DeletePlainCounterHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["deleteCounter"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) DeletePlainGaugeHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["deleteGauge"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) DeleteMetricsHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["deleteByPattern"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) ResetPlainCounterHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["resetCounter"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) GetPlainCounterRateHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["getCounterRate"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) ListMetricsHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["listMetrics"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) GetAlertsHandler(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["getAlerts"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
 func (m *MockHandlers) Ping(res http.ResponseWriter, req *http.Request) {
 	m.pathTimesCalled["ping"] += 1
 	res.WriteHeader(http.StatusOK)
 }
 
+func (m *MockHandlers) Healthz(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["healthz"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
+func (m *MockHandlers) Readyz(res http.ResponseWriter, req *http.Request) {
+	m.pathTimesCalled["readyz"] += 1
+	res.WriteHeader(http.StatusOK)
+}
+
 func TestRouter(t *testing.T) {
 	defaultPostGaugeRequest := "/update/gauge/some_metric/10.0"
 	defaultPostCounterRequest := "/update/counter/some_metric/10"
@@ -275,6 +328,22 @@ func TestRouter(t *testing.T) {
 			expectedCode:            http.StatusOK,
 			expectedPathTimesCalled: map[string]int64{"ping": 1},
 		},
+		{
+			testName:                "ok for healthz",
+			method:                  http.MethodGet,
+			requestPath:             "/healthz",
+			requestContentType:      jsonContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"healthz": 1},
+		},
+		{
+			testName:                "ok for readyz",
+			method:                  http.MethodGet,
+			requestPath:             "/readyz",
+			requestContentType:      jsonContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"readyz": 1},
+		},
 		{
 			testName:                "ok for post metrics",
 			method:                  http.MethodPost,
@@ -283,6 +352,94 @@ func TestRouter(t *testing.T) {
 			expectedCode:            http.StatusOK,
 			expectedPathTimesCalled: map[string]int64{"postAllJson": 1},
 		},
+		{
+			testName:                "ok for delete counter",
+			method:                  http.MethodDelete,
+			requestPath:             defaultGetCounterRequest,
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"deleteCounter": 1},
+		},
+		{
+			testName:                "ok for delete gauge",
+			method:                  http.MethodDelete,
+			requestPath:             defaultGetGaugeRequest,
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"deleteGauge": 1},
+		},
+		{
+			testName:                "ok for delete by pattern",
+			method:                  http.MethodDelete,
+			requestPath:             "/values/?pattern=Host*",
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"deleteByPattern": 1},
+		},
+		{
+			testName:                "ok for reset counter",
+			method:                  http.MethodPost,
+			requestPath:             "/reset/counter/some_metric",
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"resetCounter": 1},
+		},
+		{
+			testName:                "no metric name for reset counter",
+			method:                  http.MethodPost,
+			requestPath:             "/reset/counter/",
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusNotFound,
+			expectedPathTimesCalled: map[string]int64{},
+		},
+		{
+			testName:                "ok for counter rate",
+			method:                  http.MethodGet,
+			requestPath:             "/rate/counter/some_metric",
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"getCounterRate": 1},
+		},
+		{
+			testName:                "rate for gauge",
+			method:                  http.MethodGet,
+			requestPath:             "/rate/gauge/some_metric",
+			requestContentType:      plainContentType,
+			expectedCode:            http.StatusNotFound,
+			expectedPathTimesCalled: map[string]int64{},
+		},
+		{
+			testName:                "ok for list metrics",
+			method:                  http.MethodGet,
+			requestPath:             "/api/metrics?type=gauge&limit=10",
+			requestContentType:      jsonContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"listMetrics": 1},
+		},
+		{
+			testName:                "ok for alerts",
+			method:                  http.MethodGet,
+			requestPath:             "/alerts?resolved=true",
+			requestContentType:      jsonContentType,
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{"getAlerts": 1},
+		},
+		{
+			testName:                "ok for dashboard",
+			method:                  http.MethodGet,
+			requestPath:             "/dashboard/",
+			requestContentType:      "",
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{},
+		},
+		{
+			testName:                "ok for dashboard assets",
+			method:                  http.MethodGet,
+			requestPath:             "/dashboard/app.js",
+			requestContentType:      "",
+			expectedCode:            http.StatusOK,
+			expectedPathTimesCalled: map[string]int64{},
+		},
 	}
 	logging.Initialize("WARN")
 	logging.Initialize("ERROR")
@@ -295,7 +452,7 @@ func TestRouter(t *testing.T) {
 	handlers := NewMockHandlers()
 	encrypter := encrypt.New("test")
 
-	router := New(&handlers, encrypter, nil, nil)
+	router := New(&handlers, &handlers, &handlers, encrypter, nil, nil, nil, nil, nil, nil)
 	srv := httptest.NewServer(router)
 	defer srv.Close()
 
@@ -319,3 +476,110 @@ func TestRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestDashboardGzip(t *testing.T) {
+	handlers := NewMockHandlers()
+	router := New(&handlers, &handlers, &handlers, encrypt.New("test"), nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/dashboard/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	compressed, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	if resp.ContentLength != -1 {
+		assert.Equal(t, int64(len(compressed)), resp.ContentLength, "Content-Length должен соответствовать сжатому телу")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	assert.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "<!DOCTYPE html>")
+}
+
+func TestTokenScopes(t *testing.T) {
+	store, err := tokens.New([]tokens.Token{
+		{Name: "reader", Token: "read-token", Scopes: []string{tokens.ScopeRead}},
+		{Name: "agent", Token: "write-token", Scopes: []string{tokens.ScopeWrite}},
+		{Name: "admin", Token: "admin-token", Scopes: []string{tokens.ScopeAdmin}},
+	})
+	assert.NoError(t, err)
+
+	handlers := NewMockHandlers()
+	srv := httptest.NewServer(New(&handlers, &handlers, &handlers, encrypt.New(""), nil, nil, nil, store, nil, nil))
+	defer srv.Close()
+
+	testCases := []struct {
+		method       string
+		path         string
+		contentType  string
+		token        string
+		expectedCode int
+	}{
+		{method: http.MethodPost, path: "/update/counter/test/1", contentType: "text/plain", token: "write-token", expectedCode: http.StatusOK},
+		{method: http.MethodPost, path: "/update/counter/test/1", contentType: "text/plain", token: "read-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodPost, path: "/updates/", contentType: "application/json", token: "", expectedCode: http.StatusUnauthorized},
+		{method: http.MethodGet, path: "/value/counter/test", contentType: "text/plain", token: "read-token", expectedCode: http.StatusOK},
+		{method: http.MethodGet, path: "/value/counter/test", contentType: "text/plain", token: "write-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodDelete, path: "/value/counter/test", contentType: "text/plain", token: "read-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodDelete, path: "/value/counter/test", contentType: "text/plain", token: "admin-token", expectedCode: http.StatusOK},
+		{method: http.MethodPost, path: "/reset/counter/test", contentType: "text/plain", token: "write-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodGet, path: "/api/metrics", contentType: "", token: "admin-token", expectedCode: http.StatusOK},
+		{method: http.MethodGet, path: "/ping", contentType: "", token: "", expectedCode: http.StatusOK},
+		{method: http.MethodGet, path: "/readyz", contentType: "", token: "", expectedCode: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path+" "+tc.token, func(t *testing.T) {
+			req := resty.New().R().SetHeader("Content-Type", tc.contentType)
+			if tc.token != "" {
+				req.SetHeader("Authorization", "Bearer "+tc.token)
+			}
+			resp, err := req.Execute(tc.method, srv.URL+tc.path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode())
+		})
+	}
+}
+
+func TestHealthWithoutSecurity(t *testing.T) {
+	ipChecker, err := ipchecker.New(nil, []string{"127.0.0.0/8"}, nil)
+	require.NoError(t, err)
+	handlers := NewMockHandlers()
+	srv := httptest.NewServer(New(&handlers, &handlers, &handlers, encrypt.New("key"), encrypt.NewReplayGuard(time.Minute, false), nil, ipChecker, nil, nil, nil))
+	defer srv.Close()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := resty.New().R().Get(srv.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode(), "проверки состояния не должны требовать IP из подсети и подписи")
+	}
+	resp, err := resty.New().R().Get(srv.URL + "/ping")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+}
+
+func TestRateLimit(t *testing.T) {
+	handlers := NewMockHandlers()
+	limiter := ratelimit.New(ratelimit.Limits{RequestRate: 1}, nil)
+	srv := httptest.NewServer(New(&handlers, &handlers, &handlers, encrypt.New(""), nil, nil, nil, nil, limiter, nil))
+	defer srv.Close()
+
+	post := func() int {
+		resp, err := resty.New().R().SetHeader("Content-Type", "text/plain").Post(srv.URL + "/update/counter/test/1")
+		assert.NoError(t, err)
+		return resp.StatusCode()
+	}
+	assert.Equal(t, http.StatusOK, post())
+	assert.Equal(t, http.StatusTooManyRequests, post())
+
+	resp, err := resty.New().R().SetHeader("Content-Type", "text/plain").Get(srv.URL + "/value/counter/test")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Чтение метрик не ограничивается")
+}
@@ -0,0 +1,135 @@
+package selfmetrics
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	statusResponseWriter struct {
+		http.ResponseWriter
+		status int
+	}
+
+	countingServerStream struct {
+		grpc.ServerStream
+		received int64
+		sent     int64
+	}
+)
+
+// Параметр маршрута chi вместе с регулярным выражением
+var routeParam = regexp.MustCompile(`\{([^:}]+)(:[^}]*)?\}`)
+
+// Переопределение метода WriteHeader для запоминания статуса ответа
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	w.ResponseWriter.WriteHeader(statusCode)
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+// Переопределение метода Write: ответ без WriteHeader имеет статус 200
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Переопределение метода RecvMsg для подсчета принятых сообщений
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received += 1
+	}
+	return err
+}
+
+// Переопределение метода SendMsg для подсчета отправленных сообщений
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent += 1
+	}
+	return err
+}
+
+// Имя маршрута chi для метрик: /update/counter/{name:[a-z]+} -> update_counter_name
+func routeName(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	name := Name(routeParam.ReplaceAllString(pattern, "$1"))
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+// Имя grpc метода для метрик: /proto.Metrics/PostMetrics -> Metrics_PostMetrics
+func methodName(fullMethod string) string {
+	return Name(fullMethod[strings.LastIndex(fullMethod, ".")+1:])
+}
+
+// Миддлваря учета HTTP запросов: число запросов по маршрутам и статусам и
+// гистограмма длительности по маршрутам. Должна подключаться к роутеру chi,
+// иначе маршрут запроса неизвестен
+func InstrumentHTTP(registry *Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			sw := &statusResponseWriter{ResponseWriter: res}
+			next.ServeHTTP(sw, req)
+
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			route := "unmatched"
+			if rctx := chi.RouteContext(req.Context()); rctx != nil {
+				route = routeName(rctx.RoutePattern())
+			}
+			registry.Add(Name("http_requests", route, strconv.Itoa(sw.status)), 1)
+			registry.Observe(Name("http_duration", route), time.Since(start))
+		})
+	}
+}
+
+// Interceptor учета grpc стримов: число стримов по методам и кодам ответа,
+// гистограмма длительности и число принятых и отправленных сообщений
+func InstrumentGRPCStream(registry *Registry) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		stream := &countingServerStream{ServerStream: ss}
+		err := handler(srv, stream)
+
+		method := methodName(info.FullMethod)
+		registry.Add(Name("grpc_requests", method, status.Code(err).String()), 1)
+		registry.Observe(Name("grpc_duration", method), time.Since(start))
+		registry.Add(Name("grpc_received", method), stream.received)
+		registry.Add(Name("grpc_sent", method), stream.sent)
+		return err
+	}
+}
+
+// Interceptor учета unary запросов grpc: число запросов по методам и кодам
+// ответа и гистограмма длительности
+func InstrumentGRPCUnary(registry *Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		method := methodName(info.FullMethod)
+		registry.Add(Name("grpc_requests", method, status.Code(err).String()), 1)
+		registry.Observe(Name("grpc_duration", method), time.Since(start))
+		return resp, err
+	}
+}
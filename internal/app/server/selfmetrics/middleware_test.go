@@ -0,0 +1,63 @@
+package selfmetrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInstrumentHTTP(t *testing.T) {
+	registry := New()
+	router := chi.NewRouter()
+	router.Use(InstrumentHTTP(registry))
+	router.Route("/update/", func(r chi.Router) {
+		r.Post("/counter/{name:[a-zA-Z0-9-_]+}/{value:[0-9]+}", func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(http.StatusOK)
+		})
+	})
+	router.Get("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("ok"))
+	})
+
+	for _, target := range []string{"/update/counter/test/1", "/update/counter/test/2", "/", "/unknown"} {
+		method := http.MethodPost
+		if target == "/" {
+			method = http.MethodGet
+		}
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+	}
+
+	assert.Equal(t, int64(2), registry.Counter("http_requests_update_counter_name_value_200"))
+	assert.Equal(t, int64(2), registry.Counter("http_duration_update_counter_name_value_count"))
+	assert.Equal(t, int64(1), registry.Counter("http_requests_root_200"))
+	assert.Equal(t, int64(1), registry.Counter("http_requests_unmatched_404"))
+}
+
+func TestInstrumentGRPCUnary(t *testing.T) {
+	registry := New()
+	interceptor := InstrumentGRPCUnary(registry)
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.Cluster/GetLocal"}
+
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	})
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.New("plain error")
+	})
+
+	assert.Equal(t, int64(1), registry.Counter("grpc_requests_Cluster_GetLocal_OK"))
+	assert.Equal(t, int64(1), registry.Counter("grpc_requests_Cluster_GetLocal_PermissionDenied"))
+	assert.Equal(t, int64(1), registry.Counter("grpc_requests_Cluster_GetLocal_Unknown"))
+	assert.Equal(t, int64(3), registry.Counter("grpc_duration_Cluster_GetLocal_count"))
+}
@@ -0,0 +1,209 @@
+// Module for server self-instrumentation metrics
+package selfmetrics
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+)
+
+// Префикс имен метрик сервера в хранилище. Имена с этим префиксом
+// зарезервированы, клиенты не могут записывать такие метрики
+const Prefix = "server_"
+
+// Границы бакетов гистограмм длительности в миллисекундах
+var Buckets = []int64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// Имя зарезервировано за метриками сервера
+func Reserved(id string) bool {
+	return strings.HasPrefix(id, Prefix)
+}
+
+// Имя метрики из частей. Символы, недопустимые в имени метрики, заменяются на '_'
+func Name(parts ...string) string {
+	var b strings.Builder
+	separated := true
+	for _, r := range strings.Join(parts, "_") {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			separated = false
+		} else if !separated {
+			b.WriteByte('_')
+			separated = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// Реестр метрик сервера. Counter'ы накапливаются и записываются в хранилище
+// приращениями с прошлой выгрузки, gauge'ы - последним значением
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	flushed  map[string]int64
+	gauges   map[string]float64
+}
+
+// Init registry
+func New() *Registry {
+	return &Registry{
+		counters: map[string]int64{},
+		flushed:  map[string]int64{},
+		gauges:   map[string]float64{},
+	}
+}
+
+// Увеличение counter'а. Имя указывается без префикса
+func (r *Registry) Add(name string, delta int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name] += delta
+}
+
+// Установка значения gauge. Имя указывается без префикса
+func (r *Registry) Set(name string, value float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[name] = value
+}
+
+// Учет длительности в гистограмме. Гистограмма хранится counter'ами:
+// <name>_bucket_<граница> - число наблюдений не дольше границы в миллисекундах,
+// <name>_bucket_inf и <name>_count - всего наблюдений, <name>_sum_us - сумма в микросекундах
+func (r *Registry) Observe(name string, duration time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, bucket := range Buckets {
+		if duration <= time.Duration(bucket)*time.Millisecond {
+			r.counters[name+"_bucket_"+strconv.FormatInt(bucket, 10)] += 1
+		}
+	}
+	r.counters[name+"_bucket_inf"] += 1
+	r.counters[name+"_count"] += 1
+	r.counters[name+"_sum_us"] += duration.Microseconds()
+}
+
+// Текущее значение counter'а с момента запуска сервера
+func (r *Registry) Counter(name string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+// Метрики для записи в хранилище: приращения counter'ов с прошлого вызова и
+// значения gauge'ов. Counter'ы без приращения не попадают в выгрузку
+func (r *Registry) Collect() []metrics.Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]metrics.Metric, 0, len(r.counters)+len(r.gauges))
+	for name, total := range r.counters {
+		delta := total - r.flushed[name]
+		if delta == 0 {
+			continue
+		}
+		r.flushed[name] = total
+		res = append(res, metrics.Metric{ID: Prefix + name, MType: "counter", Delta: &delta})
+	}
+	for name, value := range r.gauges {
+		value := value
+		res = append(res, metrics.Metric{ID: Prefix + name, MType: "gauge", Value: &value})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Откат выгрузки, которую не удалось записать: приращения попадут в следующую
+func (r *Registry) restore(metricList []metrics.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, metric := range metricList {
+		if metric.MType == "counter" {
+			name := metric.ID[len(Prefix):]
+			r.flushed[name] -= *metric.Delta
+		}
+	}
+}
+
+// Воркер, который периодически записывает метрики сервера в хранилище
+type Reporter struct {
+	intervalSec int64
+	registry    *Registry
+	storage     Storage
+}
+
+// Init reporter
+func NewReporter(intervalSec int64, registry *Registry, storage Storage) *Reporter {
+	return &Reporter{
+		intervalSec: intervalSec,
+		registry:    registry,
+		storage:     storage,
+	}
+}
+
+// Число метрик в хранилище по типам
+func (rp *Reporter) updateCardinality(ctx context.Context) {
+	metricList, err := rp.storage.ExtractMetrics(ctx)
+	if err != nil {
+		// ошибка уже учтена в метриках хранилища, кардинальность обновится при следующей выгрузке
+		return
+	}
+	var gauges, counters int
+	for _, metric := range metricList {
+		if metric.MType == "gauge" {
+			gauges += 1
+		} else {
+			counters += 1
+		}
+	}
+	rp.registry.Set("cardinality_gauge", float64(gauges))
+	rp.registry.Set("cardinality_counter", float64(counters))
+	rp.registry.Set("cardinality", float64(gauges+counters))
+}
+
+// Запись накопленных метрик сервера в хранилище
+func (rp *Reporter) Flush(ctx context.Context) error {
+	rp.updateCardinality(ctx)
+	metricList := rp.registry.Collect()
+	if len(metricList) == 0 {
+		return nil
+	}
+	if err := rp.storage.SaveMetrics(ctx, metricList); err != nil {
+		rp.registry.restore(metricList)
+		return err
+	}
+	return nil
+}
+
+// Run reporter
+func (rp *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(rp.intervalSec) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Logger.Info("Self metrics reporter shutdown")
+			return
+		case <-ticker.C:
+		}
+		if err := rp.Flush(ctx); err != nil {
+			logging.Logger.Warnf("Can't save self metrics: %s", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package selfmetrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+)
+
+type failingStorage struct{}
+
+func (failingStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	return errors.New("unavailable")
+}
+
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	storage := memstorage.New()
+	storage.Initialize(ctx)
+
+	registry := New()
+	registry.Add("requests", 2)
+	registry.Set("snapshot_size_bytes", 5)
+	reporter := NewReporter(1, registry, storage)
+	require.NoError(t, reporter.Flush(ctx))
+
+	registry.Add("requests", 3)
+	require.NoError(t, reporter.Flush(ctx))
+	require.NoError(t, reporter.Flush(ctx), "повторная выгрузка без изменений не должна менять counter")
+
+	counter := metrics.Metric{ID: "server_requests", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &counter))
+	assert.Equal(t, int64(5), *counter.Delta)
+	gauge := metrics.Metric{ID: "server_snapshot_size_bytes", MType: "gauge"}
+	require.NoError(t, storage.GetMetric(ctx, &gauge))
+	assert.Equal(t, 5.0, *gauge.Value)
+	cardinality := metrics.Metric{ID: "server_cardinality", MType: "gauge"}
+	require.NoError(t, storage.GetMetric(ctx, &cardinality))
+	assert.Equal(t, 5.0, *cardinality.Value, "кардинальность считается по хранилищу на момент выгрузки")
+	assert.Equal(t, int64(5), registry.Counter("requests"))
+}
+
+func TestFlushError(t *testing.T) {
+	ctx := context.Background()
+	registry := New()
+	registry.Add("requests", 2)
+	assert.Error(t, NewReporter(1, registry, failingStorage{}).Flush(ctx))
+
+	registry.Add("requests", 1)
+	metricList := registry.Collect()
+	require.Len(t, metricList, 1)
+	assert.Equal(t, int64(3), *metricList[0].Delta, "неудачная выгрузка должна попасть в следующую")
+}
+
+func TestObserve(t *testing.T) {
+	registry := New()
+	registry.Observe("duration", 3*time.Millisecond)
+	registry.Observe("duration", 300*time.Millisecond)
+	registry.Observe("duration", 10*time.Second)
+
+	assert.Equal(t, int64(0), registry.Counter("duration_bucket_1"))
+	assert.Equal(t, int64(1), registry.Counter("duration_bucket_5"))
+	assert.Equal(t, int64(2), registry.Counter("duration_bucket_500"))
+	assert.Equal(t, int64(2), registry.Counter("duration_bucket_5000"))
+	assert.Equal(t, int64(3), registry.Counter("duration_bucket_inf"))
+	assert.Equal(t, int64(3), registry.Counter("duration_count"))
+	assert.Equal(t, int64(10303000), registry.Counter("duration_sum_us"))
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "http_requests_update_counter_200", Name("http_requests", "/update//counter/", "200"))
+	assert.Equal(t, "grpc_requests_Metrics_PostMetrics_OK", Name("grpc_requests", "Metrics/PostMetrics", "OK"))
+	assert.True(t, Reserved("server_cardinality"))
+	assert.False(t, Reserved("servers"))
+}
@@ -0,0 +1,143 @@
+package selfmetrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+var errReplaceUnsupported = errors.New("storage can't replace its content")
+
+// Хранилище, которое учитывает длительность и ошибки операций в метриках сервера
+type InstrumentedStorage struct {
+	MetricStorage
+	registry *Registry
+}
+
+// Init instrumented storage
+func NewStorage(storage MetricStorage, registry *Registry) *InstrumentedStorage {
+	return &InstrumentedStorage{MetricStorage: storage, registry: registry}
+}
+
+// Учет операции. Ошибки из-за запроса клиента не считаются ошибками хранилища
+func (s *InstrumentedStorage) observe(op string, start time.Time, err error) {
+	s.registry.Observe(Name("storage_duration", op), time.Since(start))
+	if err == nil ||
+		errors.Is(err, storageerrors.ErrNotFound) ||
+		errors.Is(err, storageerrors.ErrInvalidMetric) ||
+		errors.Is(err, storageerrors.ErrTypeMismatch) {
+		return
+	}
+	s.registry.Add(Name("storage_errors", op), 1)
+}
+
+// Выгрузка всех метрик
+func (s *InstrumentedStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
+	start := time.Now()
+	metricList, err := s.MetricStorage.ExtractMetrics(ctx)
+	s.observe("extract_metrics", start, err)
+	return metricList, err
+}
+
+// Сохранение метрик
+func (s *InstrumentedStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	start := time.Now()
+	err := s.MetricStorage.SaveMetrics(ctx, metricList)
+	s.observe("save_metrics", start, err)
+	return err
+}
+
+// Однократное сохранение пачки
+func (s *InstrumentedStorage) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	start := time.Now()
+	applied, err := s.MetricStorage.SaveBatch(ctx, batch, metricList)
+	s.observe("save_batch", start, err)
+	return applied, err
+}
+
+// Получение метрики
+func (s *InstrumentedStorage) GetMetric(ctx context.Context, metric *metrics.Metric) error {
+	start := time.Now()
+	err := s.MetricStorage.GetMetric(ctx, metric)
+	s.observe("get_metric", start, err)
+	return err
+}
+
+// Удаление метрики
+func (s *InstrumentedStorage) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	start := time.Now()
+	err := s.MetricStorage.DeleteMetric(ctx, metric)
+	s.observe("delete_metric", start, err)
+	return err
+}
+
+// Удаление метрик по шаблону
+func (s *InstrumentedStorage) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	start := time.Now()
+	deleted, err := s.MetricStorage.DeleteMetrics(ctx, mType, pattern)
+	s.observe("delete_metrics", start, err)
+	return deleted, err
+}
+
+// Сброс counter'а
+func (s *InstrumentedStorage) ResetCounter(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.MetricStorage.ResetCounter(ctx, id)
+	s.observe("reset_counter", start, err)
+	return err
+}
+
+// Скорость роста counter'а
+func (s *InstrumentedStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	start := time.Now()
+	rate, err := s.MetricStorage.CounterRate(ctx, id)
+	s.observe("counter_rate", start, err)
+	return rate, err
+}
+
+// Список метрик по запросу
+func (s *InstrumentedStorage) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	start := time.Now()
+	metricList, err := s.MetricStorage.ListMetrics(ctx, query)
+	s.observe("list_metrics", start, err)
+	return metricList, err
+}
+
+// Удаление устаревших метрик
+func (s *InstrumentedStorage) EvictMetrics(ctx context.Context, policy retention.Policy, now time.Time) (int, error) {
+	start := time.Now()
+	evicted, err := s.MetricStorage.EvictMetrics(ctx, policy, now)
+	s.observe("evict_metrics", start, err)
+	return evicted, err
+}
+
+// Замена всего содержимого хранилища
+func (s *InstrumentedStorage) ReplaceMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	storage, ok := s.MetricStorage.(replacingStorage)
+	if !ok {
+		return errReplaceUnsupported
+	}
+	start := time.Now()
+	err := storage.ReplaceMetrics(ctx, metricList)
+	s.observe("replace_metrics", start, err)
+	return err
+}
+
+// Проверка доступности хранилища
+func (s *InstrumentedStorage) Ping(ctx context.Context) bool {
+	if storage, ok := s.MetricStorage.(externalStorage); ok {
+		return storage.Ping(ctx)
+	}
+	return true
+}
+
+// Закрытие хранилища
+func (s *InstrumentedStorage) Close() {
+	if storage, ok := s.MetricStorage.(externalStorage); ok {
+		storage.Close()
+	}
+}
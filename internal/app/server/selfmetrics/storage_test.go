@@ -0,0 +1,30 @@
+package selfmetrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
+)
+
+func TestInstrumentedStorage(t *testing.T) {
+	ctx := context.Background()
+	registry := New()
+	storage := NewStorage(memstorage.New(), registry)
+	require.NoError(t, storage.Initialize(ctx))
+
+	delta := int64(1)
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{{ID: "test", MType: "counter", Delta: &delta}}))
+	assert.Error(t, storage.GetMetric(ctx, &metrics.Metric{ID: "unknown", MType: "gauge"}))
+	assert.Error(t, storage.SaveMetrics(ctx, []metrics.Metric{{ID: "test", MType: "counter"}}))
+	assert.True(t, storage.Ping(ctx))
+
+	assert.Equal(t, int64(2), registry.Counter("storage_duration_save_metrics_count"))
+	assert.Equal(t, int64(1), registry.Counter("storage_duration_get_metric_count"))
+	assert.Equal(t, int64(0), registry.Counter("storage_errors_get_metric"), "не найденная метрика не ошибка хранилища")
+	assert.Equal(t, int64(0), registry.Counter("storage_errors_save_metrics"), "невалидная метрика не ошибка хранилища")
+}
@@ -3,6 +3,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"os/signal"
@@ -12,43 +13,169 @@ import (
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
+	"github.com/ry461ch/metric-collector/internal/app/agent"
+	"github.com/ry461ch/metric-collector/internal/app/agent/sender"
+	"github.com/ry461ch/metric-collector/internal/app/server/cluster"
+	"github.com/ry461ch/metric-collector/internal/app/server/crontasks/alerting"
+	"github.com/ry461ch/metric-collector/internal/app/server/crontasks/janitor"
+	"github.com/ry461ch/metric-collector/internal/app/server/crontasks/recording"
 	"github.com/ry461ch/metric-collector/internal/app/server/crontasks/snapshotmaker"
 	metricsgrpc "github.com/ry461ch/metric-collector/internal/app/server/grpc"
 	"github.com/ry461ch/metric-collector/internal/app/server/handlers"
+	"github.com/ry461ch/metric-collector/internal/app/server/health"
+	"github.com/ry461ch/metric-collector/internal/app/server/notifier"
+	"github.com/ry461ch/metric-collector/internal/app/server/relay"
+	"github.com/ry461ch/metric-collector/internal/app/server/replication"
 	"github.com/ry461ch/metric-collector/internal/app/server/router"
+	"github.com/ry461ch/metric-collector/internal/app/server/selfmetrics"
+	"github.com/ry461ch/metric-collector/internal/audit"
+	agentconfig "github.com/ry461ch/metric-collector/internal/config/agent"
 	config "github.com/ry461ch/metric-collector/internal/config/server"
 	"github.com/ry461ch/metric-collector/internal/fileworker"
 	pb "github.com/ry461ch/metric-collector/internal/proto"
+	"github.com/ry461ch/metric-collector/internal/rules"
 	memstorage "github.com/ry461ch/metric-collector/internal/storage/memory"
 	pgstorage "github.com/ry461ch/metric-collector/internal/storage/postgres"
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	encryptmiddleware "github.com/ry461ch/metric-collector/pkg/encrypt/middleware"
 	"github.com/ry461ch/metric-collector/pkg/ipchecker"
 	ipcheckermiddleware "github.com/ry461ch/metric-collector/pkg/ipchecker/middleware"
 	"github.com/ry461ch/metric-collector/pkg/logging"
 	"github.com/ry461ch/metric-collector/pkg/logging/middleware"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	ratelimitmiddleware "github.com/ry461ch/metric-collector/pkg/ratelimit/middleware"
 	"github.com/ry461ch/metric-collector/pkg/rsa"
 	rsamiddleware "github.com/ry461ch/metric-collector/pkg/rsa/middleware"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+	tokensmiddleware "github.com/ry461ch/metric-collector/pkg/tokens/middleware"
 )
 
+// Период обновления статуса grpc.health.v1
+const healthInterval = 5 * time.Second
+
 // Сервер для сбора и сохранения метрик
 type Server struct {
 	cfg           *config.Config
 	metricStorage Storage
 	fileWorker    *fileworker.FileWorker
 	snapshotMaker *snapshotmaker.SnapshotMaker
+	janitor       *janitor.Janitor
+	alerting      *alerting.Engine
+	recorder      *recording.Recorder
+	notifier      *notifier.Notifier
+	relay         *relay.Relay
+	upstreamRSA   *rsa.RsaEncrypter
+	replica       *replication.Node
+	cluster       *cluster.Cluster
+	replicaRSA    *rsa.RsaEncrypter
 	server        *http.Server
 	rsaDecrypter  *rsa.RsaDecrypter
 	grpcServer    *metricsgrpc.MetricsGRPCServer
 	ipChecker     *ipchecker.IPChecker
+	tokenStore    *tokens.Store
+	replayGuard   *encrypt.ReplayGuard
+	limiter       *ratelimit.Limiter
+	registry      *selfmetrics.Registry
+	selfMetrics   *selfmetrics.Reporter
+	auditLog      *audit.Log
+	health        *health.Checker
 }
 
 func getStorage(cfg *config.Config) Storage {
+	rateWindow := time.Duration(cfg.RateWindow) * time.Second
+	dedupWindow := time.Duration(cfg.DedupWindow) * time.Second
 	if cfg.DBDsn != "" {
-		return pgstorage.New(cfg.DBDsn)
+		storage := pgstorage.New(cfg.DBDsn)
+		storage.SetRateWindow(rateWindow)
+		storage.SetDedupWindow(dedupWindow)
+		return storage
 	} else {
-		return memstorage.New()
+		storage := memstorage.New()
+		storage.SetRateWindow(rateWindow)
+		storage.SetDedupWindow(dedupWindow)
+		return storage
+	}
+}
+
+// Создание релея, который пересылает метрики на вышестоящий сервер тем же
+// отправщиком, что и агент
+func newRelay(cfg *config.Config, metricStorage Storage, localIP string) (*relay.Relay, *rsa.RsaEncrypter) {
+	upstreamCfg := &agentconfig.Config{
+		Addr:      cfg.Upstream,
+		SecretKey: cfg.UpstreamKey,
+		CryptoKey: cfg.UpstreamCrypto,
+		UseGRPC:   cfg.UpstreamGRPC != "",
+		GRPCAddr:  cfg.UpstreamGRPC,
+		Token:     cfg.UpstreamToken,
+	}
+	var encrypter *encrypt.Encrypter
+	if upstreamCfg.SecretKey != "" {
+		encrypter = encrypt.New(upstreamCfg.SecretKey)
+	}
+	var rsaEncrypter *rsa.RsaEncrypter
+	if upstreamCfg.CryptoKey != "" {
+		rsaEncrypter = rsa.NewEncrypter(upstreamCfg.CryptoKey)
+	}
+	upstreamSender := sender.New(encrypter, rsaEncrypter, upstreamCfg, localIP)
+	return relay.New(cfg.ForwardInterval, cfg.SpoolPath, metricStorage, upstreamSender), rsaEncrypter
+}
+
+// Создание узла репликации. Подключения к follower'ам проходят через те же
+// interceptor'ы, что и у агента
+func newReplica(cfg *config.Config, metricStorage Storage, localIP string) (*replication.Node, *rsa.RsaEncrypter) {
+	var rsaEncrypter *rsa.RsaEncrypter
+	var interceptors []grpc.StreamClientInterceptor
+	var unaryInterceptors []grpc.UnaryClientInterceptor
+	if localIP != "" {
+		interceptors = append(interceptors, ipcheckermiddleware.SetIPGRPCClientStreamInterceptor(localIP))
+		unaryInterceptors = append(unaryInterceptors, ipcheckermiddleware.SetIPGRPCClientUnaryInterceptor(localIP))
+	}
+	if cfg.PeerToken != "" {
+		interceptors = append(interceptors, tokensmiddleware.SetTokenGRPCClientStreamInterceptor(cfg.PeerToken))
+		unaryInterceptors = append(unaryInterceptors, tokensmiddleware.SetTokenGRPCClientUnaryInterceptor(cfg.PeerToken))
+	}
+	if cfg.SecretKey != "" {
+		interceptors = append(interceptors, encryptmiddleware.SignStreamClientInterceptor(encrypt.New(cfg.SecretKey)))
+		unaryInterceptors = append(unaryInterceptors, encryptmiddleware.SignUnaryClientInterceptor(encrypt.New(cfg.SecretKey)))
+	}
+	if cfg.ReplicaCrypto != "" {
+		rsaEncrypter = rsa.NewEncrypter(cfg.ReplicaCrypto)
+		interceptors = append(interceptors, rsamiddleware.EncryptStreamClientInterceptor(rsaEncrypter))
+	}
+	dial := func(addr string) (*grpc.ClientConn, error) {
+		return grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithChainStreamInterceptor(interceptors...), grpc.WithChainUnaryInterceptor(unaryInterceptors...))
+	}
+	node, err := replication.New(cfg.ReplicaRole, cfg.Followers, cfg.ReplicaLogSize, metricStorage, dial)
+	if err != nil {
+		logging.Logger.Fatalf("Can't configure replication: %s", err)
+	}
+	return node, rsaEncrypter
+}
+
+// Создание узла кластера. Запросы к остальным узлам unary и проходят те же
+// проверки, что и запросы агентов: X-Real-IP, токен и подпись
+func newCluster(cfg *config.Config, metricStorage Storage, localIP string) *cluster.Cluster {
+	var interceptors []grpc.UnaryClientInterceptor
+	if localIP != "" {
+		interceptors = append(interceptors, ipcheckermiddleware.SetIPGRPCClientUnaryInterceptor(localIP))
+	}
+	if cfg.PeerToken != "" {
+		interceptors = append(interceptors, tokensmiddleware.SetTokenGRPCClientUnaryInterceptor(cfg.PeerToken))
+	}
+	if cfg.SecretKey != "" {
+		interceptors = append(interceptors, encryptmiddleware.SignUnaryClientInterceptor(encrypt.New(cfg.SecretKey)))
+	}
+	dial := func(addr string) (*grpc.ClientConn, error) {
+		return grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithChainUnaryInterceptor(interceptors...))
+	}
+	node, err := cluster.New(cfg.ClusterSelf, cfg.ClusterNodes, metricStorage, dial)
+	if err != nil {
+		logging.Logger.Fatalf("Can't configure cluster: %s", err)
 	}
+	return node
 }
 
 // Init server instance
@@ -61,28 +188,166 @@ func New(cfg *config.Config) *Server {
 	}
 
 	var ipChecker *ipchecker.IPChecker
-	if cfg.TrustedSubnet != "" {
-		ipChecker = ipchecker.New(cfg.TrustedSubnet)
+	if len(cfg.TrustedSubnets) > 0 || len(cfg.DeniedSubnets) > 0 {
+		var err error
+		ipChecker, err = ipchecker.New(cfg.TrustedSubnets, cfg.DeniedSubnets, cfg.TrustedProxies)
+		if err != nil {
+			logging.Logger.Fatalf("Can't configure trusted subnets: %s", err)
+		}
+	}
+
+	var tokenStore *tokens.Store
+	if cfg.TokensFile != "" {
+		var err error
+		tokenStore, err = tokens.Load(cfg.TokensFile)
+		if err != nil {
+			logging.Logger.Fatalf("Can't load tokens file: %s", err)
+		}
+	}
+
+	ruleFile := &rules.File{}
+	if cfg.RulesFile != "" {
+		var err error
+		ruleFile, err = rules.Load(cfg.RulesFile)
+		if err != nil {
+			logging.Logger.Fatalf("Can't load rules file: %s", err)
+		}
 	}
 
 	// initialize storage
-	metricStorage := getStorage(cfg)
+	registry := selfmetrics.New()
+	var metricStorage Storage = selfmetrics.NewStorage(getStorage(cfg), registry)
 	fileWorker := fileworker.New(cfg.FileStoragePath, metricStorage)
-	handleService := handlers.New(cfg, metricStorage, fileWorker)
-	handler := router.New(handleService, encrypt.New(cfg.SecretKey), rsaDecrypter, ipChecker)
-	snapshotMaker := snapshotmaker.New(cfg.StoreInterval, fileWorker)
+	// в режиме релея метрики от клиентов проходят через релей, а восстановление
+	// из файла и фоновые задачи работают с локальным хранилищем напрямую
+	var acceptingStorage Storage = metricStorage
+	var localIP string
+	if cfg.IsRelay() || cfg.ReplicaRole != "" || len(cfg.ClusterNodes) > 0 {
+		localIP = agent.GetLocalIP()
+	}
+	var replica *replication.Node
+	var replicaRSA *rsa.RsaEncrypter
+	if cfg.ReplicaRole != "" {
+		replica, replicaRSA = newReplica(cfg, metricStorage, localIP)
+		acceptingStorage = replica
+	}
+	var clusterNode *cluster.Cluster
+	if len(cfg.ClusterNodes) > 0 {
+		clusterNode = newCluster(cfg, acceptingStorage, localIP)
+		acceptingStorage = clusterNode
+	}
+	var metricRelay *relay.Relay
+	var upstreamRSA *rsa.RsaEncrypter
+	if cfg.IsRelay() {
+		metricRelay, upstreamRSA = newRelay(cfg, acceptingStorage, localIP)
+		acceptingStorage = metricRelay
+	}
+	var alertNotifier *notifier.Notifier
+	var engineNotifier alerting.Notifier
+	if len(cfg.Webhooks) > 0 {
+		alertNotifier = notifier.New(cfg)
+		engineNotifier = alertNotifier
+	}
+	// фоновые задачи работают с метриками через кластер: правила видят метрики
+	// всех узлов, а производные метрики записываются узлу-владельцу
+	var sharedStorage Storage = metricStorage
+	if clusterNode != nil {
+		sharedStorage = clusterNode
+	}
+	alertingEngine := alerting.New(cfg.RulesInterval, ruleFile.Alerts, sharedStorage, engineNotifier)
+	recorder := recording.New(cfg.RulesInterval, ruleFile.Records, sharedStorage)
+	if clusterNode != nil {
+		alertingEngine.SetOwner(clusterNode.Owns)
+		recorder.SetOwner(clusterNode.Owns)
+	}
+	handleService := handlers.New(cfg, acceptingStorage, fileWorker)
+	grpcServer := metricsgrpc.New(cfg, acceptingStorage, fileWorker, alertingEngine)
+	handleService.SetIPChecker(ipChecker)
+	grpcServer.SetIPChecker(ipChecker)
+	var auditLog *audit.Log
+	if cfg.AuditFile != "" {
+		var err error
+		auditLog, err = audit.Open(cfg.AuditFile, cfg.AuditMaxSize, cfg.AuditMaxFiles)
+		if err != nil {
+			logging.Logger.Fatalf("Can't open audit log: %s", err)
+		}
+		auditor := audit.New(auditLog, ipChecker)
+		handleService.SetAuditor(auditor)
+		grpcServer.SetAuditor(auditor)
+	}
+	var replayGuard *encrypt.ReplayGuard
+	if cfg.SecretKey != "" {
+		replayGuard = encrypt.NewReplayGuard(time.Duration(cfg.SignWindow)*time.Second, cfg.AllowLegacySign)
+	}
+	var limiter *ratelimit.Limiter
+	if cfg.AgentLimits().Enabled() {
+		limiter = ratelimit.New(cfg.AgentLimits(), func(reason string) {
+			registry.Add("ratelimit_rejected_"+reason, 1)
+		})
+	}
+	handleService.SetLimiter(limiter)
+	grpcServer.SetLimiter(limiter)
+	checker := health.New("storage", "snapshot", "grpc")
+	checker.AddCheck("storage", func(ctx context.Context) error {
+		if externalStorage, ok := metricStorage.(ExternalStorage); ok && !externalStorage.Ping(ctx) {
+			return errors.New("storage is not available")
+		}
+		return nil
+	})
+	handler := router.New(handleService, handlers.NewAlertHandlers(alertingEngine), handlers.NewHealthHandlers(checker), encrypt.New(cfg.SecretKey), replayGuard, rsaDecrypter, ipChecker, tokenStore, limiter, registry)
+	snapshotMaker := snapshotmaker.New(cfg.StoreInterval, fileWorker, registry)
+	metricJanitor := janitor.New(cfg.JanitorInterval, cfg.Retention, sharedStorage)
+	metricJanitor.SetLimiter(limiter)
 	server := &http.Server{Addr: cfg.Addr.Host + ":" + strconv.FormatInt(cfg.Addr.Port, 10), Handler: handler}
-	grpcServer := metricsgrpc.New(cfg, metricStorage, fileWorker)
 
 	return &Server{
 		cfg:           cfg,
 		metricStorage: metricStorage,
 		fileWorker:    fileWorker,
 		snapshotMaker: snapshotMaker,
+		janitor:       metricJanitor,
+		alerting:      alertingEngine,
+		recorder:      recorder,
+		notifier:      alertNotifier,
+		relay:         metricRelay,
+		upstreamRSA:   upstreamRSA,
+		replica:       replica,
+		replicaRSA:    replicaRSA,
+		cluster:       clusterNode,
 		server:        server,
 		rsaDecrypter:  rsaDecrypter,
 		grpcServer:    grpcServer,
 		ipChecker:     ipChecker,
+		tokenStore:    tokenStore,
+		replayGuard:   replayGuard,
+		limiter:       limiter,
+		registry:      registry,
+		selfMetrics:   selfmetrics.NewReporter(cfg.SelfInterval, registry, sharedStorage),
+		auditLog:      auditLog,
+		health:        checker,
+	}
+}
+
+// Перечитывание RSA ключей по SIGHUP и при изменении файлов
+func (s *Server) watchKeys(ctx context.Context) {
+	interval := time.Duration(s.cfg.CryptoReload) * time.Second
+	onReload := func(name string) func(err error) {
+		return func(err error) {
+			if err != nil {
+				logging.Logger.Errorf("Can't reload %s: %s", name, err)
+				return
+			}
+			logging.Logger.Infof("Reloaded %s", name)
+		}
+	}
+	if s.rsaDecrypter != nil {
+		go s.rsaDecrypter.Watch(ctx, interval, onReload("private keys"))
+	}
+	if s.upstreamRSA != nil {
+		go s.upstreamRSA.Watch(ctx, interval, onReload("upstream public key"))
+	}
+	if s.replicaRSA != nil {
+		go s.replicaRSA.Watch(ctx, interval, onReload("replication public key"))
 	}
 }
 
@@ -92,6 +357,7 @@ func (s *Server) Run(ctx context.Context) {
 	defer stopCancel()
 
 	err := s.metricStorage.Initialize(stopCtx)
+	s.health.Set("storage", err)
 	if s.rsaDecrypter != nil {
 		err = s.rsaDecrypter.Initialize(stopCtx)
 		if err != nil {
@@ -100,6 +366,19 @@ func (s *Server) Run(ctx context.Context) {
 		}
 	}
 
+	if s.upstreamRSA != nil {
+		if rsaErr := s.upstreamRSA.Initialize(stopCtx); rsaErr != nil {
+			logging.Logger.Errorln("Can't parse upstream public key file")
+			return
+		}
+	}
+	if s.replicaRSA != nil {
+		if rsaErr := s.replicaRSA.Initialize(stopCtx); rsaErr != nil {
+			logging.Logger.Errorln("Can't parse replication public key file")
+			return
+		}
+	}
+
 	if err != nil {
 		logging.Logger.Warnln("Db wasn't initialized")
 	} else if externalStorage, ok := s.metricStorage.(ExternalStorage); ok {
@@ -107,7 +386,9 @@ func (s *Server) Run(ctx context.Context) {
 	}
 
 	if s.cfg.Restore && s.cfg.DBDsn == "" {
-		s.fileWorker.ExportFromFile(stopCtx)
+		s.health.Set("snapshot", s.fileWorker.ExportFromFile(stopCtx))
+	} else {
+		s.health.Set("snapshot", nil)
 	}
 
 	// run server
@@ -117,21 +398,51 @@ func (s *Server) Run(ctx context.Context) {
 	}()
 
 	// prepare grpc server
-	listen, err := net.Listen("tcp", ":3200")
+	listen, err := net.Listen("tcp", s.cfg.GRPCAddr)
 	if err != nil {
 		logging.Logger.Fatal(err)
 	}
+	s.health.Set("grpc", nil)
 
 	var interceptors []grpc.StreamServerInterceptor
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	interceptors = append(interceptors, selfmetrics.InstrumentGRPCStream(s.registry))
+	unaryInterceptors = append(unaryInterceptors, selfmetrics.InstrumentGRPCUnary(s.registry))
 	interceptors = append(interceptors, requestlogger.LoggingStreamServerInterceptor)
+	unaryInterceptors = append(unaryInterceptors, requestlogger.LoggingUnaryServerInterceptor)
 	if s.ipChecker != nil {
 		interceptors = append(interceptors, ipcheckermiddleware.CheckGRPCRequesterIP(s.ipChecker))
+		unaryInterceptors = append(unaryInterceptors, ipcheckermiddleware.CheckGRPCRequesterIPUnary(s.ipChecker))
+	}
+	if s.tokenStore != nil {
+		// методы репликации и кластера не перечислены и требуют права admin
+		interceptors = append(interceptors, tokensmiddleware.CheckGRPCToken(s.tokenStore, metricsgrpc.MethodScopes))
+		unaryInterceptors = append(unaryInterceptors, tokensmiddleware.CheckGRPCTokenUnary(s.tokenStore, metricsgrpc.MethodScopes))
+	}
+	if s.limiter != nil {
+		interceptors = append(interceptors, ratelimitmiddleware.LimitGRPCRequests(s.limiter, s.ipChecker, []string{pb.Metrics_PostMetrics_FullMethodName}))
 	}
 	if s.rsaDecrypter != nil {
-		interceptors = append(interceptors, rsamiddleware.DecryptStreamServerInterceptor(s.rsaDecrypter))
+		interceptors = append(interceptors, health.SkipStream(rsamiddleware.DecryptStreamServerInterceptor(s.rsaDecrypter)))
 	}
-	grpcServer := grpc.NewServer(grpc.ChainStreamInterceptor(interceptors...))
+	if s.cfg.SecretKey != "" {
+		// проверки состояния доступны без подписи и шифрования
+		interceptors = append(interceptors, health.SkipStream(encryptmiddleware.VerifyStreamServerInterceptor(encrypt.New(s.cfg.SecretKey), s.replayGuard)))
+		unaryInterceptors = append(unaryInterceptors, health.SkipUnary(encryptmiddleware.VerifyUnaryServerInterceptor(encrypt.New(s.cfg.SecretKey), s.replayGuard)))
+	}
+	grpcServer := grpc.NewServer(
+		grpc.ChainStreamInterceptor(interceptors...),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+	)
 	pb.RegisterMetricsServer(grpcServer, s.grpcServer)
+	healthpb.RegisterHealthServer(grpcServer, s.health.GRPCServer())
+	if s.cluster != nil {
+		defer s.cluster.Close()
+		pb.RegisterClusterServer(grpcServer, cluster.NewGRPCServer(s.cluster))
+	}
+	if s.replica != nil {
+		pb.RegisterReplicationServer(grpcServer, replication.NewGRPCServer(s.replica))
+	}
 
 	// run grpc server
 	go func() {
@@ -141,19 +452,64 @@ func (s *Server) Run(ctx context.Context) {
 	}()
 
 	// run crontasks
-	snapshotMakerCtx, snapshotMakerCtxCancel := context.WithCancel(stopCtx)
-	defer snapshotMakerCtxCancel()
+	crontasksCtx, crontasksCtxCancel := context.WithCancel(stopCtx)
+	defer crontasksCtxCancel()
+	s.watchKeys(crontasksCtx)
+	go s.health.Watch(crontasksCtx, healthInterval)
 	go func() {
 		if s.cfg.StoreInterval != int64(0) {
-			s.snapshotMaker.Run(snapshotMakerCtx)
+			s.snapshotMaker.Run(crontasksCtx)
+		}
+	}()
+	go func() {
+		if !s.cfg.Retention.IsEmpty() && s.cfg.JanitorInterval != int64(0) {
+			s.janitor.Run(crontasksCtx)
+		}
+	}()
+	go func() {
+		if s.cfg.RulesFile != "" && s.cfg.RulesInterval != int64(0) {
+			s.alerting.Run(crontasksCtx)
+		}
+	}()
+	go func() {
+		if s.cfg.RulesFile != "" && s.cfg.RulesInterval != int64(0) {
+			s.recorder.Run(crontasksCtx)
+		}
+	}()
+	go func() {
+		if s.notifier != nil {
+			s.notifier.Run(crontasksCtx)
+		}
+	}()
+	go func() {
+		if s.cfg.SelfInterval != int64(0) {
+			s.selfMetrics.Run(crontasksCtx)
+		}
+	}()
+
+	go func() {
+		if s.replica != nil {
+			s.replica.Run(crontasksCtx)
+		}
+	}()
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		if s.relay != nil {
+			s.relay.Run(crontasksCtx)
 		}
 	}()
 
 	<-stopCtx.Done()
+	s.health.Shutdown()
 	grpcServer.GracefulStop()
+	s.server.Shutdown(ctx)
+	<-relayDone
 	fileCtx, fileCtxCancel := context.WithTimeout(ctx, 1*time.Second)
 	s.fileWorker.ImportToFile(fileCtx)
 	fileCtxCancel()
-	s.server.Shutdown(ctx)
+	if s.auditLog != nil {
+		s.auditLog.Close()
+	}
 	logging.Logger.Infoln("Gracefull shutdown")
 }
@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+
+	"github.com/ry461ch/metric-collector/pkg/ipchecker"
+	ipcheckermiddleware "github.com/ry461ch/metric-collector/pkg/ipchecker/middleware"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+)
+
+// Запись операций в журнал с владельцем токена и адресом клиента из запроса
+type Auditor struct {
+	log       *Log
+	ipChecker *ipchecker.IPChecker
+}
+
+// Init auditor. ipChecker нужен для учета доверенных прокси, может быть nil
+func New(log *Log, ipChecker *ipchecker.IPChecker) *Auditor {
+	return &Auditor{log: log, ipChecker: ipChecker}
+}
+
+func (a *Auditor) append(ctx context.Context, entry Entry) {
+	if identity, ok := tokens.FromContext(ctx); ok {
+		entry.Identity = identity.Name
+	}
+	if err := a.log.Append(entry); err != nil {
+		logging.Logger.Errorf("Can't write audit record: %s", err)
+	}
+}
+
+// Запись операции, выполненной http запросом
+func (a *Auditor) RecordHTTP(req *http.Request, entry Entry) {
+	entry.Endpoint = req.Method + " " + req.URL.Path
+	if ip := a.ipChecker.ClientIP(req.RemoteAddr, req.Header.Get("X-Forwarded-For"), req.Header.Get("X-Real-IP")); ip != nil {
+		entry.SourceIP = ip.String()
+	}
+	a.append(req.Context(), entry)
+}
+
+// Запись операции, выполненной grpc запросом
+func (a *Auditor) RecordGRPC(ctx context.Context, entry Entry) {
+	entry.Endpoint, _ = grpc.Method(ctx)
+	if ip, ok := ipcheckermiddleware.GRPCClientIP(ctx, a.ipChecker); ok && ip != nil {
+		entry.SourceIP = ip.String()
+	}
+	a.append(ctx, entry)
+}
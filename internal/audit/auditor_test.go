@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/pkg/ipchecker"
+	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+)
+
+func TestRecordHTTP(t *testing.T) {
+	logging.Initialize("INFO")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, 0, 0)
+	require.NoError(t, err)
+	ipChecker, err := ipchecker.New(nil, nil, []string{"10.0.0.1"})
+	require.NoError(t, err)
+	auditor := New(log, ipChecker)
+
+	store, err := tokens.New([]tokens.Token{{Name: "agent-1", Token: "secret", Scopes: []string{tokens.ScopeWrite}}})
+	require.NoError(t, err)
+	identity, _ := store.Lookup("secret")
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.168.1.5")
+	req = req.WithContext(tokens.WithIdentity(req.Context(), identity))
+	auditor.RecordHTTP(req, NewEntry(ActionWrite, "PollCount"))
+	require.NoError(t, log.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var entry Entry
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(string(data))), &entry))
+	assert.Equal(t, "agent-1", entry.Identity)
+	assert.Equal(t, "192.168.1.5", entry.SourceIP)
+	assert.Equal(t, "POST /updates/", entry.Endpoint)
+	assert.Equal(t, []string{"PollCount"}, entry.IDs)
+	assert.Equal(t, 1, entry.Count)
+	assert.NotEmpty(t, entry.Hash)
+}
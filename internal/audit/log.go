@@ -0,0 +1,270 @@
+// Module for audit log of write and admin operations
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Действия, которые попадают в журнал
+const (
+	ActionWrite  = "write"  // запись метрик
+	ActionDelete = "delete" // удаление метрик
+	ActionReset  = "reset"  // сброс counter'а
+)
+
+// Максимальное число имен метрик в записи журнала, кол-во метрик записывается всегда
+const MaxIDs = 100
+
+// Запись журнала аудита. Hash - sha256 от записи с пустым Hash, в которой
+// PrevHash - хеш предыдущей записи, поэтому изменение или удаление записи
+// ломает цепочку
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Identity string    `json:"identity,omitempty"` // имя токена
+	SourceIP string    `json:"source_ip,omitempty"`
+	Endpoint string    `json:"endpoint"` // http метод и путь или полное имя grpc метода
+	Action   string    `json:"action"`
+	Count    int       `json:"count"`             // кол-во затронутых метрик
+	IDs      []string  `json:"ids,omitempty"`     // имена метрик, не больше MaxIDs
+	Pattern  string    `json:"pattern,omitempty"` // шаблон массового удаления
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// Запись об операции над метриками с именами ids
+func NewEntry(action string, ids ...string) Entry {
+	entry := Entry{Action: action, Count: len(ids)}
+	if len(ids) > MaxIDs {
+		ids = ids[:MaxIDs]
+	}
+	entry.IDs = append([]string(nil), ids...)
+	return entry
+}
+
+// Хеш записи вместе с хешем предыдущей записи
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Журнал аудита: файл в формате JSON lines, в который записи только
+// дописываются. При превышении maxSize файл переименовывается в path.1,
+// старые файлы сдвигаются, хранится не больше maxFiles старых файлов.
+// Цепочка хешей продолжается через ротацию
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	lastHash string
+}
+
+// Открытие журнала. Цепочка продолжается с последней записи существующего файла
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	for _, file := range []string{path, rotatedPath(path, 1)} {
+		hash, err := lastHash(file)
+		if err != nil {
+			return nil, err
+		}
+		if hash != "" {
+			l.lastHash = hash
+			break
+		}
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func rotatedPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// Хеш последней записи файла, пустой для отсутствующего или пустого файла
+func lastHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if last == nil {
+		return "", nil
+	}
+	var entry Entry
+	if err := json.Unmarshal(last, &entry); err != nil {
+		return "", fmt.Errorf("can't parse last audit record of %s: %w", path, err)
+	}
+	return entry.Hash, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Ротация файлов журнала: path.N-1 -> path.N, ..., path -> path.1
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(rotatedPath(l.path, l.maxFiles))
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(rotatedPath(l.path, n), rotatedPath(l.path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Добавление записи в журнал. Время, если не задано, и хеши заполняются журналом
+func (l *Log) Append(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.PrevHash = l.lastHash
+	hash, err := entry.hash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("can't rotate audit log: %w", err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	l.lastHash = hash
+	return nil
+}
+
+// Закрытие журнала
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Проверка цепочки хешей журнала вместе с файлами после ротации, от самого
+// старого к текущему. Возвращает кол-во проверенных записей. Первая запись
+// самого старого файла может ссылаться на запись из удаленного при ротации файла
+func Verify(path string) (int, error) {
+	var files []string
+	for n := 1; ; n++ {
+		if _, err := os.Stat(rotatedPath(path, n)); err != nil {
+			break
+		}
+		files = append([]string{rotatedPath(path, n)}, files...)
+	}
+	files = append(files, path)
+
+	count := 0
+	prevHash := ""
+	first := true
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return count, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var entry Entry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: invalid record: %w", name, line, err)
+			}
+			hash, err := entry.hash()
+			if err != nil {
+				file.Close()
+				return count, err
+			}
+			if hash != entry.Hash {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: record hash mismatch", name, line)
+			}
+			if !first && entry.PrevHash != prevHash {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: broken hash chain", name, line)
+			}
+			first = false
+			prevHash = entry.Hash
+			count++
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return count, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(NewEntry(ActionWrite, "PollCount", "Alloc")))
+	require.NoError(t, log.Append(NewEntry(ActionReset, "PollCount")))
+	require.NoError(t, log.Close())
+
+	// после перезапуска цепочка продолжается
+	log, err = Open(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(Entry{Action: ActionDelete, Count: 3, Pattern: "Host*"}))
+	require.NoError(t, log.Close())
+
+	count, err := Verify(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestTamper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, 0, 0)
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, log.Append(NewEntry(ActionWrite, id)))
+	}
+	require.NoError(t, log.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	changed := strings.Replace(lines[1], `"ids":["b"]`, `"ids":["x"]`, 1)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join([]string{lines[0], changed, lines[2]}, "\n")), 0600))
+	_, err = Verify(path)
+	assert.ErrorContains(t, err, "hash mismatch", "измененная запись должна обнаруживаться")
+
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join([]string{lines[0], lines[2]}, "\n")), 0600))
+	count, err := Verify(path)
+	assert.ErrorContains(t, err, "broken hash chain", "удаленная запись должна обнаруживаться")
+	assert.Equal(t, 1, count)
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, 300, 2)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Append(NewEntry(ActionWrite, "PollCount")))
+	}
+	require.NoError(t, log.Close())
+
+	assert.FileExists(t, path+".1")
+	assert.FileExists(t, path+".2")
+	assert.NoFileExists(t, path+".3", "старые файлы сверх maxFiles удаляются")
+
+	count, err := Verify(path)
+	require.NoError(t, err, "цепочка должна проходить через ротацию")
+	assert.Greater(t, count, 0)
+	assert.Less(t, count, 10)
+}
+
+func TestNewEntryLimitsIDs(t *testing.T) {
+	ids := make([]string, MaxIDs+5)
+	entry := NewEntry(ActionWrite, ids...)
+	assert.Equal(t, MaxIDs+5, entry.Count)
+	assert.Len(t, entry.IDs, MaxIDs)
+}
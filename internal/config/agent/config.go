@@ -21,14 +21,19 @@ type Config struct {
 	SecretKey         string             `short:"k" env:"KEY"`
 	RateLimit         int64              `short:"l" env:"RATE_LIMIT"`
 	CryptoKey         string             `long:"crypto-key" env:"CRYPTO_KEY" json:"crypto_key"`
+	CryptoReload      int64              `long:"crypto-key-reload" env:"CRYPTO_KEY_RELOAD" json:"crypto_key_reload"`
 	UseGRPC           bool               `long:"grpc" env:"USE_GRPC" json:"use_grpc"`
+	GRPCAddr          string             `long:"grpc-address" env:"GRPC_ADDRESS" json:"grpc_address"`
+	Token             string             `long:"token" env:"TOKEN"`
+	LegacySignature   bool               `long:"legacy-signature" env:"LEGACY_SIGNATURE" json:"legacy_signature"`
+	StatusAddr        string             `long:"status-address" env:"STATUS_ADDRESS" json:"status_address"`
 	Config            string             `long:"config" short:"c" env:"CONFIG"`
 }
 
 // Парсинг аргументов и переменных окружения для создания конфига агента
 func New() *Config {
 	addr := netaddr.NetAddress{Host: "localhost", Port: 8080}
-	cfg := &Config{ReportIntervalSec: 10, PollIntervalSec: 2, Addr: addr}
+	cfg := &Config{ReportIntervalSec: 10, PollIntervalSec: 2, Addr: addr, GRPCAddr: ":3200", CryptoReload: 30}
 
 	args := []string{}
 	for _, arg := range os.Args[1:] {
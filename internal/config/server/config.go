@@ -11,6 +11,8 @@ import (
 
 	"github.com/ry461ch/metric-collector/internal/config/helper"
 	"github.com/ry461ch/metric-collector/internal/models/netaddr"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
 )
 
 // Конфиг сервера
@@ -21,10 +23,52 @@ type Config struct {
 	StoreInterval   int64              `short:"i" env:"STORE_INTERVAL" json:"store_interval"`
 	FileStoragePath string             `short:"f" env:"FILE_STORAGE_PATH" json:"store_file"`
 	Restore         bool               `short:"r" env:"RESTORE" json:"restore"`
-	TrustedSubnet   string             `short:"t" env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedSubnets  []string           `short:"t" long:"trusted-subnet" env:"TRUSTED_SUBNET" env-delim:"," json:"trusted_subnet"`
+	DeniedSubnets   []string           `long:"denied-subnet" env:"DENIED_SUBNETS" env-delim:"," json:"denied_subnets"`
+	TrustedProxies  []string           `long:"trusted-proxy" env:"TRUSTED_PROXIES" env-delim:"," json:"trusted_proxies"`
 	SecretKey       string             `short:"k" env:"KEY"`
 	CryptoKey       string             `long:"crypto-key" env:"CRYPTO_KEY" json:"crypto_key"`
+	CryptoReload    int64              `long:"crypto-key-reload" env:"CRYPTO_KEY_RELOAD" json:"crypto_key_reload"`
 	Config          string             `long:"config" short:"c" env:"CONFIG"`
+	Retention       retention.Policy   `long:"retention" env:"RETENTION" json:"retention"`
+	JanitorInterval int64              `long:"janitor-interval" env:"JANITOR_INTERVAL" json:"janitor_interval"`
+	RateWindow      int64              `long:"rate-window" env:"RATE_WINDOW" json:"rate_window"`
+	RulesFile       string             `long:"rules-file" env:"RULES_FILE" json:"rules_file"`
+	RulesInterval   int64              `long:"rules-interval" env:"RULES_INTERVAL" json:"rules_interval"`
+	Webhooks        []string           `long:"webhook" env:"WEBHOOKS" json:"webhooks"`
+	WebhookKey      string             `long:"webhook-key" env:"WEBHOOK_KEY"`
+	NotifyGroupBy   []string           `long:"notify-group-by" env:"NOTIFY_GROUP_BY" json:"notify_group_by"`
+	NotifyGroupWait int64              `long:"notify-group-wait" env:"NOTIFY_GROUP_WAIT" json:"notify_group_wait"`
+	NotifyRepeat    int64              `long:"notify-repeat-interval" env:"NOTIFY_REPEAT_INTERVAL" json:"notify_repeat_interval"`
+	NotifyRetries   int                `long:"notify-retries" env:"NOTIFY_RETRIES" json:"notify_retries"`
+	GRPCAddr        string             `long:"grpc-address" env:"GRPC_ADDRESS" json:"grpc_address"`
+	Upstream        netaddr.NetAddress `long:"upstream" env:"UPSTREAM" json:"upstream"`
+	UpstreamGRPC    string             `long:"upstream-grpc" env:"UPSTREAM_GRPC" json:"upstream_grpc"`
+	UpstreamKey     string             `long:"upstream-key" env:"UPSTREAM_KEY"`
+	UpstreamCrypto  string             `long:"upstream-crypto-key" env:"UPSTREAM_CRYPTO_KEY" json:"upstream_crypto_key"`
+	ForwardInterval int64              `long:"forward-interval" env:"FORWARD_INTERVAL" json:"forward_interval"`
+	SpoolPath       string             `long:"spool-path" env:"SPOOL_PATH" json:"spool_path"`
+	ReplicaRole     string             `long:"replication-role" env:"REPLICATION_ROLE" json:"replication_role"`
+	Followers       []string           `long:"follower" env:"FOLLOWERS" json:"followers"`
+	ReplicaCrypto   string             `long:"replication-crypto-key" env:"REPLICATION_CRYPTO_KEY" json:"replication_crypto_key"`
+	ReplicaLogSize  int                `long:"replication-log-size" env:"REPLICATION_LOG_SIZE" json:"replication_log_size"`
+	ClusterSelf     string             `long:"cluster-self" env:"CLUSTER_SELF" json:"cluster_self"`
+	ClusterNodes    []string           `long:"cluster-node" env:"CLUSTER_NODES" json:"cluster_nodes"`
+	DedupWindow     int64              `long:"dedup-window" env:"DEDUP_WINDOW" json:"dedup_window"`
+	SignWindow      int64              `long:"signature-window" env:"SIGNATURE_WINDOW" json:"signature_window"`
+	AllowLegacySign bool               `long:"allow-legacy-signature" env:"ALLOW_LEGACY_SIGNATURE" json:"allow_legacy_signature"`
+	TokensFile      string             `long:"tokens-file" env:"TOKENS_FILE" json:"tokens_file"`
+	PeerToken       string             `long:"peer-token" env:"PEER_TOKEN"`
+	UpstreamToken   string             `long:"upstream-token" env:"UPSTREAM_TOKEN"`
+	RequestRate     float64            `long:"agent-request-rate" env:"AGENT_REQUEST_RATE" json:"agent_request_rate"`
+	RequestBurst    int                `long:"agent-request-burst" env:"AGENT_REQUEST_BURST" json:"agent_request_burst"`
+	MetricRate      float64            `long:"agent-metric-rate" env:"AGENT_METRIC_RATE" json:"agent_metric_rate"`
+	MetricBurst     int                `long:"agent-metric-burst" env:"AGENT_METRIC_BURST" json:"agent_metric_burst"`
+	MaxAgentMetrics int                `long:"agent-max-metrics" env:"AGENT_MAX_METRICS" json:"agent_max_metrics"`
+	SelfInterval    int64              `long:"self-metrics-interval" env:"SELF_METRICS_INTERVAL" json:"self_metrics_interval"`
+	AuditFile       string             `long:"audit-file" env:"AUDIT_FILE" json:"audit_file"`
+	AuditMaxSize    int64              `long:"audit-max-size" env:"AUDIT_MAX_SIZE" json:"audit_max_size"`
+	AuditMaxFiles   int                `long:"audit-max-files" env:"AUDIT_MAX_FILES" json:"audit_max_files"`
 }
 
 // Парсинг аргументов и переменных окружения для создания конфига сервера
@@ -33,6 +77,22 @@ func New() *Config {
 	cfg := &Config{
 		LogLevel:        "INFO",
 		StoreInterval:   10,
+		JanitorInterval: 60,
+		RulesInterval:   15,
+		RateWindow:      60,
+		NotifyGroupWait: 10,
+		NotifyRepeat:    3600,
+		NotifyRetries:   3,
+		GRPCAddr:        ":3200",
+		ForwardInterval: 10,
+		SpoolPath:       "/tmp/metrics-relay-spool.json",
+		ReplicaLogSize:  1024,
+		DedupWindow:     300,
+		SignWindow:      300,
+		CryptoReload:    30,
+		SelfInterval:    10,
+		AuditMaxSize:    100 * 1024 * 1024,
+		AuditMaxFiles:   10,
 		FileStoragePath: "/tmp/metrics-db.json",
 		Restore:         true,
 		Addr:            addr,
@@ -62,6 +122,11 @@ func New() *Config {
 	return cfg
 }
 
+// Сервер работает в режиме релея и пересылает метрики на вышестоящий сервер
+func (c *Config) IsRelay() bool {
+	return c.Upstream.Host != "" || c.UpstreamGRPC != ""
+}
+
 func parseArgs(cfg *Config, args []string) {
 	_, err := flags.ParseArgs(cfg, args)
 	if err != nil {
@@ -75,3 +140,14 @@ func parseEnv(cfg *Config) {
 		log.Fatalf("Can't parse env variables: %s", err)
 	}
 }
+
+// Лимиты на одного агента
+func (c *Config) AgentLimits() ratelimit.Limits {
+	return ratelimit.Limits{
+		RequestRate:  c.RequestRate,
+		RequestBurst: c.RequestBurst,
+		MetricRate:   c.MetricRate,
+		MetricBurst:  c.MetricBurst,
+		MaxMetricIDs: c.MaxAgentMetrics,
+	}
+}
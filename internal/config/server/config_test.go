@@ -14,3 +14,19 @@ func TestBase(t *testing.T) {
 	assert.Equal(t, cfg.LogLevel, "INFO")
 	assert.Equal(t, cfg.StoreInterval, int64(10))
 }
+
+func TestRetentionEnv(t *testing.T) {
+	t.Setenv("RETENTION", "gauge:Host*=1h,counter:*=24h")
+	cfg := New()
+	assert.Equal(t, "gauge:Host*=1h0m0s,counter:*=24h0m0s", cfg.Retention.String())
+	assert.Equal(t, int64(60), cfg.JanitorInterval)
+}
+
+func TestSubnetsEnv(t *testing.T) {
+	t.Setenv("TRUSTED_SUBNET", "10.0.0.0/8,2001:db8::/32")
+	t.Setenv("TRUSTED_PROXIES", "127.0.0.1")
+	cfg := New()
+	assert.Equal(t, []string{"10.0.0.0/8", "2001:db8::/32"}, cfg.TrustedSubnets)
+	assert.Equal(t, []string{"127.0.0.1"}, cfg.TrustedProxies)
+	assert.Empty(t, cfg.DeniedSubnets)
+}
@@ -88,3 +88,12 @@ func (fw *FileWorker) ImportToFile(ctx context.Context) error {
 
 	return nil
 }
+
+// Размер файла со слепком метрик
+func (fw *FileWorker) FileSize() (int64, error) {
+	info, err := os.Stat(fw.filePath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,29 @@
+// Module with alert model
+package alerts
+
+import "time"
+
+// Состояния алерта
+const (
+	StatePending  = "pending"  // условие выполняется, но меньше заданного времени
+	StateFiring   = "firing"   // условие выполняется дольше заданного времени
+	StateResolved = "resolved" // сработавший алерт перестал выполняться
+)
+
+// Алерт, порожденный правилом
+type Alert struct {
+	Name        string            `json:"name"`                                  // имя правила
+	Expr        string            `json:"expr"`                                  // условие правила
+	State       string            `json:"state" enums:"pending,firing,resolved"` // состояние алерта
+	Value       float64           `json:"value"`                                 // последнее вычисленное значение
+	ActiveAt    time.Time         `json:"active_at"`                             // с какого момента выполняется условие
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`                    // когда алерт перешел в firing
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`                 // когда алерт перешел в resolved
+	Labels      map[string]string `json:"labels,omitempty"`                      // метки из правила
+	Annotations map[string]string `json:"annotations,omitempty"`                 // описание из правила
+}
+
+// Алерт активен, если он в состоянии pending или firing
+func (a Alert) IsActive() bool {
+	return a.State == StatePending || a.State == StateFiring
+}
@@ -0,0 +1,13 @@
+package metrics
+
+import "time"
+
+// Сколько по умолчанию помнить примененные пачки для отсечения повторов
+const DefaultDedupWindow = 5 * time.Minute
+
+// Идентификатор пачки метрик. Агент присваивает каждой пачке уникальный BatchID
+// и повторяет его при переотправке, сервер применяет пачку с одним ключом только раз
+type BatchKey struct {
+	AgentID string
+	BatchID string
+}
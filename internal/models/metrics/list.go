@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Позиция в отсортированном списке метрик. Метрики сортируются побайтово по имени, затем по типу
+type Cursor struct {
+	ID    string `json:"id"`
+	MType string `json:"type"`
+}
+
+// Параметры выборки списка метрик
+type ListQuery struct {
+	MType  string  // фильтр по типу, пустой - любой тип
+	Prefix string  // фильтр по префиксу имени
+	Regex  string  // фильтр по регулярному выражению для имени
+	After  *Cursor // выдаются только метрики строго после курсора
+	Limit  int     // максимальное кол-во метрик, 0 - без ограничения
+}
+
+// Сравнение позиций метрик в отсортированном списке
+func CompareKeys(idA, typeA, idB, typeB string) int {
+	if c := strings.Compare(idA, idB); c != 0 {
+		return c
+	}
+	return strings.Compare(typeA, typeB)
+}
+
+// Курсор, указывающий на метрику
+func CursorOf(metric Metric) Cursor {
+	return Cursor{ID: metric.ID, MType: metric.MType}
+}
+
+// Непрозрачное строковое представление курсора для передачи клиенту
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Разбор курсора, полученного от клиента
+func DecodeCursor(s string) (Cursor, error) {
+	cursor := Cursor{}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return cursor, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
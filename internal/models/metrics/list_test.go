@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := CursorOf(Metric{ID: "Alloc", MType: "gauge"})
+	decoded, err := DecodeCursor(cursor.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	_, err = DecodeCursor("not a cursor")
+	assert.Error(t, err)
+	_, err = DecodeCursor(Cursor{}.Encode())
+	assert.Error(t, err, "курсор без имени метрики невалиден")
+}
+
+func TestCompareKeys(t *testing.T) {
+	assert.Equal(t, -1, CompareKeys("Alloc", "gauge", "Buck", "counter"))
+	assert.Equal(t, -1, CompareKeys("Alloc", "counter", "Alloc", "gauge"))
+	assert.Equal(t, 0, CompareKeys("Alloc", "gauge", "Alloc", "gauge"))
+	assert.Equal(t, 1, CompareKeys("alloc", "gauge", "Alloc", "gauge"), "сортировка побайтовая")
+}
@@ -6,4 +6,5 @@ type Metric struct {
 	MType string   `json:"type" enums:"counter,gauge"` // параметр, принимающий значение gauge или counter
 	Delta *int64   `json:"delta,omitempty"`            // значение метрики в случае передачи counter
 	Value *float64 `json:"value,omitempty"`            // значение метрики в случае передачи gauge
+	Rate  *float64 `json:"rate,omitempty"`             // скорость роста counter в секунду, заполняется только в ответах сервера
 }
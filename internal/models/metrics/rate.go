@@ -0,0 +1,49 @@
+package metrics
+
+import "time"
+
+// Окно для вычисления скорости роста counter метрик по умолчанию
+const DefaultRateWindow = time.Minute
+
+// Окно для вычисления скорости роста counter метрики. Хранит два наблюдения:
+// Mid - последнее наблюдение, сдвигающее окно, Base - предыдущее такое наблюдение.
+// Окно сдвигается, когда с момента Mid прошло не меньше window, поэтому
+// скорость считается за интервал от window до 2*window
+type RateWindow struct {
+	HasBase   bool
+	BaseValue int64
+	BaseAt    time.Time
+	MidValue  int64
+	MidAt     time.Time
+}
+
+// Окно для нового counter
+func NewRateWindow(value int64, at time.Time) RateWindow {
+	return RateWindow{MidValue: value, MidAt: at}
+}
+
+// Обновление окна после изменения counter с prev на value в момент at.
+// Уменьшение counter считается сбросом, и окно начинается заново
+func (w RateWindow) Observe(prev, value int64, at time.Time, window time.Duration) RateWindow {
+	if value < prev {
+		return NewRateWindow(value, at)
+	}
+	if at.Sub(w.MidAt) >= window {
+		return RateWindow{HasBase: true, BaseValue: w.MidValue, BaseAt: w.MidAt, MidValue: value, MidAt: at}
+	}
+	return w
+}
+
+// Скорость роста counter в секунду на момент now при текущем значении value.
+// Если counter перестал обновляться, скорость постепенно падает до нуля
+func (w RateWindow) Rate(value int64, now time.Time) float64 {
+	startValue, startAt := w.MidValue, w.MidAt
+	if w.HasBase {
+		startValue, startAt = w.BaseValue, w.BaseAt
+	}
+	elapsed := now.Sub(startAt).Seconds()
+	if elapsed <= 0 || value < startValue {
+		return 0
+	}
+	return float64(value-startValue) / elapsed
+}
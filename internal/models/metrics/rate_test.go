@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateWindow(t *testing.T) {
+	start := time.Now()
+	window := time.Minute
+	w := NewRateWindow(0, start)
+	assert.Equal(t, 0.0, w.Rate(0, start), "одного наблюдения недостаточно")
+
+	// counter растет на 10 каждые 10 секунд
+	value := int64(0)
+	for i := 1; i <= 6; i++ {
+		prev := value
+		value += 10
+		w = w.Observe(prev, value, start.Add(time.Duration(i)*10*time.Second), window)
+	}
+	assert.True(t, w.HasBase, "окно сдвигается через window")
+	assert.Equal(t, int64(0), w.BaseValue)
+	assert.Equal(t, start.Add(time.Minute), w.MidAt)
+	assert.InDelta(t, 1.0, w.Rate(value, start.Add(time.Minute)), 1e-9)
+
+	w = w.Observe(value, value+10, start.Add(70*time.Second), window)
+	value += 10
+	assert.InDelta(t, 1.0, w.Rate(value, start.Add(70*time.Second)), 1e-9)
+
+	// counter перестал обновляться
+	assert.InDelta(t, 70.0/140, w.Rate(value, start.Add(140*time.Second)), 1e-9)
+
+	// сброс counter
+	w = w.Observe(value, 0, start.Add(150*time.Second), window)
+	assert.False(t, w.HasBase)
+	assert.Equal(t, 0.0, w.Rate(0, start.Add(150*time.Second)))
+	w = w.Observe(0, 20, start.Add(160*time.Second), window)
+	assert.InDelta(t, 2.0, w.Rate(20, start.Add(160*time.Second)), 1e-9, "после сброса скорость считается от момента сброса")
+}
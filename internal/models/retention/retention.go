@@ -0,0 +1,134 @@
+// Module for metric retention policy parsing
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Правило хранения: метрики типа MType с именем, подходящим под шаблон Pattern,
+// удаляются, если не обновлялись дольше TTL. Пустой тип или "*" - любой тип
+type Rule struct {
+	MType   string
+	Pattern string
+	TTL     time.Duration
+}
+
+// Политика хранения метрик. Правила проверяются по порядку, применяется первое подходящее.
+// Метрики, под которые не подошло ни одно правило, хранятся бессрочно
+type Policy struct {
+	Rules []Rule
+}
+
+// Проверка, подходит ли метрика под правило
+func (r Rule) Match(mType, id string) bool {
+	if r.MType != "" && r.MType != "*" && r.MType != mType {
+		return false
+	}
+	matched, err := path.Match(r.Pattern, id)
+	return err == nil && matched
+}
+
+// Строковое представление правила в формате [type:]pattern=ttl
+func (r Rule) String() string {
+	rule := r.Pattern + "=" + r.TTL.String()
+	if r.MType != "" {
+		rule = r.MType + ":" + rule
+	}
+	return rule
+}
+
+// Получение TTL для метрики. Второе значение false, если метрика хранится бессрочно
+func (p Policy) TTL(mType, id string) (time.Duration, bool) {
+	for _, rule := range p.Rules {
+		if rule.Match(mType, id) {
+			return rule.TTL, true
+		}
+	}
+	return 0, false
+}
+
+// Проверка, истек ли срок хранения метрики на момент now
+func (p Policy) Expired(mType, id string, updatedAt time.Time, now time.Time) bool {
+	ttl, ok := p.TTL(mType, id)
+	return ok && now.Sub(updatedAt) > ttl
+}
+
+// Пустая политика - метрики хранятся бессрочно
+func (p Policy) IsEmpty() bool {
+	return len(p.Rules) == 0
+}
+
+// Строковое представление политики
+func (p Policy) String() string {
+	rules := make([]string, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		rules = append(rules, rule.String())
+	}
+	return strings.Join(rules, ",")
+}
+
+// Создание политики из строки вида "gauge:*=1h,counter:Poll*=24h,*=72h"
+func (p *Policy) Set(s string) error {
+	rules := []Rule{}
+	for _, rawRule := range strings.Split(s, ",") {
+		rawRule = strings.TrimSpace(rawRule)
+		if rawRule == "" {
+			continue
+		}
+		rule, err := parseRule(rawRule)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	p.Rules = rules
+	return nil
+}
+
+// Создание политики из json и переменных окружения
+func (p *Policy) UnmarshalText(text []byte) error {
+	return p.Set(string(text))
+}
+
+// Создание политики из аргументов командной строки
+func (p *Policy) UnmarshalFlag(value string) error {
+	return p.Set(value)
+}
+
+func parseRule(rawRule string) (Rule, error) {
+	selector, rawTTL, found := strings.Cut(rawRule, "=")
+	if !found {
+		return Rule{}, fmt.Errorf("need retention rule in a form [type:]pattern=ttl, got %q", rawRule)
+	}
+
+	rule := Rule{Pattern: strings.TrimSpace(selector)}
+	if mType, pattern, found := strings.Cut(rule.Pattern, ":"); found {
+		rule.MType = strings.TrimSpace(mType)
+		rule.Pattern = strings.TrimSpace(pattern)
+	}
+	switch rule.MType {
+	case "", "*", "gauge", "counter":
+	default:
+		return Rule{}, fmt.Errorf("unknown metric type %q in retention rule", rule.MType)
+	}
+	if rule.Pattern == "" {
+		return Rule{}, errors.New("empty pattern in retention rule")
+	}
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return Rule{}, fmt.Errorf("invalid pattern %q in retention rule: %w", rule.Pattern, err)
+	}
+
+	ttl, err := time.ParseDuration(strings.TrimSpace(rawTTL))
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid ttl in retention rule %q: %w", rawRule, err)
+	}
+	if ttl <= 0 {
+		return Rule{}, fmt.Errorf("ttl must be positive in retention rule %q", rawRule)
+	}
+	rule.TTL = ttl
+	return rule, nil
+}
@@ -0,0 +1,78 @@
+package retention
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyParse(t *testing.T) {
+	testCases := []struct {
+		testName       string
+		input          string
+		expectedPolicy Policy
+		wantErr        bool
+	}{
+		{
+			testName:       "empty",
+			input:          "",
+			expectedPolicy: Policy{Rules: []Rule{}},
+		},
+		{
+			testName: "several rules",
+			input:    "gauge:*=1h, counter:Poll*=24h,*=72h",
+			expectedPolicy: Policy{Rules: []Rule{
+				{MType: "gauge", Pattern: "*", TTL: time.Hour},
+				{MType: "counter", Pattern: "Poll*", TTL: 24 * time.Hour},
+				{MType: "", Pattern: "*", TTL: 72 * time.Hour},
+			}},
+		},
+		{testName: "without ttl", input: "gauge:*", wantErr: true},
+		{testName: "invalid ttl", input: "gauge:*=abc", wantErr: true},
+		{testName: "negative ttl", input: "gauge:*=-1h", wantErr: true},
+		{testName: "unknown type", input: "histogram:*=1h", wantErr: true},
+		{testName: "invalid pattern", input: "gauge:[=1h", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			policy := Policy{}
+			err := policy.Set(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPolicy, policy)
+		})
+	}
+}
+
+func TestPolicyUnmarshalJSON(t *testing.T) {
+	cfg := struct {
+		Retention Policy `json:"retention"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(`{"retention": "gauge:*=10m"}`), &cfg))
+	assert.Equal(t, "gauge:*=10m0s", cfg.Retention.String())
+}
+
+func TestPolicyExpired(t *testing.T) {
+	policy := Policy{}
+	require.NoError(t, policy.Set("gauge:Host*=1h,counter:*=24h"))
+	now := time.Now()
+
+	assert.True(t, policy.Expired("gauge", "HostCPU", now.Add(-2*time.Hour), now))
+	assert.False(t, policy.Expired("gauge", "HostCPU", now.Add(-30*time.Minute), now))
+	assert.False(t, policy.Expired("gauge", "Alloc", now.Add(-1000*time.Hour), now), "метрика без правила хранится бессрочно")
+	assert.True(t, policy.Expired("counter", "PollCount", now.Add(-25*time.Hour), now))
+	assert.False(t, policy.Expired("counter", "PollCount", now.Add(-23*time.Hour), now))
+
+	ttl, ok := policy.TTL("gauge", "HostMem")
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, ttl)
+	_, ok = policy.TTL("gauge", "Alloc")
+	assert.False(t, ok)
+}
@@ -0,0 +1,368 @@
+package rules
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Источник значений метрик для вычисления выражения
+type Env interface {
+	// Значение метрики, пустой тип - сначала ищется gauge, потом counter
+	Value(mType string, name string) (float64, bool)
+	// Значения всех метрик, имена которых полностью подходят под регулярное выражение
+	Match(re *regexp.Regexp) []float64
+	// Скорость роста counter метрики в секунду
+	Rate(name string) (float64, bool)
+}
+
+// Выражение над метриками. Выражение не имеет значения, если нет какой-то из
+// метрик, под регулярное выражение не подошла ни одна метрика или произошло деление на ноль
+type Expr interface {
+	Eval(env Env) (float64, bool)
+}
+
+type numberExpr float64
+
+func (e numberExpr) Eval(env Env) (float64, bool) {
+	return float64(e), true
+}
+
+type metricExpr struct {
+	mType string
+	name  string
+}
+
+func (e metricExpr) Eval(env Env) (float64, bool) {
+	return env.Value(e.mType, e.name)
+}
+
+type rateExpr string
+
+func (e rateExpr) Eval(env Env) (float64, bool) {
+	return env.Rate(string(e))
+}
+
+type negExpr struct {
+	operand Expr
+}
+
+func (e negExpr) Eval(env Env) (float64, bool) {
+	value, ok := e.operand.Eval(env)
+	return -value, ok
+}
+
+type binaryExpr struct {
+	op          byte
+	left, right Expr
+}
+
+func (e binaryExpr) Eval(env Env) (float64, bool) {
+	left, ok := e.left.Eval(env)
+	if !ok {
+		return 0, false
+	}
+	right, ok := e.right.Eval(env)
+	if !ok {
+		return 0, false
+	}
+
+	switch e.op {
+	case '+':
+		return left + right, true
+	case '-':
+		return left - right, true
+	case '*':
+		return left * right, true
+	case '/':
+		if right == 0 {
+			return 0, false
+		}
+		return left / right, true
+	default:
+		return 0, false
+	}
+}
+
+type aggrExpr struct {
+	fn string
+	re *regexp.Regexp
+}
+
+func (e aggrExpr) Eval(env Env) (float64, bool) {
+	values := env.Match(e.re)
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	switch e.fn {
+	case "sum", "avg":
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		if e.fn == "avg" {
+			return sum / float64(len(values)), true
+		}
+		return sum, true
+	case "max":
+		res := math.Inf(-1)
+		for _, value := range values {
+			res = math.Max(res, value)
+		}
+		return res, true
+	case "min":
+		res := math.Inf(1)
+		for _, value := range values {
+			res = math.Min(res, value)
+		}
+		return res, true
+	case "count":
+		return float64(len(values)), true
+	default:
+		return 0, false
+	}
+}
+
+// Разбор выражения. Поддерживаются:
+//   - числа и имена метрик, перед именем можно указать тип: "gauge:Alloc";
+//   - операторы + - * / и скобки;
+//   - sum, avg, min, max, count по метрикам, имена которых подходят под регулярное выражение: avg("CPUutilization[0-9]+");
+//   - rate(PollCount) - скорость роста counter метрики в секунду.
+func ParseExpr(input string) (Expr, error) {
+	p := &exprParser{input: input}
+	p.next()
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return expr, nil
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokIdent
+	tokString
+	tokOp
+	tokInvalid
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	input string
+	pos   int
+	tok   token
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("expression %q, position %d: %s", p.input, p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+func isIdentRune(r rune, first bool) bool {
+	return r == '_' || unicode.IsLetter(r) || (!first && unicode.IsDigit(r))
+}
+
+// Чтение следующего токена
+func (p *exprParser) next() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := rune(p.input[p.pos])
+	switch {
+	case strings.ContainsRune("+-*/():", c):
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.input) && p.input[p.pos] != '"' {
+			if p.input[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			p.tok = token{kind: tokInvalid, text: p.input[start:], pos: start}
+			return
+		}
+		p.pos++
+		p.tok = token{kind: tokString, text: p.input[start:p.pos], pos: start}
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.input) && strings.ContainsRune("0123456789.eE", rune(p.input[p.pos])) {
+			if (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') && p.pos+1 < len(p.input) && strings.ContainsRune("+-", rune(p.input[p.pos+1])) {
+				p.pos++
+			}
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.input[start:p.pos], pos: start}
+	case isIdentRune(c, true):
+		for p.pos < len(p.input) && isIdentRune(rune(p.input[p.pos]), false) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.input[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokInvalid, text: string(c), pos: start}
+	}
+}
+
+func (p *exprParser) isOp(ops string) bool {
+	return p.tok.kind == tokOp && strings.Contains(ops, p.tok.text)
+}
+
+func (p *exprParser) expect(op string) error {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		return p.errorf("expected %q", op)
+	}
+	p.next()
+	return nil
+}
+
+// sum := product (('+' | '-') product)*
+func (p *exprParser) parseSum() (Expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+-") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// product := unary (('*' | '/') unary)*
+func (p *exprParser) parseProduct() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*/") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// unary := '-' unary | primary
+func (p *exprParser) parseUnary() (Expr, error) {
+	if p.isOp("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negExpr{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := number | metric | func '(' args ')' | '(' sum ')'
+func (p *exprParser) parsePrimary() (Expr, error) {
+	switch p.tok.kind {
+	case tokNumber:
+		value, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.tok.text)
+		}
+		p.next()
+		return numberExpr(value), nil
+	case tokIdent:
+		name := p.tok.text
+		p.next()
+		if p.isOp("(") {
+			return p.parseCall(name)
+		}
+		if p.isOp(":") {
+			return p.parseTypedMetric(name)
+		}
+		return metricExpr{name: name}, nil
+	case tokOp:
+		if p.isOp("(") {
+			p.next()
+			expr, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", p.tok.text)
+}
+
+func (p *exprParser) parseTypedMetric(mType string) (Expr, error) {
+	if mType != "gauge" && mType != "counter" {
+		return nil, p.errorf("unknown metric type %q", mType)
+	}
+	p.next()
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("expected metric name")
+	}
+	name := p.tok.text
+	p.next()
+	return metricExpr{mType: mType, name: name}, nil
+}
+
+func (p *exprParser) parseCall(fn string) (Expr, error) {
+	p.next()
+	var expr Expr
+	switch fn {
+	case "rate":
+		if p.tok.kind == tokIdent && p.tok.text == "counter" {
+			p.next()
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+		}
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("rate expects counter name")
+		}
+		expr = rateExpr(p.tok.text)
+	case "sum", "avg", "min", "max", "count":
+		if p.tok.kind != tokString {
+			return nil, p.errorf("%s expects quoted regular expression", fn)
+		}
+		pattern, err := strconv.Unquote(p.tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid string %s", p.tok.text)
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, p.errorf("invalid regular expression: %s", err)
+		}
+		expr = aggrExpr{fn: fn, re: re}
+	default:
+		return nil, p.errorf("unknown function %q", fn)
+	}
+	p.next()
+	return expr, p.expect(")")
+}
@@ -0,0 +1,110 @@
+package rules
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type MockEnv struct {
+	gauges   map[string]float64
+	counters map[string]float64
+	rates    map[string]float64
+}
+
+func (m *MockEnv) Value(mType string, name string) (float64, bool) {
+	if value, ok := m.gauges[name]; ok && mType != "counter" {
+		return value, true
+	}
+	if value, ok := m.counters[name]; ok && mType != "gauge" {
+		return value, true
+	}
+	return 0, false
+}
+
+func (m *MockEnv) Match(re *regexp.Regexp) []float64 {
+	values := []float64{}
+	for name, value := range m.gauges {
+		if re.MatchString(name) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (m *MockEnv) Rate(name string) (float64, bool) {
+	rate, ok := m.rates[name]
+	return rate, ok
+}
+
+func TestExprEval(t *testing.T) {
+	env := &MockEnv{
+		gauges: map[string]float64{
+			"TotalMemory":     1000,
+			"FreeMemory":      250,
+			"CPUutilization1": 10,
+			"CPUutilization2": 30,
+			"CPUutilization":  100,
+			"Zero":            0,
+		},
+		counters: map[string]float64{"PollCount": 42},
+		rates:    map[string]float64{"PollCount": 0.5},
+	}
+
+	testCases := []struct {
+		testName      string
+		expr          string
+		expectedValue float64
+		expectedOk    bool
+	}{
+		{testName: "difference", expr: "TotalMemory - FreeMemory", expectedValue: 750, expectedOk: true},
+		{testName: "precedence", expr: "TotalMemory - FreeMemory * 2", expectedValue: 500, expectedOk: true},
+		{testName: "parentheses", expr: "(TotalMemory - FreeMemory) / TotalMemory * 100", expectedValue: 75, expectedOk: true},
+		{testName: "unary minus", expr: "-FreeMemory + 1e3", expectedValue: 750, expectedOk: true},
+		{testName: "avg over regex", expr: `avg("CPUutilization[0-9]+")`, expectedValue: 20, expectedOk: true},
+		{testName: "sum over regex", expr: `sum("CPUutilization[0-9]+")`, expectedValue: 40, expectedOk: true},
+		{testName: "max over regex", expr: `max("CPUutilization.*")`, expectedValue: 100, expectedOk: true},
+		{testName: "min over regex", expr: `min("CPUutilization.*")`, expectedValue: 10, expectedOk: true},
+		{testName: "count over regex", expr: `count("CPU.*")`, expectedValue: 3, expectedOk: true},
+		{testName: "regex is anchored", expr: `count("CPU")`, expectedOk: false},
+		{testName: "typed metric", expr: "counter:PollCount * 2", expectedValue: 84, expectedOk: true},
+		{testName: "wrong type", expr: "gauge:PollCount", expectedOk: false},
+		{testName: "rate", expr: "rate(PollCount) * 60", expectedValue: 30, expectedOk: true},
+		{testName: "typed rate", expr: "rate(counter:PollCount)", expectedValue: 0.5, expectedOk: true},
+		{testName: "missing metric", expr: "TotalMemory - Unknown", expectedOk: false},
+		{testName: "division by zero", expr: "TotalMemory / Zero", expectedOk: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			expr, err := ParseExpr(tc.expr)
+			require.NoError(t, err)
+			value, ok := expr.Eval(env)
+			assert.Equal(t, tc.expectedOk, ok)
+			if tc.expectedOk {
+				assert.InDelta(t, tc.expectedValue, value, 1e-9)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"TotalMemory -",
+		"(TotalMemory",
+		"TotalMemory FreeMemory",
+		"median(\"CPU.*\")",
+		"avg(CPU)",
+		"avg(\"[\")",
+		"rate(\"PollCount\")",
+		"histogram:Alloc",
+		"Alloc % 2",
+		"\"unterminated",
+	} {
+		_, err := ParseExpr(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,204 @@
+// Module for loading and parsing rule definitions
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Длительность, которая в json задается строкой вида "5m"
+type Duration time.Duration
+
+// Разбор длительности из строки
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Строковое представление длительности
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Функции, применяемые к значению метрики
+const (
+	FuncValue = "value" // текущее значение метрики
+	FuncRate  = "rate"  // скорость роста counter метрики в секунду
+)
+
+// Условие вида "<metric> [rate] <op> <threshold>", например "HeapInuse > 1e9" или "PollCount rate == 0".
+// Перед именем метрики можно указать тип: "gauge:HeapInuse"
+type Condition struct {
+	MType     string // тип метрики, пустой - сначала ищется gauge, потом counter
+	Metric    string
+	Func      string
+	Op        string
+	Threshold float64
+}
+
+// Разбор условия правила
+func ParseCondition(expr string) (Condition, error) {
+	fields := strings.Fields(expr)
+	cond := Condition{Func: FuncValue}
+
+	switch {
+	case len(fields) == 3:
+	case len(fields) == 4 && fields[1] == FuncRate:
+		cond.Func = FuncRate
+		fields = []string{fields[0], fields[2], fields[3]}
+	default:
+		return cond, fmt.Errorf("need condition in a form \"<metric> [rate] <op> <threshold>\", got %q", expr)
+	}
+
+	cond.Metric = fields[0]
+	if mType, name, found := strings.Cut(fields[0], ":"); found {
+		cond.MType = mType
+		cond.Metric = name
+	}
+	switch cond.MType {
+	case "", "gauge", "counter":
+	default:
+		return cond, fmt.Errorf("unknown metric type %q in condition %q", cond.MType, expr)
+	}
+	if cond.Metric == "" {
+		return cond, fmt.Errorf("empty metric name in condition %q", expr)
+	}
+	if cond.Func == FuncRate {
+		if cond.MType == "gauge" {
+			return cond, fmt.Errorf("rate is supported only for counters, condition %q", expr)
+		}
+		cond.MType = "counter"
+	}
+
+	switch fields[1] {
+	case ">", ">=", "<", "<=", "==", "!=":
+		cond.Op = fields[1]
+	default:
+		return cond, fmt.Errorf("unknown operator %q in condition %q", fields[1], expr)
+	}
+
+	threshold, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return cond, fmt.Errorf("invalid threshold in condition %q: %w", expr, err)
+	}
+	cond.Threshold = threshold
+	return cond, nil
+}
+
+// Проверка значения на соответствие условию
+func (c Condition) Holds(value float64) bool {
+	switch c.Op {
+	case ">":
+		return value > c.Threshold
+	case ">=":
+		return value >= c.Threshold
+	case "<":
+		return value < c.Threshold
+	case "<=":
+		return value <= c.Threshold
+	case "==":
+		return value == c.Threshold
+	case "!=":
+		return value != c.Threshold
+	default:
+		return false
+	}
+}
+
+// Правило алерта
+type AlertRule struct {
+	Name        string            `json:"name"`
+	Expr        string            `json:"expr"`
+	For         Duration          `json:"for"` // сколько условие должно выполняться, чтобы алерт сработал
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+
+	Condition Condition `json:"-"`
+}
+
+// Правило записи, результат выражения сохраняется как gauge метрика с именем Record
+type RecordRule struct {
+	Record string `json:"record"`
+	Expr   string `json:"expr"`
+
+	Expression Expr `json:"-"`
+}
+
+// Допустимое имя метрики
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
+// Файл с правилами
+type File struct {
+	Alerts  []AlertRule  `json:"alerts"`
+	Records []RecordRule `json:"records"`
+}
+
+// Проверка правил, разбор условий и выражений
+func (f *File) Validate() error {
+	names := map[string]bool{}
+	for i := range f.Alerts {
+		rule := &f.Alerts[i]
+		if rule.Name == "" {
+			return errors.New("alert rule name is required")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.For < 0 {
+			return fmt.Errorf("negative for duration in alert rule %q", rule.Name)
+		}
+
+		cond, err := ParseCondition(rule.Expr)
+		if err != nil {
+			return fmt.Errorf("alert rule %q: %w", rule.Name, err)
+		}
+		rule.Condition = cond
+	}
+
+	records := map[string]bool{}
+	for i := range f.Records {
+		rule := &f.Records[i]
+		if !metricNameRe.MatchString(rule.Record) {
+			return fmt.Errorf("invalid record rule metric name %q", rule.Record)
+		}
+		if records[rule.Record] {
+			return fmt.Errorf("duplicate record rule %q", rule.Record)
+		}
+		records[rule.Record] = true
+
+		expr, err := ParseExpr(rule.Expr)
+		if err != nil {
+			return fmt.Errorf("record rule %q: %w", rule.Record, err)
+		}
+		rule.Expression = expr
+	}
+	return nil
+}
+
+// Загрузка правил из json файла
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,110 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	testCases := []struct {
+		testName     string
+		expr         string
+		expectedCond Condition
+		wantErr      bool
+	}{
+		{
+			testName:     "gauge threshold",
+			expr:         "HeapInuse > 1e9",
+			expectedCond: Condition{Metric: "HeapInuse", Func: FuncValue, Op: ">", Threshold: 1e9},
+		},
+		{
+			testName:     "typed metric",
+			expr:         "counter:PollCount >= 10",
+			expectedCond: Condition{MType: "counter", Metric: "PollCount", Func: FuncValue, Op: ">=", Threshold: 10},
+		},
+		{
+			testName:     "rate",
+			expr:         "PollCount rate == 0",
+			expectedCond: Condition{MType: "counter", Metric: "PollCount", Func: FuncRate, Op: "==", Threshold: 0},
+		},
+		{testName: "rate of gauge", expr: "gauge:Alloc rate > 1", wantErr: true},
+		{testName: "unknown operator", expr: "Alloc => 1", wantErr: true},
+		{testName: "unknown function", expr: "Alloc avg > 1", wantErr: true},
+		{testName: "invalid threshold", expr: "Alloc > abc", wantErr: true},
+		{testName: "unknown type", expr: "histogram:Alloc > 1", wantErr: true},
+		{testName: "empty", expr: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			cond, err := ParseCondition(tc.expr)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCond, cond)
+		})
+	}
+}
+
+func TestConditionHolds(t *testing.T) {
+	cond := Condition{Op: ">", Threshold: 10}
+	assert.True(t, cond.Holds(11))
+	assert.False(t, cond.Holds(10))
+
+	cond = Condition{Op: "==", Threshold: 0}
+	assert.True(t, cond.Holds(0))
+	assert.False(t, cond.Holds(0.1))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"alerts": [
+			{"name": "HighHeap", "expr": "HeapInuse > 100000000", "for": "5m", "labels": {"severity": "warning"}},
+			{"name": "AgentStalled", "expr": "PollCount rate == 0", "for": "1m"}
+		],
+		"records": [
+			{"record": "used_memory", "expr": "TotalMemory - FreeMemory"},
+			{"record": "cpu_avg", "expr": "avg(\"CPUutilization[0-9]+\")"}
+		]
+	}`), 0666))
+
+	file, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, file.Alerts, 2)
+	assert.Equal(t, Duration(5*time.Minute), file.Alerts[0].For)
+	assert.Equal(t, "warning", file.Alerts[0].Labels["severity"])
+	assert.Equal(t, FuncRate, file.Alerts[1].Condition.Func)
+	require.Len(t, file.Records, 2)
+	assert.NotNil(t, file.Records[1].Expression)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"alerts": [{"name": "A", "expr": "x > 1"}, {"name": "A", "expr": "y > 1"}]}`), 0666))
+	_, err = Load(path)
+	assert.Error(t, err, "имена правил должны быть уникальными")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"alerts": [{"name": "A", "expr": "x ? 1"}]}`), 0666))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"records": [{"record": "a", "expr": "x"}, {"record": "a", "expr": "y"}]}`), 0666))
+	_, err = Load(path)
+	assert.Error(t, err, "имена метрик правил записи должны быть уникальными")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"records": [{"record": "a b", "expr": "x"}]}`), 0666))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"records": [{"record": "a", "expr": "x +"}]}`), 0666))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	_, err = Load(filepath.Join(t.TempDir(), "unknown.json"))
+	assert.Error(t, err)
+}
@@ -1,115 +1,791 @@
+// Module for saving metrics in memory
 package memstorage
 
 import (
 	"context"
-	"errors"
+	"math"
+	"path"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+// Кол-во шардов по умолчанию
+const defaultShardCount = 32
+
+// Кол-во попыток чтения без блокировок, после которых читатель блокирует шарды,
+// чтобы не голодать под постоянной записью
+const maxOptimisticReads = 8
+
+type (
+	// Ячейка со значением gauge метрики
+	gaugeCell struct {
+		bits      atomic.Uint64
+		updatedAt atomic.Int64
+	}
+
+	// Ячейка со значением counter метрики
+	counterCell struct {
+		value     atomic.Int64
+		updatedAt atomic.Int64
+		rate      atomic.Pointer[metrics.RateWindow]
+	}
+
+	// Шард хранилища. Карты ячеек неизменяемы после публикации: при добавлении
+	// новых метрик писатель копирует карту и атомарно подменяет указатель,
+	// поэтому читатели работают без блокировок. Версия нечетная, пока в шард
+	// пишется пачка: читатель, заставший запись или смену версии, читает заново
+	shard struct {
+		mutex    sync.Mutex
+		version  atomic.Uint64
+		gauges   atomic.Pointer[map[string]*gaugeCell]
+		counters atomic.Pointer[map[string]*counterCell]
+	}
+)
+
+// Максимальное кол-во запоминаемых пачек одного агента
+const maxBatchesPerAgent = 4096
+
+type (
+	// Примененная пачка агента
+	appliedBatch struct {
+		id        string
+		appliedAt time.Time
+	}
+
+	// Недавно примененные пачки одного агента в порядке применения.
+	// removed - окно удалено из хранилища и больше не используется
+	batchWindow struct {
+		mutex   sync.Mutex
+		applied map[string]struct{}
+		order   []appliedBatch
+		removed bool
+	}
 )
 
 // Хранилище метрик в памяти
 type MemStorage struct {
-	counterMutex sync.RWMutex
-	counter      map[string]int64
-	gaugeMutex   sync.RWMutex
-	gauge        map[string]float64
+	shardCount   int
+	shards       []*shard
+	rateWindow   time.Duration
+	dedupWindow  time.Duration
+	batchesMutex sync.Mutex
+	batches      map[string]*batchWindow
+	batchesSweep time.Time
 }
 
 // Создание инстанса хранилки метрик в памяти
 func New() *MemStorage {
-	return &MemStorage{counter: nil, gauge: nil}
+	return NewSharded(defaultShardCount)
+}
+
+// Создание инстанса хранилки метрик в памяти с заданным кол-вом шардов
+func NewSharded(shardCount int) *MemStorage {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	return &MemStorage{
+		shardCount:  shardCount,
+		shards:      nil,
+		rateWindow:  metrics.DefaultRateWindow,
+		dedupWindow: metrics.DefaultDedupWindow,
+		batches:     map[string]*batchWindow{},
+	}
+}
+
+// Установка окна для вычисления скорости роста counter метрик
+func (ms *MemStorage) SetRateWindow(window time.Duration) {
+	ms.rateWindow = window
+}
+
+// Установка окна, в течение которого повторно присланная пачка не применяется
+func (ms *MemStorage) SetDedupWindow(window time.Duration) {
+	ms.dedupWindow = window
 }
 
 // Инициализация инстанса хранилки
 func (ms *MemStorage) Initialize(ctx context.Context) error {
-	ms.counter = map[string]int64{}
-	ms.gauge = map[string]float64{}
+	shards := make([]*shard, ms.shardCount)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	ms.shards = shards
 	return nil
 }
 
-// Сохранение метрик в хранилку
+func newShard() *shard {
+	sh := &shard{}
+	gauges := map[string]*gaugeCell{}
+	counters := map[string]*counterCell{}
+	sh.gauges.Store(&gauges)
+	sh.counters.Store(&counters)
+	return sh
+}
+
+// FNV-1a без аллокаций
+func (ms *MemStorage) shardIndex(id string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(ms.shards)))
+}
+
+func validateMetric(metric metrics.Metric) error {
+	if metric.ID == "" {
+		return storageerrors.NewInvalidMetricError(metric.ID, "id is required")
+	}
+
+	switch metric.MType {
+	case "":
+		return storageerrors.NewInvalidMetricError(metric.ID, "type is required")
+	case "gauge":
+		if metric.Value == nil {
+			return storageerrors.NewInvalidMetricError(metric.ID, "value is required for gauge")
+		}
+	case "counter":
+		if metric.Delta == nil {
+			return storageerrors.NewInvalidMetricError(metric.ID, "delta is required for counter")
+		}
+	default:
+		return storageerrors.NewInvalidMetricError(metric.ID, "unknown type "+metric.MType)
+	}
+	return nil
+}
+
+// Обновление окна скорости роста counter. Новое окно публикуется,
+// только если оно изменилось, поэтому обычное обновление обходится без аллокаций
+func (cell *counterCell) observeRate(prev, value int64, at time.Time, rateWindow time.Duration) {
+	window := cell.rate.Load()
+	if window == nil {
+		newWindow := metrics.NewRateWindow(value, at)
+		cell.rate.Store(&newWindow)
+		return
+	}
+	if next := window.Observe(prev, value, at, rateWindow); next != *window {
+		published := next
+		cell.rate.Store(&published)
+	}
+}
+
+// Применение к шарду метрик пачки с заданными позициями.
+// Вызывается под блокировкой шарда
+func (sh *shard) apply(metricList []metrics.Metric, positions []int, updatedAt int64, rateWindow time.Duration) {
+	gauges := *sh.gauges.Load()
+	counters := *sh.counters.Load()
+	var newGauges map[string]*gaugeCell
+	var newCounters map[string]*counterCell
+
+	for _, pos := range positions {
+		metric := metricList[pos]
+		switch metric.MType {
+		case "gauge":
+			cell, ok := gauges[metric.ID]
+			if !ok && newGauges != nil {
+				cell, ok = newGauges[metric.ID]
+			}
+			if !ok {
+				if newGauges == nil {
+					newGauges = make(map[string]*gaugeCell, len(gauges)+1)
+				}
+				cell = &gaugeCell{}
+				newGauges[metric.ID] = cell
+			}
+			cell.bits.Store(math.Float64bits(*metric.Value))
+			cell.updatedAt.Store(updatedAt)
+		case "counter":
+			cell, ok := counters[metric.ID]
+			if !ok && newCounters != nil {
+				cell, ok = newCounters[metric.ID]
+			}
+			if !ok {
+				if newCounters == nil {
+					newCounters = make(map[string]*counterCell, len(counters)+1)
+				}
+				cell = &counterCell{}
+				newCounters[metric.ID] = cell
+			}
+			prev := cell.value.Load()
+			value := cell.value.Add(*metric.Delta)
+			cell.updatedAt.Store(updatedAt)
+			cell.observeRate(prev, value, time.Unix(0, updatedAt), rateWindow)
+		}
+	}
+
+	// публикуем новые карты только после того, как все ячейки заполнены
+	if newGauges != nil {
+		for key, cell := range gauges {
+			newGauges[key] = cell
+		}
+		published := newGauges
+		sh.gauges.Store(&published)
+	}
+	if newCounters != nil {
+		for key, cell := range counters {
+			newCounters[key] = cell
+		}
+		published := newCounters
+		sh.counters.Store(&published)
+	}
+}
+
+// Сохранение метрик в хранилку.
+// Пачка либо применяется целиком, либо не применяется совсем: сначала проверяются
+// все метрики, затем блокируются все затронутые шарды (всегда в порядке возрастания
+// индекса, чтобы не было дедлоков), и только после этого записываются значения.
+// Конкурентные пачки, затрагивающие общие шарды, не перемешиваются между собой.
+// Версии шардов остаются нечетными до конца записи всей пачки, поэтому читатели
+// не видят пачку примененной частично
 func (ms *MemStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
-	// prepare arrays
 	for _, metric := range metricList {
-		if metric.ID == "" {
-			return errors.New("INVALID_METRIC")
+		if err := validateMetric(metric); err != nil {
+			return err
+		}
+	}
+	if len(metricList) == 0 {
+		return nil
+	}
+
+	// позиции метрик, упорядоченные по индексу шарда (сортировка вставками устойчива
+	// и сохраняет порядок метрик внутри шарда); для типичных пачек обходится без аллокаций
+	var shardIndexesBuf, positionsBuf [64]int
+	shardIndexes := shardIndexesBuf[:0]
+	positions := positionsBuf[:0]
+	for pos, metric := range metricList {
+		idx := ms.shardIndex(metric.ID)
+		i := len(positions)
+		shardIndexes = append(shardIndexes, idx)
+		positions = append(positions, pos)
+		for ; i > 0 && shardIndexes[i-1] > idx; i-- {
+			shardIndexes[i], positions[i] = shardIndexes[i-1], positions[i-1]
+		}
+		shardIndexes[i], positions[i] = idx, pos
+	}
+
+	for i, idx := range shardIndexes {
+		if i == 0 || shardIndexes[i-1] != idx {
+			ms.shards[idx].mutex.Lock()
+			ms.shards[idx].version.Add(1)
+		}
+	}
+	updatedAt := time.Now().UnixNano()
+	for start := 0; start < len(shardIndexes); {
+		end := start + 1
+		for end < len(shardIndexes) && shardIndexes[end] == shardIndexes[start] {
+			end++
+		}
+		ms.shards[shardIndexes[start]].apply(metricList, positions[start:end], updatedAt, ms.rateWindow)
+		start = end
+	}
+	for i, idx := range shardIndexes {
+		if i == 0 || shardIndexes[i-1] != idx {
+			ms.shards[idx].version.Add(1)
+			ms.shards[idx].mutex.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// Окно пачек агента, создается при первой пачке. Заодно раз в dedupWindow
+// удаляются окна, все пачки которых старше dedupWindow. Занятые окна пропускаются,
+// в них идет сохранение пачки
+func (ms *MemStorage) batchWindowOf(agentID string, now time.Time) *batchWindow {
+	ms.batchesMutex.Lock()
+	defer ms.batchesMutex.Unlock()
+	if now.Sub(ms.batchesSweep) >= ms.dedupWindow {
+		ms.batchesSweep = now
+		for key, window := range ms.batches {
+			if !window.mutex.TryLock() {
+				continue
+			}
+			window.prune(now, ms.dedupWindow)
+			if len(window.order) == 0 {
+				window.removed = true
+				delete(ms.batches, key)
+			}
+			window.mutex.Unlock()
+		}
+	}
+	window, ok := ms.batches[agentID]
+	if !ok {
+		window = &batchWindow{applied: map[string]struct{}{}}
+		ms.batches[agentID] = window
+	}
+	return window
+}
+
+// Забыть пачки, примененные раньше now-dedupWindow. Вызывается под window.mutex
+func (window *batchWindow) prune(now time.Time, dedupWindow time.Duration) {
+	expired := 0
+	for expired < len(window.order) &&
+		(now.Sub(window.order[expired].appliedAt) >= dedupWindow || len(window.order)-expired > maxBatchesPerAgent) {
+		delete(window.applied, window.order[expired].id)
+		expired++
+	}
+	window.order = window.order[expired:]
+}
+
+// Сохранение пачки метрик ровно один раз. Если пачка с тем же ключом уже
+// применялась в пределах окна, метрики не сохраняются и возвращается false.
+// Пачки одного агента применяются последовательно
+func (ms *MemStorage) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	now := time.Now()
+	var window *batchWindow
+	for {
+		window = ms.batchWindowOf(batch.AgentID, now)
+		window.mutex.Lock()
+		// окно могли удалить, пока оно не было заблокировано
+		if !window.removed {
+			break
+		}
+		window.mutex.Unlock()
+	}
+	defer window.mutex.Unlock()
+
+	window.prune(now, ms.dedupWindow)
+	if _, ok := window.applied[batch.BatchID]; ok {
+		return false, nil
+	}
+	if err := ms.SaveMetrics(ctx, metricList); err != nil {
+		return false, err
+	}
+	window.applied[batch.BatchID] = struct{}{}
+	window.order = append(window.order, appliedBatch{id: batch.BatchID, appliedAt: now})
+	return true, nil
+}
+
+// Удаление из шарда метрик, для которых isExpired вернул true.
+// Вызывается под блокировкой шарда
+func (sh *shard) evict(isExpired func(mType, id string, updatedAt time.Time) bool) int {
+	evicted := 0
+
+	gauges := *sh.gauges.Load()
+	var newGauges map[string]*gaugeCell
+	for key, cell := range gauges {
+		if isExpired("gauge", key, time.Unix(0, cell.updatedAt.Load())) {
+			if newGauges == nil {
+				newGauges = make(map[string]*gaugeCell, len(gauges))
+				for key, cell := range gauges {
+					newGauges[key] = cell
+				}
+			}
+			delete(newGauges, key)
+			evicted++
 		}
-		if metric.MType == "" {
-			return errors.New("INVALID_METRIC")
+	}
+	if newGauges != nil {
+		sh.gauges.Store(&newGauges)
+	}
+
+	counters := *sh.counters.Load()
+	var newCounters map[string]*counterCell
+	for key, cell := range counters {
+		if isExpired("counter", key, time.Unix(0, cell.updatedAt.Load())) {
+			if newCounters == nil {
+				newCounters = make(map[string]*counterCell, len(counters))
+				for key, cell := range counters {
+					newCounters[key] = cell
+				}
+			}
+			delete(newCounters, key)
+			evicted++
+		}
+	}
+	if newCounters != nil {
+		sh.counters.Store(&newCounters)
+	}
+
+	return evicted
+}
+
+// Удаление метрик, срок хранения которых по политике истек на момент now.
+// Возвращает кол-во удаленных метрик
+func (ms *MemStorage) EvictMetrics(ctx context.Context, policy retention.Policy, now time.Time) (int, error) {
+	if policy.IsEmpty() {
+		return 0, nil
+	}
+	return ms.evict(func(mType, id string, updatedAt time.Time) bool {
+		return policy.Expired(mType, id, updatedAt, now)
+	}), nil
+}
+
+// Удаление метрик, для которых isExpired вернул true. Возвращает кол-во удаленных метрик
+func (ms *MemStorage) evict(isExpired func(mType, id string, updatedAt time.Time) bool) int {
+	evicted := 0
+	for _, sh := range ms.shards {
+		sh.mutex.Lock()
+		sh.version.Add(1)
+		evicted += sh.evict(isExpired)
+		sh.version.Add(1)
+		sh.mutex.Unlock()
+	}
+	return evicted
+}
+
+// Замена всего содержимого хранилки списком метрик, например снимком с primary.
+// Новые карты шардов строятся заранее и публикуются под блокировкой всех шардов,
+// поэтому конкурентная запись не попадает между удалением старых метрик и записью новых
+func (ms *MemStorage) ReplaceMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	for _, metric := range metricList {
+		if err := validateMetric(metric); err != nil {
+			return err
 		}
+	}
 
+	now := time.Now()
+	gauges := make([]map[string]*gaugeCell, len(ms.shards))
+	counters := make([]map[string]*counterCell, len(ms.shards))
+	for i := range ms.shards {
+		gauges[i] = map[string]*gaugeCell{}
+		counters[i] = map[string]*counterCell{}
+	}
+	for _, metric := range metricList {
+		idx := ms.shardIndex(metric.ID)
 		switch metric.MType {
 		case "gauge":
-			if metric.Value == nil {
-				return errors.New("INVALID_METRIC")
-			}
-			ms.gaugeMutex.Lock()
-			ms.gauge[metric.ID] = *metric.Value
-			ms.gaugeMutex.Unlock()
+			cell := &gaugeCell{}
+			cell.bits.Store(math.Float64bits(*metric.Value))
+			cell.updatedAt.Store(now.UnixNano())
+			gauges[idx][metric.ID] = cell
 		case "counter":
-			if metric.Delta == nil {
-				return errors.New("INVALID_METRIC")
+			cell, ok := counters[idx][metric.ID]
+			if !ok {
+				cell = &counterCell{}
+				counters[idx][metric.ID] = cell
 			}
-			ms.counterMutex.Lock()
-			ms.counter[metric.ID] += *metric.Delta
-			ms.counterMutex.Unlock()
-		default:
-			return errors.New("INVALID_METRIC")
+			cell.value.Add(*metric.Delta)
+			cell.updatedAt.Store(now.UnixNano())
+		}
+	}
+	for _, shardCounters := range counters {
+		for _, cell := range shardCounters {
+			window := metrics.NewRateWindow(cell.value.Load(), now)
+			cell.rate.Store(&window)
 		}
 	}
 
+	for _, sh := range ms.shards {
+		sh.mutex.Lock()
+		sh.version.Add(1)
+	}
+	for i, sh := range ms.shards {
+		sh.gauges.Store(&gauges[i])
+		sh.counters.Store(&counters[i])
+	}
+	for _, sh := range ms.shards {
+		sh.version.Add(1)
+		sh.mutex.Unlock()
+	}
 	return nil
 }
 
-// Получение всех метрик из хранилки
-func (ms *MemStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
-	metricList := []metrics.Metric{}
+// Согласованное чтение шардов без блокировок. read вызывается заново, если во время
+// чтения в какой-то из шардов писалась пачка, поэтому read должен начинать с чистого
+// результата. После maxOptimisticReads неудачных попыток шарды блокируются
+func readShards(shards []*shard, read func()) {
+	var versionsBuf [defaultShardCount]uint64
+	versions := versionsBuf[:0]
+	if len(shards) > len(versionsBuf) {
+		versions = make([]uint64, 0, len(shards))
+	}
 
-	ms.gaugeMutex.RLock()
-	for key, val := range ms.gauge {
-		metricList = append(metricList, metrics.Metric{
-			ID:    key,
-			MType: "gauge",
-			Value: &val,
-		})
+	for attempt := 0; attempt < maxOptimisticReads; attempt++ {
+		versions = versions[:0]
+		for _, sh := range shards {
+			versions = append(versions, sh.version.Load())
+		}
+		if stable(shards, versions) {
+			read()
+			if stable(shards, versions) {
+				return
+			}
+		}
+		runtime.Gosched()
 	}
-	ms.gaugeMutex.RUnlock()
 
-	ms.counterMutex.RLock()
-	for key, val := range ms.counter {
-		metricList = append(metricList, metrics.Metric{
-			ID:    key,
-			MType: "counter",
-			Delta: &val,
-		})
+	for _, sh := range shards {
+		sh.mutex.Lock()
 	}
-	ms.counterMutex.RUnlock()
+	defer func() {
+		for _, sh := range shards {
+			sh.mutex.Unlock()
+		}
+	}()
+	read()
+}
 
+// Согласованное чтение одного шарда, аналог readShards без аллокаций
+func readShard(sh *shard, read func()) {
+	for attempt := 0; attempt < maxOptimisticReads; attempt++ {
+		version := sh.version.Load()
+		if version%2 == 0 {
+			read()
+			if sh.version.Load() == version {
+				return
+			}
+		}
+		runtime.Gosched()
+	}
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	read()
+}
+
+// В шарды не пишется пачка, и их версии не изменились с момента versions
+func stable(shards []*shard, versions []uint64) bool {
+	for i, sh := range shards {
+		if versions[i]%2 == 1 || sh.version.Load() != versions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Получение всех метрик из хранилки. Не блокирует писателей, результат
+// согласован: каждая пачка попадает в него целиком или не попадает совсем
+func (ms *MemStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
+	var metricList []metrics.Metric
+	readShards(ms.shards, func() {
+		metricList = ms.extract()
+	})
 	return metricList, nil
 }
 
-// Получение метрики из хранилки
+func (ms *MemStorage) extract() []metrics.Metric {
+	metricList := []metrics.Metric{}
+
+	for _, sh := range ms.shards {
+		for key, cell := range *sh.gauges.Load() {
+			val := math.Float64frombits(cell.bits.Load())
+			metricList = append(metricList, metrics.Metric{
+				ID:    key,
+				MType: "gauge",
+				Value: &val,
+			})
+		}
+		for key, cell := range *sh.counters.Load() {
+			val := cell.value.Load()
+			metricList = append(metricList, metrics.Metric{
+				ID:    key,
+				MType: "counter",
+				Delta: &val,
+			})
+		}
+	}
+
+	return metricList
+}
+
+// Получение метрики из хранилки. Не блокирует писателей
 func (ms *MemStorage) GetMetric(ctx context.Context, metric *metrics.Metric) error {
+	if metric.MType != "gauge" && metric.MType != "counter" {
+		return storageerrors.NewTypeMismatchError(metric.ID, metric.MType)
+	}
+	sh := ms.shards[ms.shardIndex(metric.ID)]
+	var err error
+	readShard(sh, func() {
+		err = sh.get(metric)
+	})
+	return err
+}
+
+func (sh *shard) get(metric *metrics.Metric) error {
 	switch metric.MType {
 	case "gauge":
-		ms.gaugeMutex.RLock()
-		val, ok := ms.gauge[metric.ID]
-		ms.gaugeMutex.RUnlock()
+		cell, ok := (*sh.gauges.Load())[metric.ID]
 		if !ok {
-			return errors.New("NOT_FOUND")
+			return storageerrors.ErrNotFound
 		}
+		val := math.Float64frombits(cell.bits.Load())
 		metric.Value = &val
 	case "counter":
-		ms.counterMutex.RLock()
-		val, ok := ms.counter[metric.ID]
-		ms.counterMutex.RUnlock()
+		cell, ok := (*sh.counters.Load())[metric.ID]
 		if !ok {
-			return errors.New("NOT_FOUND")
+			return storageerrors.ErrNotFound
 		}
+		val := cell.value.Load()
 		metric.Delta = &val
 	default:
-		return errors.New("INVALID_METRIC_TYPE")
+		return storageerrors.NewTypeMismatchError(metric.ID, metric.MType)
 	}
 	return nil
 }
+
+// Удаление одной метрики. В metric записывается последнее значение удаленной метрики
+func (ms *MemStorage) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	if metric.MType != "gauge" && metric.MType != "counter" {
+		return storageerrors.NewTypeMismatchError(metric.ID, metric.MType)
+	}
+
+	sh := ms.shards[ms.shardIndex(metric.ID)]
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.version.Add(1)
+	defer sh.version.Add(1)
+
+	switch metric.MType {
+	case "gauge":
+		gauges := *sh.gauges.Load()
+		cell, ok := gauges[metric.ID]
+		if !ok {
+			return storageerrors.ErrNotFound
+		}
+		newGauges := make(map[string]*gaugeCell, len(gauges))
+		for key, cell := range gauges {
+			newGauges[key] = cell
+		}
+		delete(newGauges, metric.ID)
+		sh.gauges.Store(&newGauges)
+		val := math.Float64frombits(cell.bits.Load())
+		metric.Value = &val
+	case "counter":
+		counters := *sh.counters.Load()
+		cell, ok := counters[metric.ID]
+		if !ok {
+			return storageerrors.ErrNotFound
+		}
+		newCounters := make(map[string]*counterCell, len(counters))
+		for key, cell := range counters {
+			newCounters[key] = cell
+		}
+		delete(newCounters, metric.ID)
+		sh.counters.Store(&newCounters)
+		val := cell.value.Load()
+		metric.Delta = &val
+	}
+	return nil
+}
+
+// Удаление всех метрик типа mType (пустой тип - любой), имена которых подходят под шаблон
+// в формате path.Match. Возвращает кол-во удаленных метрик
+func (ms *MemStorage) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	if mType != "" && mType != "gauge" && mType != "counter" {
+		return 0, storageerrors.NewTypeMismatchError(pattern, mType)
+	}
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return 0, storageerrors.NewInvalidMetricError(pattern, "invalid name pattern")
+	}
+
+	return ms.evict(func(metricType, id string, updatedAt time.Time) bool {
+		if mType != "" && metricType != mType {
+			return false
+		}
+		matched, _ := path.Match(pattern, id)
+		return matched
+	}), nil
+}
+
+// Сброс значения counter метрики в ноль
+func (ms *MemStorage) ResetCounter(ctx context.Context, id string) error {
+	sh := ms.shards[ms.shardIndex(id)]
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.version.Add(1)
+	defer sh.version.Add(1)
+
+	cell, ok := (*sh.counters.Load())[id]
+	if !ok {
+		return storageerrors.ErrNotFound
+	}
+	now := time.Now()
+	window := metrics.NewRateWindow(0, now)
+	cell.value.Store(0)
+	cell.updatedAt.Store(now.UnixNano())
+	cell.rate.Store(&window)
+	return nil
+}
+
+// Получение скорости роста counter метрики в секунду. Не блокирует писателей
+func (ms *MemStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	sh := ms.shards[ms.shardIndex(id)]
+	var value int64
+	var window *metrics.RateWindow
+	found := false
+	readShard(sh, func() {
+		var cell *counterCell
+		cell, found = (*sh.counters.Load())[id]
+		if found {
+			value = cell.value.Load()
+			window = cell.rate.Load()
+		}
+	})
+	if !found {
+		return 0, storageerrors.ErrNotFound
+	}
+	if window == nil {
+		return 0, nil
+	}
+	return window.Rate(value, time.Now()), nil
+}
+
+// Получение отсортированного по имени и типу списка метрик, удовлетворяющих фильтрам запроса.
+// Не блокирует писателей
+func (ms *MemStorage) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	if query.MType != "" && query.MType != "gauge" && query.MType != "counter" {
+		return nil, storageerrors.NewTypeMismatchError("", query.MType)
+	}
+	var re *regexp.Regexp
+	if query.Regex != "" {
+		var err error
+		re, err = regexp.Compile(query.Regex)
+		if err != nil {
+			return nil, storageerrors.NewInvalidMetricError(query.Regex, "invalid regex")
+		}
+	}
+
+	match := func(mType, id string) bool {
+		if query.MType != "" && mType != query.MType {
+			return false
+		}
+		if !strings.HasPrefix(id, query.Prefix) {
+			return false
+		}
+		if query.After != nil && metrics.CompareKeys(id, mType, query.After.ID, query.After.MType) <= 0 {
+			return false
+		}
+		return re == nil || re.MatchString(id)
+	}
+
+	var metricList []metrics.Metric
+	readShards(ms.shards, func() {
+		metricList = ms.list(match)
+	})
+
+	slices.SortFunc(metricList, func(a, b metrics.Metric) int {
+		return metrics.CompareKeys(a.ID, a.MType, b.ID, b.MType)
+	})
+	if query.Limit > 0 && len(metricList) > query.Limit {
+		metricList = metricList[:query.Limit]
+	}
+	return metricList, nil
+}
+
+func (ms *MemStorage) list(match func(mType, id string) bool) []metrics.Metric {
+	metricList := []metrics.Metric{}
+	for _, sh := range ms.shards {
+		for key, cell := range *sh.gauges.Load() {
+			if match("gauge", key) {
+				val := math.Float64frombits(cell.bits.Load())
+				metricList = append(metricList, metrics.Metric{ID: key, MType: "gauge", Value: &val})
+			}
+		}
+		for key, cell := range *sh.counters.Load() {
+			if match("counter", key) {
+				val := cell.value.Load()
+				metricList = append(metricList, metrics.Metric{ID: key, MType: "counter", Delta: &val})
+			}
+		}
+	}
+	return metricList
+}
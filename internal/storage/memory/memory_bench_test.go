@@ -0,0 +1,217 @@
+package memstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ry461ch/metric-collector/internal/app/server/handlers"
+	config "github.com/ry461ch/metric-collector/internal/config/server"
+	"github.com/ry461ch/metric-collector/internal/fileworker"
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+// Предыдущая реализация хранилища с двумя глобальными мьютексами,
+// оставлена только для сравнения в бенчмарках
+type mutexStorage struct {
+	unsupportedOps
+
+	counterMutex sync.RWMutex
+	counter      map[string]int64
+	gaugeMutex   sync.RWMutex
+	gauge        map[string]float64
+}
+
+func newMutexStorage() *mutexStorage {
+	return &mutexStorage{counter: map[string]int64{}, gauge: map[string]float64{}}
+}
+
+func (ms *mutexStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	for _, metric := range metricList {
+		if err := validateMetric(metric); err != nil {
+			return err
+		}
+		switch metric.MType {
+		case "gauge":
+			ms.gaugeMutex.Lock()
+			ms.gauge[metric.ID] = *metric.Value
+			ms.gaugeMutex.Unlock()
+		case "counter":
+			ms.counterMutex.Lock()
+			ms.counter[metric.ID] += *metric.Delta
+			ms.counterMutex.Unlock()
+		}
+	}
+	return nil
+}
+
+func (ms *mutexStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
+	metricList := []metrics.Metric{}
+	ms.gaugeMutex.RLock()
+	for key, val := range ms.gauge {
+		metricList = append(metricList, metrics.Metric{ID: key, MType: "gauge", Value: &val})
+	}
+	ms.gaugeMutex.RUnlock()
+	ms.counterMutex.RLock()
+	for key, val := range ms.counter {
+		metricList = append(metricList, metrics.Metric{ID: key, MType: "counter", Delta: &val})
+	}
+	ms.counterMutex.RUnlock()
+	return metricList, nil
+}
+
+func (ms *mutexStorage) GetMetric(ctx context.Context, metric *metrics.Metric) error {
+	switch metric.MType {
+	case "gauge":
+		ms.gaugeMutex.RLock()
+		val, ok := ms.gauge[metric.ID]
+		ms.gaugeMutex.RUnlock()
+		if !ok {
+			return storageerrors.ErrNotFound
+		}
+		metric.Value = &val
+	case "counter":
+		ms.counterMutex.RLock()
+		val, ok := ms.counter[metric.ID]
+		ms.counterMutex.RUnlock()
+		if !ok {
+			return storageerrors.ErrNotFound
+		}
+		metric.Delta = &val
+	default:
+		return storageerrors.NewTypeMismatchError(metric.ID, metric.MType)
+	}
+	return nil
+}
+
+// Хранилище, которое можно передать в хэндлеры
+type benchStorage interface {
+	handlers.Storage
+}
+
+// Заглушки для операций, которые в бенчмарках не участвуют
+type unsupportedOps struct{}
+
+func (unsupportedOps) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	return errors.ErrUnsupported
+}
+
+func (unsupportedOps) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (unsupportedOps) ResetCounter(ctx context.Context, id string) error {
+	return errors.ErrUnsupported
+}
+
+func (unsupportedOps) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func (unsupportedOps) CounterRate(ctx context.Context, id string) (float64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (unsupportedOps) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func benchStorages() []struct {
+	name       string
+	newStorage func() benchStorage
+} {
+	return []struct {
+		name       string
+		newStorage func() benchStorage
+	}{
+		{name: "mutex", newStorage: func() benchStorage { return newMutexStorage() }},
+		{name: "sharded", newStorage: func() benchStorage {
+			storage := New()
+			storage.Initialize(context.TODO())
+			return storage
+		}},
+	}
+}
+
+// Пачка, похожая на то, что присылает агент: gauge метрики и PollCount
+func benchBatch(seed int, size int) []metrics.Metric {
+	metricList := make([]metrics.Metric, 0, size)
+	for i := 0; i < size-1; i++ {
+		value := float64(seed + i)
+		metricList = append(metricList, metrics.Metric{ID: "Gauge" + strconv.Itoa((seed+i)%500), MType: "gauge", Value: &value})
+	}
+	delta := int64(1)
+	metricList = append(metricList, metrics.Metric{ID: "PollCount", MType: "counter", Delta: &delta})
+	return metricList
+}
+
+func BenchmarkSaveMetricsParallel(b *testing.B) {
+	for _, bs := range benchStorages() {
+		b.Run(bs.name, func(b *testing.B) {
+			storage := bs.newStorage()
+			var seed atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				metricList := benchBatch(int(seed.Add(1)), 30)
+				for pb.Next() {
+					storage.SaveMetrics(context.Background(), metricList)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkReadWriteParallel(b *testing.B) {
+	for _, bs := range benchStorages() {
+		b.Run(bs.name, func(b *testing.B) {
+			storage := bs.newStorage()
+			storage.SaveMetrics(context.Background(), benchBatch(0, 500))
+			var seed atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				worker := int(seed.Add(1))
+				metricList := benchBatch(worker, 30)
+				i := 0
+				for pb.Next() {
+					if worker%2 == 0 {
+						storage.SaveMetrics(context.Background(), metricList)
+					} else {
+						metric := metrics.Metric{ID: "Gauge" + strconv.Itoa(i%500), MType: "gauge"}
+						storage.GetMetric(context.Background(), &metric)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkUpdatesHandlerParallel(b *testing.B) {
+	for _, bs := range benchStorages() {
+		b.Run(bs.name, func(b *testing.B) {
+			storage := bs.newStorage()
+			fileWorker := fileworker.New("", storage)
+			metricHandlers := handlers.New(&config.Config{StoreInterval: 1}, storage, fileWorker)
+
+			var seed atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				body, _ := json.Marshal(benchBatch(int(seed.Add(1)), 30))
+				for pb.Next() {
+					req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+					res := httptest.NewRecorder()
+					metricHandlers.PostMetricsHandler(res, req)
+					if res.Code != http.StatusOK {
+						b.Errorf("unexpected status %d", res.Code)
+					}
+				}
+			})
+		})
+	}
+}
@@ -2,11 +2,15 @@ package memstorage
 
 import (
 	"context"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/storage/storagetest"
 )
 
 func TestGauge(t *testing.T) {
@@ -137,3 +141,88 @@ func TestExtractAll(t *testing.T) {
 
 	assert.Equal(t, 2, len(resultMetrics), "Кол-во метрик не совпадает с ожидаемым")
 }
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		storage := New()
+		storage.Initialize(context.TODO())
+		return storage
+	})
+}
+
+func TestDedupWindow(t *testing.T) {
+	storage := New()
+	storage.Initialize(context.TODO())
+	storage.SetDedupWindow(20 * time.Millisecond)
+
+	delta := int64(1)
+	batch := metrics.BatchKey{AgentID: "agent", BatchID: "1"}
+	metricList := []metrics.Metric{{ID: "test", MType: "counter", Delta: &delta}}
+
+	applied, err := storage.SaveBatch(context.TODO(), batch, metricList)
+	assert.NoError(t, err)
+	assert.True(t, applied)
+	applied, _ = storage.SaveBatch(context.TODO(), batch, metricList)
+	assert.False(t, applied)
+
+	time.Sleep(30 * time.Millisecond)
+	applied, _ = storage.SaveBatch(context.TODO(), batch, metricList)
+	assert.True(t, applied, "по истечении окна пачка применяется заново")
+}
+
+func TestDedupWindowPrunesAgents(t *testing.T) {
+	storage := New()
+	storage.Initialize(context.TODO())
+	storage.SetDedupWindow(20 * time.Millisecond)
+
+	delta := int64(1)
+	metricList := []metrics.Metric{{ID: "test", MType: "counter", Delta: &delta}}
+	for _, agentID := range []string{"first", "second"} {
+		applied, err := storage.SaveBatch(context.TODO(), metrics.BatchKey{AgentID: agentID, BatchID: "1"}, metricList)
+		require.NoError(t, err)
+		assert.True(t, applied)
+	}
+	assert.Len(t, storage.batches, 2)
+
+	time.Sleep(30 * time.Millisecond)
+	applied, err := storage.SaveBatch(context.TODO(), metrics.BatchKey{AgentID: "third", BatchID: "1"}, metricList)
+	require.NoError(t, err)
+	assert.True(t, applied)
+	assert.Len(t, storage.batches, 1, "окна агентов без свежих пачек удаляются")
+}
+
+func TestReadersSeeWholeBatches(t *testing.T) {
+	ctx := context.TODO()
+	storage := New()
+	storage.Initialize(ctx)
+
+	// счетчики одной пачки попадают в разные шарды
+	delta := int64(1)
+	batch := []metrics.Metric{}
+	for i := 0; i < 256; i++ {
+		batch = append(batch, metrics.Metric{ID: "counter" + strconv.Itoa(i), MType: "counter", Delta: &delta})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			storage.SaveMetrics(ctx, batch)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		metricList, err := storage.ExtractMetrics(ctx)
+		require.NoError(t, err)
+		values := map[int64]int{}
+		for _, metric := range metricList {
+			values[*metric.Delta]++
+		}
+		require.LessOrEqual(t, len(values), 1, "пачка видна читателю частично: %v", values)
+	}
+}
@@ -4,17 +4,34 @@ package pgstorage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
 	"github.com/ry461ch/metric-collector/pkg/logging"
 )
 
+var errNotReachable = errors.New("database is not reachable")
+
 // Хранилище метрик в постгресе
 type PGStorage struct {
-	dsn string
-	db  *sql.DB
+	dsn         string
+	db          *sql.DB
+	rateWindow  time.Duration
+	dedupWindow time.Duration
 }
 
 // Get default DDL for pg storage. Could be used several times
@@ -41,17 +58,61 @@ func getDDL() string {
 
 		CREATE INDEX IF NOT EXISTS counter_metrics_created_at_idx ON content.counter_metrics(created_at);
 		CREATE INDEX IF NOT EXISTS counter_metrics_updated_at_idx ON content.counter_metrics(updated_at);
+
+		ALTER TABLE content.counter_metrics ADD COLUMN IF NOT EXISTS rate_base_delta BIGINT;
+		ALTER TABLE content.counter_metrics ADD COLUMN IF NOT EXISTS rate_base_at TIMESTAMPTZ;
+		ALTER TABLE content.counter_metrics ADD COLUMN IF NOT EXISTS rate_mid_delta BIGINT;
+		ALTER TABLE content.counter_metrics ADD COLUMN IF NOT EXISTS rate_mid_at TIMESTAMPTZ;
+
+		CREATE TABLE IF NOT EXISTS content.applied_batches (
+			agent_id VARCHAR(255) NOT NULL,
+			batch_id VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (agent_id, batch_id)
+		);
 	`
 }
 
+// Wrap connection problems into storageerrors.ErrUnavailable, so callers can retry
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code) {
+		return storageerrors.Unavailable(err)
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) || errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return storageerrors.Unavailable(err)
+	}
+	return err
+}
+
 // Get db instance
 func New(DBDsn string) *PGStorage {
 	return &PGStorage{
-		dsn: DBDsn,
-		db:  nil,
+		dsn:         DBDsn,
+		db:          nil,
+		rateWindow:  metrics.DefaultRateWindow,
+		dedupWindow: metrics.DefaultDedupWindow,
 	}
 }
 
+// Set window for counter rate computation
+func (pg *PGStorage) SetRateWindow(window time.Duration) {
+	pg.rateWindow = window
+}
+
+// Set window for dropping retried batches
+func (pg *PGStorage) SetDedupWindow(window time.Duration) {
+	pg.dedupWindow = window
+}
+
 // Init db instance
 func (pg *PGStorage) Initialize(ctx context.Context) error {
 	db, err := sql.Open("pgx", pg.dsn)
@@ -99,41 +160,89 @@ func (pg *PGStorage) Ping(ctx context.Context) bool {
 
 // Save metrics in pg storage
 func (pg *PGStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metric) error {
-	if !pg.Ping(ctx) {
-		return errors.New("DATABASE_UNAVAILABLE")
+	_, err := pg.saveMetrics(ctx, nil, metricList)
+	return err
+}
+
+// Save batch of metrics exactly once: batch key is registered in the same transaction,
+// so retried batch within dedup window is acknowledged without applying it again
+func (pg *PGStorage) SaveBatch(ctx context.Context, batch metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	return pg.saveMetrics(ctx, &batch, metricList)
+}
+
+// Register batch key in transaction, returns false if batch was already applied
+func (pg *PGStorage) registerBatch(ctx context.Context, tx *sql.Tx, batch metrics.BatchKey) (bool, error) {
+	_, err := tx.ExecContext(ctx,
+		`DELETE FROM content.applied_batches WHERE agent_id = $1 AND applied_at < CURRENT_TIMESTAMP - make_interval(secs => $2);`,
+		batch.AgentID, pg.dedupWindow.Seconds())
+	if err != nil {
+		return false, err
 	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO content.applied_batches (agent_id, batch_id) VALUES ($1, $2) ON CONFLICT (agent_id, batch_id) DO NOTHING;`,
+		batch.AgentID, batch.BatchID)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	return inserted == 1, err
+}
+
+// Validate metrics and group them by type: last gauge value wins, counter deltas are summed
+func groupMetrics(metricList []metrics.Metric) (map[string]float64, map[string]int64, error) {
 	gaugeMetrics := map[string]float64{}
 	counterMetrics := map[string]int64{}
 
-	// prepare arrays
 	for _, metric := range metricList {
 		if metric.ID == "" {
-			return errors.New("INVALID_METRIC")
+			return nil, nil, storageerrors.NewInvalidMetricError(metric.ID, "id is required")
 		}
 		if metric.MType == "" {
-			return errors.New("INVALID_METRIC")
+			return nil, nil, storageerrors.NewInvalidMetricError(metric.ID, "type is required")
 		}
 
 		switch metric.MType {
 		case "gauge":
 			if metric.Value == nil {
-				return errors.New("INVALID_METRIC")
+				return nil, nil, storageerrors.NewInvalidMetricError(metric.ID, "value is required for gauge")
 			}
 			gaugeMetrics[metric.ID] = *metric.Value
 		case "counter":
 			if metric.Delta == nil {
-				return errors.New("INVALID_METRIC")
+				return nil, nil, storageerrors.NewInvalidMetricError(metric.ID, "delta is required for counter")
 			}
 			counterMetrics[metric.ID] += *metric.Delta
 		default:
-			return errors.New("INVALID_METRIC")
+			return nil, nil, storageerrors.NewInvalidMetricError(metric.ID, "unknown type "+metric.MType)
 		}
 	}
+	return gaugeMetrics, counterMetrics, nil
+}
+
+func (pg *PGStorage) saveMetrics(ctx context.Context, batch *metrics.BatchKey, metricList []metrics.Metric) (bool, error) {
+	if !pg.Ping(ctx) {
+		return false, storageerrors.Unavailable(errNotReachable)
+	}
+	gaugeMetrics, counterMetrics, err := groupMetrics(metricList)
+	if err != nil {
+		return false, err
+	}
 
 	// begin trx
-	tx, err := pg.db.Begin()
+	tx, err := pg.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return false, wrapError(err)
+	}
+	defer tx.Rollback()
+
+	if batch != nil {
+		applied, err := pg.registerBatch(ctx, tx, *batch)
+		if err != nil {
+			return false, wrapError(err)
+		}
+		if !applied {
+			return false, nil
+		}
 	}
 
 	// insert gauge values
@@ -143,44 +252,107 @@ func (pg *PGStorage) SaveMetrics(ctx context.Context, metricList []metrics.Metri
 			  SET value = $2, updated_at = CURRENT_TIMESTAMP;`
 	stmt, err := tx.PrepareContext(ctx, gaugeQuery)
 	if err != nil {
-		return err
+		return false, wrapError(err)
 	}
 	for key, val := range gaugeMetrics {
 		_, err = stmt.ExecContext(ctx, key, val)
 		if err != nil {
-			return err
+			return false, wrapError(err)
 		}
 	}
 
-	// insert counter values
-	counterQuery := `INSERT INTO content.counter_metrics (name, delta) 
-			  VALUES ($1, $2)
+	// insert counter values, rate window is moved in the same way as metrics.RateWindow.Observe does:
+	// negative delta resets the window, otherwise window is shifted when it is older than $3 seconds
+	counterQuery := `INSERT INTO content.counter_metrics (name, delta, rate_mid_delta, rate_mid_at) 
+			  VALUES ($1, $2, $2, CURRENT_TIMESTAMP)
 			  ON CONFLICT (name) DO UPDATE
-			  SET delta = counter_metrics.delta + $2, updated_at = CURRENT_TIMESTAMP;`
+			  SET delta = counter_metrics.delta + $2,
+			  rate_base_delta = CASE
+				WHEN $2 < 0 THEN NULL
+				WHEN counter_metrics.rate_mid_at IS NULL OR CURRENT_TIMESTAMP - counter_metrics.rate_mid_at >= make_interval(secs => $3) THEN counter_metrics.rate_mid_delta
+				ELSE counter_metrics.rate_base_delta END,
+			  rate_base_at = CASE
+				WHEN $2 < 0 THEN NULL
+				WHEN counter_metrics.rate_mid_at IS NULL OR CURRENT_TIMESTAMP - counter_metrics.rate_mid_at >= make_interval(secs => $3) THEN counter_metrics.rate_mid_at
+				ELSE counter_metrics.rate_base_at END,
+			  rate_mid_delta = CASE
+				WHEN $2 < 0 OR counter_metrics.rate_mid_at IS NULL OR CURRENT_TIMESTAMP - counter_metrics.rate_mid_at >= make_interval(secs => $3) THEN counter_metrics.delta + $2
+				ELSE counter_metrics.rate_mid_delta END,
+			  rate_mid_at = CASE
+				WHEN $2 < 0 OR counter_metrics.rate_mid_at IS NULL OR CURRENT_TIMESTAMP - counter_metrics.rate_mid_at >= make_interval(secs => $3) THEN CURRENT_TIMESTAMP
+				ELSE counter_metrics.rate_mid_at END,
+			  updated_at = CURRENT_TIMESTAMP;`
 	stmt, err = tx.PrepareContext(ctx, counterQuery)
 	if err != nil {
-		return err
+		return false, wrapError(err)
 	}
 	for key, val := range counterMetrics {
-		_, err = stmt.ExecContext(ctx, key, val)
+		_, err = stmt.ExecContext(ctx, key, val, pg.rateWindow.Seconds())
 		if err != nil {
-			return err
+			return false, wrapError(err)
 		}
 	}
 
 	// commit trx
 	err = tx.Commit()
+	if err != nil {
+		return false, wrapError(err)
+	}
+
+	return true, nil
+}
+
+// Replace all stored metrics with metricList in one transaction, e.g. with snapshot from primary.
+// Readers see either old or new content, rate windows start from the replaced values
+func (pg *PGStorage) ReplaceMetrics(ctx context.Context, metricList []metrics.Metric) error {
+	if !pg.Ping(ctx) {
+		return storageerrors.Unavailable(errNotReachable)
+	}
+	gaugeMetrics, counterMetrics, err := groupMetrics(metricList)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"content.gauge_metrics", "content.counter_metrics"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return wrapError(err)
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO content.gauge_metrics (name, value) VALUES ($1, $2);`)
+	if err != nil {
+		return wrapError(err)
+	}
+	for key, val := range gaugeMetrics {
+		if _, err := stmt.ExecContext(ctx, key, val); err != nil {
+			return wrapError(err)
+		}
+	}
+
+	stmt, err = tx.PrepareContext(ctx, `INSERT INTO content.counter_metrics (name, delta, rate_mid_delta, rate_mid_at)
+			  VALUES ($1, $2, $2, CURRENT_TIMESTAMP);`)
+	if err != nil {
+		return wrapError(err)
+	}
+	for key, val := range counterMetrics {
+		if _, err := stmt.ExecContext(ctx, key, val); err != nil {
+			return wrapError(err)
+		}
+	}
+
+	return wrapError(tx.Commit())
 }
 
 // Extract all metrics
 func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, error) {
 	if !pg.Ping(ctx) {
-		return nil, errors.New("DATABASE_UNAVAILABLE")
+		return nil, storageerrors.Unavailable(errNotReachable)
 	}
 	metricList := make([]metrics.Metric, 0)
 
@@ -188,7 +360,7 @@ func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, erro
 	getGaugeQuery := "SELECT name, value FROM content.gauge_metrics"
 	rows, err := pg.db.QueryContext(ctx, getGaugeQuery)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	defer rows.Close()
 
@@ -197,7 +369,7 @@ func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, erro
 		var val float64
 		err = rows.Scan(&key, &val)
 		if err != nil {
-			return nil, err
+			return nil, wrapError(err)
 		}
 
 		metricList = append(metricList, metrics.Metric{
@@ -209,14 +381,14 @@ func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, erro
 
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
 	// get counter metrics
 	getCounterQuery := "SELECT name, delta FROM content.counter_metrics"
 	rows, err = pg.db.QueryContext(ctx, getCounterQuery)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	defer rows.Close()
 
@@ -225,7 +397,7 @@ func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, erro
 		var val int64
 		err = rows.Scan(&key, &val)
 		if err != nil {
-			return nil, err
+			return nil, wrapError(err)
 		}
 
 		metricList = append(metricList, metrics.Metric{
@@ -237,7 +409,7 @@ func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, erro
 
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
 	return metricList, nil
@@ -246,7 +418,7 @@ func (pg *PGStorage) ExtractMetrics(ctx context.Context) ([]metrics.Metric, erro
 // Get one metric by input name and type
 func (pg *PGStorage) GetMetric(ctx context.Context, metric *metrics.Metric) error {
 	if !pg.Ping(ctx) {
-		return errors.New("DATABASE_UNAVAILABLE")
+		return storageerrors.Unavailable(errNotReachable)
 	}
 	switch metric.MType {
 	case "gauge":
@@ -254,8 +426,14 @@ func (pg *PGStorage) GetMetric(ctx context.Context, metric *metrics.Metric) erro
 		row := pg.db.QueryRowContext(ctx, query, metric.ID)
 		var value sql.NullFloat64
 		err := row.Scan(&value)
-		if err == sql.ErrNoRows || !value.Valid {
-			return errors.New("NOT_FOUND")
+		if errors.Is(err, sql.ErrNoRows) {
+			return storageerrors.ErrNotFound
+		}
+		if err != nil {
+			return wrapError(err)
+		}
+		if !value.Valid {
+			return storageerrors.ErrNotFound
 		}
 		metric.Value = &value.Float64
 	case "counter":
@@ -263,12 +441,360 @@ func (pg *PGStorage) GetMetric(ctx context.Context, metric *metrics.Metric) erro
 		row := pg.db.QueryRowContext(ctx, query, metric.ID)
 		var value sql.NullInt64
 		err := row.Scan(&value)
-		if err == sql.ErrNoRows || !value.Valid {
-			return errors.New("NOT_FOUND")
+		if errors.Is(err, sql.ErrNoRows) {
+			return storageerrors.ErrNotFound
+		}
+		if err != nil {
+			return wrapError(err)
+		}
+		if !value.Valid {
+			return storageerrors.ErrNotFound
 		}
 		metric.Delta = &value.Int64
 	default:
-		return errors.New("INVALID_METRIC_TYPE")
+		return storageerrors.NewTypeMismatchError(metric.ID, metric.MType)
+	}
+	return nil
+}
+
+var metricTables = []struct {
+	mType string
+	name  string
+}{
+	{mType: "gauge", name: "content.gauge_metrics"},
+	{mType: "counter", name: "content.counter_metrics"},
+}
+
+// Delete metrics which retention expired at now. Policy is translated into one DELETE per table:
+// CASE picks cutoff of the first matching rule, so order of rules is kept, metrics without rule have NULL cutoff.
+// Both tables are cleaned in one transaction
+func (pg *PGStorage) EvictMetrics(ctx context.Context, policy retention.Policy, now time.Time) (int, error) {
+	if policy.IsEmpty() {
+		return 0, nil
+	}
+	if !pg.Ping(ctx) {
+		return 0, storageerrors.Unavailable(errNotReachable)
+	}
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	defer tx.Rollback()
+
+	evicted := 0
+	for _, table := range metricTables {
+		cases := []string{}
+		args := []any{}
+		for _, rule := range policy.Rules {
+			if rule.MType != "" && rule.MType != "*" && rule.MType != table.mType {
+				continue
+			}
+			args = append(args, globToRegex(rule.Pattern), now.Add(-rule.TTL))
+			cases = append(cases, fmt.Sprintf("WHEN name ~ $%d THEN $%d::TIMESTAMPTZ", len(args)-1, len(args)))
+		}
+		if len(cases) == 0 {
+			continue
+		}
+		query := "DELETE FROM " + table.name + " WHERE updated_at < CASE " + strings.Join(cases, " ") + " END"
+		tableEvicted, err := execAffected(ctx, tx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		evicted += tableEvicted
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, wrapError(err)
+	}
+	return evicted, nil
+}
+
+// Exec query in transaction and return number of affected rows
+func execAffected(ctx context.Context, tx *sql.Tx, query string, args ...any) (int, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	return int(affected), nil
+}
+
+// Translate path.Match pattern into anchored postgres regex with the same meaning:
+// * and ? don't match '/', character classes are copied with escaped members.
+// Pattern must be already validated by path.Match
+func globToRegex(pattern string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			b.WriteByte('[')
+			i++
+			if pattern[i] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+			for ; pattern[i] != ']'; i++ {
+				c := pattern[i]
+				switch {
+				case c == '\\':
+					i++
+					writeClassByte(&b, pattern[i])
+				case c == '-':
+					b.WriteByte('-')
+				default:
+					writeClassByte(&b, c)
+				}
+			}
+			b.WriteByte(']')
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// Write member of character class, ascii punctuation is escaped to be taken literally
+func writeClassByte(b *strings.Builder, c byte) {
+	isAlnum := c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	if c < utf8.RuneSelf && !isAlnum {
+		b.WriteByte('\\')
+	}
+	b.WriteByte(c)
+}
+
+// Delete one metric. Last value of deleted metric is written into metric
+func (pg *PGStorage) DeleteMetric(ctx context.Context, metric *metrics.Metric) error {
+	if !pg.Ping(ctx) {
+		return storageerrors.Unavailable(errNotReachable)
+	}
+	switch metric.MType {
+	case "gauge":
+		query := "DELETE FROM content.gauge_metrics WHERE name = $1 RETURNING value"
+		var value float64
+		err := pg.db.QueryRowContext(ctx, query, metric.ID).Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			return storageerrors.ErrNotFound
+		}
+		if err != nil {
+			return wrapError(err)
+		}
+		metric.Value = &value
+	case "counter":
+		query := "DELETE FROM content.counter_metrics WHERE name = $1 RETURNING delta"
+		var delta int64
+		err := pg.db.QueryRowContext(ctx, query, metric.ID).Scan(&delta)
+		if errors.Is(err, sql.ErrNoRows) {
+			return storageerrors.ErrNotFound
+		}
+		if err != nil {
+			return wrapError(err)
+		}
+		metric.Delta = &delta
+	default:
+		return storageerrors.NewTypeMismatchError(metric.ID, metric.MType)
+	}
+	return nil
+}
+
+// Delete all metrics of type mType (empty type means any type) with names matching
+// path.Match pattern. Returns number of deleted metrics
+func (pg *PGStorage) DeleteMetrics(ctx context.Context, mType string, pattern string) (int, error) {
+	if mType != "" && mType != "gauge" && mType != "counter" {
+		return 0, storageerrors.NewTypeMismatchError(pattern, mType)
+	}
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return 0, storageerrors.NewInvalidMetricError(pattern, "invalid name pattern")
+	}
+
+	if !pg.Ping(ctx) {
+		return 0, storageerrors.Unavailable(errNotReachable)
+	}
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	for _, table := range metricTables {
+		if mType != "" && mType != table.mType {
+			continue
+		}
+		tableDeleted, err := execAffected(ctx, tx, "DELETE FROM "+table.name+" WHERE name ~ $1", globToRegex(pattern))
+		if err != nil {
+			return 0, err
+		}
+		deleted += tableDeleted
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, wrapError(err)
+	}
+	return deleted, nil
+}
+
+// Reset counter metric value to zero
+func (pg *PGStorage) ResetCounter(ctx context.Context, id string) error {
+	if !pg.Ping(ctx) {
+		return storageerrors.Unavailable(errNotReachable)
+	}
+	query := `UPDATE content.counter_metrics
+		SET delta = 0, updated_at = CURRENT_TIMESTAMP,
+		rate_base_delta = NULL, rate_base_at = NULL, rate_mid_delta = 0, rate_mid_at = CURRENT_TIMESTAMP
+		WHERE name = $1`
+	res, err := pg.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return wrapError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(err)
+	}
+	if affected == 0 {
+		return storageerrors.ErrNotFound
 	}
 	return nil
 }
+
+// Get counter growth rate per second
+func (pg *PGStorage) CounterRate(ctx context.Context, id string) (float64, error) {
+	if !pg.Ping(ctx) {
+		return 0, storageerrors.Unavailable(errNotReachable)
+	}
+	query := `SELECT delta, rate_base_delta, rate_base_at, rate_mid_delta, rate_mid_at, CURRENT_TIMESTAMP
+		FROM content.counter_metrics WHERE name = $1`
+	var delta int64
+	var baseDelta, midDelta sql.NullInt64
+	var baseAt, midAt sql.NullTime
+	var now time.Time
+	err := pg.db.QueryRowContext(ctx, query, id).Scan(&delta, &baseDelta, &baseAt, &midDelta, &midAt, &now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storageerrors.ErrNotFound
+	}
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	if !midDelta.Valid || !midAt.Valid {
+		// counter was written before rate tracking was enabled
+		return 0, nil
+	}
+
+	window := metrics.RateWindow{MidValue: midDelta.Int64, MidAt: midAt.Time}
+	if baseDelta.Valid && baseAt.Valid {
+		window.HasBase = true
+		window.BaseValue = baseDelta.Int64
+		window.BaseAt = baseAt.Time
+	}
+	return window.Rate(delta, now), nil
+}
+
+// Escape LIKE wildcards in prefix
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Build query for metrics listing. Filtering, ordering and pagination are done by db
+// (filters on the union are pushed down to both tables by planner),
+// names are compared bytewise (COLLATE "C") to keep order consistent with cursor
+func buildListQuery(query metrics.ListQuery) (string, []any) {
+	args := []any{}
+	arg := func(val any) string {
+		args = append(args, val)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	conditions := []string{}
+	if query.Prefix != "" {
+		conditions = append(conditions, `name LIKE `+arg(escapeLike(query.Prefix)+"%"))
+	}
+	if query.Regex != "" {
+		conditions = append(conditions, `name ~ `+arg(query.Regex))
+	}
+	if query.After != nil {
+		name := arg(query.After.ID)
+		conditions = append(conditions,
+			`(name COLLATE "C" > `+name+` OR (name = `+name+` AND type > `+arg(query.After.MType)+`))`)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	selects := []string{}
+	if query.MType == "" || query.MType == "counter" {
+		selects = append(selects, `SELECT name, 'counter' AS type, NULL::DOUBLE PRECISION AS value, delta FROM content.counter_metrics`)
+	}
+	if query.MType == "" || query.MType == "gauge" {
+		selects = append(selects, `SELECT name, 'gauge' AS type, value, NULL::BIGINT AS delta FROM content.gauge_metrics`)
+	}
+
+	sqlQuery := "SELECT name, type, value, delta FROM (" + strings.Join(selects, " UNION ALL ") + ") AS m" +
+		where + ` ORDER BY name COLLATE "C", type`
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT " + arg(query.Limit)
+	}
+	return sqlQuery, args
+}
+
+// List metrics sorted by name and type, filtered by query
+func (pg *PGStorage) ListMetrics(ctx context.Context, query metrics.ListQuery) ([]metrics.Metric, error) {
+	if query.MType != "" && query.MType != "gauge" && query.MType != "counter" {
+		return nil, storageerrors.NewTypeMismatchError("", query.MType)
+	}
+	if query.Regex != "" {
+		if _, err := regexp.Compile(query.Regex); err != nil {
+			return nil, storageerrors.NewInvalidMetricError(query.Regex, "invalid regex")
+		}
+	}
+	if !pg.Ping(ctx) {
+		return nil, storageerrors.Unavailable(errNotReachable)
+	}
+
+	sqlQuery, args := buildListQuery(query)
+	rows, err := pg.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.InvalidRegularExpression {
+			return nil, storageerrors.NewInvalidMetricError(query.Regex, "invalid regex")
+		}
+		return nil, wrapError(err)
+	}
+	defer rows.Close()
+
+	metricList := []metrics.Metric{}
+	for rows.Next() {
+		var metric metrics.Metric
+		var value sql.NullFloat64
+		var delta sql.NullInt64
+		err = rows.Scan(&metric.ID, &metric.MType, &value, &delta)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if value.Valid {
+			metric.Value = &value.Float64
+		}
+		if delta.Valid {
+			metric.Delta = &delta.Int64
+		}
+		metricList = append(metricList, metric)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return metricList, nil
+}
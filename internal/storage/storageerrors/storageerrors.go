@@ -0,0 +1,67 @@
+// Module with errors returned by metric storages
+package storageerrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ошибки хранилищ метрик. Хранилища оборачивают их через %w,
+// поэтому проверять ошибки нужно через errors.Is / errors.As
+var (
+	// ErrInvalidMetric - метрика не прошла валидацию
+	ErrInvalidMetric = errors.New("INVALID_METRIC")
+	// ErrNotFound - метрика не найдена в хранилище
+	ErrNotFound = errors.New("NOT_FOUND")
+	// ErrTypeMismatch - тип метрики не gauge и не counter
+	ErrTypeMismatch = errors.New("INVALID_METRIC_TYPE")
+	// ErrUnavailable - хранилище временно недоступно, запрос можно повторить
+	ErrUnavailable = errors.New("STORAGE_UNAVAILABLE")
+)
+
+// InvalidMetricError - ошибка валидации метрики с указанием причины
+type InvalidMetricError struct {
+	ID     string
+	Reason string
+}
+
+// Текст ошибки валидации
+func (e *InvalidMetricError) Error() string {
+	return fmt.Sprintf("%s: metric %q: %s", ErrInvalidMetric, e.ID, e.Reason)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrInvalidMetric)
+func (e *InvalidMetricError) Unwrap() error {
+	return ErrInvalidMetric
+}
+
+// TypeMismatchError - ошибка неизвестного типа метрики
+type TypeMismatchError struct {
+	ID    string
+	MType string
+}
+
+// Текст ошибки несоответствия типа
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%s: metric %q has type %q", ErrTypeMismatch, e.ID, e.MType)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrTypeMismatch)
+func (e *TypeMismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+// Создание ошибки валидации метрики
+func NewInvalidMetricError(id string, reason string) error {
+	return &InvalidMetricError{ID: id, Reason: reason}
+}
+
+// Создание ошибки неизвестного типа метрики
+func NewTypeMismatchError(id string, mType string) error {
+	return &TypeMismatchError{ID: id, MType: mType}
+}
+
+// Оборачивание ошибки как ошибки недоступности хранилища
+func Unavailable(err error) error {
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
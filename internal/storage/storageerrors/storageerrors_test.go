@@ -0,0 +1,27 @@
+package storageerrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapping(t *testing.T) {
+	err := fmt.Errorf("save: %w", NewInvalidMetricError("test", "value is required for gauge"))
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+	assert.NotErrorIs(t, err, ErrTypeMismatch)
+
+	var invalidErr *InvalidMetricError
+	assert.True(t, errors.As(err, &invalidErr))
+	assert.Equal(t, "value is required for gauge", invalidErr.Reason)
+
+	err = NewTypeMismatchError("test", "invalid")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	assert.Equal(t, `INVALID_METRIC_TYPE: metric "test" has type "invalid"`, err.Error())
+
+	err = Unavailable(errors.New("connection refused"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, "STORAGE_UNAVAILABLE: connection refused", err.Error())
+}
@@ -0,0 +1,497 @@
+// Module with conformance tests for metric storages
+package storagetest
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ry461ch/metric-collector/internal/models/metrics"
+	"github.com/ry461ch/metric-collector/internal/models/retention"
+	"github.com/ry461ch/metric-collector/internal/storage/storageerrors"
+)
+
+// Factory - функция, создающая новое пустое и проинициализированное хранилище для каждого теста
+type Factory func(t *testing.T) Storage
+
+// Run запускает все проверки соответствия для хранилища, создаваемого фабрикой
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("CounterAccumulation", func(t *testing.T) {
+		testCounterAccumulation(t, newStorage(t))
+	})
+	t.Run("GaugeOverwrite", func(t *testing.T) {
+		testGaugeOverwrite(t, newStorage(t))
+	})
+	t.Run("InvalidMetric", func(t *testing.T) {
+		testInvalidMetric(t, newStorage(t))
+	})
+	t.Run("AtomicBatch", func(t *testing.T) {
+		testAtomicBatch(t, newStorage(t))
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		testConcurrency(t, newStorage(t))
+	})
+	t.Run("ExtractCompleteness", func(t *testing.T) {
+		testExtractCompleteness(t, newStorage(t))
+	})
+	t.Run("Eviction", func(t *testing.T) {
+		testEviction(t, newStorage(t))
+	})
+	t.Run("Delete", func(t *testing.T) {
+		testDelete(t, newStorage(t))
+	})
+	t.Run("DeleteByPattern", func(t *testing.T) {
+		testDeleteByPattern(t, newStorage(t))
+	})
+	t.Run("ResetCounter", func(t *testing.T) {
+		testResetCounter(t, newStorage(t))
+	})
+	t.Run("List", func(t *testing.T) {
+		testList(t, newStorage(t))
+	})
+	t.Run("CounterRate", func(t *testing.T) {
+		testCounterRate(t, newStorage(t))
+	})
+	t.Run("SaveBatch", func(t *testing.T) {
+		testSaveBatch(t, newStorage(t))
+	})
+	t.Run("Replace", func(t *testing.T) {
+		testReplace(t, newStorage(t))
+	})
+}
+
+func counter(id string, delta int64) metrics.Metric {
+	return metrics.Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+func gauge(id string, value float64) metrics.Metric {
+	return metrics.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func testCounterAccumulation(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 10)}))
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 12)}))
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 5), counter("test", 5)}))
+
+	searchMetric := metrics.Metric{ID: "test", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	require.NotNil(t, searchMetric.Delta)
+	assert.Equal(t, int64(32), *searchMetric.Delta, "неверно накапливается counter метрика")
+	assert.Nil(t, searchMetric.Value, "у counter метрики не должно быть value")
+}
+
+func testGaugeOverwrite(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{gauge("test", 10.0)}))
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{gauge("test", 12.5)}))
+
+	searchMetric := metrics.Metric{ID: "test", MType: "gauge"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	require.NotNil(t, searchMetric.Value)
+	assert.Equal(t, 12.5, *searchMetric.Value, "неверно обновляется gauge метрика")
+	assert.Nil(t, searchMetric.Delta, "у gauge метрики не должно быть delta")
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{gauge("test", 1.0), gauge("test", -3.5)}))
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, -3.5, *searchMetric.Value, "в пачке должно сохраниться последнее значение gauge")
+}
+
+func testInvalidMetric(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	delta := int64(1)
+	value := 1.0
+
+	invalidMetrics := []struct {
+		testName string
+		metric   metrics.Metric
+	}{
+		{testName: "empty id", metric: metrics.Metric{ID: "", MType: "counter", Delta: &delta}},
+		{testName: "empty type", metric: metrics.Metric{ID: "test", MType: "", Delta: &delta}},
+		{testName: "unknown type", metric: metrics.Metric{ID: "test", MType: "invalid", Value: &value}},
+		{testName: "gauge without value", metric: metrics.Metric{ID: "test", MType: "gauge", Delta: &delta}},
+		{testName: "counter without delta", metric: metrics.Metric{ID: "test", MType: "counter", Value: &value}},
+	}
+	for _, tc := range invalidMetrics {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := storage.SaveMetrics(ctx, []metrics.Metric{tc.metric})
+			assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+		})
+	}
+
+	notExistsMetric := metrics.Metric{ID: "unknown", MType: "gauge"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &notExistsMetric), storageerrors.ErrNotFound)
+	notExistsMetric = metrics.Metric{ID: "unknown", MType: "counter"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &notExistsMetric), storageerrors.ErrNotFound)
+
+	invalidTypeMetric := metrics.Metric{ID: "test", MType: "invalid"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &invalidTypeMetric), storageerrors.ErrTypeMismatch)
+}
+
+func testAtomicBatch(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	metricList := []metrics.Metric{
+		counter("valid_counter", 1),
+		gauge("valid_gauge", 1.0),
+		{ID: "invalid", MType: "gauge"},
+	}
+	assert.ErrorIs(t, storage.SaveMetrics(ctx, metricList), storageerrors.ErrInvalidMetric)
+
+	searchMetric := metrics.Metric{ID: "valid_counter", MType: "counter"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &searchMetric), storageerrors.ErrNotFound, "пачка с невалидной метрикой применилась частично")
+	searchMetric = metrics.Metric{ID: "valid_gauge", MType: "gauge"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &searchMetric), storageerrors.ErrNotFound, "пачка с невалидной метрикой применилась частично")
+}
+
+func testConcurrency(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	workers := 8
+	iterations := 50
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				metricList := []metrics.Metric{
+					counter("shared", 1),
+					gauge("gauge_"+strconv.Itoa(w), float64(i)),
+				}
+				assert.NoError(t, storage.SaveMetrics(ctx, metricList))
+
+				searchMetric := metrics.Metric{ID: "shared", MType: "counter"}
+				assert.NoError(t, storage.GetMetric(ctx, &searchMetric))
+				_, err := storage.ExtractMetrics(ctx)
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	searchMetric := metrics.Metric{ID: "shared", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(workers*iterations), *searchMetric.Delta, "потеряны конкурентные обновления counter метрики")
+
+	for w := 0; w < workers; w++ {
+		searchMetric := metrics.Metric{ID: "gauge_" + strconv.Itoa(w), MType: "gauge"}
+		require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+		assert.Equal(t, float64(iterations-1), *searchMetric.Value, "неверное значение gauge после конкурентных обновлений")
+	}
+}
+
+func testExtractCompleteness(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	resultMetrics, err := storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, resultMetrics, "новое хранилище должно быть пустым")
+
+	count := 20
+	metricList := []metrics.Metric{}
+	for i := 0; i < count; i++ {
+		metricList = append(metricList, counter("metric_"+strconv.Itoa(i), int64(i)))
+		metricList = append(metricList, gauge("metric_"+strconv.Itoa(i), float64(i)/2))
+	}
+	require.NoError(t, storage.SaveMetrics(ctx, metricList))
+
+	resultMetrics, err = storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, resultMetrics, 2*count, "Кол-во метрик не совпадает с ожидаемым")
+
+	counters := map[string]int64{}
+	gauges := map[string]float64{}
+	for _, metric := range resultMetrics {
+		switch metric.MType {
+		case "counter":
+			require.NotNil(t, metric.Delta)
+			counters[metric.ID] = *metric.Delta
+		case "gauge":
+			require.NotNil(t, metric.Value)
+			gauges[metric.ID] = *metric.Value
+		default:
+			t.Errorf("unexpected metric type %q", metric.MType)
+		}
+	}
+	for i := 0; i < count; i++ {
+		id := "metric_" + strconv.Itoa(i)
+		assert.Equal(t, int64(i), counters[id], "неверное значение counter метрики %s", id)
+		assert.Equal(t, float64(i)/2, gauges[id], "неверное значение gauge метрики %s", id)
+	}
+}
+
+func testEviction(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		counter("stale_counter", 1),
+		counter("fresh_counter", 1),
+		gauge("stale_gauge", 1.0),
+		gauge("fresh_gauge", 1.0),
+		gauge("kept/stale", 1.0),
+	}))
+
+	policy := retention.Policy{}
+	require.NoError(t, policy.Set("*_counter=1h,stale_*=1m"))
+	evicted, err := storage.EvictMetrics(ctx, policy, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, evicted, "свежие метрики не должны удаляться")
+
+	// stale_counter подходит под оба правила, применяется первое
+	evicted, err = storage.EvictMetrics(ctx, policy, time.Now().Add(10*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+
+	evicted, err = storage.EvictMetrics(ctx, policy, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+
+	searchMetric := metrics.Metric{ID: "stale_counter", MType: "counter"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &searchMetric), storageerrors.ErrNotFound)
+	searchMetric = metrics.Metric{ID: "stale_gauge", MType: "gauge"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &searchMetric), storageerrors.ErrNotFound)
+	searchMetric = metrics.Metric{ID: "fresh_gauge", MType: "gauge"}
+	assert.NoError(t, storage.GetMetric(ctx, &searchMetric), "метрика без правила хранится бессрочно")
+	searchMetric = metrics.Metric{ID: "kept/stale", MType: "gauge"}
+	assert.NoError(t, storage.GetMetric(ctx, &searchMetric), "* в шаблоне не совпадает с /")
+
+	// удаленный counter начинает накапливаться заново
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("stale_counter", 5)}))
+	searchMetric = metrics.Metric{ID: "stale_counter", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(5), *searchMetric.Delta)
+
+	resultMetrics, err := storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, resultMetrics, 3)
+}
+
+func testDelete(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 7), gauge("test", 1.5)}))
+
+	deleted := metrics.Metric{ID: "test", MType: "counter"}
+	require.NoError(t, storage.DeleteMetric(ctx, &deleted))
+	require.NotNil(t, deleted.Delta)
+	assert.Equal(t, int64(7), *deleted.Delta, "должно вернуться последнее значение удаленной метрики")
+
+	searchMetric := metrics.Metric{ID: "test", MType: "counter"}
+	assert.ErrorIs(t, storage.GetMetric(ctx, &searchMetric), storageerrors.ErrNotFound)
+	searchMetric = metrics.Metric{ID: "test", MType: "gauge"}
+	assert.NoError(t, storage.GetMetric(ctx, &searchMetric), "метрика другого типа с тем же именем не должна удаляться")
+
+	deleted = metrics.Metric{ID: "test", MType: "counter"}
+	assert.ErrorIs(t, storage.DeleteMetric(ctx, &deleted), storageerrors.ErrNotFound)
+	deleted = metrics.Metric{ID: "test", MType: "invalid"}
+	assert.ErrorIs(t, storage.DeleteMetric(ctx, &deleted), storageerrors.ErrTypeMismatch)
+}
+
+func testDeleteByPattern(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		gauge("HostCPU", 1.0),
+		gauge("HostMem", 1.0),
+		gauge("Alloc", 1.0),
+		counter("HostRestarts", 1),
+	}))
+
+	deleted, err := storage.DeleteMetrics(ctx, "gauge", "Host*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	resultMetrics, err := storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, resultMetrics, 2)
+
+	deleted, err = storage.DeleteMetrics(ctx, "", "*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	_, err = storage.DeleteMetrics(ctx, "gauge", "[")
+	assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+	_, err = storage.DeleteMetrics(ctx, "invalid", "*")
+	assert.ErrorIs(t, err, storageerrors.ErrTypeMismatch)
+}
+
+func testResetCounter(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 10)}))
+	require.NoError(t, storage.ResetCounter(ctx, "test"))
+
+	searchMetric := metrics.Metric{ID: "test", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(0), *searchMetric.Delta)
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 3)}))
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(3), *searchMetric.Delta, "после сброса counter должен накапливаться с нуля")
+
+	assert.ErrorIs(t, storage.ResetCounter(ctx, "unknown"), storageerrors.ErrNotFound)
+}
+
+func testCounterRate(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 10), gauge("gauge", 1)}))
+	rate, err := storage.CounterRate(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, rate, "по одному значению скорость не вычисляется")
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("test", 10)}))
+	rate, err = storage.CounterRate(ctx, "test")
+	require.NoError(t, err)
+	assert.Greater(t, rate, 0.0)
+
+	require.NoError(t, storage.ResetCounter(ctx, "test"))
+	rate, err = storage.CounterRate(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, rate, "после сброса скорость считается заново")
+
+	_, err = storage.CounterRate(ctx, "gauge")
+	assert.ErrorIs(t, err, storageerrors.ErrNotFound)
+	_, err = storage.CounterRate(ctx, "unknown")
+	assert.ErrorIs(t, err, storageerrors.ErrNotFound)
+}
+
+func metricKeys(metricList []metrics.Metric) []string {
+	keys := []string{}
+	for _, metric := range metricList {
+		keys = append(keys, metric.MType+"/"+metric.ID)
+	}
+	return keys
+}
+
+func testList(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{
+		gauge("b_gauge", 2.0),
+		gauge("a_gauge", 1.0),
+		counter("a_gauge", 5),
+		gauge("Host_CPU", 3.0),
+		gauge("HostXCPU", 4.0),
+		counter("c_counter", 7),
+	}))
+
+	resultMetrics, err := storage.ListMetrics(ctx, metrics.ListQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"gauge/HostXCPU", "gauge/Host_CPU", "counter/a_gauge", "gauge/a_gauge", "gauge/b_gauge", "counter/c_counter",
+	}, metricKeys(resultMetrics), "метрики должны быть отсортированы побайтово по имени, затем по типу")
+	assert.Equal(t, int64(5), *resultMetrics[2].Delta)
+	assert.Nil(t, resultMetrics[2].Value)
+	assert.Equal(t, 1.0, *resultMetrics[3].Value)
+	assert.Nil(t, resultMetrics[3].Delta)
+
+	resultMetrics, err = storage.ListMetrics(ctx, metrics.ListQuery{MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"counter/a_gauge", "counter/c_counter"}, metricKeys(resultMetrics))
+
+	resultMetrics, err = storage.ListMetrics(ctx, metrics.ListQuery{Prefix: "Host_"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge/Host_CPU"}, metricKeys(resultMetrics), "символы шаблонов в префиксе не должны работать как шаблоны")
+
+	resultMetrics, err = storage.ListMetrics(ctx, metrics.ListQuery{Regex: "^[ab]_"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"counter/a_gauge", "gauge/a_gauge", "gauge/b_gauge"}, metricKeys(resultMetrics))
+
+	// постраничный обход должен вернуть все метрики ровно один раз
+	pages := []string{}
+	query := metrics.ListQuery{Limit: 4}
+	for {
+		page, err := storage.ListMetrics(ctx, query)
+		require.NoError(t, err)
+		pages = append(pages, metricKeys(page)...)
+		if len(page) < query.Limit {
+			break
+		}
+		cursor := metrics.CursorOf(page[len(page)-1])
+		query.After = &cursor
+	}
+	assert.Equal(t, []string{
+		"gauge/HostXCPU", "gauge/Host_CPU", "counter/a_gauge", "gauge/a_gauge", "gauge/b_gauge", "counter/c_counter",
+	}, pages)
+
+	// курсор остается валидным, даже если метрика, на которую он указывает, удалена
+	cursor := metrics.Cursor{ID: "a_gauge", MType: "counter"}
+	deleted := metrics.Metric{ID: "a_gauge", MType: "counter"}
+	require.NoError(t, storage.DeleteMetric(ctx, &deleted))
+	resultMetrics, err = storage.ListMetrics(ctx, metrics.ListQuery{After: &cursor, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge/a_gauge"}, metricKeys(resultMetrics))
+
+	_, err = storage.ListMetrics(ctx, metrics.ListQuery{Regex: "("})
+	assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+	_, err = storage.ListMetrics(ctx, metrics.ListQuery{MType: "invalid"})
+	assert.ErrorIs(t, err, storageerrors.ErrTypeMismatch)
+}
+
+func testSaveBatch(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	batch := metrics.BatchKey{AgentID: "agent", BatchID: "1"}
+
+	applied, err := storage.SaveBatch(ctx, batch, []metrics.Metric{counter("test", 10)})
+	require.NoError(t, err)
+	assert.True(t, applied)
+	applied, err = storage.SaveBatch(ctx, batch, []metrics.Metric{counter("test", 10)})
+	require.NoError(t, err)
+	assert.False(t, applied, "повтор пачки не должен применяться")
+
+	applied, err = storage.SaveBatch(ctx, metrics.BatchKey{AgentID: "agent", BatchID: "2"}, []metrics.Metric{counter("test", 1)})
+	require.NoError(t, err)
+	assert.True(t, applied)
+	applied, err = storage.SaveBatch(ctx, metrics.BatchKey{AgentID: "other", BatchID: "1"}, []metrics.Metric{counter("test", 1)})
+	require.NoError(t, err)
+	assert.True(t, applied, "идентификаторы пачек разных агентов не пересекаются")
+
+	searchMetric := metrics.Metric{ID: "test", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(12), *searchMetric.Delta)
+
+	invalid := metrics.BatchKey{AgentID: "agent", BatchID: "3"}
+	_, err = storage.SaveBatch(ctx, invalid, []metrics.Metric{counter("test", 1), {ID: "invalid", MType: "gauge"}})
+	assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+	applied, err = storage.SaveBatch(ctx, invalid, []metrics.Metric{counter("test", 1)})
+	require.NoError(t, err)
+	assert.True(t, applied, "невалидная пачка не должна запоминаться")
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(13), *searchMetric.Delta)
+}
+
+func testReplace(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	require.NoError(t, storage.SaveMetrics(ctx, []metrics.Metric{counter("old", 1), gauge("kept", 1), counter("requests", 5)}))
+
+	require.NoError(t, storage.ReplaceMetrics(ctx, []metrics.Metric{gauge("kept", 2), counter("requests", 7), gauge("new", 3)}))
+	metricList, err := storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"gauge/kept", "counter/requests", "gauge/new"}, metricKeys(metricList))
+
+	searchMetric := metrics.Metric{ID: "requests", MType: "counter"}
+	require.NoError(t, storage.GetMetric(ctx, &searchMetric))
+	assert.Equal(t, int64(7), *searchMetric.Delta, "значение заменяется, а не прибавляется")
+	assert.ErrorIs(t, storage.GetMetric(ctx, &metrics.Metric{ID: "old", MType: "counter"}), storageerrors.ErrNotFound)
+
+	err = storage.ReplaceMetrics(ctx, []metrics.Metric{gauge("other", 1), {ID: "invalid", MType: "gauge"}})
+	assert.ErrorIs(t, err, storageerrors.ErrInvalidMetric)
+	metricList, err = storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metricList, 3, "невалидный снимок не должен менять содержимое")
+
+	require.NoError(t, storage.ReplaceMetrics(ctx, nil))
+	metricList, err = storage.ExtractMetrics(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, metricList)
+}
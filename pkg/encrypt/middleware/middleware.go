@@ -2,11 +2,24 @@ package encryptmiddleware
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	pb "github.com/ry461ch/metric-collector/pkg/encrypt/signed"
 )
 
 // Миддлваря для расшфровки шифровки сообщений
@@ -22,12 +35,43 @@ func (re *ResponseEncrypter) Write(b []byte) (int, error) {
 	return re.ResponseWriter.Write(b)
 }
 
-// Проверка подписи пришедшего запроса и отправка зашифрованного сообщения клиенту
-func CheckRequestAndEncryptResponse(encrypter *encrypt.Encrypter) func(http.Handler) http.Handler {
+// Проверка подписи по времени и nonce из заголовков X-Timestamp и X-Nonce,
+// методу, пути с query и телу запроса
+func checkSignedRequest(r *http.Request, reqBody []byte, reqHash []byte, encrypter *encrypt.Encrypter, guard *encrypt.ReplayGuard) bool {
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	nonce := r.Header.Get("X-Nonce")
+	if !hmac.Equal(reqHash, encrypter.SignRequest(r.Method, r.URL.RequestURI(), timestamp, nonce, reqBody)) {
+		return false
+	}
+	return guard == nil || guard.Accept(time.Unix(timestamp, 0), nonce, time.Now())
+}
+
+// Может ли запрос изменить данные. Такие запросы без подписи отклоняются
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// Проверка подписи пришедшего запроса и отправка зашифрованного сообщения клиенту.
+// Если guard задан, подпись должна покрывать время и nonce, повторы и
+// устаревшие запросы отклоняются, а запросы на запись без подписи не принимаются.
+// Подпись только по телу и запросы без подписи принимаются, если это разрешено в guard.
+// На неверную подпись и повтор отвечает 401
+func CheckRequestAndEncryptResponse(encrypter *encrypt.Encrypter, guard *encrypt.ReplayGuard) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			reqHeaderHash256 := r.Header.Get("HashSHA256")
 			if reqHeaderHash256 == "" {
+				if guard != nil && !guard.AllowLegacy() && isWriteRequest(r) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -39,15 +83,218 @@ func CheckRequestAndEncryptResponse(encrypter *encrypt.Encrypter) func(http.Hand
 				return
 			}
 			reqBody := buf.Bytes()
-			reqHash := encrypter.EncryptMessage(reqBody)
-
-			if fmt.Sprintf("%x", reqHash) != reqHeaderHash256 {
-				w.WriteHeader(http.StatusBadRequest)
+			reqHash, err := hex.DecodeString(reqHeaderHash256)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 
+			if r.Header.Get("X-Timestamp") != "" || r.Header.Get("X-Nonce") != "" {
+				if !checkSignedRequest(r, reqBody, reqHash, encrypter, guard) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			} else {
+				if guard != nil && !guard.AllowLegacy() {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if !hmac.Equal(reqHash, encrypter.EncryptMessage(reqBody)) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+
 			r.Body = io.NopCloser(bytes.NewBuffer(reqBody))
 			next.ServeHTTP(&ResponseEncrypter{ResponseWriter: w, encrypter: encrypter}, r)
 		})
 	}
 }
+
+// Случайный nonce стрима
+func newNonce() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+type signedClientStream struct {
+	grpc.ClientStream
+	encrypter *encrypt.Encrypter
+	method    string
+	timestamp int64
+	nonce     string
+	seq       uint64
+}
+
+// Подпись сообщения на стороне grpc клиента
+func (scs *signedClientStream) SendMsg(req interface{}) error {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "message is not protobuf")
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	scs.seq++
+	return scs.ClientStream.SendMsg(&pb.SignedObject{
+		Data: data,
+		Seq:  scs.seq,
+		Hash: scs.encrypter.SignStreamMessage(scs.method, scs.timestamp, scs.nonce, scs.seq, data),
+	})
+}
+
+// Interceptor подписи сообщений на стороне клиента. Время и nonce стрима
+// передаются в метаданных, каждое сообщение подписывается вместе с ними и
+// своим номером. При шифровании RSA должен стоять перед interceptor'ом шифровки
+func SignStreamClientInterceptor(encrypter *encrypt.Encrypter) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		timestamp := time.Now().Unix()
+		nonce := newNonce()
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-timestamp", strconv.FormatInt(timestamp, 10), "x-nonce", nonce)
+		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &signedClientStream{
+			ClientStream: clientStream,
+			encrypter:    encrypter,
+			method:       method,
+			timestamp:    timestamp,
+			nonce:        nonce,
+		}, nil
+	}
+}
+
+type verifiedServerStream struct {
+	grpc.ServerStream
+	encrypter *encrypt.Encrypter
+	method    string
+	timestamp int64
+	nonce     string
+	seq       uint64
+}
+
+// Проверка подписи и порядка сообщений на стороне grpc сервера
+func (vss *verifiedServerStream) RecvMsg(req interface{}) error {
+	msg := &pb.SignedObject{}
+	if err := vss.ServerStream.RecvMsg(msg); err != nil {
+		return err
+	}
+	if msg.GetSeq() != vss.seq+1 {
+		return status.Error(codes.Unauthenticated, "unexpected message sequence")
+	}
+	if !hmac.Equal(msg.GetHash(), vss.encrypter.SignStreamMessage(vss.method, vss.timestamp, vss.nonce, msg.GetSeq(), msg.GetData())) {
+		return status.Error(codes.Unauthenticated, "invalid message signature")
+	}
+	vss.seq = msg.GetSeq()
+	return proto.Unmarshal(msg.GetData(), req.(proto.Message))
+}
+
+// Interceptor проверки подписи сообщений на стороне сервера. Если guard задан,
+// устаревшие и повторные стримы отклоняются, а неподписанные стримы
+// пропускаются, только если это разрешено в guard. При шифровании RSA должен
+// стоять после interceptor'а расшифровки
+func VerifyStreamServerInterceptor(encrypter *encrypt.Encrypter, guard *encrypt.ReplayGuard) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		var timestampValue, nonce string
+		if md, ok := metadata.FromIncomingContext(ss.Context()); ok {
+			if values := md.Get("x-timestamp"); len(values) > 0 {
+				timestampValue = values[0]
+			}
+			if values := md.Get("x-nonce"); len(values) > 0 {
+				nonce = values[0]
+			}
+		}
+		if timestampValue == "" && nonce == "" && guard != nil && guard.AllowLegacy() {
+			return handler(srv, ss)
+		}
+
+		timestamp, err := strconv.ParseInt(timestampValue, 10, 64)
+		if err != nil || nonce == "" {
+			return status.Error(codes.Unauthenticated, "stream is not signed")
+		}
+		if guard != nil && !guard.Accept(time.Unix(timestamp, 0), nonce, time.Now()) {
+			return status.Error(codes.Unauthenticated, "stale or replayed stream")
+		}
+		return handler(srv, &verifiedServerStream{
+			ServerStream: ss,
+			encrypter:    encrypter,
+			method:       info.FullMethod,
+			timestamp:    timestamp,
+			nonce:        nonce,
+		})
+	}
+}
+
+// Сериализация unary запроса для подписи. Детерминированная, чтобы клиент и
+// сервер получили одинаковые байты
+var unaryMarshal = proto.MarshalOptions{Deterministic: true}
+
+// Interceptor подписи unary запросов на стороне клиента. Время, nonce и подпись
+// запроса передаются в метаданных
+func SignUnaryClientInterceptor(encrypter *encrypt.Encrypter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "message is not protobuf")
+		}
+		data, err := unaryMarshal.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		timestamp := time.Now().Unix()
+		nonce := newNonce()
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			"x-timestamp", strconv.FormatInt(timestamp, 10),
+			"x-nonce", nonce,
+			"x-signature", hex.EncodeToString(encrypter.SignUnaryRequest(method, timestamp, nonce, data)),
+		)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Interceptor проверки подписи unary запросов на стороне сервера. Если guard
+// задан, устаревшие и повторные запросы отклоняются, а неподписанные запросы
+// пропускаются, только если это разрешено в guard
+func VerifyUnaryServerInterceptor(encrypter *encrypt.Encrypter, guard *encrypt.ReplayGuard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var timestampValue, nonce, signature string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("x-timestamp"); len(values) > 0 {
+				timestampValue = values[0]
+			}
+			if values := md.Get("x-nonce"); len(values) > 0 {
+				nonce = values[0]
+			}
+			if values := md.Get("x-signature"); len(values) > 0 {
+				signature = values[0]
+			}
+		}
+		if timestampValue == "" && nonce == "" && signature == "" && guard != nil && guard.AllowLegacy() {
+			return handler(ctx, req)
+		}
+
+		timestamp, err := strconv.ParseInt(timestampValue, 10, 64)
+		hash, hashErr := hex.DecodeString(signature)
+		if err != nil || hashErr != nil || nonce == "" || signature == "" {
+			return nil, status.Error(codes.Unauthenticated, "request is not signed")
+		}
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "message is not protobuf")
+		}
+		data, err := unaryMarshal.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "can't marshal request")
+		}
+		if !hmac.Equal(hash, encrypter.SignUnaryRequest(info.FullMethod, timestamp, nonce, data)) {
+			return nil, status.Error(codes.Unauthenticated, "invalid request signature")
+		}
+		if guard != nil && !guard.Accept(time.Unix(timestamp, 0), nonce, time.Now()) {
+			return nil, status.Error(codes.Unauthenticated, "stale or replayed request")
+		}
+		return handler(ctx, req)
+	}
+}
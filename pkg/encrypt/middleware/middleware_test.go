@@ -0,0 +1,259 @@
+package encryptmiddleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"gopkg.in/resty.v1"
+
+	"github.com/ry461ch/metric-collector/pkg/encrypt"
+	pb "github.com/ry461ch/metric-collector/pkg/encrypt/signed"
+)
+
+func mockServer(encrypter *encrypt.Encrypter, guard *encrypt.ReplayGuard) *httptest.Server {
+	router := chi.NewRouter()
+	router.Use(CheckRequestAndEncryptResponse(encrypter, guard))
+	router.Post("/*", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	})
+	router.Get("/*", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	})
+	return httptest.NewServer(router)
+}
+
+func signedRequest(encrypter *encrypt.Encrypter, timestamp int64, nonce string, body []byte) *resty.Request {
+	return signedURIRequest(encrypter, "/updates/", timestamp, nonce, body)
+}
+
+func signedURIRequest(encrypter *encrypt.Encrypter, requestURI string, timestamp int64, nonce string, body []byte) *resty.Request {
+	return resty.New().R().
+		SetHeader("X-Timestamp", strconv.FormatInt(timestamp, 10)).
+		SetHeader("X-Nonce", nonce).
+		SetHeader("HashSHA256", fmt.Sprintf("%x", encrypter.SignRequest(http.MethodPost, requestURI, timestamp, nonce, body))).
+		SetBody(body)
+}
+
+func TestReplayProtection(t *testing.T) {
+	encrypter := encrypt.New("test")
+	srv := mockServer(encrypter, encrypt.NewReplayGuard(time.Minute, false))
+	defer srv.Close()
+
+	body := []byte(`[{"id": "PollCount", "type": "counter", "delta": 1}]`)
+	now := time.Now().Unix()
+
+	resp, _ := signedRequest(encrypter, now, "first", body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, _ = signedRequest(encrypter, now, "first", body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "повтор nonce должен отклоняться")
+
+	resp, _ = signedRequest(encrypter, now-120, "stale", body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "устаревший запрос должен отклоняться")
+
+	resp, _ = signedRequest(encrypter, now, "second", body).Post(srv.URL + "/update/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "подпись должна покрывать путь")
+
+	resp, _ = signedURIRequest(encrypter, "/values/?pattern=Host*", now, "query", body).Post(srv.URL + "/values/?pattern=%2A")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "подпись должна покрывать query")
+	resp, _ = signedURIRequest(encrypter, "/values/?pattern=Host*", now, "query", body).Post(srv.URL + "/values/?pattern=Host*")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, _ = signedRequest(encrypt.New("other"), now, "third", body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+	legacy := resty.New().R().SetHeader("HashSHA256", fmt.Sprintf("%x", encrypter.EncryptMessage(body))).SetBody(body)
+	resp, _ = legacy.Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "подпись только по телу запрещена")
+
+	resp, _ = resty.New().R().SetBody(body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "запись без подписи запрещена")
+
+	resp, _ = resty.New().R().Get(srv.URL + "/value/counter/PollCount")
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "чтение не требует подписи")
+}
+
+func TestLegacySignature(t *testing.T) {
+	encrypter := encrypt.New("test")
+	srv := mockServer(encrypter, encrypt.NewReplayGuard(time.Minute, true))
+	defer srv.Close()
+
+	body := []byte(`[]`)
+	resp, _ := resty.New().R().SetHeader("HashSHA256", fmt.Sprintf("%x", encrypter.EncryptMessage(body))).SetBody(body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, _ = resty.New().R().SetHeader("HashSHA256", fmt.Sprintf("%x", encrypter.EncryptMessage([]byte("other")))).SetBody(body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+	resp, _ = resty.New().R().SetBody(body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "старые агенты без ключа принимаются в режиме совместимости")
+
+	resp, _ = signedRequest(encrypter, time.Now().Unix(), "nonce", body).Post(srv.URL + "/updates/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "новые агенты проверяются и в режиме совместимости")
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	sent []*pb.SignedObject
+}
+
+func (fcs *fakeClientStream) SendMsg(m interface{}) error {
+	fcs.sent = append(fcs.sent, m.(*pb.SignedObject))
+	return nil
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	received []*pb.SignedObject
+}
+
+func (fss *fakeServerStream) Context() context.Context {
+	return fss.ctx
+}
+
+func (fss *fakeServerStream) RecvMsg(m interface{}) error {
+	if len(fss.received) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(proto.Message), fss.received[0])
+	fss.received = fss.received[1:]
+	return nil
+}
+
+// Отправка сообщений через подписывающий interceptor. Возвращает входящий
+// контекст сервера и подписанные сообщения
+func signStream(t *testing.T, encrypter *encrypt.Encrypter, values ...string) (context.Context, []*pb.SignedObject) {
+	var incoming context.Context
+	clientStream := &fakeClientStream{}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		incoming = metadata.NewIncomingContext(context.Background(), md)
+		return clientStream, nil
+	}
+	stream, err := SignStreamClientInterceptor(encrypter)(context.Background(), &grpc.StreamDesc{}, nil, "/proto.Metrics/PostMetrics", streamer)
+	require.NoError(t, err)
+	for _, value := range values {
+		require.NoError(t, stream.SendMsg(wrapperspb.String(value)))
+	}
+	return incoming, clientStream.sent
+}
+
+// Прием сообщений через проверяющий interceptor
+func verifyStream(encrypter *encrypt.Encrypter, guard *encrypt.ReplayGuard, ctx context.Context, msgs []*pb.SignedObject) ([]string, error) {
+	var received []string
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			value := &wrapperspb.StringValue{}
+			err := stream.RecvMsg(value)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			received = append(received, value.GetValue())
+		}
+	}
+	err := VerifyStreamServerInterceptor(encrypter, guard)(nil, &fakeServerStream{ctx: ctx, received: msgs}, &grpc.StreamServerInfo{FullMethod: "/proto.Metrics/PostMetrics"}, handler)
+	return received, err
+}
+
+func TestSignedStream(t *testing.T) {
+	encrypter := encrypt.New("test")
+	guard := encrypt.NewReplayGuard(time.Minute, false)
+
+	ctx, msgs := signStream(t, encrypter, "first", "second")
+	received, err := verifyStream(encrypter, guard, ctx, msgs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, received)
+
+	_, err = verifyStream(encrypter, guard, ctx, msgs)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "повтор стрима должен отклоняться")
+
+	ctx, msgs = signStream(t, encrypter, "first", "second")
+	_, err = verifyStream(encrypt.New("other"), nil, ctx, msgs)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "подпись другим ключом")
+
+	ctx, msgs = signStream(t, encrypter, "first", "second")
+	_, err = verifyStream(encrypter, nil, ctx, []*pb.SignedObject{msgs[1], msgs[0]})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "перестановка сообщений")
+
+	ctx, msgs = signStream(t, encrypter, "first")
+	msgs[0].Data = append(msgs[0].Data, 'x')
+	_, err = verifyStream(encrypter, nil, ctx, msgs)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "подмена сообщения")
+
+	_, err = verifyStream(encrypter, guard, context.Background(), nil)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "неподписанный стрим")
+	_, err = verifyStream(encrypter, encrypt.NewReplayGuard(time.Minute, true), context.Background(), nil)
+	assert.NoError(t, err, "неподписанный стрим в режиме совместимости")
+}
+
+// Вызов unary метода через подписывающий и проверяющий interceptor'ы. tamper
+// позволяет изменить запрос после подписи
+func callUnary(signer, verifier *encrypt.Encrypter, guard *encrypt.ReplayGuard, tamper func(*wrapperspb.StringValue)) (string, error) {
+	const method = "/proto.Cluster/Get"
+	var incoming context.Context
+	var sent *wrapperspb.StringValue
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		incoming = metadata.NewIncomingContext(context.Background(), md)
+		sent = proto.Clone(req.(proto.Message)).(*wrapperspb.StringValue)
+		return nil
+	}
+	if signer != nil {
+		if err := SignUnaryClientInterceptor(signer)(context.Background(), method, wrapperspb.String("value"), nil, nil, invoker); err != nil {
+			return "", err
+		}
+	} else {
+		incoming, sent = context.Background(), wrapperspb.String("value")
+	}
+	if tamper != nil {
+		tamper(sent)
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	resp, err := VerifyUnaryServerInterceptor(verifier, guard)(incoming, sent, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	if err != nil {
+		return "", err
+	}
+	return resp.(*wrapperspb.StringValue).GetValue(), nil
+}
+
+func TestSignedUnary(t *testing.T) {
+	encrypter := encrypt.New("test")
+	guard := encrypt.NewReplayGuard(time.Minute, false)
+
+	value, err := callUnary(encrypter, encrypter, guard, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	_, err = callUnary(encrypter, encrypt.New("other"), guard, nil)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "подпись другим ключом")
+
+	_, err = callUnary(encrypter, encrypter, guard, func(req *wrapperspb.StringValue) { req.Value = "other" })
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "подмена запроса")
+
+	_, err = callUnary(nil, encrypter, guard, nil)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "неподписанный запрос")
+	_, err = callUnary(nil, encrypter, encrypt.NewReplayGuard(time.Minute, true), nil)
+	assert.NoError(t, err, "неподписанный запрос в режиме совместимости")
+}
@@ -0,0 +1,89 @@
+package encrypt
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Защита от повтора подписанных запросов: запрос принимается, если его
+// время отличается от текущего не больше чем на window, а nonce еще не
+// встречался за это время
+type ReplayGuard struct {
+	window      time.Duration
+	allowLegacy bool
+	mu          sync.Mutex
+	seen        map[string]time.Time
+	lastSweep   time.Time
+}
+
+// Создание защиты от повторов. allowLegacy разрешает запросы старых агентов,
+// подписанные только по телу, без времени и nonce
+func NewReplayGuard(window time.Duration, allowLegacy bool) *ReplayGuard {
+	return &ReplayGuard{
+		window:      window,
+		allowLegacy: allowLegacy,
+		seen:        map[string]time.Time{},
+	}
+}
+
+// Разрешены ли запросы, подписанные только по телу
+func (rg *ReplayGuard) AllowLegacy() bool {
+	return rg.allowLegacy
+}
+
+// Проверка времени и nonce запроса. Принятый nonce запоминается до момента,
+// когда запрос с его временем перестанет проходить проверку
+func (rg *ReplayGuard) Accept(timestamp time.Time, nonce string, now time.Time) bool {
+	if nonce == "" || timestamp.Before(now.Add(-rg.window)) || timestamp.After(now.Add(rg.window)) {
+		return false
+	}
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if now.Sub(rg.lastSweep) >= rg.window {
+		for key, expiresAt := range rg.seen {
+			if !expiresAt.After(now) {
+				delete(rg.seen, key)
+			}
+		}
+		rg.lastSweep = now
+	}
+	if expiresAt, ok := rg.seen[nonce]; ok && expiresAt.After(now) {
+		return false
+	}
+	rg.seen[nonce] = timestamp.Add(rg.window)
+	return true
+}
+
+// Подпись запроса: метод, путь с query, время в unix-секундах, nonce и тело
+func (e *Encrypter) SignRequest(method string, requestURI string, timestamp int64, nonce string, body []byte) []byte {
+	return e.sign(body, method, requestURI, strconv.FormatInt(timestamp, 10), nonce)
+}
+
+// Подпись сообщения grpc стрима: метод, время и nonce стрима, номер сообщения в
+// стриме и само сообщение
+func (e *Encrypter) SignStreamMessage(method string, timestamp int64, nonce string, seq uint64, data []byte) []byte {
+	return e.sign(data, method, strconv.FormatInt(timestamp, 10), nonce, strconv.FormatUint(seq, 10))
+}
+
+// Подпись unary grpc запроса: метод, время, nonce и сообщение. Маркер unary
+// не дает выдать подпись запроса за подпись сообщения стрима
+func (e *Encrypter) SignUnaryRequest(method string, timestamp int64, nonce string, data []byte) []byte {
+	return e.sign(data, method, strconv.FormatInt(timestamp, 10), nonce, "unary")
+}
+
+// Подпись полей, разделенных переводом строки, и данных после них
+func (e *Encrypter) sign(data []byte, fields ...string) []byte {
+	size := len(data)
+	for _, field := range fields {
+		size += len(field) + 1
+	}
+	message := make([]byte, 0, size)
+	for _, field := range fields {
+		message = append(message, field...)
+		message = append(message, '\n')
+	}
+	message = append(message, data...)
+	return e.EncryptMessage(message)
+}
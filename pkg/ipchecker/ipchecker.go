@@ -1,19 +1,123 @@
 package ipchecker
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"strings"
+)
 
+// Проверка адресов клиентов по спискам разрешенных и запрещенных подсетей
 type IPChecker struct {
-	trustedSubnet *net.IPNet
+	allowed        []*net.IPNet
+	denied         []*net.IPNet
+	trustedProxies []*net.IPNet
 }
 
-func New(subnet string) *IPChecker {
-	_, ipNet, err := net.ParseCIDR(subnet)
-	if err != nil {
-		return nil
+// Разбор списка подсетей. Адрес без маски считается подсетью из одного адреса
+func parseNets(subnets []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(subnets))
+	for _, subnet := range subnets {
+		subnet = strings.TrimSpace(subnet)
+		if subnet == "" {
+			continue
+		}
+		if !strings.Contains(subnet, "/") {
+			ip := net.ParseIP(subnet)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", subnet)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+		res = append(res, ipNet)
 	}
-	return &IPChecker{trustedSubnet: ipNet}
+	return res, nil
 }
 
+// Создание проверки. allowed - разрешенные подсети, пустой список разрешает
+// все адреса, кроме denied. trustedProxies - адреса прокси, которым можно
+// доверять заголовки X-Forwarded-For и X-Real-IP
+func New(allowed []string, denied []string, trustedProxies []string) (*IPChecker, error) {
+	var ic IPChecker
+	var err error
+	if ic.allowed, err = parseNets(allowed); err != nil {
+		return nil, err
+	}
+	if ic.denied, err = parseNets(denied); err != nil {
+		return nil, err
+	}
+	if ic.trustedProxies, err = parseNets(trustedProxies); err != nil {
+		return nil, err
+	}
+	return &ic, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Проверка, что адрес разрешен: не входит в запрещенные подсети и входит в
+// разрешенные, если они заданы
 func (ic *IPChecker) Contains(ip *net.IP) bool {
-	return ic.trustedSubnet.Contains(*ip)
+	if ip == nil || *ip == nil || containsIP(ic.denied, *ip) {
+		return false
+	}
+	return len(ic.allowed) == 0 || containsIP(ic.allowed, *ip)
+}
+
+// Проверка, что адрес принадлежит доверенному прокси. Без настроенной
+// проверки доверенных прокси нет
+func (ic *IPChecker) IsTrustedProxy(ip net.IP) bool {
+	return ic != nil && ip != nil && containsIP(ic.trustedProxies, ip)
+}
+
+// Адрес из host:port или из голого адреса
+func parseHostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.TrimSpace(addr))
+}
+
+// Адрес клиента по адресу соединения. Заголовки X-Forwarded-For и X-Real-IP
+// учитываются, только если соединение пришло от доверенного прокси: цепочка
+// X-Forwarded-For просматривается справа налево до первого адреса, который
+// не является доверенным прокси
+func (ic *IPChecker) ClientIP(remoteAddr string, forwardedFor string, realIP string) net.IP {
+	ip := parseHostIP(remoteAddr)
+	if !ic.IsTrustedProxy(ip) {
+		return ip
+	}
+	if forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := parseHostIP(hops[i])
+			if hop == nil {
+				return ip
+			}
+			ip = hop
+			if !ic.IsTrustedProxy(hop) {
+				return hop
+			}
+		}
+		return ip
+	}
+	if real := parseHostIP(realIP); real != nil {
+		return real
+	}
+	return ip
 }
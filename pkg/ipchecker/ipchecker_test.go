@@ -5,10 +5,12 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBase(t *testing.T) {
-	ipchecker := New("127.0.0.1/30")
+	ipchecker, err := New([]string{"127.0.0.1/30"}, nil, nil)
+	require.NoError(t, err)
 	reqIP := net.ParseIP("127.0.0.2")
 	assert.True(t, ipchecker.Contains(&reqIP))
 
@@ -17,6 +19,44 @@ func TestBase(t *testing.T) {
 }
 
 func TestInvalidCIDR(t *testing.T) {
-	ipchecker := New("invalid")
-	assert.Nil(t, ipchecker, "initiated")
+	_, err := New([]string{"invalid"}, nil, nil)
+	assert.Error(t, err)
+	_, err = New(nil, []string{"10.0.0.0/33"}, nil)
+	assert.Error(t, err)
+	_, err = New(nil, nil, []string{"proxy"})
+	assert.Error(t, err)
+}
+
+func TestAllowDeny(t *testing.T) {
+	ipchecker, err := New([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16", "2001:db8::1"}, nil)
+	require.NoError(t, err)
+
+	for ip, allowed := range map[string]bool{
+		"10.0.0.1":    true,
+		"10.1.0.1":    false,
+		"192.168.0.1": false,
+		"2001:db8::2": true,
+		"2001:db8::1": false,
+		"::1":         false,
+	} {
+		parsed := net.ParseIP(ip)
+		assert.Equal(t, allowed, ipchecker.Contains(&parsed), ip)
+	}
+
+	onlyDeny, err := New(nil, []string{"10.0.0.0/8"}, nil)
+	require.NoError(t, err)
+	ip := net.ParseIP("192.168.0.1")
+	assert.True(t, onlyDeny.Contains(&ip), "без списка разрешенных разрешено все, кроме запрещенного")
+}
+
+func TestClientIP(t *testing.T) {
+	ipchecker, err := New(nil, nil, []string{"10.0.0.1", "fd00::/8"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "192.168.0.5", ipchecker.ClientIP("192.168.0.5:1234", "1.2.3.4", "5.6.7.8").String(), "заголовки от недоверенного адреса игнорируются")
+	assert.Equal(t, "1.2.3.4", ipchecker.ClientIP("10.0.0.1:1234", "9.9.9.9, 1.2.3.4", "").String())
+	assert.Equal(t, "1.2.3.4", ipchecker.ClientIP("10.0.0.1:1234", "1.2.3.4, fd00::2", "").String(), "доверенные прокси в цепочке пропускаются")
+	assert.Equal(t, "5.6.7.8", ipchecker.ClientIP("[fd00::1]:1234", "", "5.6.7.8").String())
+	assert.Equal(t, "10.0.0.1", ipchecker.ClientIP("10.0.0.1:1234", "garbage", "").String())
+	assert.Equal(t, "2001:db8::1", ipchecker.ClientIP("[2001:db8::1]:1234", "", "").String())
 }
@@ -8,18 +8,19 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
 	"github.com/ry461ch/metric-collector/pkg/ipchecker"
 )
 
-// Проверка подписи пришедшего запроса
+// Проверка адреса клиента пришедшего запроса. Адрес берется из соединения,
+// X-Forwarded-For и X-Real-IP учитываются только от доверенных прокси
 func CheckRequesterIP(ipChecker *ipchecker.IPChecker) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-			ip := req.Header.Get("X-Real-IP")
-			realIP := net.ParseIP(ip)
-			if realIP == nil || !ipChecker.Contains(&realIP) {
+			realIP := ipChecker.ClientIP(req.RemoteAddr, req.Header.Get("X-Forwarded-For"), req.Header.Get("X-Real-IP"))
+			if !ipChecker.Contains(&realIP) {
 				res.WriteHeader(http.StatusForbidden)
 				return
 			}
@@ -29,28 +30,57 @@ func CheckRequesterIP(ipChecker *ipchecker.IPChecker) func(http.Handler) http.Ha
 	}
 }
 
-// Проверка X-Real-IP на стороне grpc-сервера
-func CheckGRPCRequesterIP(ipChecker *ipchecker.IPChecker) func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		md, ok := metadata.FromIncomingContext(ss.Context())
-		if !ok {
-			return status.Error(codes.DataLoss, "missing context")
+// Адрес клиента grpc запроса по peer соединения. X-Forwarded-For и X-Real-IP
+// из метаданных учитываются только от доверенных прокси, ipChecker может быть nil
+func GRPCClientIP(ctx context.Context, ipChecker *ipchecker.IPChecker) (net.IP, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil, false
+	}
+	var forwardedFor, realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("X-Forwarded-For"); len(values) > 0 {
+			forwardedFor = values[len(values)-1]
 		}
-
-		values := md.Get("X-Real-IP")
-		if len(values) == 0 {
-			return status.Error(codes.DataLoss, "missing ip")
+		if values := md.Get("X-Real-IP"); len(values) > 0 {
+			realIP = values[0]
 		}
+	}
+	return ipChecker.ClientIP(p.Addr.String(), forwardedFor, realIP), true
+}
 
-		realIP := net.ParseIP(values[0])
-		if realIP == nil || !ipChecker.Contains(&realIP) {
-			return status.Error(codes.DataLoss, "forbidden")
-		}
+// Проверка адреса клиента grpc запроса
+func checkGRPCRequesterIP(ctx context.Context, ipChecker *ipchecker.IPChecker) error {
+	clientIP, ok := GRPCClientIP(ctx, ipChecker)
+	if !ok {
+		return status.Error(codes.DataLoss, "missing peer")
+	}
+	if !ipChecker.Contains(&clientIP) {
+		return status.Error(codes.DataLoss, "forbidden")
+	}
+	return nil
+}
 
+// Проверка адреса клиента на стороне grpc-сервера
+func CheckGRPCRequesterIP(ipChecker *ipchecker.IPChecker) func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkGRPCRequesterIP(ss.Context(), ipChecker); err != nil {
+			return err
+		}
 		return handler(srv, ss)
 	}
 }
 
+// Проверка адреса клиента на стороне grpc-сервера для unary запросов
+func CheckGRPCRequesterIPUnary(ipChecker *ipchecker.IPChecker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkGRPCRequesterIP(ctx, ipChecker); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
 // Добавление X-Real-IP на стороне grpc-клиента
 func SetIPGRPCClientStreamInterceptor(ip string) grpc.StreamClientInterceptor {
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
@@ -64,3 +94,11 @@ func SetIPGRPCClientStreamInterceptor(ip string) grpc.StreamClientInterceptor {
 		return clientStream, nil
 	}
 }
+
+// Добавление X-Real-IP на стороне grpc-клиента для unary запросов
+func SetIPGRPCClientUnaryInterceptor(ip string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		newCtx := metadata.AppendToOutgoingContext(ctx, "X-Real-IP", ip)
+		return invoker(newCtx, method, req, reply, cc, opts...)
+	}
+}
@@ -1,12 +1,18 @@
 package ipcheckermiddleware
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"gopkg.in/resty.v1"
 
 	"github.com/ry461ch/metric-collector/pkg/ipchecker"
@@ -22,15 +28,114 @@ func mockRouter(ipChecker *ipchecker.IPChecker) chi.Router {
 }
 
 func TestBase(t *testing.T) {
-	ipChecker := ipchecker.New("127.0.0.1/30")
+	ipChecker, err := ipchecker.New([]string{"127.0.0.1/30"}, nil, nil)
+	require.NoError(t, err)
 	router := mockRouter(ipChecker)
-	srv := httptest.NewServer(router)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "127.0.0.2:1234"
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code, "Invalid status code")
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "127.0.1.1:1234"
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code, "Invalid status code")
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "127.0.1.1:1234"
+	req.Header.Set("X-Real-IP", "127.0.0.2")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code, "X-Real-IP от недоверенного клиента не должен учитываться")
+}
+
+func TestServer(t *testing.T) {
+	ipChecker, err := ipchecker.New([]string{"127.0.0.1"}, nil, nil)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mockRouter(ipChecker))
 	defer srv.Close()
 
-	client := resty.New()
-	resp, _ := client.R().SetHeader("X-Real-IP", "127.0.0.2").Post(srv.URL + "/")
-	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Invalid status code")
+	resp, _ := resty.New().R().SetHeader("X-Real-IP", "10.0.0.1").Post(srv.URL + "/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Адрес соединения должен проверяться вместо заголовка")
+}
+
+func TestTrustedProxy(t *testing.T) {
+	ipChecker, err := ipchecker.New([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.13"}, []string{"192.168.0.1"})
+	require.NoError(t, err)
+	router := mockRouter(ipChecker)
+
+	for _, tc := range []struct {
+		forwardedFor string
+		realIP       string
+		status       int
+	}{
+		{forwardedFor: "10.0.0.2", status: http.StatusOK},
+		{forwardedFor: "10.0.0.2, 10.0.0.13", status: http.StatusForbidden},
+		{forwardedFor: "2001:db8::5", status: http.StatusOK},
+		{realIP: "10.1.2.3", status: http.StatusOK},
+		{realIP: "172.16.0.1", status: http.StatusForbidden},
+		{status: http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "192.168.0.1:1234"
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, tc.status, res.Code, tc)
+	}
+}
+
+func peerContext(addr string, md metadata.MD) context.Context {
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: tcpAddr})
+	if md != nil {
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return ctx
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	ipChecker, err := ipchecker.New([]string{"127.0.0.1/30", "::1"}, nil, []string{"10.0.0.1"})
+	require.NoError(t, err)
+	interceptor := CheckGRPCRequesterIPUnary(ipChecker)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	resp, err := interceptor(peerContext("127.0.0.2:5000", nil), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = interceptor(peerContext("[::1]:5000", nil), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+
+	_, err = interceptor(peerContext("127.0.1.1:5000", metadata.Pairs("X-Real-IP", "127.0.0.2")), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.Error(t, err, "X-Real-IP от недоверенного клиента не должен учитываться")
+
+	_, err = interceptor(peerContext("10.0.0.1:5000", metadata.Pairs("X-Real-IP", "127.0.0.2")), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+
+	_, err = interceptor(peerContext("10.0.0.1:5000", metadata.Pairs("X-Forwarded-For", "127.0.1.1")), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.Error(t, err)
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.Error(t, err)
+}
 
-	resp, _ = client.R().SetHeader("X-Real-IP", "127.0.1.1").Post(srv.URL + "/")
-	assert.Equal(t, http.StatusForbidden, resp.StatusCode(), "Invalid status code")
+func TestSetIPUnaryInterceptor(t *testing.T) {
+	interceptor := SetIPGRPCClientUnaryInterceptor("127.0.0.2")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		assert.Equal(t, []string{"127.0.0.2"}, md.Get("X-Real-IP"))
+		return nil
+	}
+	assert.NoError(t, interceptor(context.Background(), "/proto.Cluster/GetLocal", nil, nil, nil, invoker))
 }
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"context"
+	"sync"
+)
+
+type requestFieldsKey struct{}
+
+// Поля, которые обработчики добавляют в лог запроса
+type requestFields struct {
+	mu            sync.Mutex
+	keysAndValues []interface{}
+}
+
+// Контекст запроса, в который можно добавлять поля для лога запроса
+func WithRequestFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestFieldsKey{}, &requestFields{})
+}
+
+// Добавление полей в лог запроса. Без WithRequestFields поля игнорируются
+func AddRequestFields(ctx context.Context, keysAndValues ...interface{}) {
+	fields, ok := ctx.Value(requestFieldsKey{}).(*requestFields)
+	if !ok {
+		return
+	}
+	fields.mu.Lock()
+	fields.keysAndValues = append(fields.keysAndValues, keysAndValues...)
+	fields.mu.Unlock()
+}
+
+// Поля, добавленные в лог запроса
+func RequestFields(ctx context.Context) []interface{} {
+	fields, ok := ctx.Value(requestFieldsKey{}).(*requestFields)
+	if !ok {
+		return nil
+	}
+	fields.mu.Lock()
+	defer fields.mu.Unlock()
+	return append([]interface{}(nil), fields.keysAndValues...)
+}
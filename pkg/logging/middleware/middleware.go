@@ -1,6 +1,7 @@
 package requestlogger
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -23,10 +24,16 @@ type (
 
 	loggingStreamServer struct {
 		grpc.ServerStream
+		ctx  context.Context
 		size int
 	}
 )
 
+// Контекст стрима с полями для лога запроса
+func (lss *loggingStreamServer) Context() context.Context {
+	return lss.ctx
+}
+
 // Переопределение метода RecvMsg для grpc миддлвари логгера
 func (lss *loggingStreamServer) RecvMsg(req interface{}) error {
 	err := lss.ServerStream.RecvMsg(req)
@@ -66,18 +73,19 @@ func WithLogging(h http.Handler) http.Handler {
 			responseData:   responseData,
 		}
 
-		h.ServeHTTP(&lw, r)
+		ctx := logging.WithRequestFields(r.Context())
+		h.ServeHTTP(&lw, r.WithContext(ctx))
 
 		duration := time.Since(start)
 
-		logging.Logger.Infoln(
+		logging.Logger.Infoln(append([]interface{}{
 			"type", "http",
 			"uri", r.RequestURI,
 			"method", r.Method,
 			"status", responseData.status,
 			"duration", duration,
 			"size", responseData.size,
-		)
+		}, logging.RequestFields(ctx)...)...)
 	}
 	return http.HandlerFunc(logFn)
 }
@@ -88,6 +96,7 @@ func LoggingStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info
 
 	loggingStreamServer := &loggingStreamServer{
 		ServerStream: ss,
+		ctx:          logging.WithRequestFields(ss.Context()),
 		size:         0,
 	}
 
@@ -97,11 +106,33 @@ func LoggingStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info
 	}
 
 	duration := time.Since(start)
-	logging.Logger.Infoln(
+	logging.Logger.Infoln(append([]interface{}{
 		"type", "grpc",
 		"method", info.FullMethod,
 		"duration", duration,
 		"size", loggingStreamServer.size,
-	)
+	}, logging.RequestFields(loggingStreamServer.ctx)...)...)
 	return err
 }
+
+// Interceptor логирования unary запросов grpc
+func LoggingUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	size := 0
+	if msg, ok := req.(proto.Message); ok {
+		size = proto.Size(msg)
+	}
+	ctx = logging.WithRequestFields(ctx)
+	resp, err := handler(ctx, req)
+	if err != nil {
+		logging.Logger.Errorln(err)
+	}
+	duration := time.Since(start)
+	logging.Logger.Infoln(append([]interface{}{
+		"type", "grpc",
+		"method", info.FullMethod,
+		"duration", duration,
+		"size", size,
+	}, logging.RequestFields(ctx)...)...)
+	return resp, err
+}
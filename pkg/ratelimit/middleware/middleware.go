@@ -0,0 +1,86 @@
+package ratelimitmiddleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ry461ch/metric-collector/pkg/ipchecker"
+	ipcheckermiddleware "github.com/ry461ch/metric-collector/pkg/ipchecker/middleware"
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+)
+
+// Агент запроса: имя токена, а без токена - адрес клиента
+func agentName(ctx context.Context, clientIP string) string {
+	if identity, ok := tokens.FromContext(ctx); ok {
+		return "token:" + identity.Name
+	}
+	return "ip:" + clientIP
+}
+
+// Ответ на запрос сверх лимита
+func WriteTooManyRequests(res http.ResponseWriter, err error) {
+	res.Header().Set("Retry-After", "1")
+	http.Error(res, err.Error(), http.StatusTooManyRequests)
+}
+
+// Ограничение запросов агента. Должен стоять после проверки токена, чтобы
+// агент определялся по имени токена. Запрос расходует лимит, даже если
+// ничего не сохранил, кроме повтора уже примененной пачки. ipChecker может быть nil
+func LimitRequests(limiter *ratelimit.Limiter, ipChecker *ipchecker.IPChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			clientIP := ipChecker.ClientIP(req.RemoteAddr, req.Header.Get("X-Forwarded-For"), req.Header.Get("X-Real-IP"))
+			name := agentName(req.Context(), clientIP.String())
+			request, err := limiter.BeginRequest(name, time.Now())
+			if err != nil {
+				WriteTooManyRequests(res, err)
+				return
+			}
+			next.ServeHTTP(res, req.WithContext(ratelimit.WithRequest(req.Context(), request)))
+		})
+	}
+}
+
+// Статус grpc для ошибки лимита
+func GRPCError(err error) error {
+	if errors.Is(err, ratelimit.ErrRateLimited) || errors.Is(err, ratelimit.ErrQuotaExceeded) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Контекст стрима с агентом
+func (ls *limitedStream) Context() context.Context {
+	return ls.ctx
+}
+
+// Ограничение стримов агента на стороне grpc-сервера. Ограничиваются только
+// методы из methods
+func LimitGRPCRequests(limiter *ratelimit.Limiter, ipChecker *ipchecker.IPChecker, methods []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !slices.Contains(methods, info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx := ss.Context()
+		clientIP, _ := ipcheckermiddleware.GRPCClientIP(ctx, ipChecker)
+		name := agentName(ctx, clientIP.String())
+		request, err := limiter.BeginRequest(name, time.Now())
+		if err != nil {
+			return GRPCError(err)
+		}
+		return handler(srv, &limitedStream{ServerStream: ss, ctx: ratelimit.WithRequest(ctx, request)})
+	}
+}
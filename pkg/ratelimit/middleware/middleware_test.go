@@ -0,0 +1,80 @@
+package ratelimitmiddleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/ry461ch/metric-collector/pkg/ratelimit"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+)
+
+func TestLimitRequests(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Limits{RequestRate: 1, MaxMetricIDs: 1}, nil)
+	handler := LimitRequests(limiter, nil)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if err := ratelimit.AllowMetrics(req.Context(), req.URL.Query()["id"]...); err != nil {
+			WriteTooManyRequests(res, err)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(remoteAddr string, query string, identity *tokens.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/"+query, nil)
+		req.RemoteAddr = remoteAddr
+		if identity != nil {
+			req = req.WithContext(tokens.WithIdentity(req.Context(), identity))
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1000", "?id=a", nil).Code)
+	res := send("10.0.0.1:1001", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code, "лимит считается по адресу, а не по порту")
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.2:1000", "?id=a&id=b", nil).Code, "превышение квоты метрик")
+
+	store, err := tokens.New([]tokens.Token{{Name: "agent", Token: "secret", Scopes: []string{tokens.ScopeWrite}}})
+	require.NoError(t, err)
+	identity, _ := store.Lookup("secret")
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1000", "", identity).Code, "агент с токеном определяется по имени токена")
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.3:1000", "", identity).Code)
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (fs *fakeStream) Context() context.Context {
+	return fs.ctx
+}
+
+func TestLimitGRPCRequests(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Limits{RequestRate: 1}, nil)
+	interceptor := LimitGRPCRequests(limiter, nil, []string{"/proto.Metrics/PostMetrics"})
+	addr, _ := net.ResolveTCPAddr("tcp", "10.0.0.1:5000")
+	stream := &fakeStream{ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: addr})}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return ratelimit.AllowMetrics(ss.Context(), "a")
+	}
+
+	info := &grpc.StreamServerInfo{FullMethod: "/proto.Metrics/PostMetrics"}
+	assert.NoError(t, interceptor(nil, stream, info, handler))
+	err := interceptor(nil, stream, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	other := &grpc.StreamServerInfo{FullMethod: "/proto.Replication/Replicate"}
+	assert.NoError(t, interceptor(nil, stream, other, handler), "методы вне списка не ограничиваются")
+}
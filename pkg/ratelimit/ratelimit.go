@@ -0,0 +1,421 @@
+// Module for per-agent rate limits and metric quotas
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Причины отказа
+const (
+	ReasonRequests    = "requests"    // превышен лимит запросов в секунду
+	ReasonMetrics     = "metrics"     // превышен лимит метрик в секунду
+	ReasonCardinality = "cardinality" // превышена квота различных метрик
+)
+
+var (
+	// Превышен лимит запросов или метрик в секунду
+	ErrRateLimited = errors.New("rate limited")
+	// Превышена квота на число различных метрик агента
+	ErrQuotaExceeded = errors.New("metric quota exceeded")
+)
+
+// Лимиты на одного агента. Нулевое значение отключает ограничение
+type Limits struct {
+	RequestRate  float64 // запросов в секунду
+	RequestBurst int     // запас запросов, по умолчанию равен RequestRate
+	MetricRate   float64 // метрик в секунду
+	MetricBurst  int     // запас метрик, по умолчанию равен MetricRate
+	MaxMetricIDs int     // число различных метрик, которые может записать агент
+}
+
+// Включено ли хотя бы одно ограничение
+func (l Limits) Enabled() bool {
+	return l.RequestRate > 0 || l.MetricRate > 0 || l.MaxMetricIDs > 0
+}
+
+// Token bucket. Запрос больше запаса пропускается при полном запасе и уводит
+// его в минус, поэтому большие пачки не отклоняются навсегда
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	size := float64(burst)
+	if size <= 0 {
+		size = math.Max(math.Ceil(rate), 1)
+	}
+	return &bucket{rate: rate, burst: size, tokens: size, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *bucket) allow(n float64) bool {
+	return b.tokens >= math.Min(n, b.burst)
+}
+
+// Агент без метрик в квоте, не присылавший запросов дольше этого времени,
+// забывается, чтобы карта агентов не росла бесконечно
+const agentIdleTimeout = 10 * time.Minute
+
+// Метрика в квоте агента
+type quotaID struct {
+	pending   int  // кол-во резервов, пачки которых еще не сохранены
+	committed bool // метрика сохранена
+}
+
+// Состояние одного агента
+type agentState struct {
+	requests *bucket
+	metrics  *bucket
+	ids      map[string]*quotaID
+	lastSeen time.Time
+}
+
+// Агент давно не присылал запросов, не держит квоту и его запасы полны,
+// поэтому его состояние можно создать заново без потери учета
+func (state *agentState) idle(now time.Time) bool {
+	if now.Sub(state.lastSeen) < agentIdleTimeout || len(state.ids) > 0 {
+		return false
+	}
+	for _, b := range []*bucket{state.requests, state.metrics} {
+		if b != nil {
+			b.refill(now)
+			if b.tokens < b.burst {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Ограничитель запросов и метрик по агентам. Агент определяется по имени
+// токена или адресу клиента
+type Limiter struct {
+	limits    Limits
+	onReject  func(reason string)
+	mu        sync.Mutex
+	agents    map[string]*agentState
+	lastSweep time.Time
+}
+
+// Init limiter. onReject вызывается на каждый отказ, может быть nil
+func New(limits Limits, onReject func(reason string)) *Limiter {
+	return &Limiter{
+		limits:   limits,
+		onReject: onReject,
+		agents:   map[string]*agentState{},
+	}
+}
+
+// Состояние агента, вызывается под l.mu. Заодно раз в agentIdleTimeout
+// забываются простаивающие агенты
+func (l *Limiter) agent(name string, now time.Time) *agentState {
+	if now.Sub(l.lastSweep) >= agentIdleTimeout {
+		for agentName, state := range l.agents {
+			if state.idle(now) {
+				delete(l.agents, agentName)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	state, ok := l.agents[name]
+	if !ok {
+		state = &agentState{ids: map[string]*quotaID{}}
+		if l.limits.RequestRate > 0 {
+			state.requests = newBucket(l.limits.RequestRate, l.limits.RequestBurst, now)
+		}
+		if l.limits.MetricRate > 0 {
+			state.metrics = newBucket(l.limits.MetricRate, l.limits.MetricBurst, now)
+		}
+		l.agents[name] = state
+	}
+	if now.After(state.lastSeen) {
+		state.lastSeen = now
+	}
+	return state
+}
+
+func (l *Limiter) reject(reason string, err error) error {
+	if l.onReject != nil {
+		l.onReject(reason)
+	}
+	return err
+}
+
+// Учет одного запроса агента
+func (l *Limiter) AllowRequest(name string, now time.Time) error {
+	l.mu.Lock()
+	state := l.agent(name, now)
+	allowed := true
+	if state.requests != nil {
+		state.requests.refill(now)
+		allowed = state.requests.allow(1)
+		if allowed {
+			state.requests.tokens--
+		}
+	}
+	l.mu.Unlock()
+
+	if !allowed {
+		return l.reject(ReasonRequests, ErrRateLimited)
+	}
+	return nil
+}
+
+// Запрос агента, за который списан токен лимита запросов
+type Request struct {
+	limiter  *Limiter
+	name     string
+	charged  bool
+	refunded atomic.Bool
+}
+
+// Списание токена за запрос агента. Токен не возвращается, даже если запрос
+// ничего не сохранил: иначе поток ошибочных запросов не ограничивался бы.
+// Исключение - повтор уже примененной пачки, см. Reservation.Duplicate
+func (l *Limiter) BeginRequest(name string, now time.Time) (*Request, error) {
+	if err := l.AllowRequest(name, now); err != nil {
+		return nil, err
+	}
+	return &Request{limiter: l, name: name, charged: true}, nil
+}
+
+// Возврат токена за запрос. Токен возвращается не больше одного раза
+func (r *Request) refund() {
+	if !r.charged || !r.refunded.CompareAndSwap(false, true) {
+		return
+	}
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	if state, ok := r.limiter.agents[r.name]; ok && state.requests != nil {
+		state.requests.tokens = math.Min(state.requests.burst, state.requests.tokens+1)
+	}
+}
+
+// Резерв лимита метрик и квоты под пачку. Пока пачка сохраняется, ее новые метрики
+// занимают квоту, чтобы конкурентные пачки не превысили ее вместе. После сохранения
+// резерв подтверждается через Commit, а при ошибке или повторе пачки - отменяется через Cancel
+type Reservation struct {
+	request *Request
+	ids     []string
+	metrics float64
+	done    bool
+}
+
+// Резерв лимитов под запись метрик агентом. Пачка принимается или отклоняется целиком
+func (l *Limiter) ReserveMetrics(name string, ids []string, now time.Time) (*Reservation, error) {
+	return l.reserve(&Request{limiter: l, name: name}, ids, now)
+}
+
+func (l *Limiter) reserve(request *Request, ids []string, now time.Time) (*Reservation, error) {
+	l.mu.Lock()
+	state := l.agent(request.name, now)
+
+	uniqueIDs := make([]string, 0, len(ids))
+	seen := make(map[string]struct{}, len(ids))
+	newIDs := 0
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		uniqueIDs = append(uniqueIDs, id)
+		if _, ok := state.ids[id]; !ok {
+			newIDs++
+		}
+	}
+	if l.limits.MaxMetricIDs > 0 && len(state.ids)+newIDs > l.limits.MaxMetricIDs {
+		l.mu.Unlock()
+		return nil, l.reject(ReasonCardinality, ErrQuotaExceeded)
+	}
+	reservation := &Reservation{request: request}
+	if state.metrics != nil {
+		state.metrics.refill(now)
+		if !state.metrics.allow(float64(len(ids))) {
+			l.mu.Unlock()
+			return nil, l.reject(ReasonMetrics, ErrRateLimited)
+		}
+		state.metrics.tokens -= float64(len(ids))
+		reservation.metrics = float64(len(ids))
+	}
+	if l.limits.MaxMetricIDs > 0 {
+		for _, id := range uniqueIDs {
+			quota, ok := state.ids[id]
+			if !ok {
+				quota = &quotaID{}
+				state.ids[id] = quota
+			}
+			quota.pending++
+		}
+		reservation.ids = uniqueIDs
+	}
+	l.mu.Unlock()
+	return reservation, nil
+}
+
+// Подтверждение резерва после сохранения пачки: метрики остаются в квоте
+func (r *Reservation) Commit() {
+	if r == nil || r.done {
+		return
+	}
+	r.done = true
+
+	l := r.request.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.agents[r.request.name]
+	if !ok {
+		return
+	}
+	for _, id := range r.ids {
+		if quota, ok := state.ids[id]; ok {
+			quota.pending--
+			quota.committed = true
+		}
+	}
+}
+
+// Отмена резерва, если пачка уже была применена: агенту возвращаются лимит
+// метрик, квота и токен запроса, повторивший пачку агент не расходует лимиты
+func (r *Reservation) Duplicate() {
+	if r == nil || r.done {
+		return
+	}
+	r.Cancel()
+	r.request.refund()
+}
+
+// Отмена резерва, если пачка не сохранилась: лимит метрик и квота
+// возвращаются агенту, токен запроса остается списанным
+func (r *Reservation) Cancel() {
+	if r == nil || r.done {
+		return
+	}
+	r.done = true
+
+	l := r.request.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.agents[r.request.name]
+	if !ok {
+		return
+	}
+	if state.metrics != nil {
+		state.metrics.tokens = math.Min(state.metrics.burst, state.metrics.tokens+r.metrics)
+	}
+	for _, id := range r.ids {
+		if quota, ok := state.ids[id]; ok {
+			quota.pending--
+			if quota.pending <= 0 && !quota.committed {
+				delete(state.ids, id)
+			}
+		}
+	}
+}
+
+// Учет записи метрик агентом, которые сохраняются сразу же
+func (l *Limiter) AllowMetrics(name string, ids []string, now time.Time) error {
+	reservation, err := l.ReserveMetrics(name, ids, now)
+	reservation.Commit()
+	return err
+}
+
+// Ключ метрики в квоте агента. Метрики разных типов с одним именем -
+// разные метрики хранилища, поэтому учитываются отдельно
+func MetricKey(mType string, id string) string {
+	return mType + "/" + id
+}
+
+// Освобождение квоты удаленных метрик у всех агентов по ключам MetricKey.
+// Метрики пачек, которые сейчас сохраняются, остаются в квоте до конца сохранения
+func (l *Limiter) Release(keys ...string) {
+	if l == nil || len(keys) == 0 {
+		return
+	}
+	released := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		released[key] = struct{}{}
+	}
+	l.ReleaseMatching(func(key string) bool {
+		_, ok := released[key]
+		return ok
+	})
+}
+
+// Освобождение квоты метрик, удаленных по шаблону имени. Пустой mType
+// означает метрики всех типов
+func (l *Limiter) ReleasePattern(mType string, pattern string) {
+	l.ReleaseMatching(func(key string) bool {
+		keyType, id, _ := strings.Cut(key, "/")
+		if mType != "" && keyType != mType {
+			return false
+		}
+		matched, _ := path.Match(pattern, id)
+		return matched
+	})
+}
+
+// Освобождение квоты метрик, для ключей которых release вернул true, у всех агентов
+func (l *Limiter) ReleaseMatching(release func(key string) bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, state := range l.agents {
+		for id, quota := range state.ids {
+			if !release(id) {
+				continue
+			}
+			quota.committed = false
+			if quota.pending <= 0 {
+				delete(state.ids, id)
+			}
+		}
+	}
+}
+
+type agentKey struct{}
+
+// Контекст с агентом, для которого учитываются метрики
+func WithAgent(ctx context.Context, limiter *Limiter, name string) context.Context {
+	return WithRequest(ctx, &Request{limiter: limiter, name: name})
+}
+
+// Контекст с запросом агента, для которого учитываются метрики
+func WithRequest(ctx context.Context, request *Request) context.Context {
+	return context.WithValue(ctx, agentKey{}, request)
+}
+
+// Резерв лимитов под запись метрик агентом из контекста. Запросы без агента
+// не ограничиваются, для них возвращается пустой резерв
+func ReserveMetrics(ctx context.Context, ids ...string) (*Reservation, error) {
+	request, ok := ctx.Value(agentKey{}).(*Request)
+	if !ok {
+		return nil, nil
+	}
+	return request.limiter.reserve(request, ids, time.Now())
+}
+
+// Учет записи метрик агентом из контекста, которые сохраняются сразу же
+func AllowMetrics(ctx context.Context, ids ...string) error {
+	reservation, err := ReserveMetrics(ctx, ids...)
+	reservation.Commit()
+	return err
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestRate(t *testing.T) {
+	var rejected []string
+	limiter := New(Limits{RequestRate: 2}, func(reason string) {
+		rejected = append(rejected, reason)
+	})
+	now := time.Now()
+
+	assert.NoError(t, limiter.AllowRequest("agent", now))
+	assert.NoError(t, limiter.AllowRequest("agent", now))
+	assert.ErrorIs(t, limiter.AllowRequest("agent", now), ErrRateLimited)
+	assert.NoError(t, limiter.AllowRequest("other", now), "лимит считается отдельно для каждого агента")
+	assert.NoError(t, limiter.AllowRequest("agent", now.Add(500*time.Millisecond)), "запас пополняется со временем")
+	assert.Equal(t, []string{ReasonRequests}, rejected)
+}
+
+func TestMetricRate(t *testing.T) {
+	limiter := New(Limits{MetricRate: 10, MetricBurst: 5}, nil)
+	now := time.Now()
+
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"a", "b", "c"}, now))
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{"a", "b", "c"}, now), ErrRateLimited)
+	assert.NoError(t, limiter.AllowMetrics("agent", make([]string, 20), now.Add(time.Second)), "пачка больше запаса проходит при полном запасе")
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{"a"}, now.Add(2*time.Second)), ErrRateLimited, "большая пачка уводит запас в минус")
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"a"}, now.Add(4*time.Second)))
+}
+
+func TestCardinality(t *testing.T) {
+	var rejected []string
+	limiter := New(Limits{MaxMetricIDs: 2}, func(reason string) {
+		rejected = append(rejected, reason)
+	})
+	now := time.Now()
+
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"a", "b", "a"}, now))
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"b"}, now), "известные метрики не расходуют квоту")
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{"a", "c"}, now), ErrQuotaExceeded)
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"a"}, now), "отклоненная пачка не должна расходовать квоту")
+	assert.Equal(t, []string{ReasonCardinality}, rejected)
+}
+
+func TestContext(t *testing.T) {
+	limiter := New(Limits{MaxMetricIDs: 1}, nil)
+	assert.NoError(t, AllowMetrics(context.Background(), "a", "b"), "без агента в контексте ограничений нет")
+
+	ctx := WithAgent(context.Background(), limiter, "agent")
+	assert.NoError(t, AllowMetrics(ctx, "a"))
+	assert.ErrorIs(t, AllowMetrics(ctx, "b"), ErrQuotaExceeded)
+}
+
+func TestReservation(t *testing.T) {
+	limiter := New(Limits{MetricRate: 1, MetricBurst: 2, MaxMetricIDs: 2}, nil)
+	now := time.Now()
+
+	reservation, err := limiter.ReserveMetrics("agent", []string{"a", "b"}, now)
+	assert.NoError(t, err)
+	_, err = limiter.ReserveMetrics("agent", []string{"c"}, now)
+	assert.ErrorIs(t, err, ErrQuotaExceeded, "несохраненная пачка держит квоту")
+	reservation.Cancel()
+	reservation.Commit()
+
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"c", "d"}, now), "отмененная пачка возвращает квоту и лимит метрик")
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{"a"}, now.Add(2*time.Second)), ErrQuotaExceeded)
+}
+
+func TestRequestRefund(t *testing.T) {
+	limiter := New(Limits{RequestRate: 1, RequestBurst: 2}, nil)
+	now := time.Now()
+
+	request, err := limiter.BeginRequest("agent", now)
+	assert.NoError(t, err)
+	reservation, err := ReserveMetrics(WithRequest(context.Background(), request), "a")
+	assert.NoError(t, err)
+	reservation.Cancel()
+
+	request, err = limiter.BeginRequest("agent", now)
+	assert.NoError(t, err)
+	reservation, err = ReserveMetrics(WithRequest(context.Background(), request), "a")
+	assert.NoError(t, err)
+	reservation.Duplicate()
+	reservation.Duplicate()
+
+	_, err = limiter.BeginRequest("agent", now)
+	assert.NoError(t, err, "повтор примененной пачки не расходует лимит запросов")
+	_, err = limiter.BeginRequest("agent", now)
+	assert.ErrorIs(t, err, ErrRateLimited, "запрос, который не сохранил пачку, расходует лимит")
+}
+
+func TestRelease(t *testing.T) {
+	limiter := New(Limits{MaxMetricIDs: 2}, nil)
+	now := time.Now()
+
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"a", "b"}, now))
+	limiter.Release("a")
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"b", "c"}, now), "удаленная метрика не занимает квоту")
+
+	pending, err := limiter.ReserveMetrics("agent", []string{"b"}, now)
+	assert.NoError(t, err)
+	limiter.ReleaseMatching(func(id string) bool { return true })
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{"d"}, now))
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{"e"}, now), ErrQuotaExceeded, "метрика сохраняемой пачки остается в квоте")
+	pending.Commit()
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{"e"}, now), ErrQuotaExceeded)
+
+	var nilLimiter *Limiter
+	nilLimiter.Release("a")
+}
+
+func TestReleasePattern(t *testing.T) {
+	limiter := New(Limits{MaxMetricIDs: 3}, nil)
+	now := time.Now()
+
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{MetricKey("gauge", "Host1"), MetricKey("counter", "Host1"), MetricKey("gauge", "Alloc")}, now))
+	limiter.ReleasePattern("gauge", "Host*")
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{MetricKey("gauge", "Host2")}, now))
+	assert.ErrorIs(t, limiter.AllowMetrics("agent", []string{MetricKey("gauge", "Host3")}, now), ErrQuotaExceeded, "counter с тем же именем остается в квоте")
+
+	limiter.ReleasePattern("", "Host*")
+	assert.NoError(t, limiter.AllowMetrics("agent", []string{MetricKey("gauge", "Host3"), MetricKey("counter", "Host3")}, now))
+}
+
+func TestIdleAgents(t *testing.T) {
+	limiter := New(Limits{RequestRate: 1, MaxMetricIDs: 1}, nil)
+	now := time.Now()
+
+	assert.NoError(t, limiter.AllowRequest("idle", now))
+	assert.NoError(t, limiter.AllowMetrics("quota", []string{"a"}, now))
+	assert.NoError(t, limiter.AllowRequest("active", now.Add(agentIdleTimeout)))
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	assert.NotContains(t, limiter.agents, "idle")
+	assert.Contains(t, limiter.agents, "quota", "агент с метриками в квоте не забывается")
+	assert.Contains(t, limiter.agents, "active")
+}
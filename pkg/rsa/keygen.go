@@ -0,0 +1,40 @@
+package rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+)
+
+const (
+	privateKeyType = "RSA PRIVATE KEY"
+	publicKeyType  = "RSA PUBLIC KEY"
+)
+
+// Генерация пары ключей PKCS#1 в директории dir. Приватный ключ сохраняется
+// в <keyID>.key, публичный - в <keyID>.pub. Возвращает пути к файлам
+func GenerateKeyPair(dir string, keyID string, bits int) (string, string, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+
+	privatePath := filepath.Join(dir, keyID+".key")
+	publicPath := filepath.Join(dir, keyID+".pub")
+
+	privateBytes := pem.EncodeToMemory(&pem.Block{Type: privateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if err := os.WriteFile(privatePath, privateBytes, 0600); err != nil {
+		return "", "", err
+	}
+	publicBytes := pem.EncodeToMemory(&pem.Block{Type: publicKeyType, Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)})
+	if err := os.WriteFile(publicPath, publicBytes, 0644); err != nil {
+		return "", "", err
+	}
+	return privatePath, publicPath, nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
@@ -15,7 +16,10 @@ import (
 	pb "github.com/ry461ch/metric-collector/pkg/rsa/encrypted"
 )
 
-// Проверка подписи пришедшего запроса
+// Заголовок и ключ метаданных grpc с идентификатором ключа шифрования
+const KeyIDHeader = "X-Key-ID"
+
+// Расшифровка тела пришедшего запроса ключом из заголовка X-Key-ID
 func DecryptRequest(decrypter *rsa.RsaDecrypter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -26,7 +30,7 @@ func DecryptRequest(decrypter *rsa.RsaDecrypter) func(http.Handler) http.Handler
 				return
 			}
 			reqBody := buf.Bytes()
-			reqDecrypted, err := decrypter.Decrypt(reqBody)
+			reqDecrypted, err := decrypter.DecryptWithKey(r.Header.Get(KeyIDHeader), reqBody)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -41,6 +45,7 @@ func DecryptRequest(decrypter *rsa.RsaDecrypter) func(http.Handler) http.Handler
 type decrypterServerStream struct {
 	grpc.ServerStream
 	decrypter *rsa.RsaDecrypter
+	keyID     string
 }
 
 // Расшифровка полученных данных в grpc interceptore
@@ -51,7 +56,7 @@ func (dss *decrypterServerStream) RecvMsg(req interface{}) error {
 		return err
 	}
 
-	reqDecrypted, err := dss.decrypter.Decrypt(msg.Data)
+	reqDecrypted, err := dss.decrypter.DecryptWithKey(dss.keyID, msg.Data)
 	if err != nil {
 		return status.Errorf(codes.Unauthenticated, "can't parse input data: %v", err)
 	}
@@ -66,6 +71,11 @@ func DecryptStreamServerInterceptor(decrypter *rsa.RsaDecrypter) grpc.StreamServ
 			ServerStream: ss,
 			decrypter:    decrypter,
 		}
+		if md, ok := metadata.FromIncomingContext(ss.Context()); ok {
+			if values := md.Get(KeyIDHeader); len(values) > 0 {
+				wrappedStream.keyID = values[0]
+			}
+		}
 
 		return handler(srv, wrappedStream)
 	}
@@ -101,7 +111,8 @@ func (ecs *encryptedClientStream) SendMsg(req interface{}) error {
 // Interceptor шифровки сообщений на стороне клиента
 func EncryptStreamClientInterceptor(encrypter *rsa.RsaEncrypter) grpc.StreamClientInterceptor {
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		newCtx := metadata.AppendToOutgoingContext(ctx, KeyIDHeader, encrypter.KeyID())
+		clientStream, err := streamer(newCtx, desc, cc, method, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/resty.v1"
 
 	rsacomponent "github.com/ry461ch/metric-collector/pkg/rsa"
@@ -82,3 +83,26 @@ func TestBase(t *testing.T) {
 	resp, _ = client.R().SetBody(reqStr).Post(srv.URL + "/")
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Invalid status code")
 }
+
+func TestKeyIDHeader(t *testing.T) {
+	dir := t.TempDir()
+	_, oldPublic, err := rsacomponent.GenerateKeyPair(dir, "old", 2048)
+	require.NoError(t, err)
+	_, newPublic, err := rsacomponent.GenerateKeyPair(dir, "new", 2048)
+	require.NoError(t, err)
+	decrypter := rsacomponent.NewDecrypter(dir)
+	require.NoError(t, decrypter.Initialize(context.TODO()))
+
+	srv := httptest.NewServer(mockRouter(t, decrypter))
+	defer srv.Close()
+
+	for _, publicPath := range []string{oldPublic, newPublic} {
+		encrypter := rsacomponent.NewEncrypter(publicPath)
+		require.NoError(t, encrypter.Initialize(context.TODO()))
+		body, err := encrypter.Encrypt([]byte("Test"))
+		require.NoError(t, err)
+
+		resp, _ := resty.New().R().SetHeader(KeyIDHeader, encrypter.KeyID()).SetBody(body).Post(srv.URL + "/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode(), "Оба активных ключа должны приниматься")
+	}
+}
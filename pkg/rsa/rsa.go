@@ -8,23 +8,46 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Идентификатор ключа по имени файла без расширения
+func keyIDFromPath(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
 // Шифровальщик запросов RSA
 type RsaEncrypter struct {
 	secretKeyFile string
+	mu            sync.RWMutex
 	publicKey     *rsa.PublicKey
+	keyID         string
+	fingerprint   string
 }
 
 // Создание инстанса шифровальщика RSA
 func NewEncrypter(secretKeyFile string) *RsaEncrypter {
-	return &RsaEncrypter{secretKeyFile: secretKeyFile}
+	return &RsaEncrypter{secretKeyFile: secretKeyFile, keyID: keyIDFromPath(secretKeyFile)}
 }
 
 // Инициализация шифровальщика
 func (re *RsaEncrypter) Initialize(ctx context.Context) error {
-	var secretKey []byte
+	return re.Reload()
+}
+
+// Перечитывание публичного ключа. При ошибке остается прежний ключ
+func (re *RsaEncrypter) Reload() error {
+	fingerprint, err := keysFingerprint(re.secretKeyFile)
+	if err != nil {
+		return err
+	}
 	secretKey, err := os.ReadFile(re.secretKeyFile)
 	if err != nil {
 		return err
@@ -34,60 +57,229 @@ func (re *RsaEncrypter) Initialize(ctx context.Context) error {
 	if block == nil {
 		return errors.New("invalid pem key")
 	}
-	re.publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
 	if err != nil {
 		return err
 	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.publicKey = publicKey
+	re.fingerprint = fingerprint
 	return nil
 }
 
+// Идентификатор ключа, которым шифруются сообщения
+func (re *RsaEncrypter) KeyID() string {
+	return re.keyID
+}
+
+// Слежение за файлом ключа: ключ перечитывается по SIGHUP и при изменении файла
+func (re *RsaEncrypter) Watch(ctx context.Context, interval time.Duration, onReload func(err error)) {
+	watch(ctx, interval, re.changed, re.Reload, onReload)
+}
+
+func (re *RsaEncrypter) changed() bool {
+	fingerprint, err := keysFingerprint(re.secretKeyFile)
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+	return err == nil && fingerprint != re.fingerprint
+}
+
 // Шифрование сообщения публичным ключом
 func (re *RsaEncrypter) Encrypt(sourceText []byte) ([]byte, error) {
+	re.mu.RLock()
+	publicKey := re.publicKey
+	re.mu.RUnlock()
+	if publicKey == nil {
+		return nil, errors.New("public key is not loaded")
+	}
+
 	sha256Hash := sha256.New()
 	var label []byte
-	encryptedText, err := rsa.EncryptOAEP(sha256Hash, rand.Reader, re.publicKey, sourceText, label)
+	encryptedText, err := rsa.EncryptOAEP(sha256Hash, rand.Reader, publicKey, sourceText, label)
 	if err != nil {
 		return nil, err
 	}
 	return encryptedText, nil
 }
 
-// Расшифровщик запросов RSA
+type privateKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// Расшифровщик запросов RSA. Ключ берется из файла или из всех приватных
+// ключей директории, каждый из которых считается активным
 type RsaDecrypter struct {
 	secretKeyFile string
-	privateKey    *rsa.PrivateKey
+	mu            sync.RWMutex
+	keys          []privateKey
+	fingerprint   string
 }
 
-// Создание инстанса расшифровщика
+// Создание инстанса расшифровщика. secretKeyFile - файл ключа или директория с ключами
 func NewDecrypter(secretKeyFile string) *RsaDecrypter {
 	return &RsaDecrypter{secretKeyFile: secretKeyFile}
 }
 
 // Инициализация расшифровщика
 func (rd *RsaDecrypter) Initialize(ctx context.Context) error {
-	var secretKey []byte
-	secretKey, err := os.ReadFile(rd.secretKeyFile)
+	return rd.Reload()
+}
+
+// Загрузка ключа из одного файла
+func loadPrivateKeyFile(path string) (*rsa.PrivateKey, error) {
+	secretKey, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	block, _ := pem.Decode(secretKey)
 	if block == nil {
-		return errors.New("invalid pem key")
+		return nil, errors.New("invalid pem key")
 	}
-	rd.privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// Загрузка всех приватных ключей директории. Файлы без приватного ключа,
+// например публичные ключи из той же пары, пропускаются
+func loadPrivateKeyDir(dir string) ([]privateKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []privateKey
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(content)
+		if block == nil || block.Type != privateKeyType {
+			continue
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", entry.Name(), err)
+		}
+		keys = append(keys, privateKey{id: keyIDFromPath(path), key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no private keys in %s", dir)
+	}
+	return keys, nil
+}
+
+// Перечитывание ключей. При ошибке остаются прежние ключи
+func (rd *RsaDecrypter) Reload() error {
+	fingerprint, err := keysFingerprint(rd.secretKeyFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(rd.secretKeyFile)
 	if err != nil {
 		return err
 	}
+
+	var keys []privateKey
+	if info.IsDir() {
+		keys, err = loadPrivateKeyDir(rd.secretKeyFile)
+	} else {
+		var key *rsa.PrivateKey
+		key, err = loadPrivateKeyFile(rd.secretKeyFile)
+		keys = []privateKey{{id: keyIDFromPath(rd.secretKeyFile), key: key}}
+	}
+	if err != nil {
+		return err
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.keys = keys
+	rd.fingerprint = fingerprint
 	return nil
 }
 
+// Идентификаторы активных ключей
+func (rd *RsaDecrypter) KeyIDs() []string {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	ids := make([]string, 0, len(rd.keys))
+	for _, key := range rd.keys {
+		ids = append(ids, key.id)
+	}
+	return ids
+}
+
+// Слежение за ключами: ключи перечитываются по SIGHUP и при изменении файлов
+func (rd *RsaDecrypter) Watch(ctx context.Context, interval time.Duration, onReload func(err error)) {
+	watch(ctx, interval, rd.changed, rd.Reload, onReload)
+}
+
+func (rd *RsaDecrypter) changed() bool {
+	fingerprint, err := keysFingerprint(rd.secretKeyFile)
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return err == nil && fingerprint != rd.fingerprint
+}
+
 // Расшифровка сообщения приватным ключом
 func (rd *RsaDecrypter) Decrypt(encryptedText []byte) ([]byte, error) {
-	sha256Hash := sha256.New()
+	return rd.DecryptWithKey("", encryptedText)
+}
+
+// Расшифровка сообщения ключом с идентификатором keyID. Если такого ключа нет
+// или он не подошел, пробуются остальные активные ключи
+func (rd *RsaDecrypter) DecryptWithKey(keyID string, encryptedText []byte) ([]byte, error) {
+	rd.mu.RLock()
+	keys := make([]privateKey, 0, len(rd.keys))
+	for _, key := range rd.keys {
+		if key.id == keyID {
+			keys = append([]privateKey{key}, keys...)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	rd.mu.RUnlock()
+
+	err := errors.New("private key is not loaded")
 	var label []byte
-	decryptedText, err := rsa.DecryptOAEP(sha256Hash, rand.Reader, rd.privateKey, encryptedText, label)
+	for _, key := range keys {
+		var decryptedText []byte
+		decryptedText, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key.key, encryptedText, label)
+		if err == nil {
+			return decryptedText, nil
+		}
+	}
+	return nil, err
+}
+
+// Отпечаток файла или содержимого директории по именам, размерам и времени
+// изменения файлов
+func keysFingerprint(path string) (string, error) {
+	info, err := os.Stat(path)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if !info.IsDir() {
+		return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entryInfo, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", entry.Name(), entryInfo.Size(), entryInfo.ModTime().UnixNano()))
 	}
-	return decryptedText, nil
+	sort.Strings(parts)
+	return strings.Join(parts, ","), nil
 }
@@ -7,9 +7,12 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBase(t *testing.T) {
@@ -67,3 +70,101 @@ func TestInvalid(t *testing.T) {
 	err = decrypter.Initialize(context.TODO())
 	assert.Error(t, err, "Not an error")
 }
+
+func TestGenerateKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	privatePath, publicPath, err := GenerateKeyPair(dir, "2024-01", 2048)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "2024-01.key"), privatePath)
+	assert.Equal(t, filepath.Join(dir, "2024-01.pub"), publicPath)
+
+	info, err := os.Stat(privatePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Приватный ключ должен быть доступен только владельцу")
+
+	encrypter := NewEncrypter(publicPath)
+	require.NoError(t, encrypter.Initialize(context.TODO()))
+	assert.Equal(t, "2024-01", encrypter.KeyID())
+	decrypter := NewDecrypter(privatePath)
+	require.NoError(t, decrypter.Initialize(context.TODO()))
+
+	encrypted, err := encrypter.Encrypt([]byte("Test"))
+	require.NoError(t, err)
+	decrypted, err := decrypter.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("Test"), decrypted)
+}
+
+func TestKeyDir(t *testing.T) {
+	dir := t.TempDir()
+	_, oldPublic, err := GenerateKeyPair(dir, "old", 2048)
+	require.NoError(t, err)
+	_, newPublic, err := GenerateKeyPair(dir, "new", 2048)
+	require.NoError(t, err)
+
+	decrypter := NewDecrypter(dir)
+	require.NoError(t, decrypter.Initialize(context.TODO()))
+	assert.ElementsMatch(t, []string{"old", "new"}, decrypter.KeyIDs())
+
+	for _, publicPath := range []string{oldPublic, newPublic} {
+		encrypter := NewEncrypter(publicPath)
+		require.NoError(t, encrypter.Initialize(context.TODO()))
+		encrypted, err := encrypter.Encrypt([]byte("Test"))
+		require.NoError(t, err)
+
+		decrypted, err := decrypter.DecryptWithKey(encrypter.KeyID(), encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("Test"), decrypted)
+
+		decrypted, err = decrypter.DecryptWithKey("unknown", encrypted)
+		require.NoError(t, err, "Неизвестный идентификатор не должен мешать расшифровке активным ключом")
+		assert.Equal(t, []byte("Test"), decrypted)
+	}
+
+	// выведенный из оборота ключ перестает приниматься после перезагрузки
+	oldEncrypter := NewEncrypter(oldPublic)
+	require.NoError(t, oldEncrypter.Initialize(context.TODO()))
+	require.NoError(t, os.Remove(filepath.Join(dir, "old.key")))
+	require.NoError(t, decrypter.Reload())
+	assert.Equal(t, []string{"new"}, decrypter.KeyIDs())
+	encrypted, _ := oldEncrypter.Encrypt([]byte("Test"))
+	_, err = decrypter.Decrypt(encrypted)
+	assert.Error(t, err)
+
+	emptyDir := t.TempDir()
+	assert.Error(t, NewDecrypter(emptyDir).Initialize(context.TODO()), "Директория без ключей должна давать ошибку")
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	_, _, err := GenerateKeyPair(dir, "first", 2048)
+	require.NoError(t, err)
+	decrypter := NewDecrypter(dir)
+	require.NoError(t, decrypter.Initialize(context.TODO()))
+
+	reloaded := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		decrypter.Watch(ctx, 10*time.Millisecond, func(err error) {
+			reloaded <- err
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// ключ появляется в директории атомарно, как при выкладке через rename
+	secondPrivate, _, err := GenerateKeyPair(t.TempDir(), "second", 2048)
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(secondPrivate, filepath.Join(dir, "second.key")))
+	select {
+	case err := <-reloaded:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ключи не перечитаны после изменения директории")
+	}
+	assert.ElementsMatch(t, []string{"first", "second"}, decrypter.KeyIDs())
+}
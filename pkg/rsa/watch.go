@@ -0,0 +1,40 @@
+package rsa
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Перезагрузка ключей по SIGHUP и по изменению файлов, которое проверяется
+// раз в interval. При нулевом interval файлы не проверяются
+func watch(ctx context.Context, interval time.Duration, changed func() bool, reload func() error, onReload func(err error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			if !changed() {
+				continue
+			}
+		}
+		err := reload()
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+}
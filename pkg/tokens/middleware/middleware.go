@@ -0,0 +1,136 @@
+package tokensmiddleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ry461ch/metric-collector/pkg/logging"
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+)
+
+// Токен из заголовка Authorization вида "Bearer <token>"
+func bearerToken(header string) string {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Проверка токена и права. Возвращает контекст с владельцем токена
+func authorize(ctx context.Context, store *tokens.Store, token string, scope string) (context.Context, codes.Code) {
+	if token == "" {
+		return ctx, codes.Unauthenticated
+	}
+	identity, ok := store.Lookup(token)
+	if !ok {
+		return ctx, codes.Unauthenticated
+	}
+	logging.AddRequestFields(ctx, "agent", identity.Name)
+	if !identity.Has(scope) {
+		return ctx, codes.PermissionDenied
+	}
+	return tokens.WithIdentity(ctx, identity), codes.OK
+}
+
+// Проверка bearer токена и его права на запрос
+func RequireScope(store *tokens.Store, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx, code := authorize(req.Context(), store, bearerToken(req.Header.Get("Authorization")), scope)
+			switch code {
+			case codes.Unauthenticated:
+				res.Header().Set("WWW-Authenticate", "Bearer")
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			case codes.PermissionDenied:
+				res.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(res, req.WithContext(ctx))
+		})
+	}
+}
+
+// Право, необходимое для вызова grpc метода. Методы, которых нет в scopes,
+// требуют права admin
+func methodScope(scopes map[string]string, method string) string {
+	if scope, ok := scopes[method]; ok {
+		return scope
+	}
+	return tokens.ScopeAdmin
+}
+
+// Проверка токена из метаданных grpc запроса. Методы с правом public
+// вызываются без токена
+func authorizeGRPC(ctx context.Context, store *tokens.Store, scope string) (context.Context, error) {
+	if scope == tokens.ScopePublic {
+		return ctx, nil
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = bearerToken(values[0])
+		}
+	}
+	ctx, code := authorize(ctx, store, token, scope)
+	switch code {
+	case codes.Unauthenticated:
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	case codes.PermissionDenied:
+		return nil, status.Error(codes.PermissionDenied, "token has no "+scope+" scope")
+	}
+	return ctx, nil
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Контекст стрима с владельцем токена
+func (as *authorizedStream) Context() context.Context {
+	return as.ctx
+}
+
+// Проверка токена на стороне grpc-сервера. scopes - права по полному имени метода
+func CheckGRPCToken(store *tokens.Store, scopes map[string]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorizeGRPC(ss.Context(), store, methodScope(scopes, info.FullMethod))
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// Проверка токена на стороне grpc-сервера для unary запросов
+func CheckGRPCTokenUnary(store *tokens.Store, scopes map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorizeGRPC(ctx, store, methodScope(scopes, info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Добавление токена на стороне grpc-клиента
+func SetTokenGRPCClientStreamInterceptor(token string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), desc, cc, method, opts...)
+	}
+}
+
+// Добавление токена на стороне grpc-клиента для unary запросов
+func SetTokenGRPCClientUnaryInterceptor(token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,97 @@
+package tokensmiddleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/resty.v1"
+
+	"github.com/ry461ch/metric-collector/pkg/tokens"
+)
+
+func newStore(t *testing.T) *tokens.Store {
+	store, err := tokens.New([]tokens.Token{
+		{Name: "reader", Token: "read-token", Scopes: []string{tokens.ScopeRead}},
+		{Name: "agent", Token: "write-token", Scopes: []string{tokens.ScopeWrite}},
+	})
+	require.NoError(t, err)
+	return store
+}
+
+func TestRequireScope(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(RequireScope(newStore(t), tokens.ScopeWrite))
+	router.Post("/*", func(res http.ResponseWriter, req *http.Request) {
+		identity, ok := tokens.FromContext(req.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "agent", identity.Name)
+		res.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := resty.New()
+	resp, _ := client.R().SetHeader("Authorization", "Bearer write-token").Post(srv.URL + "/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp, _ = client.R().SetHeader("Authorization", "Bearer read-token").Post(srv.URL + "/")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+
+	resp, _ = client.R().SetHeader("Authorization", "Bearer unknown").Post(srv.URL + "/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+	resp, _ = client.R().Post(srv.URL + "/")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+	assert.Equal(t, "Bearer", resp.Header().Get("WWW-Authenticate"))
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	interceptor := CheckGRPCTokenUnary(newStore(t), map[string]string{
+		"/proto.Metrics/GetMetric":     tokens.ScopeRead,
+		"/grpc.health.v1.Health/Check": tokens.ScopePublic,
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		identity, _ := tokens.FromContext(ctx)
+		return identity.Name, nil
+	}
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	resp, err := interceptor(withToken("read-token"), nil, &grpc.UnaryServerInfo{FullMethod: "/proto.Metrics/GetMetric"}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "reader", resp)
+
+	_, err = interceptor(withToken("write-token"), nil, &grpc.UnaryServerInfo{FullMethod: "/proto.Metrics/GetMetric"}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = interceptor(withToken("read-token"), nil, &grpc.UnaryServerInfo{FullMethod: "/proto.Cluster/GetLocal"}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "неперечисленные методы требуют admin")
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/proto.Metrics/GetMetric"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	assert.NoError(t, err, "методы с правом public доступны без токена")
+}
+
+func TestSetTokenUnaryInterceptor(t *testing.T) {
+	interceptor := SetTokenGRPCClientUnaryInterceptor("secret")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		assert.Equal(t, []string{"Bearer secret"}, md.Get("authorization"))
+		return nil
+	}
+	assert.NoError(t, interceptor(context.Background(), "/proto.Cluster/GetLocal", nil, nil, nil, invoker))
+}
@@ -0,0 +1,142 @@
+// Module for per-agent API tokens and their scopes
+package tokens
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Права токена
+const (
+	ScopeRead  = "read"  // чтение метрик и алертов
+	ScopeWrite = "write" // запись метрик
+	ScopeAdmin = "admin" // удаление и сброс метрик, служебные запросы; включает read и write
+)
+
+// Право для grpc методов, которые доступны без токена, например проверки готовности.
+// Токену его выдать нельзя
+const ScopePublic = "public"
+
+// Описание токена в файле токенов
+type Token struct {
+	Name     string   `json:"name"`               // имя агента, попадает в логи
+	Token    string   `json:"token"`              // значение токена
+	Scopes   []string `json:"scopes"`             // права токена
+	Prefixes []string `json:"prefixes,omitempty"` // префиксы имен метрик, которые разрешено писать; пустой - любые
+}
+
+// Файл токенов
+type File struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// Владелец токена, от имени которого выполняется запрос
+type Identity struct {
+	Name     string
+	scopes   []string
+	prefixes []string
+}
+
+// Проверка права. admin включает все остальные права
+func (i *Identity) Has(scope string) bool {
+	return slices.Contains(i.scopes, scope) || slices.Contains(i.scopes, ScopeAdmin)
+}
+
+// Проверка, что токену разрешена запись метрики с таким именем
+func (i *Identity) CanWrite(id string) bool {
+	if !i.Has(ScopeWrite) {
+		return false
+	}
+	if len(i.prefixes) == 0 || slices.Contains(i.scopes, ScopeAdmin) {
+		return true
+	}
+	for _, prefix := range i.prefixes {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Хранилище токенов. Токены хранятся в виде хешей, поиск не зависит от
+// длины общего префикса переданного и известного токена
+type Store struct {
+	identities map[[sha256.Size]byte]*Identity
+}
+
+// Init token store from tokens
+func New(tokenList []Token) (*Store, error) {
+	store := &Store{identities: map[[sha256.Size]byte]*Identity{}}
+	for _, token := range tokenList {
+		if token.Name == "" || token.Token == "" {
+			return nil, fmt.Errorf("token name and value are required")
+		}
+		for _, scope := range token.Scopes {
+			if scope != ScopeRead && scope != ScopeWrite && scope != ScopeAdmin {
+				return nil, fmt.Errorf("token %s: unknown scope %q", token.Name, scope)
+			}
+		}
+		key := sha256.Sum256([]byte(token.Token))
+		if _, ok := store.identities[key]; ok {
+			return nil, fmt.Errorf("token %s: duplicate token value", token.Name)
+		}
+		store.identities[key] = &Identity{
+			Name:     token.Name,
+			scopes:   slices.Clone(token.Scopes),
+			prefixes: slices.Clone(token.Prefixes),
+		}
+	}
+	return store, nil
+}
+
+// Загрузка токенов из json файла
+func Load(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read tokens file: %w", err)
+	}
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("can't parse tokens file: %w", err)
+	}
+	return New(file.Tokens)
+}
+
+// Владелец токена, false если токен неизвестен
+func (s *Store) Lookup(token string) (*Identity, bool) {
+	identity, ok := s.identities[sha256.Sum256([]byte(token))]
+	return identity, ok
+}
+
+type identityKey struct{}
+
+// Контекст с владельцем токена
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// Владелец токена из контекста запроса, false если запрос без токена
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// Проверка, что запрос из контекста может писать все метрики. Запросы без
+// токена не ограничиваются: авторизация выключена или проверяется раньше
+func CanWriteAll(ctx context.Context, ids ...string) bool {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		return true
+	}
+	for _, id := range ids {
+		if !identity.CanWrite(id) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package tokens
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentity(t *testing.T) {
+	store, err := New([]Token{
+		{Name: "reader", Token: "r", Scopes: []string{ScopeRead}},
+		{Name: "agent", Token: "w", Scopes: []string{ScopeWrite}, Prefixes: []string{"host1.", "host2."}},
+		{Name: "admin", Token: "a", Scopes: []string{ScopeAdmin}, Prefixes: []string{"host1."}},
+	})
+	require.NoError(t, err)
+
+	_, ok := store.Lookup("unknown")
+	assert.False(t, ok)
+
+	reader, ok := store.Lookup("r")
+	require.True(t, ok)
+	assert.True(t, reader.Has(ScopeRead))
+	assert.False(t, reader.Has(ScopeWrite))
+	assert.False(t, reader.CanWrite("host1.cpu"))
+
+	agent, _ := store.Lookup("w")
+	assert.Equal(t, "agent", agent.Name)
+	assert.False(t, agent.Has(ScopeRead))
+	assert.True(t, agent.CanWrite("host2.cpu"))
+	assert.False(t, agent.CanWrite("host3.cpu"))
+
+	admin, _ := store.Lookup("a")
+	assert.True(t, admin.Has(ScopeRead))
+	assert.True(t, admin.CanWrite("host3.cpu"), "admin не ограничивается префиксами")
+
+	ctx := WithIdentity(context.Background(), agent)
+	assert.True(t, CanWriteAll(ctx, "host1.cpu", "host2.mem"))
+	assert.False(t, CanWriteAll(ctx, "host1.cpu", "other"))
+	assert.True(t, CanWriteAll(context.Background(), "other"), "запрос без токена не ограничивается")
+}
+
+func TestInvalidTokens(t *testing.T) {
+	_, err := New([]Token{{Name: "agent", Token: "t", Scopes: []string{"delete"}}})
+	assert.Error(t, err)
+	_, err = New([]Token{{Name: "agent", Scopes: []string{ScopeRead}}})
+	assert.Error(t, err)
+	_, err = New([]Token{
+		{Name: "first", Token: "t", Scopes: []string{ScopeRead}},
+		{Name: "second", Token: "t", Scopes: []string{ScopeWrite}},
+	})
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tokens": [{"name": "agent", "token": "secret", "scopes": ["write"], "prefixes": ["host1."]}]}`), 0600))
+
+	store, err := Load(path)
+	require.NoError(t, err)
+	identity, ok := store.Lookup("secret")
+	require.True(t, ok)
+	assert.True(t, identity.CanWrite("host1.cpu"))
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -20,6 +20,9 @@ const docTemplate = `{
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
                 "description": "Get all metrics",
@@ -38,11 +41,193 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Error",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/alerts": {
+            "get": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get pending and firing alerts, resolved alerts are returned only if requested",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get alerts",
+                "operationId": "alertsGetAlerts",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Include resolved alerts",
+                        "name": "resolved",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseAlertListObject"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List metrics sorted by name and type with filtering and cursor pagination",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List metrics",
+                "operationId": "storageListMetrics",
+                "parameters": [
+                    {
+                        "enum": [
+                            "counter",
+                            "gauge"
+                        ],
+                        "type": "string",
+                        "description": "Metric type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Metric name prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Regular expression for metric name",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Page size, from 1 to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor from previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseMetricListObject"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Process is alive, storage and other components are not checked",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Liveness check",
+                "operationId": "infoHealthz",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Response"
+                        }
                     }
                 }
             }
@@ -73,11 +258,174 @@ const docTemplate = `{
                 }
             }
         },
+        "/rate/counter/{name}": {
+            "get": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get counter metric growth rate per second over the configured window",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Get counter growth rate",
+                "operationId": "storageGetPlainCounterRate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Metric name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Storage is initialised, snapshot is restored and gRPC listener is up. Response contains status of every component",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Readiness check",
+                "operationId": "infoReadyz",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Response"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/reset/counter/{name}": {
+            "post": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Set counter metric value to zero",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Reset counter metric",
+                "operationId": "storageResetPlainCounter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Metric name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/update": {
             "post": {
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
                 "description": "Post json metric",
@@ -113,11 +461,35 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Error",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
@@ -127,6 +499,9 @@ const docTemplate = `{
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
                 "description": "Save counter metric",
@@ -161,8 +536,274 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/update/gauge/{name}/{value}": {
+            "post": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Save gauge metric",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Save one metric with gauge type",
+                "operationId": "storagePostPlainGauge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Metric name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Metric value",
+                        "name": "value",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/updates": {
+            "post": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Post json metrics",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Post json metric s",
+                "operationId": "storagePostJSONSeveral",
+                "parameters": [
+                    {
+                        "description": "Metric data",
+                        "name": "metrics",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/metrics.Metric"
+                            }
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Batch id, retried batch with the same id from the same token or client address is not applied twice",
+                        "name": "X-Batch-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        },
+                        "headers": {
+                            "X-Batch-Duplicate": {
+                                "type": "string",
+                                "description": "true if batch was already applied"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/value": {
+            "get": {
+                "security": [
+                    {
+                        "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get json metric",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get json metric",
+                "operationId": "storageGetJSON",
+                "parameters": [
+                    {
+                        "description": "Metric data",
+                        "name": "metric",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/metrics.Metric"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/metrics.Metric"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "type": "string"
                         }
@@ -172,26 +813,35 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/update/gauge/{name}/{value}": {
-            "post": {
+        "/value/counter/{name}": {
+            "get": {
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Save gauge metric",
+                "description": "Get counter metric",
                 "consumes": [
                     "text/plain"
                 ],
                 "produces": [
                     "text/plain"
                 ],
-                "summary": "Save one metric with gauge type",
-                "operationId": "storagePostPlainGauge",
+                "summary": "Get one metric with counter type",
+                "operationId": "storageGetPlainCounter",
                 "parameters": [
                     {
                         "type": "string",
@@ -199,13 +849,6 @@ const docTemplate = `{
                         "name": "name",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "type": "number",
-                        "description": "Metric value",
-                        "name": "value",
-                        "in": "path",
-                        "required": true
                     }
                 ],
                 "responses": {
@@ -221,43 +864,63 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Error",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
-            }
-        },
-        "/updates": {
-            "post": {
+            },
+            "delete": {
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Post json metrics",
+                "description": "Delete counter metric, returns its last value",
                 "consumes": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "produces": [
-                    "application/json"
+                    "text/plain"
                 ],
-                "summary": "Post json metric s",
-                "operationId": "storagePostJSONSeveral",
+                "summary": "Delete one metric with counter type",
+                "operationId": "storageDeletePlainCounter",
                 "parameters": [
                     {
-                        "description": "Metric data",
-                        "name": "metrics",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/metrics.Metric"
-                            }
-                        }
+                        "type": "string",
+                        "description": "Metric name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -267,8 +930,20 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "type": "string"
                         }
@@ -278,42 +953,49 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/value": {
+        "/value/gauge/{name}": {
             "get": {
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Get json metric",
+                "description": "Get gauge metric",
                 "consumes": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "produces": [
-                    "application/json"
+                    "text/plain"
                 ],
-                "summary": "Get json metric",
-                "operationId": "storageGetJSON",
+                "summary": "Get one metric with gauge type",
+                "operationId": "storageGetPlainGauge",
                 "parameters": [
                     {
-                        "description": "Metric data",
-                        "name": "metric",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/metrics.Metric"
-                        }
+                        "type": "string",
+                        "description": "Metric name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/metrics.Metric"
+                            "type": "string"
                         }
                     },
                     "400": {
@@ -322,6 +1004,18 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -333,26 +1027,33 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
-            }
-        },
-        "/value/counter/{name}": {
-            "get": {
+            },
+            "delete": {
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Get counter metric",
+                "description": "Delete gauge metric, returns its last value",
                 "consumes": [
                     "text/plain"
                 ],
                 "produces": [
                     "text/plain"
                 ],
-                "summary": "Get one metric with counter type",
-                "operationId": "storageGetPlainCounter",
+                "summary": "Delete one metric with gauge type",
+                "operationId": "storageDeletePlainGauge",
                 "parameters": [
                     {
                         "type": "string",
@@ -369,8 +1070,14 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "string"
                         }
@@ -386,58 +1093,86 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "503": {
+                        "description": "Storage Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/value/gauge/{name}": {
-            "get": {
+        "/values": {
+            "delete": {
                 "security": [
                     {
                         "SecurityKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Get gauge metric",
-                "consumes": [
-                    "text/plain"
-                ],
+                "description": "Delete all metrics with names matching shell pattern (e.g. Host*), optionally only of one type",
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
-                "summary": "Get one metric with gauge type",
-                "operationId": "storageGetPlainGauge",
+                "summary": "Delete metrics by name pattern",
+                "operationId": "storageDeleteByPattern",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Metric name",
-                        "name": "name",
-                        "in": "path",
+                        "description": "Metric name pattern",
+                        "name": "pattern",
+                        "in": "query",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "counter",
+                            "gauge"
+                        ],
+                        "type": "string",
+                        "description": "Metric type",
+                        "name": "type",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.ResponseDeletedObject"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Internal Error",
+                        "description": "Internal Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ResponseErrorObject"
                         }
                     }
                 }
@@ -445,6 +1180,135 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "alerts.Alert": {
+            "type": "object",
+            "properties": {
+                "active_at": {
+                    "description": "с какого момента выполняется условие",
+                    "type": "string"
+                },
+                "annotations": {
+                    "description": "описание из правила",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "expr": {
+                    "description": "условие правила",
+                    "type": "string"
+                },
+                "fired_at": {
+                    "description": "когда алерт перешел в firing",
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "метки из правила",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "description": "имя правила",
+                    "type": "string"
+                },
+                "resolved_at": {
+                    "description": "когда алерт перешел в resolved",
+                    "type": "string"
+                },
+                "state": {
+                    "description": "состояние алерта",
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "firing",
+                        "resolved"
+                    ]
+                },
+                "value": {
+                    "description": "последнее вычисленное значение",
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.ResponseAlertListObject": {
+            "type": "object",
+            "properties": {
+                "alerts": {
+                    "description": "алерты, отсортированные по имени",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/alerts.Alert"
+                    }
+                }
+            }
+        },
+        "handlers.ResponseDeletedObject": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "description": "кол-во удаленных метрик",
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.ResponseErrorObject": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.ResponseMetricListObject": {
+            "type": "object",
+            "properties": {
+                "metrics": {
+                    "description": "метрики, отсортированные по имени и типу",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/metrics.Metric"
+                    }
+                },
+                "next_cursor": {
+                    "description": "курсор следующей страницы, пустой на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "health.ComponentStatus": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "причина, по которой компонент не готов",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "ok или fail",
+                    "type": "string"
+                }
+            }
+        },
+        "health.Response": {
+            "type": "object",
+            "properties": {
+                "components": {
+                    "description": "состояние по компонентам",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/health.ComponentStatus"
+                    }
+                },
+                "status": {
+                    "description": "ok, если готовы все компоненты",
+                    "type": "string"
+                }
+            }
+        },
         "metrics.Metric": {
             "type": "object",
             "properties": {
@@ -456,6 +1320,10 @@ const docTemplate = `{
                     "description": "имя метрики",
                     "type": "string"
                 },
+                "rate": {
+                    "description": "скорость роста counter в секунду, заполняется только в ответах сервера",
+                    "type": "number"
+                },
                 "type": {
                     "description": "параметр, принимающий значение gauge или counter",
                     "type": "string",
@@ -472,6 +1340,11 @@ const docTemplate = `{
         }
     },
     "securityDefinitions": {
+        "BearerAuth": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        },
         "SecurityKeyAuth": {
             "type": "apiKey",
             "name": "HashSHA256",